@@ -3,8 +3,12 @@ package config
 import (
 	"fmt"
 	"log"
+	"net/url"
 	"os"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -17,19 +21,149 @@ type Config struct {
 	GracefulShutdownTimeout time.Duration `envconfig:"GRACEFUL_SHUTDOWN_TIMEOUT" default:"0s"`
 	HealthToken             string        `envconfig:"HEALTH_TOKEN" default:"health-token"`
 
+	Server struct {
+		// zero disables a timeout. The header timeout is for every request, the read and write timeouts are the
+		// deadlines of the routes except the bulk upload and the change feed which have their own
+		ReadHeaderTimeout time.Duration `envconfig:"SERVER_READ_HEADER_TIMEOUT" default:"10s"`
+		ReadTimeout       time.Duration `envconfig:"SERVER_READ_TIMEOUT" default:"60s"`
+		WriteTimeout      time.Duration `envconfig:"SERVER_WRITE_TIMEOUT" default:"90s"`
+		IdleTimeout       time.Duration `envconfig:"SERVER_IDLE_TIMEOUT" default:"120s"`
+		// read and write deadline of the bulk upload
+		BulkTimeout time.Duration `envconfig:"SERVER_BULK_TIMEOUT" default:"1h"`
+		// write deadline of the change feed, it must outlast CHANGES_MAX_WAIT. The change streams are ended
+		// before it and resumed by the event source
+		StreamTimeout  time.Duration `envconfig:"SERVER_STREAM_TIMEOUT" default:"1h"`
+		MaxHeaderBytes int           `envconfig:"SERVER_MAX_HEADER_BYTES" default:"1048576"`
+		// request body limits, the bulk one is for the ndjson stream of the bulk endpoint
+		MaxBodyBytes     int64 `envconfig:"SERVER_MAX_BODY_BYTES" default:"10485760"`
+		MaxBulkBodyBytes int64 `envconfig:"SERVER_MAX_BULK_BODY_BYTES" default:"1073741824"`
+		// TLS is served when both files are set, the changed files are reloaded on every interval
+		TLSCertFile       string        `envconfig:"SERVER_TLS_CERT_FILE"`
+		TLSKeyFile        string        `envconfig:"SERVER_TLS_KEY_FILE"`
+		TLSReloadInterval time.Duration `envconfig:"SERVER_TLS_RELOAD_INTERVAL" default:"1m"`
+		// HTTP/2 without TLS, i.e: behind a proxy terminating TLS
+		H2C bool `envconfig:"SERVER_H2C" default:"false"`
+	}
+
 	Advertisement struct {
 		MasterDataPath string `envconfig:"ADVERTISEMENT_MASTER_DATA_PATH" default:"./data/data.gz"`
 
+		Bulk struct {
+			BatchSize    int `envconfig:"ADVERTISEMENT_BULK_BATCH_SIZE" default:"500"`
+			MaxLineBytes int `envconfig:"ADVERTISEMENT_BULK_MAX_LINE_BYTES" default:"1048576"`
+		}
+
 		Bleve struct {
-			IndexName string `envconfig:"ADVERTISEMENT_BLEVE_INDEX_NAME" default:"kraicklist.bleve"`
+			IndexName  string `envconfig:"ADVERTISEMENT_BLEVE_INDEX_NAME" default:"kraicklist.bleve"`
+			BatchSize  int    `envconfig:"ADVERTISEMENT_BLEVE_BATCH_SIZE" default:"100"`
+			NumWorkers int    `envconfig:"ADVERTISEMENT_BLEVE_NUM_WORKERS" default:"4"`
 		}
 
 		Elastic struct {
 			IndexName string `envconfig:"ADVERTISEMENT_ELASTIC_INDEX_NAME" default:"kraicklist-dev"`
 		}
+
+		Memory struct {
+			// gzip jsonl file the in-memory index is loaded from and written to on shutdown, empty disables it
+			SnapshotPath string `envconfig:"ADVERTISEMENT_MEMORY_SNAPSHOT_PATH"`
+		}
+
+		SQLite struct {
+			Path string `envconfig:"ADVERTISEMENT_SQLITE_PATH" default:"./data/kraicklist.sqlite"`
+		}
+	}
+
+	Store struct {
+		// the primary store is the system of record of the ads, the index is updated from its changes.
+		// it's opt-in, the index stays the only copy of the ads unless it's enabled
+		Enabled bool   `envconfig:"STORE_ENABLED" default:"false"`
+		Path    string `envconfig:"STORE_PATH" default:"./data/kraicklist.db"`
+		// wait for the store lock held by other process, i.e: seeding while the API is running
+		LockTimeout  time.Duration `envconfig:"STORE_LOCK_TIMEOUT" default:"3s"`
+		SyncInterval time.Duration `envconfig:"STORE_SYNC_INTERVAL" default:"5s"`
+	}
+
+	Tenant struct {
+		// the tenants created by the admin API, each has its own indices, store and jobs
+		RegistryPath string `envconfig:"TENANT_REGISTRY_PATH" default:"./data/tenants.json"`
+		// header selecting the tenant, the requests without a tenant are served by the default indices
+		Header string `envconfig:"TENANT_HEADER" default:"X-Tenant"`
+	}
+
+	Auth struct {
+		// the API keys are required on the API when it's enabled, the health check keeps HEALTH_TOKEN
+		Enabled  bool   `envconfig:"AUTH_ENABLED" default:"false"`
+		KeysPath string `envconfig:"AUTH_KEYS_PATH" default:"./data/api_keys.json"`
+		// default lifetime of the issued keys, and how long the rotated key still works
+		KeyTTL        time.Duration `envconfig:"AUTH_KEY_TTL" default:"2160h"`
+		RotationGrace time.Duration `envconfig:"AUTH_ROTATION_GRACE" default:"24h"`
+	}
+
+	RateLimit struct {
+		// token buckets per api key, or per client ip without the key. The requests per second refill the bucket
+		// up to the burst, the admin endpoints aren't limited
+		Enabled     bool    `envconfig:"RATE_LIMIT_ENABLED" default:"false"`
+		SearchRate  float64 `envconfig:"RATE_LIMIT_SEARCH_RATE" default:"10"`
+		SearchBurst int     `envconfig:"RATE_LIMIT_SEARCH_BURST" default:"20"`
+		IndexRate   float64 `envconfig:"RATE_LIMIT_INDEX_RATE" default:"1"`
+		IndexBurst  int     `envconfig:"RATE_LIMIT_INDEX_BURST" default:"5"`
+		// take the client ip from X-Forwarded-For, only behind a proxy setting it
+		TrustProxy bool `envconfig:"RATE_LIMIT_TRUST_PROXY" default:"false"`
+		// the buckets of the clients idle for longer are forgotten
+		IdleTimeout time.Duration `envconfig:"RATE_LIMIT_IDLE_TIMEOUT" default:"10m"`
+	}
+
+	Usage struct {
+		// daily request counters per client, kept regardless of the rate limit
+		Path          string        `envconfig:"USAGE_PATH" default:"./data/usage.json"`
+		FlushInterval time.Duration `envconfig:"USAGE_FLUSH_INTERVAL" default:"1m"`
+		Retention     time.Duration `envconfig:"USAGE_RETENTION" default:"2160h"`
+	}
+
+	Changes struct {
+		// changes older than the retention are pruned once they're applied to the index
+		Retention     time.Duration `envconfig:"CHANGES_RETENTION" default:"168h"`
+		PruneInterval time.Duration `envconfig:"CHANGES_PRUNE_INTERVAL" default:"1h"`
+		// bounds of the limit and the long-poll wait of the change feed
+		MaxLimit int           `envconfig:"CHANGES_MAX_LIMIT" default:"1000"`
+		MaxWait  time.Duration `envconfig:"CHANGES_MAX_WAIT" default:"60s"`
+	}
+
+	Job struct {
+		Dir       string `envconfig:"JOB_DIR" default:"./data/jobs"`
+		Workers   int    `envconfig:"JOB_WORKERS" default:"2"`
+		QueueSize int    `envconfig:"JOB_QUEUE_SIZE" default:"100"`
+	}
+
+	IndexerActivated string `envconfig:"INDEXER_ACTIVATED" default:"bleve"` // any registered search backend, i.e: bleve | elastic | memory | sqlite
+
+	Failover struct {
+		// local replica which gets the writes and serves the searches while the primary is failing, empty disables it
+		Indexer           string        `envconfig:"FAILOVER_INDEXER"`
+		CheckInterval     time.Duration `envconfig:"FAILOVER_CHECK_INTERVAL" default:"5s"`
+		LatencyThreshold  time.Duration `envconfig:"FAILOVER_LATENCY_THRESHOLD" default:"2s"`
+		FailureThreshold  int           `envconfig:"FAILOVER_FAILURE_THRESHOLD" default:"3"`
+		RecoveryThreshold int           `envconfig:"FAILOVER_RECOVERY_THRESHOLD" default:"3"`
+	}
+
+	Federation struct {
+		// other indexers searched along with INDEXER_ACTIVATED as name[:index][@timeout],
+		// i.e: bleve:legacy.bleve@500ms, empty disables it
+		Sources []string      `envconfig:"FEDERATION_SOURCES"`
+		Timeout time.Duration `envconfig:"FEDERATION_TIMEOUT" default:"2s"`
+		Merge   string        `envconfig:"FEDERATION_MERGE" default:"rrf"` // rrf | score
+		RRFK    int           `envconfig:"FEDERATION_RRF_K" default:"60"`
 	}
 
-	IndexerActivated string `envconfig:"INDEXER_ACTIVATED" default:"bleve"` // bleve | elastic
+	Shadow struct {
+		// secondary search backend which gets the writes and is compared on each search, empty disables it
+		Indexer     string        `envconfig:"SHADOW_INDEXER"`
+		TopK        int           `envconfig:"SHADOW_TOP_K" default:"10"`
+		Timeout     time.Duration `envconfig:"SHADOW_TIMEOUT" default:"5s"`
+		MaxInflight int           `envconfig:"SHADOW_MAX_INFLIGHT" default:"10"`
+		// number of the latest comparisons kept for the report
+		Retention int `envconfig:"SHADOW_RETENTION" default:"1000"`
+	}
 
 	Elastic struct {
 		Host     []string `envconfig:"ELASTIC_HOST" default:"http://localhost:9200"`
@@ -38,6 +172,19 @@ type Config struct {
 
 		PingRetry    int           `envconfig:"ELASTIC_PING_RETRY" default:"10"`
 		PingWaitTime time.Duration `envconfig:"ELASTIC_PING_WAIT_TIME" default:"5s"`
+
+		Bulk struct {
+			NumWorkers    int           `envconfig:"ELASTIC_BULK_NUM_WORKERS" default:"100"`
+			FlushBytes    int           `envconfig:"ELASTIC_BULK_FLUSH_BYTES" default:"5000000"`
+			FlushInterval time.Duration `envconfig:"ELASTIC_BULK_FLUSH_INTERVAL" default:"30s"`
+
+			MaxRetries      int           `envconfig:"ELASTIC_BULK_MAX_RETRIES" default:"3"`
+			RetryBackoffMin time.Duration `envconfig:"ELASTIC_BULK_RETRY_BACKOFF_MIN" default:"100ms"`
+			RetryBackoffMax time.Duration `envconfig:"ELASTIC_BULK_RETRY_BACKOFF_MAX" default:"10s"`
+
+			// ratio of failed docs, 0 to 1, which makes the whole bulk to be failed
+			FailureThreshold float64 `envconfig:"ELASTIC_BULK_FAILURE_THRESHOLD" default:"1"`
+		}
 	}
 }
 
@@ -53,6 +200,246 @@ func Get() *Config {
 	return &conf
 }
 
+// Load reads the config from the environment without exiting on invalid values
+func Load() (*Config, error) {
+	var out Config
+	if err := envconfig.Process("", &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Validate checks the config values, each problem is described with the env var to fix
+func (c Config) Validate() (problems []string) {
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if port, err := strconv.Atoi(c.Port); err != nil || port <= 0 || port > 65535 {
+		add("PORT %q must be a port number between 1 and 65535", c.Port)
+	}
+	switch strings.ToUpper(c.LogLevel) {
+	case "DEBUG", "INFO", "WARN", "ERROR":
+	default:
+		add("LOG_LEVEL %q must be one of DEBUG, INFO, WARN or ERROR", c.LogLevel)
+	}
+	if c.GracefulShutdownTimeout < 0 {
+		add("GRACEFUL_SHUTDOWN_TIMEOUT can't be negative")
+	}
+	if c.HealthToken == "" {
+		add("HEALTH_TOKEN is empty, the health check would be unprotected")
+	}
+	if c.IndexerActivated == "" {
+		add("INDEXER_ACTIVATED is empty")
+	}
+
+	if c.Advertisement.MasterDataPath == "" {
+		add("ADVERTISEMENT_MASTER_DATA_PATH is empty")
+	}
+	positives := map[string]int{
+		"ADVERTISEMENT_BULK_BATCH_SIZE":     c.Advertisement.Bulk.BatchSize,
+		"ADVERTISEMENT_BULK_MAX_LINE_BYTES": c.Advertisement.Bulk.MaxLineBytes,
+		"ADVERTISEMENT_BLEVE_BATCH_SIZE":    c.Advertisement.Bleve.BatchSize,
+		"ADVERTISEMENT_BLEVE_NUM_WORKERS":   c.Advertisement.Bleve.NumWorkers,
+		"JOB_WORKERS":                       c.Job.Workers,
+		"JOB_QUEUE_SIZE":                    c.Job.QueueSize,
+		"ELASTIC_BULK_NUM_WORKERS":          c.Elastic.Bulk.NumWorkers,
+		"ELASTIC_BULK_FLUSH_BYTES":          c.Elastic.Bulk.FlushBytes,
+		"ELASTIC_PING_RETRY":                c.Elastic.PingRetry,
+		"FAILOVER_FAILURE_THRESHOLD":        c.Failover.FailureThreshold,
+		"FAILOVER_RECOVERY_THRESHOLD":       c.Failover.RecoveryThreshold,
+		"FEDERATION_RRF_K":                  c.Federation.RRFK,
+		"CHANGES_MAX_LIMIT":                 c.Changes.MaxLimit,
+		"SHADOW_TOP_K":                      c.Shadow.TopK,
+		"SHADOW_MAX_INFLIGHT":               c.Shadow.MaxInflight,
+		"SHADOW_RETENTION":                  c.Shadow.Retention,
+		"RATE_LIMIT_SEARCH_BURST":           c.RateLimit.SearchBurst,
+		"SERVER_MAX_HEADER_BYTES":           c.Server.MaxHeaderBytes,
+		"RATE_LIMIT_INDEX_BURST":            c.RateLimit.IndexBurst,
+	}
+	for _, name := range sortedKeys(positives) {
+		if positives[name] <= 0 {
+			add("%s must be greater than 0, got %d", name, positives[name])
+		}
+	}
+
+	bleveName := c.Advertisement.Bleve.IndexName
+	if bleveName == "" || strings.ContainsAny(bleveName, `/\`) || bleveName == "." || bleveName == ".." {
+		add("ADVERTISEMENT_BLEVE_INDEX_NAME %q must be a directory name under ./data", bleveName)
+	}
+	esName := c.Advertisement.Elastic.IndexName
+	if esName == "" || esName != strings.ToLower(esName) || strings.ContainsAny(esName, ` "*\<|,>/?`) {
+		add("ADVERTISEMENT_ELASTIC_INDEX_NAME %q must be lowercase without spaces or any of \"*\\<|,>/?", esName)
+	}
+	if c.Advertisement.SQLite.Path == "" {
+		add("ADVERTISEMENT_SQLITE_PATH is empty")
+	}
+	if c.Store.Enabled && c.Store.Path == "" {
+		add("STORE_PATH is empty, set STORE_ENABLED=false to disable the store")
+	}
+	if c.Store.LockTimeout <= 0 || c.Store.SyncInterval <= 0 {
+		add("STORE_LOCK_TIMEOUT and STORE_SYNC_INTERVAL must be greater than 0")
+	}
+	if c.Changes.Retention <= 0 || c.Changes.PruneInterval <= 0 || c.Changes.MaxWait <= 0 {
+		add("CHANGES_RETENTION, CHANGES_PRUNE_INTERVAL and CHANGES_MAX_WAIT must be greater than 0")
+	}
+	if c.Tenant.RegistryPath == "" || c.Tenant.Header == "" {
+		add("TENANT_REGISTRY_PATH and TENANT_HEADER must be set")
+	}
+	if c.Auth.KeysPath == "" {
+		add("AUTH_KEYS_PATH is empty")
+	}
+	if c.Auth.KeyTTL <= 0 || c.Auth.RotationGrace < 0 {
+		add("AUTH_KEY_TTL must be greater than 0 and AUTH_ROTATION_GRACE can't be negative")
+	}
+	if c.Server.ReadHeaderTimeout < 0 || c.Server.ReadTimeout < 0 || c.Server.WriteTimeout < 0 ||
+		c.Server.IdleTimeout < 0 || c.Server.BulkTimeout < 0 || c.Server.StreamTimeout < 0 {
+		add("SERVER_READ_HEADER_TIMEOUT, SERVER_READ_TIMEOUT, SERVER_WRITE_TIMEOUT, SERVER_IDLE_TIMEOUT, " +
+			"SERVER_BULK_TIMEOUT and SERVER_STREAM_TIMEOUT can't be negative")
+	}
+	if shorterTimeout(c.Server.ReadTimeout, c.Server.ReadHeaderTimeout) {
+		add("SERVER_READ_TIMEOUT %s can't be shorter than SERVER_READ_HEADER_TIMEOUT %s",
+			c.Server.ReadTimeout, c.Server.ReadHeaderTimeout)
+	}
+	if shorterTimeout(c.Server.BulkTimeout, c.Server.ReadTimeout) ||
+		shorterTimeout(c.Server.BulkTimeout, c.Server.WriteTimeout) {
+		add("SERVER_BULK_TIMEOUT %s can't be shorter than SERVER_READ_TIMEOUT %s or SERVER_WRITE_TIMEOUT %s",
+			c.Server.BulkTimeout, c.Server.ReadTimeout, c.Server.WriteTimeout)
+	}
+	if c.Server.StreamTimeout > 0 && c.Server.StreamTimeout <= c.Changes.MaxWait {
+		add("SERVER_STREAM_TIMEOUT %s must be longer than CHANGES_MAX_WAIT %s", c.Server.StreamTimeout, c.Changes.MaxWait)
+	}
+	if c.Server.MaxBodyBytes <= 0 || c.Server.MaxBulkBodyBytes <= 0 {
+		add("SERVER_MAX_BODY_BYTES and SERVER_MAX_BULK_BODY_BYTES must be greater than 0")
+	}
+	if (c.Server.TLSCertFile == "") != (c.Server.TLSKeyFile == "") {
+		add("SERVER_TLS_CERT_FILE and SERVER_TLS_KEY_FILE must be set together")
+	}
+	if c.Server.TLSCertFile != "" && c.Server.TLSReloadInterval <= 0 {
+		add("SERVER_TLS_RELOAD_INTERVAL must be greater than 0")
+	}
+	if c.Server.TLSCertFile != "" && c.Server.H2C {
+		add("SERVER_H2C is only for the cleartext server, HTTP/2 is already served on TLS")
+	}
+	if c.RateLimit.SearchRate <= 0 || c.RateLimit.IndexRate <= 0 || c.RateLimit.IdleTimeout <= 0 {
+		add("RATE_LIMIT_SEARCH_RATE, RATE_LIMIT_INDEX_RATE and RATE_LIMIT_IDLE_TIMEOUT must be greater than 0")
+	}
+	if c.Usage.Path == "" {
+		add("USAGE_PATH is empty")
+	}
+	if c.Usage.FlushInterval <= 0 || c.Usage.Retention <= 0 {
+		add("USAGE_FLUSH_INTERVAL and USAGE_RETENTION must be greater than 0")
+	}
+	if c.Job.Dir == "" {
+		add("JOB_DIR is empty")
+	}
+	if c.Shadow.Indexer != "" && c.Shadow.Indexer == c.IndexerActivated {
+		add("SHADOW_INDEXER %q must be different from INDEXER_ACTIVATED", c.Shadow.Indexer)
+	}
+	if c.Failover.Indexer != "" && c.Failover.Indexer == c.IndexerActivated {
+		add("FAILOVER_INDEXER %q must be different from INDEXER_ACTIVATED", c.Failover.Indexer)
+	}
+	if c.Failover.Indexer != "" && c.Failover.Indexer == c.Shadow.Indexer {
+		add("FAILOVER_INDEXER and SHADOW_INDEXER can't be the same indexer %q", c.Failover.Indexer)
+	}
+	if c.Failover.CheckInterval <= 0 || c.Failover.LatencyThreshold <= 0 {
+		add("FAILOVER_CHECK_INTERVAL and FAILOVER_LATENCY_THRESHOLD must be greater than 0")
+	}
+	if _, err := c.FederationSources(); err != nil {
+		add("%v", err)
+	}
+	if c.Federation.Timeout <= 0 {
+		add("FEDERATION_TIMEOUT must be greater than 0")
+	}
+	if c.Federation.Merge != FederationMergeRRF && c.Federation.Merge != FederationMergeScore {
+		add("FEDERATION_MERGE %q must be %s or %s", c.Federation.Merge, FederationMergeRRF, FederationMergeScore)
+	}
+	if c.Shadow.Timeout <= 0 {
+		add("SHADOW_TIMEOUT must be greater than 0")
+	}
+
+	if len(c.Elastic.Host) == 0 {
+		add("ELASTIC_HOST is empty")
+	}
+	for _, host := range c.Elastic.Host {
+		if u, err := url.Parse(host); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("ELASTIC_HOST %q must be an http or https URL, i.e: http://localhost:9200", host)
+		}
+	}
+	if (c.Elastic.Username == "") != (c.Elastic.Password == "") {
+		add("ELASTIC_USERNAME and ELASTIC_PASSWORD must be set together")
+	}
+	if c.Elastic.Bulk.MaxRetries < 0 {
+		add("ELASTIC_BULK_MAX_RETRIES can't be negative")
+	}
+	if c.Elastic.Bulk.FlushInterval <= 0 {
+		add("ELASTIC_BULK_FLUSH_INTERVAL must be greater than 0")
+	}
+	if c.Elastic.Bulk.RetryBackoffMin <= 0 || c.Elastic.Bulk.RetryBackoffMin > c.Elastic.Bulk.RetryBackoffMax {
+		add("ELASTIC_BULK_RETRY_BACKOFF_MIN must be greater than 0 and not greater than ELASTIC_BULK_RETRY_BACKOFF_MAX")
+	}
+	if threshold := c.Elastic.Bulk.FailureThreshold; threshold <= 0 || threshold > 1 {
+		add("ELASTIC_BULK_FAILURE_THRESHOLD must be greater than 0 and at most 1, got %v", threshold)
+	}
+	return
+}
+
+const (
+	FederationMergeRRF   = "rrf"
+	FederationMergeScore = "score"
+)
+
+// FederationSource is an entry of FEDERATION_SOURCES, empty IndexName is the configured index of the indexer
+type FederationSource struct {
+	Indexer   string
+	IndexName string
+	Timeout   time.Duration
+}
+
+// FederationSources parses FEDERATION_SOURCES, the sources without a timeout use FEDERATION_TIMEOUT
+func (c Config) FederationSources() (out []FederationSource, err error) {
+	seen := map[string]bool{}
+	for _, spec := range c.Federation.Sources {
+		source := FederationSource{Timeout: c.Federation.Timeout}
+		value := strings.TrimSpace(spec)
+		if i := strings.LastIndex(value, "@"); i >= 0 {
+			if source.Timeout, err = time.ParseDuration(value[i+1:]); err != nil || source.Timeout <= 0 {
+				err = fmt.Errorf("FEDERATION_SOURCES %q must have a positive timeout, i.e: bleve:legacy.bleve@500ms", spec)
+				return nil, err
+			}
+			value = value[:i]
+		}
+		source.Indexer = value
+		if i := strings.Index(value, ":"); i >= 0 {
+			source.Indexer, source.IndexName = value[:i], value[i+1:]
+		}
+		if source.Indexer == "" {
+			return nil, fmt.Errorf("FEDERATION_SOURCES %q must be name[:index][@timeout], i.e: bleve:legacy.bleve@500ms", spec)
+		}
+		key := source.Indexer + ":" + source.IndexName
+		if seen[key] || (source.Indexer == c.IndexerActivated && source.IndexName == "") {
+			return nil, fmt.Errorf("FEDERATION_SOURCES %q is listed twice or is the INDEXER_ACTIVATED index", spec)
+		}
+		seen[key] = true
+		out = append(out, source)
+	}
+	return
+}
+
+// shorterTimeout reports whether the timeout a expires before b, zero never expires
+func shorterTimeout(a, b time.Duration) bool {
+	return a > 0 && (b == 0 || a < b)
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func (c Config) PrintPretty() {
 	printTags(reflect.TypeOf(&c).Elem())
 	fmt.Println()
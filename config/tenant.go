@@ -0,0 +1,42 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+)
+
+// tenantNamePattern keeps the names valid on every indexer, i.e: elastic index names are lowercase
+var tenantNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// ValidateTenantName checks the name could be used on the index names and the file names
+func ValidateTenantName(name string) error {
+	if !tenantNamePattern.MatchString(name) {
+		return fmt.Errorf("tenant %q must be 1 to 32 lowercase letters, digits, - or _, starting with a letter or digit", name)
+	}
+	return nil
+}
+
+// ForTenant returns a copy of the config whose indices, store and jobs are scoped to the tenant.
+// The failover, federation and shadow modes are only for the default indices, so they're disabled
+func (c *Config) ForTenant(name string) *Config {
+	out := *c
+	out.Advertisement.Bleve.IndexName = name + "." + c.Advertisement.Bleve.IndexName
+	out.Advertisement.Elastic.IndexName = c.Advertisement.Elastic.IndexName + "-" + name
+	out.Advertisement.SQLite.Path = tenantPath(c.Advertisement.SQLite.Path, name)
+	if c.Advertisement.Memory.SnapshotPath != "" {
+		out.Advertisement.Memory.SnapshotPath = tenantPath(c.Advertisement.Memory.SnapshotPath, name)
+	}
+	out.Store.Path = tenantPath(c.Store.Path, name)
+	out.Job.Dir = filepath.Clean(c.Job.Dir) + "-" + name
+
+	out.Failover.Indexer = ""
+	out.Federation.Sources = nil
+	out.Shadow.Indexer = ""
+	return &out
+}
+
+// tenantPath prefixes the file name with the tenant, i.e: ./data/acme.kraicklist.db
+func tenantPath(path, name string) string {
+	return filepath.Join(filepath.Dir(path), name+"."+filepath.Base(path))
+}
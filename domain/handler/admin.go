@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/isdzulqor/kraicklist/domain/service"
+	"github.com/isdzulqor/kraicklist/helper/errors"
+	"github.com/isdzulqor/kraicklist/helper/response"
+)
+
+const (
+	defaultStatsTop    = 10
+	defaultShadowLimit = 20
+)
+
+type Admin struct {
+	adService *service.Advertisement
+}
+
+func InitAdmin(adService *service.Advertisement) *Admin {
+	return &Admin{
+		adService: adService,
+	}
+}
+
+func (h *Admin) GetStats(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	top := defaultStatsTop
+	if value := r.FormValue("top"); value != "" {
+		var err error
+		if top, err = strconv.Atoi(value); err != nil || top <= 0 {
+			err = errors.ErrorParamInvalid.AppendMessage("top param must be a positive number.")
+			response.Failed(ctx, w, errors.GetStatusCode(err), err)
+			return
+		}
+	}
+
+	stats, err := h.adService.GetStats(ctx, top)
+	if err != nil {
+		response.Failed(ctx, w, errors.GetStatusCode(err), err)
+		return
+	}
+	response.Success(ctx, w, http.StatusOK, stats)
+}
+
+func (h *Admin) GetShadowReport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	limit := defaultShadowLimit
+	if value := r.FormValue("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			err = errors.ErrorParamInvalid.AppendMessage("limit param must be a positive number.")
+			response.Failed(ctx, w, errors.GetStatusCode(err), err)
+			return
+		}
+	}
+
+	report, err := h.adService.GetShadowReport(limit)
+	if err != nil {
+		response.Failed(ctx, w, errors.GetStatusCode(err), err)
+		return
+	}
+	response.Success(ctx, w, http.StatusOK, report)
+}
@@ -1,8 +1,14 @@
 package handler
 
 import (
+	"bufio"
+	"compress/gzip"
 	"encoding/json"
+	"io"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/isdzulqor/kraicklist/config"
 	"github.com/isdzulqor/kraicklist/domain/model"
@@ -12,16 +18,23 @@ import (
 	"github.com/isdzulqor/kraicklist/helper/response"
 )
 
+const (
+	ndjsonContentType = "application/x-ndjson"
+	degradedHeader    = "X-Degraded"
+)
+
 type Advertisement struct {
 	conf *config.Config
 
-	adService *service.Advertisement
+	adService  *service.Advertisement
+	jobService *service.Job
 }
 
-func InitAdvertisement(conf *config.Config, adService *service.Advertisement) *Advertisement {
+func InitAdvertisement(conf *config.Config, adService *service.Advertisement, jobService *service.Job) *Advertisement {
 	return &Advertisement{
-		conf:      conf,
-		adService: adService,
+		conf:       conf,
+		adService:  adService,
+		jobService: jobService,
 	}
 }
 
@@ -35,13 +48,24 @@ func (h *Advertisement) SearchAds(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result, err := h.adService.SearchAds(ctx, keyword)
+	result, status, err := h.adService.SearchAds(ctx, keyword)
 	if err != nil {
 		response.Failed(ctx, w, errors.GetStatusCode(err), err)
 		return
 	}
 
-	response.Success(ctx, w, http.StatusOK, result)
+	meta := map[string]interface{}{}
+	// the ads may be stale when they're served by the failover replica
+	if status.Degraded {
+		w.Header().Set(degradedHeader, "true")
+		meta["degraded"] = true
+		meta["degraded_reason"] = status.Reason
+	}
+	if len(status.Sources) > 0 {
+		meta["sources"] = status.Sources
+		meta["partial"] = status.Partial
+	}
+	response.SuccessWithMeta(ctx, w, http.StatusOK, result, meta)
 }
 
 func (h *Advertisement) IndexAds(w http.ResponseWriter, r *http.Request) {
@@ -50,15 +74,106 @@ func (h *Advertisement) IndexAds(w http.ResponseWriter, r *http.Request) {
 
 	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
 		logging.DebugContext(ctx, "failed to decode body param err: %v", err)
-		err = errors.ErrorParamInvalid
+		err = bodyError(err)
+		response.Failed(ctx, w, errors.GetStatusCode(err), err)
+		return
+	}
+
+	// async mode, the ads will be indexed by a job in background
+	if async, _ := strconv.ParseBool(r.FormValue("async")); async {
+		job, err := h.jobService.SubmitIndexAds(ctx, requestData)
+		if err != nil {
+			response.Failed(ctx, w, errors.GetStatusCode(err), err)
+			return
+		}
+		w.Header().Set("Location", "/api/jobs/"+job.ID)
+		response.Success(ctx, w, http.StatusAccepted, job)
+		return
+	}
+
+	statuses, err := h.adService.IndexAds(ctx, requestData)
+	if err != nil {
+		response.Failed(ctx, w, errors.GetStatusCode(err), err)
+		return
+	}
+
+	// some of ads are failed to be indexed
+	if statuses.CountFailed() > 0 {
+		response.Success(ctx, w, http.StatusMultiStatus, statuses)
+		return
+	}
+	response.Success(ctx, w, http.StatusOK, statuses)
+}
+
+// BulkIndexAds streams ndjson ads from request body into the indexer in batches,
+// and streams back the indexing status of each line as ndjson with a summary on the last line
+func (h *Advertisement) BulkIndexAds(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != ndjsonContentType {
+		err := errors.ErrorParamInvalid.AppendMessage("Content-Type must be " + ndjsonContentType)
 		response.Failed(ctx, w, errors.GetStatusCode(err), err)
 		return
 	}
 
-	if err := h.adService.IndexAds(ctx, requestData); err != nil {
+	// the body is read before the response is written, so the clients expecting 100 Continue get it
+	buffered := bufio.NewReader(r.Body)
+	if _, err := buffered.Peek(1); err != nil && err != io.EOF {
+		logging.DebugContext(ctx, "failed to read body err: %v", err)
+		err = bodyError(err)
 		response.Failed(ctx, w, errors.GetStatusCode(err), err)
 		return
 	}
 
-	response.Success(ctx, w, http.StatusOK, "success")
+	var body io.Reader = buffered
+	if strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip") {
+		gzipReader, err := gzip.NewReader(buffered)
+		if err != nil {
+			logging.DebugContext(ctx, "failed to read gzip body err: %v", err)
+			err = errors.ErrorParamInvalid.AppendMessage("body is not a valid gzip stream")
+			response.Failed(ctx, w, errors.GetStatusCode(err), err)
+			return
+		}
+		defer gzipReader.Close()
+		body = gzipReader
+	}
+
+	// the statuses are written while the body is still read, HTTP/1 would close the unread body
+	// on the first flush otherwise. HTTP/2 is always full duplex
+	controller := http.NewResponseController(w)
+	if err := controller.EnableFullDuplex(); err != nil {
+		logging.DebugContext(ctx, "full duplex is not enabled, err: %v", err)
+	}
+
+	w.Header().Set("Content-Type", ndjsonContentType)
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	pendingFlush := 0
+	summary, err := h.adService.StreamIndexAds(ctx, body,
+		h.conf.Advertisement.Bulk.BatchSize,
+		h.conf.Advertisement.Bulk.MaxLineBytes,
+		func(status model.AdLineStatus) error {
+			if err := encoder.Encode(status); err != nil {
+				return err
+			}
+			// flush once per batch to keep the client updated
+			if pendingFlush++; pendingFlush >= h.conf.Advertisement.Bulk.BatchSize {
+				pendingFlush = 0
+				return controller.Flush()
+			}
+			return nil
+		})
+	if err != nil {
+		logging.WarnContext(ctx, "bulk index is stopped, err: %v", err)
+		encoder.Encode(map[string]interface{}{
+			"summary": summary,
+			"error":   err.Error(),
+		})
+		return
+	}
+	encoder.Encode(map[string]interface{}{
+		"summary": summary,
+	})
 }
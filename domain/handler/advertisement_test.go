@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/isdzulqor/kraicklist/config"
+	"github.com/isdzulqor/kraicklist/domain/model"
+	"github.com/isdzulqor/kraicklist/domain/repository"
+	"github.com/isdzulqor/kraicklist/domain/service"
+	"github.com/isdzulqor/kraicklist/external/engine"
+	"github.com/isdzulqor/kraicklist/helper/errors"
+)
+
+// thresholdBackend fails the whole indexing like a bulk reaching the failure threshold, the failed docs are on the error
+type thresholdBackend struct {
+	engine.SearchBackend
+	rejected int64
+}
+
+func (b thresholdBackend) Index(ctx context.Context, ads model.Advertisements) error {
+	docErrors := errors.DocErrors{errors.NewDocError(fmt.Sprint(b.rejected), fmt.Errorf("bad title"))}
+	return errors.ErrorThirdParty.AppendMessage("1 of 2 docs failed to be indexed").SetData(docErrors)
+}
+
+func TestIndexAdsReportsTheDocsOfTheThresholdError(t *testing.T) {
+	ctx := context.Background()
+	conf := &config.Config{}
+	memory, err := engine.Open(ctx, "memory", conf, engine.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer memory.Close()
+	backend := thresholdBackend{SearchBackend: memory, rejected: 2}
+	h := InitAdvertisement(conf, service.InitAdvertisement(repository.InitAdvertisement(backend)), nil)
+
+	body := `[{"id": 1, "title": "first"}, {"id": 2, "title": "second"}]`
+	recorder := httptest.NewRecorder()
+	h.IndexAds(recorder, httptest.NewRequest(http.MethodPost, "/api/advertisement/index", strings.NewReader(body)))
+
+	if recorder.Code != http.StatusMultiStatus {
+		t.Fatalf("status = %d, want %d, body: %s", recorder.Code, http.StatusMultiStatus, recorder.Body)
+	}
+	var resp struct {
+		Data model.AdIndexStatuses `json:"data"`
+	}
+	if err = json.Unmarshal(recorder.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Data) != 2 || resp.Data[1].Status != "failed" || resp.Data[1].Reason != "bad title" {
+		t.Fatalf("expected ad 2 to be failed by bad title, got %+v", resp.Data)
+	}
+}
+
+func TestBulkIndexAdsReadsTheWholeChunkedBody(t *testing.T) {
+	ctx := context.Background()
+	conf := &config.Config{}
+	conf.Advertisement.Bulk.BatchSize = 10
+	conf.Advertisement.Bulk.MaxLineBytes = 1 << 20
+
+	backend, err := engine.Open(ctx, "memory", conf, engine.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	h := InitAdvertisement(conf, service.InitAdvertisement(repository.InitAdvertisement(backend)), nil)
+	server := httptest.NewServer(http.HandlerFunc(h.BulkIndexAds))
+	defer server.Close()
+
+	// the pipe has no length, so the body is sent chunked while the statuses are streamed back
+	const lines = 2000
+	body, writer := io.Pipe()
+	go func() {
+		encoder := json.NewEncoder(writer)
+		for i := 1; i <= lines; i++ {
+			if err := encoder.Encode(model.Advertisement{ID: int64(i), Title: fmt.Sprintf("ad %d", i)}); err != nil {
+				writer.CloseWithError(err)
+				return
+			}
+		}
+		writer.Close()
+	}()
+
+	resp, err := http.Post(server.URL, ndjsonContentType, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	statuses := 0
+	var last struct {
+		Summary model.BulkIndexSummary `json:"summary"`
+		Error   string                 `json:"error"`
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var status model.AdLineStatus
+		if err = json.Unmarshal(scanner.Bytes(), &status); err == nil && status.Status == "indexed" {
+			statuses++
+			continue
+		}
+		if err = json.Unmarshal(scanner.Bytes(), &last); err != nil {
+			t.Fatalf("unexpected line %s", scanner.Text())
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if last.Error != "" {
+		t.Fatalf("bulk index is stopped, err: %s", last.Error)
+	}
+	if statuses != lines || last.Summary.Total != lines || last.Summary.Indexed != lines {
+		t.Fatalf("expected %d indexed lines, got %d statuses and summary %+v", lines, statuses, last.Summary)
+	}
+}
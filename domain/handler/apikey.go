@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/isdzulqor/kraicklist/domain/service"
+	"github.com/isdzulqor/kraicklist/helper/errors"
+	"github.com/isdzulqor/kraicklist/helper/logging"
+	"github.com/isdzulqor/kraicklist/helper/response"
+
+	"github.com/gorilla/mux"
+)
+
+type APIKey struct {
+	authService *service.Auth
+}
+
+func InitAPIKey(authService *service.Auth) *APIKey {
+	return &APIKey{
+		authService: authService,
+	}
+}
+
+func (h *APIKey) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	keys, err := h.authService.GetAPIKeys(ctx)
+	if err != nil {
+		response.Failed(ctx, w, errors.GetStatusCode(err), err)
+		return
+	}
+	response.Success(ctx, w, http.StatusOK, keys)
+}
+
+func (h *APIKey) IssueAPIKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var requestData struct {
+		service.IssueAPIKeyRequest
+		TTL string `json:"ttl"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		logging.DebugContext(ctx, "failed to decode body param err: %v", err)
+		err = bodyError(err)
+		response.Failed(ctx, w, errors.GetStatusCode(err), err)
+		return
+	}
+	if requestData.TTL != "" {
+		var err error
+		if requestData.IssueAPIKeyRequest.TTL, err = time.ParseDuration(requestData.TTL); err != nil {
+			err = errors.ErrorParamInvalid.AppendMessage("ttl must be a duration, i.e: 720h.")
+			response.Failed(ctx, w, errors.GetStatusCode(err), err)
+			return
+		}
+	}
+
+	key, err := h.authService.IssueAPIKey(ctx, requestData.IssueAPIKeyRequest)
+	if err != nil {
+		response.Failed(ctx, w, errors.GetStatusCode(err), err)
+		return
+	}
+	response.Success(ctx, w, http.StatusCreated, key)
+}
+
+// RotateAPIKey issues the replacing key, the body is optional with the grace period of the replaced key
+func (h *APIKey) RotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var requestData struct {
+		Grace string `json:"grace"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil && err != io.EOF {
+		logging.DebugContext(ctx, "failed to decode body param err: %v", err)
+		err = bodyError(err)
+		response.Failed(ctx, w, errors.GetStatusCode(err), err)
+		return
+	}
+	grace := time.Duration(-1)
+	if requestData.Grace != "" {
+		var err error
+		if grace, err = time.ParseDuration(requestData.Grace); err != nil || grace < 0 {
+			err = errors.ErrorParamInvalid.AppendMessage("grace must be a duration, i.e: 24h.")
+			response.Failed(ctx, w, errors.GetStatusCode(err), err)
+			return
+		}
+	}
+
+	key, err := h.authService.RotateAPIKey(ctx, mux.Vars(r)["id"], grace)
+	if err != nil {
+		response.Failed(ctx, w, errors.GetStatusCode(err), err)
+		return
+	}
+	response.Success(ctx, w, http.StatusCreated, key)
+}
+
+func (h *APIKey) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	key, err := h.authService.RevokeAPIKey(ctx, mux.Vars(r)["id"])
+	if err != nil {
+		response.Failed(ctx, w, errors.GetStatusCode(err), err)
+		return
+	}
+	response.Success(ctx, w, http.StatusOK, key)
+}
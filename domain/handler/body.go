@@ -0,0 +1,11 @@
+package handler
+
+import "github.com/isdzulqor/kraicklist/helper/errors"
+
+// bodyError keeps the body over the route limit as errors.ErrorPayloadTooLarge, the rest is an invalid param
+func bodyError(err error) error {
+	if errors.GetCodeFromError(err) == errors.PayloadTooLargeError {
+		return err
+	}
+	return errors.ErrorParamInvalid
+}
@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/isdzulqor/kraicklist/config"
+	"github.com/isdzulqor/kraicklist/domain/repository"
+	"github.com/isdzulqor/kraicklist/domain/service"
+	"github.com/isdzulqor/kraicklist/external/engine"
+	"github.com/isdzulqor/kraicklist/helper/errors"
+)
+
+// failedBody fails the read like the body over the route limit
+type failedBody struct {
+	err error
+}
+
+func (b failedBody) Read([]byte) (int, error) {
+	return 0, b.err
+}
+
+func TestIndexAdsBodyErrors(t *testing.T) {
+	ctx := context.Background()
+	conf := &config.Config{}
+	backend, err := engine.Open(ctx, "memory", conf, engine.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	h := InitAdvertisement(conf, service.InitAdvertisement(repository.InitAdvertisement(backend)), nil)
+
+	tests := []struct {
+		name       string
+		body       io.Reader
+		wantStatus int
+		wantCode   string
+	}{
+		{
+			name:       "body over the limit",
+			body:       failedBody{err: errors.ErrorPayloadTooLarge.AppendMessage("request body must be up to 8 bytes")},
+			wantStatus: http.StatusRequestEntityTooLarge,
+			wantCode:   errors.PayloadTooLargeError,
+		},
+		{
+			name:       "broken body",
+			body:       failedBody{err: io.ErrUnexpectedEOF},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   errors.GetCodeFromError(errors.ErrorParamInvalid),
+		},
+		{
+			name:       "malformed json",
+			body:       strings.NewReader(`[{"id":`),
+			wantStatus: http.StatusBadRequest,
+			wantCode:   errors.GetCodeFromError(errors.ErrorParamInvalid),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			h.IndexAds(recorder, httptest.NewRequest(http.MethodPost, "/api/advertisement/index", tt.body))
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+			if !strings.Contains(recorder.Body.String(), `"code":"`+tt.wantCode+`"`) {
+				t.Errorf("body = %s, want code %s", recorder.Body, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/isdzulqor/kraicklist/domain/model"
+	"github.com/isdzulqor/kraicklist/helper/errors"
+	"github.com/isdzulqor/kraicklist/helper/logging"
+	"github.com/isdzulqor/kraicklist/helper/response"
+)
+
+const (
+	eventStreamContentType = "text/event-stream"
+	lastEventIDHeader      = "Last-Event-ID"
+
+	defaultChangesLimit = 100
+	// a comment is sent on the idle stream to keep the proxies from closing it
+	changesHeartbeat = 15 * time.Second
+)
+
+// GetChanges lists the ad changes after the since seq as json, it long-polls when wait is set.
+// The changes are streamed as server-sent events when the client accepts text/event-stream
+func (h *Advertisement) GetChanges(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	since, err := parseSince(r)
+	if err != nil {
+		response.Failed(ctx, w, errors.GetStatusCode(err), err)
+		return
+	}
+	limit := defaultChangesLimit
+	if value := r.FormValue("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 || limit > h.conf.Changes.MaxLimit {
+			err = errors.ErrorParamInvalid.AppendMessage(
+				fmt.Sprintf("limit param must be a positive number up to %d.", h.conf.Changes.MaxLimit))
+			response.Failed(ctx, w, errors.GetStatusCode(err), err)
+			return
+		}
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), eventStreamContentType) {
+		h.streamChanges(w, r, since, limit)
+		return
+	}
+
+	var wait time.Duration
+	if value := r.FormValue("wait"); value != "" {
+		if wait, err = time.ParseDuration(value); err != nil || wait < 0 || wait > h.conf.Changes.MaxWait {
+			err = errors.ErrorParamInvalid.AppendMessage(
+				fmt.Sprintf("wait param must be a duration up to %s, i.e: 30s.", h.conf.Changes.MaxWait))
+			response.Failed(ctx, w, errors.GetStatusCode(err), err)
+			return
+		}
+	}
+
+	feed, err := h.adService.GetChanges(ctx, since, limit, wait)
+	if err != nil {
+		response.Failed(ctx, w, errors.GetStatusCode(err), err)
+		return
+	}
+	response.Success(ctx, w, http.StatusOK, feed)
+}
+
+// parseSince reads the since param, or the Last-Event-ID header of the reconnecting event source
+func parseSince(r *http.Request) (uint64, error) {
+	value := r.FormValue("since")
+	if value == "" {
+		value = r.Header.Get(lastEventIDHeader)
+	}
+	if value == "" {
+		return 0, nil
+	}
+	since, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, errors.ErrorParamInvalid.AppendMessage("since param must be a sequence number.")
+	}
+	return since, nil
+}
+
+// streamChanges sends every change as an event whose id is its seq until the client is gone.
+// The stream is ended before SERVER_STREAM_TIMEOUT, the event source reconnects from the Last-Event-ID
+func (h *Advertisement) streamChanges(w http.ResponseWriter, r *http.Request, since uint64, limit int) {
+	ctx := r.Context()
+
+	var deadline time.Time
+	if h.conf.Server.StreamTimeout > 0 {
+		deadline = time.Now().Add(h.conf.Server.StreamTimeout - changesHeartbeat - time.Second)
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		err := errors.ErrorInternalServer.AppendMessage("streaming is not supported")
+		response.Failed(ctx, w, errors.GetStatusCode(err), err)
+		return
+	}
+
+	// the first page is read before the headers, so the errors are still sent as json
+	feed, err := h.adService.GetChanges(ctx, since, limit, 0)
+	if err != nil {
+		response.Failed(ctx, w, errors.GetStatusCode(err), err)
+		return
+	}
+	w.Header().Set("Content-Type", eventStreamContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		if err = writeChangeEvents(w, feed.Changes); err != nil {
+			logging.DebugContext(ctx, "change stream is closed, err: %v", err)
+			return
+		}
+		flusher.Flush()
+		if ctx.Err() != nil || (!deadline.IsZero() && time.Now().After(deadline)) {
+			return
+		}
+
+		since = feed.LastSeq
+		if feed, err = h.adService.GetChanges(ctx, since, limit, changesHeartbeat); err != nil {
+			logging.WarnContext(ctx, "change stream is stopped, err: %v", err)
+			data, _ := json.Marshal(map[string]string{"code": errors.GetCodeFromError(err), "message": errors.GetMessageOnly(err).Error()})
+			fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+			return
+		}
+	}
+}
+
+func writeChangeEvents(w http.ResponseWriter, changes []model.AdChange) error {
+	if len(changes) == 0 {
+		_, err := fmt.Fprint(w, ": heartbeat\n\n")
+		return err
+	}
+	for _, change := range changes {
+		data, err := json.Marshal(change)
+		if err != nil {
+			return err
+		}
+		if _, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", change.Seq, change.Op, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/isdzulqor/kraicklist/domain/service"
+	"github.com/isdzulqor/kraicklist/helper/errors"
+	"github.com/isdzulqor/kraicklist/helper/response"
+
+	"github.com/gorilla/mux"
+)
+
+type Job struct {
+	jobService *service.Job
+}
+
+func InitJob(jobService *service.Job) *Job {
+	return &Job{
+		jobService: jobService,
+	}
+}
+
+func (h *Job) GetJob(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	job, err := h.jobService.GetJob(ctx, mux.Vars(r)["id"])
+	if err != nil {
+		response.Failed(ctx, w, errors.GetStatusCode(err), err)
+		return
+	}
+	response.Success(ctx, w, http.StatusOK, job)
+}
+
+func (h *Job) CancelJob(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	job, err := h.jobService.CancelJob(ctx, mux.Vars(r)["id"])
+	if err != nil {
+		response.Failed(ctx, w, errors.GetStatusCode(err), err)
+		return
+	}
+	response.Success(ctx, w, http.StatusAccepted, job)
+}
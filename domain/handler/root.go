@@ -4,5 +4,10 @@ import "github.com/isdzulqor/kraicklist/helper/health"
 
 type Root struct {
 	Advertisement *Advertisement
+	Job           *Job
+	Admin         *Admin
+	Tenant        *Tenant
+	APIKey        *APIKey
+	Usage         *Usage
 	Health        *health.HealthHandler
 }
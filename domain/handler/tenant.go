@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/isdzulqor/kraicklist/domain/service"
+	"github.com/isdzulqor/kraicklist/helper/errors"
+	"github.com/isdzulqor/kraicklist/helper/logging"
+	"github.com/isdzulqor/kraicklist/helper/response"
+
+	"github.com/gorilla/mux"
+)
+
+type Tenant struct {
+	tenantService *service.Tenant
+}
+
+func InitTenant(tenantService *service.Tenant) *Tenant {
+	return &Tenant{
+		tenantService: tenantService,
+	}
+}
+
+func (h *Tenant) GetTenants(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	tenants, err := h.tenantService.GetTenants(ctx)
+	if err != nil {
+		response.Failed(ctx, w, errors.GetStatusCode(err), err)
+		return
+	}
+	response.Success(ctx, w, http.StatusOK, tenants)
+}
+
+func (h *Tenant) CreateTenant(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var requestData struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		logging.DebugContext(ctx, "failed to decode body param err: %v", err)
+		err = bodyError(err)
+		response.Failed(ctx, w, errors.GetStatusCode(err), err)
+		return
+	}
+
+	tenant, err := h.tenantService.CreateTenant(ctx, requestData.Name)
+	if err != nil {
+		response.Failed(ctx, w, errors.GetStatusCode(err), err)
+		return
+	}
+	response.Success(ctx, w, http.StatusCreated, tenant)
+}
+
+func (h *Tenant) DropTenant(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	tenant, err := h.tenantService.DropTenant(ctx, mux.Vars(r)["name"])
+	if err != nil {
+		response.Failed(ctx, w, errors.GetStatusCode(err), err)
+		return
+	}
+	response.Success(ctx, w, http.StatusOK, tenant)
+}
@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/isdzulqor/kraicklist/domain/service"
+	"github.com/isdzulqor/kraicklist/helper/errors"
+	"github.com/isdzulqor/kraicklist/helper/response"
+)
+
+type Usage struct {
+	rateLimit *service.RateLimit
+}
+
+func InitUsage(rateLimit *service.RateLimit) *Usage {
+	return &Usage{
+		rateLimit: rateLimit,
+	}
+}
+
+// GetUsages lists the daily usage between the from and to dates, optionally of a client,
+// i.e: ?from=2021-01-01&to=2021-01-31&client=key:3f2a9c1d0b4e5f67
+func (h *Usage) GetUsages(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	usages, err := h.rateLimit.GetUsages(ctx, r.FormValue("from"), r.FormValue("to"), r.FormValue("client"))
+	if err != nil {
+		response.Failed(ctx, w, errors.GetStatusCode(err), err)
+		return
+	}
+	response.Success(ctx, w, http.StatusOK, usages)
+}
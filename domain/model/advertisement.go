@@ -1,9 +1,14 @@
 package model
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 
 	"github.com/isdzulqor/kraicklist/external/index"
+	"github.com/isdzulqor/kraicklist/external/store"
+	"github.com/isdzulqor/kraicklist/helper/errors"
 )
 
 type Advertisement struct {
@@ -16,8 +21,143 @@ type Advertisement struct {
 	ImageURLs interface{} `json:"image_urls"` // TODO: revise to slices, needs to sanitize when indexing
 }
 
+// TagList returns the tags as a list of string
+func (ad Advertisement) TagList() []string {
+	return toStringList(ad.Tags)
+}
+
+// Normalize turns single value tags and image_urls into lists,
+// bleve stored fields return an array with one element as a plain value
+func (ad *Advertisement) Normalize() {
+	if tag, ok := ad.Tags.(string); ok {
+		ad.Tags = []string{tag}
+	}
+	if imageURL, ok := ad.ImageURLs.(string); ok {
+		ad.ImageURLs = []string{imageURL}
+	}
+}
+
+// Checksum hashes the ad content regardless of the indexer it's read from,
+// empty and missing lists are considered equal as bleve doesn't store empty arrays
+func (ad Advertisement) Checksum() string {
+	canonical := ad
+	canonical.Tags = toStringList(ad.Tags)
+	canonical.ImageURLs = toStringList(ad.ImageURLs)
+	data, _ := json.Marshal(canonical)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func toStringList(value interface{}) (out []string) {
+	switch v := value.(type) {
+	case string:
+		out = []string{v}
+	case []string:
+		out = v
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+	}
+	return
+}
+
 type Advertisements []Advertisement
 
+const (
+	SortRelevance = "relevance"
+	SortNewest    = "newest"
+	SortOldest    = "oldest"
+)
+
+// AdSearchQuery is a search keyword along with the filter, sort and number of results,
+// zero Size falls back to the indexer default
+type AdSearchQuery struct {
+	Keyword string
+	Filter  AdFilter
+	Sort    string
+	Size    int
+}
+
+// AdSearchHit is a matched ad along with its relevance score
+// SearchStatus reports how a search is served, Degraded is set when it's served by the failover replica
+// and Sources lists the status of each federated source
+type SearchStatus struct {
+	Degraded bool
+	Reason   string
+
+	Sources []SearchSourceStatus
+	// Partial is set when some of the federated sources are failed
+	Partial bool
+}
+
+const (
+	SearchSourceOK      = "ok"
+	SearchSourceFailed  = "failed"
+	SearchSourceTimeout = "timeout"
+)
+
+// SearchSourceStatus is the result of a federated source, Hits is the number of its hits before the merge
+type SearchSourceStatus struct {
+	Source    string  `json:"source"`
+	Status    string  `json:"status"`
+	Hits      int     `json:"hits"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type AdSearchHit struct {
+	Advertisement
+	Score float64 `json:"score"`
+}
+
+type AdSearchHits []AdSearchHit
+
+func (hits AdSearchHits) Ads() (out Advertisements) {
+	for _, hit := range hits {
+		out = append(out, hit.Advertisement)
+	}
+	return
+}
+
+// AdFilter narrows ads down by tags and updated_at, zero values are not filtered
+type AdFilter struct {
+	// Tags matches ads having any of the tags
+	Tags []string `json:"tags,omitempty"`
+
+	// UpdatedSince and UpdatedUntil are inclusive unix timestamps
+	UpdatedSince int64 `json:"updated_since,omitempty"`
+	UpdatedUntil int64 `json:"updated_until,omitempty"`
+}
+
+// IsEmpty reports whether the filter passes every ad
+func (f AdFilter) IsEmpty() bool {
+	return len(f.Tags) == 0 && f.UpdatedSince == 0 && f.UpdatedUntil == 0
+}
+
+// Match reports whether the ad passes the filter
+func (f AdFilter) Match(ad Advertisement) bool {
+	if f.UpdatedSince > 0 && ad.UpdatedAt < f.UpdatedSince {
+		return false
+	}
+	if f.UpdatedUntil > 0 && ad.UpdatedAt > f.UpdatedUntil {
+		return false
+	}
+	if len(f.Tags) == 0 {
+		return true
+	}
+	for _, tag := range ad.TagList() {
+		for _, want := range f.Tags {
+			if tag == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (ads Advertisements) ToBleveDocs() (out index.BleveDocs, err error) {
 	if len(ads) == 0 {
 		err = fmt.Errorf("no ads to be converted to bleve docs")
@@ -45,3 +185,122 @@ func (ads Advertisements) ToElasticDocs() (out index.ElasticDocs, err error) {
 	}
 	return
 }
+
+func (ads Advertisements) ToMemoryDocs() (out index.MemoryDocs, err error) {
+	if len(ads) == 0 {
+		err = fmt.Errorf("no ads to be converted to memory docs")
+		return
+	}
+	for _, ad := range ads {
+		out = append(out, index.MemoryDoc{
+			ID:   fmt.Sprint(ad.ID),
+			Data: ad,
+		})
+	}
+	return
+}
+
+func (ads Advertisements) ToSQLiteDocs() (out index.SQLiteDocs, err error) {
+	if len(ads) == 0 {
+		err = fmt.Errorf("no ads to be converted to sqlite docs")
+		return
+	}
+	for _, ad := range ads {
+		out = append(out, index.SQLiteDoc{
+			ID:   fmt.Sprint(ad.ID),
+			Data: ad,
+		})
+	}
+	return
+}
+
+func (ads Advertisements) ToStoreDocs() (out []store.Doc, err error) {
+	for _, ad := range ads {
+		source, err := json.Marshal(ad)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode ad %d, err: %v", ad.ID, err)
+		}
+		out = append(out, store.Doc{ID: ad.ID, Source: source})
+	}
+	return
+}
+
+// NewAdsFromStoreDocs decodes the stored docs
+func NewAdsFromStoreDocs(docs []store.Doc) (out Advertisements, err error) {
+	out = make(Advertisements, 0, len(docs))
+	for _, doc := range docs {
+		var ad Advertisement
+		if err = json.Unmarshal(doc.Source, &ad); err != nil {
+			err = fmt.Errorf("failed to decode stored ad %d, err: %v", doc.ID, err)
+			return
+		}
+		out = append(out, ad)
+	}
+	return
+}
+
+const (
+	IndexStatusIndexed = "indexed"
+	IndexStatusFailed  = "failed"
+)
+
+// AdIndexStatus represents the indexing result of a single ad
+type AdIndexStatus struct {
+	ID     int64  `json:"id"`
+	Status string `json:"status"` // indexed | failed
+	Reason string `json:"reason,omitempty"`
+}
+
+type AdIndexStatuses []AdIndexStatus
+
+// NewAdIndexStatuses constructs indexing result of each ad,
+// ads which are listed on docErrors will be marked as failed
+func NewAdIndexStatuses(ads Advertisements, docErrors errors.DocErrors) (out AdIndexStatuses) {
+	reasons := docErrors.Reasons()
+	out = make(AdIndexStatuses, 0, len(ads))
+	for _, ad := range ads {
+		status := AdIndexStatus{
+			ID:     ad.ID,
+			Status: IndexStatusIndexed,
+		}
+		if reason, failed := reasons[fmt.Sprint(ad.ID)]; failed {
+			status.Status = IndexStatusFailed
+			status.Reason = reason
+		}
+		out = append(out, status)
+	}
+	return
+}
+
+func (statuses AdIndexStatuses) CountFailed() (count int) {
+	for _, status := range statuses {
+		if status.Status == IndexStatusFailed {
+			count++
+		}
+	}
+	return
+}
+
+// AdLineStatus represents the indexing result of a single line on bulk ndjson upload
+type AdLineStatus struct {
+	Line   int    `json:"line"`
+	ID     int64  `json:"id,omitempty"`
+	Status string `json:"status"` // indexed | failed
+	Reason string `json:"reason,omitempty"`
+}
+
+// BulkIndexSummary is the final counts of bulk ndjson upload
+type BulkIndexSummary struct {
+	Total   int `json:"total"`
+	Indexed int `json:"indexed"`
+	Failed  int `json:"failed"`
+}
+
+func (s *BulkIndexSummary) Add(status AdLineStatus) {
+	s.Total++
+	if status.Status == IndexStatusFailed {
+		s.Failed++
+		return
+	}
+	s.Indexed++
+}
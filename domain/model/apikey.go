@@ -0,0 +1,57 @@
+package model
+
+import "time"
+
+const (
+	ScopeSearch = "search"
+	ScopeIndex  = "index"
+	ScopeAdmin  = "admin"
+)
+
+// Scopes lists the scopes an API key could carry
+var Scopes = []string{ScopeSearch, ScopeIndex, ScopeAdmin}
+
+func IsValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKey is an issued key, only the hash of its secret is kept. The key without a tenant is for all tenants
+type APIKey struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	Tenant    string     `json:"tenant,omitempty"`
+	Hash      string     `json:"-"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	// RotatedTo is the ID of the key replacing this one, it expires after the rotation grace period
+	RotatedTo string `json:"rotated_to,omitempty"`
+}
+
+type APIKeys []APIKey
+
+func (k APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// IsActive reports the key is neither revoked nor expired at the time
+func (k APIKey) IsActive(at time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || at.Before(*k.ExpiresAt))
+}
+
+// IssuedAPIKey is the key along with its secret, the secret is only shown once
+type IssuedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
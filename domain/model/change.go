@@ -0,0 +1,30 @@
+package model
+
+import (
+	"time"
+
+	"github.com/isdzulqor/kraicklist/external/store"
+)
+
+const (
+	AdChangeUpsert = store.ChangeUpsert
+	AdChangeDelete = store.ChangeDelete
+)
+
+// AdChange is an upsert or a delete of an ad on the change feed. Ad is the current stored ad,
+// so it may be newer than the change and it's empty when the ad is deleted later on
+type AdChange struct {
+	Seq uint64         `json:"seq"`
+	Op  string         `json:"op"`
+	ID  int64          `json:"id"`
+	At  time.Time      `json:"at"`
+	Ad  *Advertisement `json:"ad,omitempty"`
+}
+
+// AdChangeFeed is a page of the change feed, LastSeq is the since of the next page
+type AdChangeFeed struct {
+	Changes  []AdChange `json:"changes"`
+	FirstSeq uint64     `json:"first_seq"`
+	LastSeq  uint64     `json:"last_seq"`
+	HasMore  bool       `json:"has_more"`
+}
@@ -0,0 +1,31 @@
+package model
+
+import "time"
+
+const (
+	FailoverEventFailover = "failover"
+	FailoverEventRecovery = "recovery"
+)
+
+// FailoverEvent is a switch between the primary and the replica
+type FailoverEvent struct {
+	Type   string    `json:"type"`
+	Reason string    `json:"reason"`
+	At     time.Time `json:"at"`
+}
+
+// FailoverStatus reports the current state and the counters of the failover mode, Events lists the latest first
+type FailoverStatus struct {
+	Primary  string     `json:"primary"`
+	Replica  string     `json:"replica"`
+	Degraded bool       `json:"degraded"`
+	Reason   string     `json:"reason,omitempty"`
+	Since    *time.Time `json:"since,omitempty"`
+
+	Failovers          int64 `json:"failovers"`
+	Recoveries         int64 `json:"recoveries"`
+	DegradedSearches   int64 `json:"degraded_searches"`
+	ReplicaWriteErrors int64 `json:"replica_write_errors"`
+
+	Events []FailoverEvent `json:"events"`
+}
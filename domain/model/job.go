@@ -0,0 +1,98 @@
+package model
+
+import "time"
+
+const (
+	JobStatusQueued    = "queued"
+	JobStatusRunning   = "running"
+	JobStatusSucceeded = "succeeded"
+	JobStatusFailed    = "failed"
+	JobStatusCancelled = "cancelled"
+
+	JobTypeIndexAds = "index_ads"
+
+	// JobMaxErrors caps the doc errors kept on the job, Failed still counts all of them
+	JobMaxErrors = 100
+)
+
+// Job represents an asynchronous indexing job.
+// Processed is the offset of the payload which has been indexed, it's used to resume the job
+type Job struct {
+	ID        string        `json:"id"`
+	Type      string        `json:"type"`
+	Status    string        `json:"status"`
+	Total     int           `json:"total"`
+	Processed int           `json:"processed"`
+	Indexed   int           `json:"indexed"`
+	Failed    int           `json:"failed"`
+	Progress  float64       `json:"progress"`         // percentage of processed docs
+	Errors    []JobDocError `json:"errors,omitempty"` // the first JobMaxErrors failed docs
+	Error     string        `json:"error,omitempty"`
+	CreatedAt time.Time     `json:"created_at"`
+	StartedAt *time.Time    `json:"started_at,omitempty"`
+	EndedAt   *time.Time    `json:"ended_at,omitempty"`
+}
+
+type JobDocError struct {
+	ID     int64  `json:"id"`
+	Reason string `json:"reason"`
+}
+
+type Jobs []Job
+
+func NewJob(id, jobType string, total int) Job {
+	return Job{
+		ID:        id,
+		Type:      jobType,
+		Status:    JobStatusQueued,
+		Total:     total,
+		CreatedAt: time.Now(),
+	}
+}
+
+// IsFinished tells whether the job reaches its final status
+func (j Job) IsFinished() bool {
+	switch j.Status {
+	case JobStatusSucceeded, JobStatusFailed, JobStatusCancelled:
+		return true
+	}
+	return false
+}
+
+// AddStatuses accumulates indexing statuses of a processed batch
+func (j *Job) AddStatuses(statuses AdIndexStatuses) {
+	for _, status := range statuses {
+		j.Processed++
+		if status.Status == IndexStatusFailed {
+			j.Failed++
+			if len(j.Errors) < JobMaxErrors {
+				j.Errors = append(j.Errors, JobDocError{
+					ID:     status.ID,
+					Reason: status.Reason,
+				})
+			}
+			continue
+		}
+		j.Indexed++
+	}
+	if j.Total > 0 {
+		j.Progress = float64(j.Processed) * 100 / float64(j.Total)
+	}
+}
+
+func (j *Job) Start() {
+	now := time.Now()
+	j.Status = JobStatusRunning
+	if j.StartedAt == nil {
+		j.StartedAt = &now
+	}
+}
+
+func (j *Job) End(status string, err error) {
+	now := time.Now()
+	j.Status = status
+	j.EndedAt = &now
+	if err != nil {
+		j.Error = err.Error()
+	}
+}
@@ -0,0 +1,57 @@
+package model
+
+import "time"
+
+// ShadowComparison compares the top hits of a search on the primary and the secondary indexer
+type ShadowComparison struct {
+	Keyword string   `json:"keyword"`
+	Filter  AdFilter `json:"filter"`
+	Sort    string   `json:"sort,omitempty"`
+
+	PrimaryIDs   []int64 `json:"primary_ids"`
+	SecondaryIDs []int64 `json:"secondary_ids"`
+
+	// Jaccard is the overlap of the top hits, 1 when both are the same set or both are empty
+	Jaccard float64 `json:"jaccard"`
+	// RankCorrelation is the spearman correlation of the ranks of the common hits,
+	// it's nil when there are less than 2 common hits
+	RankCorrelation *float64 `json:"rank_correlation"`
+
+	PrimaryLatencyMs   float64 `json:"primary_latency_ms"`
+	SecondaryLatencyMs float64 `json:"secondary_latency_ms"`
+	// LatencyDiffMs is the secondary latency minus the primary latency
+	LatencyDiffMs float64 `json:"latency_diff_ms"`
+
+	Error      string    `json:"error,omitempty"`
+	ComparedAt time.Time `json:"compared_at"`
+}
+
+// Divergence scores how different the results are, from 0 for identical to 2 for disjoint
+func (c ShadowComparison) Divergence() float64 {
+	out := 1 - c.Jaccard
+	if c.RankCorrelation != nil {
+		out += (1 - *c.RankCorrelation) / 2
+	} else if c.Jaccard < 1 {
+		out++
+	}
+	return out
+}
+
+// ShadowReport summarizes the comparisons, Divergent lists the most divergent retained queries first
+type ShadowReport struct {
+	Primary   string `json:"primary"`
+	Secondary string `json:"secondary"`
+	TopK      int    `json:"top_k"`
+
+	Compared        int64 `json:"compared"`
+	Skipped         int64 `json:"skipped"`
+	Failed          int64 `json:"failed"`
+	SecondaryWrites int64 `json:"secondary_writes"`
+	WriteErrors     int64 `json:"write_errors"`
+
+	MeanJaccard         float64 `json:"mean_jaccard"`
+	MeanRankCorrelation float64 `json:"mean_rank_correlation"`
+	MeanLatencyDiffMs   float64 `json:"mean_latency_diff_ms"`
+
+	Divergent []ShadowComparison `json:"divergent"`
+}
@@ -0,0 +1,29 @@
+package model
+
+// IndexStats is the backend neutral statistics of the ads index
+type IndexStats struct {
+	Indexer       string `json:"indexer"`
+	Index         string `json:"index"`
+	DocCount      int64  `json:"doc_count"`
+	DiskSizeBytes int64  `json:"disk_size_bytes"`
+
+	// Segments is omitted when the index type doesn't have segments, i.e: bleve upside_down
+	Segments *int64 `json:"segments,omitempty"`
+
+	// TopTerms are the most frequent terms of the title
+	TopTerms       []TermCount `json:"top_terms"`
+	TopTags        []TermCount `json:"top_tags"`
+	TagCardinality int64       `json:"tag_cardinality"`
+
+	OldestUpdatedAt int64 `json:"oldest_updated_at"`
+	NewestUpdatedAt int64 `json:"newest_updated_at"`
+
+	// Warnings lists the stats those couldn't be gathered
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// TermCount is a term along with the number of ads having it
+type TermCount struct {
+	Term  string `json:"term"`
+	Count int64  `json:"count"`
+}
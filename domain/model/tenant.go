@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+// Tenant is a marketplace whose ads are on its own indices, store and jobs
+type Tenant struct {
+	Name      string    `json:"name"`
+	Indexer   string    `json:"indexer"`
+	IndexName string    `json:"index_name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Tenants []Tenant
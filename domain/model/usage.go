@@ -0,0 +1,28 @@
+package model
+
+import "time"
+
+// UsageDateLayout is the day of the usage counters, on UTC
+const UsageDateLayout = "2006-01-02"
+
+// Usage is the requests of a client on a budget in a day. The client is the api key, i.e: key:3f2a9c1d0b4e5f67,
+// or the ip of the requests without a key, i.e: ip:10.0.0.1
+type Usage struct {
+	Date     string `json:"date"`
+	Client   string `json:"client"`
+	Budget   string `json:"budget"`
+	Requests int64  `json:"requests"`
+	Rejected int64  `json:"rejected"`
+}
+
+type Usages []Usage
+
+// RateLimitDecision is the state of the client bucket after taking a request
+type RateLimitDecision struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is the wait until the bucket is full, RetryAfter is the wait until the next request is allowed
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
@@ -2,76 +2,139 @@ package repository
 
 import (
 	"context"
-	"fmt"
+	"sync"
 
-	"github.com/isdzulqor/kraicklist/config"
 	"github.com/isdzulqor/kraicklist/domain/model"
-	"github.com/isdzulqor/kraicklist/external/index"
+	"github.com/isdzulqor/kraicklist/external/engine"
+	"github.com/isdzulqor/kraicklist/external/store"
+	"github.com/isdzulqor/kraicklist/helper/errors"
 )
 
 type Advertisement struct {
-	conf *config.Config
+	backend engine.SearchBackend
 
-	bleveIndex *index.BleveIndex
-	esIndex    *index.ElasticIndex
+	// store is the system of record when it's set, the writes are committed there first
+	store  *store.BoltStore
+	syncMu sync.Mutex
 }
 
-func InitAdvertisement(conf *config.Config, bleveIndex *index.BleveIndex, esIndex *index.ElasticIndex) *Advertisement {
+func InitAdvertisement(backend engine.SearchBackend) *Advertisement {
 	return &Advertisement{
-		conf:       conf,
-		bleveIndex: bleveIndex,
-		esIndex:    esIndex,
+		backend: backend,
 	}
 }
 
-func (ad *Advertisement) SearchAds(ctx context.Context, query string) (out model.Advertisements, err error) {
-	if ad.conf.IndexerActivated == index.IndexElastic {
-		esQuery := index.ElasticRootQuery{}
-		esQuery.ConstructElasticMultiMatchQuery(query, "title", "content", "tags")
-		if _, err = ad.esIndex.SearchQuery(ctx, esQuery, &out); err != nil {
-			// TODO: error handler
-		}
-		return
+// SearchAds searches ads by the keyword, hits are ordered by the relevance score unless other sort is given.
+// The status reports whether the hits are served by the failover replica
+func (ad *Advertisement) SearchAds(ctx context.Context, query model.AdSearchQuery) (hits model.AdSearchHits,
+	status model.SearchStatus, err error) {
+	ctx, searchStatus := engine.WithSearchStatus(ctx)
+	hits, err = ad.backend.Search(ctx, query)
+	status = *searchStatus
+	return
+}
+
+// IndexAds creates or replaces the ads, they're committed on the store first then the index is synced from it.
+// errors.DocErrors is returned when some of them are failed to be indexed
+func (ad *Advertisement) IndexAds(ctx context.Context, in model.Advertisements) error {
+	if ad.store == nil {
+		return ad.backend.Index(ctx, in)
+	}
+	docs, err := in.ToStoreDocs()
+	if err != nil {
+		return err
+	}
+	ids := make([]int64, 0, len(in))
+	for _, doc := range in {
+		ids = append(ids, doc.ID)
 	}
+	return ad.writeAndSync(ctx, ids, func() error {
+		return ad.store.Put(docs)
+	})
+}
 
-	if _, err = ad.bleveIndex.SearchQuery(ctx, query, &out); err != nil {
-		// TODO: error handler
+// GetUpdatedAts returns updated_at of the indexed ads by their IDs, ads which are not indexed yet are not listed
+func (ad *Advertisement) GetUpdatedAts(ctx context.Context, ids []int64) (out map[int64]int64, err error) {
+	ads, err := ad.backend.Get(ctx, ids, "updated_at")
+	if err != nil {
+		return
+	}
+	out = make(map[int64]int64, len(ads))
+	for _, doc := range ads {
+		out[doc.ID] = doc.UpdatedAt
 	}
 	return
 }
 
-func (ad *Advertisement) IndexAds(ctx context.Context, in model.Advertisements) (err error) {
-	var (
-		elasticDocs index.ElasticDocs
-		bleveDocs   index.BleveDocs
-	)
-	// indexing using elastic
-	if ad.conf.IndexerActivated == index.IndexElastic {
-		if elasticDocs, err = in.ToElasticDocs(); err != nil {
-			err = fmt.Errorf("failed to convert to elasticDocs, err:%v", err)
-			return
-		}
-		// TODO: error handler
-		var errorElasticDocs *index.ElasticDocErrors
-		errorElasticDocs, err = ad.esIndex.BulkIndexDocs(ctx, elasticDocs)
-		if err != nil {
-			return
+// ScanIDs pages through IDs of all indexed ads
+func (ad *Advertisement) ScanIDs(ctx context.Context, pageSize int, fn func(ids []int64) error) error {
+	return ad.backend.Scan(ctx, model.AdFilter{}, pageSize, func(ads model.Advertisements) error {
+		ids := make([]int64, 0, len(ads))
+		for _, doc := range ads {
+			ids = append(ids, doc.ID)
 		}
-		if errorElasticDocs != nil {
-			err = errorElasticDocs.ToError()
-			return
+		return fn(ids)
+	}, "id")
+}
+
+// DeleteAds deletes the ads by their IDs, errors.DocErrors is returned when some of them are failed
+func (ad *Advertisement) DeleteAds(ctx context.Context, ids []int64) error {
+	if ad.store == nil {
+		return ad.backend.Delete(ctx, ids)
+	}
+	// the ads missing from the store may still be indexed, i.e: indexed before the store is enabled
+	stored, err := ad.store.Get(ids)
+	if err != nil {
+		return err
+	}
+	isStored := make(map[int64]bool, len(stored))
+	for _, doc := range stored {
+		isStored[doc.ID] = true
+	}
+	var unstored []int64
+	for _, id := range ids {
+		if !isStored[id] {
+			unstored = append(unstored, id)
 		}
-		return
 	}
 
-	// using bleve
-	if bleveDocs, err = in.ToBleveDocs(); err != nil {
-		err = fmt.Errorf("failed to convert to bleveDocs, err:%v", err)
-		return
+	err = ad.writeAndSync(ctx, ids, func() error {
+		return ad.store.Delete(ids)
+	})
+	if err != nil || len(unstored) == 0 {
+		return err
 	}
-	if errorDocs := ad.bleveIndex.BulkIndex(ctx, bleveDocs); errorDocs != nil {
-		err = errorDocs.ToError()
+	return ad.backend.Delete(ctx, unstored)
+}
+
+// ScanAds pages through all indexed ads passing the filter
+func (ad *Advertisement) ScanAds(ctx context.Context, filter model.AdFilter, pageSize int,
+	fn func(ads model.Advertisements) error) error {
+	return ad.backend.Scan(ctx, filter, pageSize, fn)
+}
+
+// GetAds returns the indexed ads by their IDs, ads which are not indexed are not listed
+func (ad *Advertisement) GetAds(ctx context.Context, ids []int64) (model.Advertisements, error) {
+	return ad.backend.Get(ctx, ids)
+}
+
+// CountAds returns the number of indexed ads, recent writes are counted
+func (ad *Advertisement) CountAds(ctx context.Context) (int64, error) {
+	return ad.backend.Count(ctx)
+}
+
+// GetStats gathers the statistics of the ads index, topN limits the top terms and tags
+func (ad *Advertisement) GetStats(ctx context.Context, topN int) (model.IndexStats, error) {
+	return ad.backend.Stats(ctx, topN)
+}
+
+// GetShadowReport reports the comparisons of the shadow mode, limit bounds the listed divergent queries
+func (ad *Advertisement) GetShadowReport(limit int) (out model.ShadowReport, err error) {
+	reporter, ok := ad.backend.(engine.ShadowReporter)
+	if !ok {
+		err = errors.ErrorNotFound.AppendMessage("shadow mode is disabled, set SHADOW_INDEXER")
 		return
 	}
+	out = reporter.ShadowReport(limit)
 	return
 }
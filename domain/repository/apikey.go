@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/isdzulqor/kraicklist/domain/model"
+	"github.com/isdzulqor/kraicklist/helper/errors"
+)
+
+// apiKeyRecord keeps the hash which is never listed on the responses
+type apiKeyRecord struct {
+	model.APIKey
+	Hash string `json:"hash"`
+}
+
+// APIKey persists the API keys as a json file. The keys are cached until the file is modified,
+// so the keys issued by the CLI are picked up by the running API
+type APIKey struct {
+	path string
+	mu   sync.Mutex
+
+	cached  model.APIKeys
+	modTime time.Time
+}
+
+func InitAPIKey(path string) *APIKey {
+	return &APIKey{
+		path: path,
+	}
+}
+
+func (r *APIKey) GetAPIKeys() (model.APIKeys, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.read()
+}
+
+func (r *APIKey) GetAPIKey(id string) (out model.APIKey, err error) {
+	keys, err := r.GetAPIKeys()
+	if err != nil {
+		return
+	}
+	for _, key := range keys {
+		if key.ID == id {
+			return key, nil
+		}
+	}
+	err = errors.ErrorNotFound.AppendMessage(fmt.Sprintf("api key %s is not found", id))
+	return
+}
+
+// SaveAPIKeys creates or replaces the keys by their IDs
+func (r *APIKey) SaveAPIKeys(keys ...model.APIKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, err := r.read()
+	if err != nil {
+		return err
+	}
+	out := append(model.APIKeys{}, existing...)
+	for _, key := range keys {
+		replaced := false
+		for i := range out {
+			if out[i].ID == key.ID {
+				out[i], replaced = key, true
+			}
+		}
+		if !replaced {
+			out = append(out, key)
+		}
+	}
+
+	records := make([]apiKeyRecord, 0, len(out))
+	for _, key := range out {
+		records = append(records, apiKeyRecord{APIKey: key, Hash: key.Hash})
+	}
+	if err = writeJSONFile(r.path, records); err != nil {
+		return fmt.Errorf("failed to write api keys %s, err: %v", r.path, err)
+	}
+	r.cached = nil
+	return nil
+}
+
+func (r *APIKey) read() (model.APIKeys, error) {
+	info, err := os.Stat(r.path)
+	if os.IsNotExist(err) {
+		return model.APIKeys{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read api keys %s, err: %v", r.path, err)
+	}
+	if r.cached != nil && info.ModTime().Equal(r.modTime) {
+		return r.cached, nil
+	}
+
+	content, err := ioutil.ReadFile(r.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read api keys %s, err: %v", r.path, err)
+	}
+	var records []apiKeyRecord
+	if err = json.Unmarshal(content, &records); err != nil {
+		return nil, fmt.Errorf("failed to decode api keys %s, err: %v", r.path, err)
+	}
+	keys := make(model.APIKeys, 0, len(records))
+	for _, record := range records {
+		key := record.APIKey
+		key.Hash = record.Hash
+		keys = append(keys, key)
+	}
+	r.cached, r.modTime = keys, info.ModTime()
+	return keys, nil
+}
@@ -0,0 +1,140 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/isdzulqor/kraicklist/domain/model"
+	"github.com/isdzulqor/kraicklist/helper/errors"
+	"github.com/isdzulqor/kraicklist/helper/files"
+	"github.com/isdzulqor/kraicklist/helper/logging"
+)
+
+const (
+	jobStateSuffix   = ".job.json"
+	jobPayloadSuffix = ".payload.json"
+	jobCorruptSuffix = ".corrupt"
+)
+
+// Job persists jobs state and their payload as json files on a directory,
+// so jobs could be resumed after the process restarted
+type Job struct {
+	dir string
+	mu  sync.RWMutex
+}
+
+func InitJob(dir string) (*Job, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create job dir %s, err: %v", dir, err)
+	}
+	return &Job{
+		dir: dir,
+	}, nil
+}
+
+func (r *Job) SaveJob(ctx context.Context, job model.Job) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.writeFile(job.ID+jobStateSuffix, job)
+}
+
+func (r *Job) GetJob(ctx context.Context, id string) (out model.Job, err error) {
+	// id comes from the request path, prevents reading files outside of the job dir
+	if id == "" || filepath.Base(id) != id || strings.HasPrefix(id, ".") {
+		err = errors.ErrorNotFound.AppendMessage("job is not found")
+		return
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	err = r.readFile(id+jobStateSuffix, &out)
+	return
+}
+
+// GetUnfinishedJobs lists jobs those are still queued or interrupted while running.
+// A corrupt job file is moved aside with the .corrupt suffix to be inspected, so it doesn't block the others
+func (r *Job) GetUnfinishedJobs(ctx context.Context) (out model.Jobs, err error) {
+	files, err := filepath.Glob(filepath.Join(r.dir, "*"+jobStateSuffix))
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, file := range files {
+		content, readErr := ioutil.ReadFile(file)
+		if os.IsNotExist(readErr) {
+			continue
+		}
+		if readErr != nil {
+			err = fmt.Errorf("failed to read %s, err: %v", filepath.Base(file), readErr)
+			return
+		}
+		var job model.Job
+		if decodeErr := json.Unmarshal(content, &job); decodeErr != nil {
+			logging.WarnContext(ctx, "job file %s is corrupt, it's moved to %s, err: %v",
+				file, file+jobCorruptSuffix, decodeErr)
+			if err = os.Rename(file, file+jobCorruptSuffix); err != nil {
+				err = fmt.Errorf("failed to move corrupt job file %s, err: %v", file, err)
+				return
+			}
+			continue
+		}
+		if !job.IsFinished() {
+			out = append(out, job)
+		}
+	}
+	return
+}
+
+func (r *Job) SavePayload(ctx context.Context, id string, ads model.Advertisements) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.writeFile(id+jobPayloadSuffix, ads)
+}
+
+func (r *Job) GetPayload(ctx context.Context, id string) (out model.Advertisements, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	err = r.readFile(id+jobPayloadSuffix, &out)
+	return
+}
+
+// DeletePayload removes the payload once the job is finished, the job state is kept for status polling
+func (r *Job) DeletePayload(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := os.Remove(filepath.Join(r.dir, id+jobPayloadSuffix)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete payload of job %s, err: %v", id, err)
+	}
+	return nil
+}
+
+func (r *Job) writeFile(name string, data interface{}) error {
+	content, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s, err: %v", name, err)
+	}
+	if err = files.WriteAtomic(filepath.Join(r.dir, name), content, 0644); err != nil {
+		return fmt.Errorf("failed to write %s, err: %v", name, err)
+	}
+	return nil
+}
+
+func (r *Job) readFile(name string, dest interface{}) error {
+	content, err := ioutil.ReadFile(filepath.Join(r.dir, name))
+	if os.IsNotExist(err) {
+		return errors.ErrorNotFound.AppendMessage("job is not found")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s, err: %v", name, err)
+	}
+	if err = json.Unmarshal(content, dest); err != nil {
+		return fmt.Errorf("failed to unmarshal %s, err: %v", name, err)
+	}
+	return nil
+}
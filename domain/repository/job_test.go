@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/isdzulqor/kraicklist/domain/model"
+)
+
+func TestGetUnfinishedJobsMovesCorruptJobsAside(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	jobRepo, err := InitJob(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued := model.NewJob("queued", model.JobTypeIndexAds, 1)
+	finished := model.NewJob("finished", model.JobTypeIndexAds, 1)
+	finished.End(model.JobStatusSucceeded, nil)
+	for _, job := range []model.Job{queued, finished} {
+		if err = jobRepo.SaveJob(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	corrupt := filepath.Join(dir, "corrupt"+jobStateSuffix)
+	if err = os.WriteFile(corrupt, []byte(`{"id":"corrupt","status":`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, err := jobRepo.GetUnfinishedJobs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].ID != queued.ID {
+		t.Fatalf("expected only the queued job, got %+v", jobs)
+	}
+	if _, err = os.Stat(corrupt + jobCorruptSuffix); err != nil {
+		t.Fatalf("expected the corrupt job to be moved aside, err: %v", err)
+	}
+	if _, err = os.Stat(corrupt); !os.IsNotExist(err) {
+		t.Fatalf("expected the corrupt job file to be gone, err: %v", err)
+	}
+}
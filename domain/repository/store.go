@@ -0,0 +1,321 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/isdzulqor/kraicklist/domain/model"
+	"github.com/isdzulqor/kraicklist/external/store"
+	"github.com/isdzulqor/kraicklist/helper/errors"
+	"github.com/isdzulqor/kraicklist/helper/logging"
+)
+
+// storeSyncBatchSize is the number of the changes applied to the index at once
+const storeSyncBatchSize = 500
+
+// WithStore makes the store the system of record of the ads, the index is updated from its changes
+func (ad *Advertisement) WithStore(adStore *store.BoltStore) *Advertisement {
+	ad.store = adStore
+	return ad
+}
+
+// checkpointName is the name of the index checkpoint on the store
+func (ad *Advertisement) checkpointName() string {
+	return ad.backend.Name() + ":" + ad.backend.IndexName()
+}
+
+// SyncIndex applies the store changes after the index checkpoint. The checkpoint moves on once the changes are
+// applied, the ads rejected by the index are returned as errors.DocErrors and kept pending on the store,
+// so they're applied again when they're put again even unchanged
+func (ad *Advertisement) SyncIndex(ctx context.Context) error {
+	if ad.store == nil {
+		return nil
+	}
+	ad.syncMu.Lock()
+	defer ad.syncMu.Unlock()
+	return ad.syncIndex(ctx)
+}
+
+// writeAndSync commits the write on the store then syncs the index under the same lock, so the changes of the write
+// are applied by this sync rather than a concurrent one. Only the failures of the written IDs are returned,
+// the failures of the other changes stay pending for their own writers
+func (ad *Advertisement) writeAndSync(ctx context.Context, ids []int64, write func() error) error {
+	ad.syncMu.Lock()
+	defer ad.syncMu.Unlock()
+	if err := write(); err != nil {
+		return err
+	}
+	docErrors, err := splitDocErrors(ad.syncIndex(ctx))
+	if err != nil {
+		return err
+	}
+	written := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		written[strconv.FormatInt(id, 10)] = true
+	}
+	var failed errors.DocErrors
+	for _, docErr := range docErrors {
+		if written[docErr.DocID] {
+			failed = append(failed, docErr)
+		}
+	}
+	return failed.ErrorOrNil()
+}
+
+// syncIndex is SyncIndex while syncMu is held
+func (ad *Advertisement) syncIndex(ctx context.Context) error {
+	checkpoint, err := ad.store.Checkpoint(ad.checkpointName())
+	if err != nil {
+		return err
+	}
+	firstSeq, err := ad.store.FirstSeq()
+	if err != nil {
+		return err
+	}
+	if checkpoint+1 < firstSeq {
+		return fmt.Errorf("index %s is behind the pruned changes, rebuild it with the reindex command", ad.checkpointName())
+	}
+	var docErrors errors.DocErrors
+	for {
+		changes, err := ad.store.Changes(checkpoint, storeSyncBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(changes) == 0 {
+			return docErrors.ErrorOrNil()
+		}
+
+		applied, failed, err := ad.applyChanges(ctx, changes)
+		if err != nil {
+			return err
+		}
+		docErrors = append(docErrors, failed...)
+
+		checkpoint = changes[len(changes)-1].Seq
+		if err = ad.store.CommitCheckpoint(ad.checkpointName(), checkpoint, applied, docErrorIDs(failed)); err != nil {
+			return err
+		}
+	}
+}
+
+// applyChanges indexes the current stored ads of the changes, ads missing from the store are deleted.
+// Only the failure of the whole index is returned as err
+func (ad *Advertisement) applyChanges(ctx context.Context, changes []store.Change) (ids []int64,
+	failed errors.DocErrors, err error) {
+	seen := make(map[int64]bool, len(changes))
+	ids = make([]int64, 0, len(changes))
+	for _, change := range changes {
+		if !seen[change.ID] {
+			seen[change.ID] = true
+			ids = append(ids, change.ID)
+		}
+	}
+
+	docs, err := ad.store.Get(ids)
+	if err != nil {
+		return
+	}
+	ads, err := model.NewAdsFromStoreDocs(docs)
+	if err != nil {
+		return
+	}
+	stored := make(map[int64]bool, len(ads))
+	for _, doc := range ads {
+		stored[doc.ID] = true
+	}
+	var deleted []int64
+	for _, id := range ids {
+		if !stored[id] {
+			deleted = append(deleted, id)
+		}
+	}
+
+	if len(ads) > 0 {
+		if failed, err = splitDocErrors(ad.backend.Index(ctx, ads)); err != nil {
+			return
+		}
+	}
+	if len(deleted) > 0 {
+		var failedDeletes errors.DocErrors
+		if failedDeletes, err = splitDocErrors(ad.backend.Delete(ctx, deleted)); err != nil {
+			return
+		}
+		failed = append(failed, failedDeletes...)
+	}
+	return
+}
+
+// docErrorIDs parses the IDs of the failed ads
+func docErrorIDs(docErrors errors.DocErrors) []int64 {
+	ids := make([]int64, 0, len(docErrors))
+	for _, docErr := range docErrors {
+		if id, err := strconv.ParseInt(docErr.DocID, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// splitDocErrors separates the failed docs from the failure of the whole operation
+func splitDocErrors(err error) (errors.DocErrors, error) {
+	if docErrors, ok := errors.AsDocErrors(err); ok {
+		return docErrors, nil
+	}
+	return nil, err
+}
+
+// ReindexFromStore indexes every stored ad then the changes written meanwhile, the index should be recreated first.
+// fn is called after every indexed page with the number of the indexed ads so far
+func (ad *Advertisement) ReindexFromStore(ctx context.Context, pageSize int,
+	fn func(indexed int, failed errors.DocErrors)) error {
+	if ad.store == nil {
+		return errors.ErrorNotFound.AppendMessage("store is disabled, set STORE_ENABLED=true")
+	}
+	ad.syncMu.Lock()
+	defer ad.syncMu.Unlock()
+
+	// the changes after this seq are applied by the sync after the scan
+	seq, err := ad.store.LastSeq()
+	if err != nil {
+		return err
+	}
+	if err = ad.store.ClearPending(ad.checkpointName()); err != nil {
+		return err
+	}
+	var rejected []int64
+	indexed := 0
+	err = ad.store.Scan(pageSize, func(docs []store.Doc) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		ads, err := model.NewAdsFromStoreDocs(docs)
+		if err != nil {
+			return err
+		}
+		failed, err := splitDocErrors(ad.backend.Index(ctx, ads))
+		if err != nil {
+			return err
+		}
+		indexed += len(ads) - len(failed)
+		rejected = append(rejected, docErrorIDs(failed)...)
+		fn(indexed, failed)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return ad.store.CommitCheckpoint(ad.checkpointName(), seq, nil, rejected)
+}
+
+// EnsureIndex rebuilds the index from the store when the index is empty while the store is not,
+// i.e: the index is recreated or lost
+func (ad *Advertisement) EnsureIndex(ctx context.Context, pageSize int) error {
+	if ad.store == nil {
+		return nil
+	}
+	stored, err := ad.store.Count()
+	if err != nil || stored == 0 {
+		return err
+	}
+	indexed, err := ad.backend.Count(ctx)
+	if err != nil || indexed > 0 {
+		return err
+	}
+
+	logging.InfoContext(ctx, "index %s is empty, rebuilding it from %d ads on the store...", ad.checkpointName(), stored)
+	start := time.Now()
+	var failed int
+	err = ad.ReindexFromStore(ctx, pageSize, func(indexed int, docErrors errors.DocErrors) {
+		failed += len(docErrors)
+	})
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		logging.WarnContext(ctx, "%d ads are failed to be indexed from the store", failed)
+	}
+	logging.InfoContext(ctx, "index %s is rebuilt in %s", ad.checkpointName(), time.Since(start).Round(time.Millisecond))
+	return ad.SyncIndex(ctx)
+}
+
+// GetChanges lists up to the limit of the changes after the since along with the stored ads.
+// It's failed with errors.ErrorGone when the changes after the since are already pruned
+func (ad *Advertisement) GetChanges(since uint64, limit int) (out model.AdChangeFeed, err error) {
+	if ad.store == nil {
+		err = errors.ErrorNotFound.AppendMessage("change feed needs the store, set STORE_ENABLED=true")
+		return
+	}
+	if out.FirstSeq, err = ad.store.FirstSeq(); err != nil {
+		return
+	}
+	if since+1 < out.FirstSeq {
+		out.LastSeq, err = ad.store.LastSeq()
+		gone := errors.ErrorGone.AppendMessage(fmt.Sprintf(
+			"changes before seq %d are pruned, resync all ads then continue from the last_seq", out.FirstSeq))
+		if err == nil {
+			err = gone.SetData(out)
+		}
+		return
+	}
+
+	// one more change tells whether there are more pages
+	changes, err := ad.store.Changes(since, limit+1)
+	if err != nil {
+		return
+	}
+	if out.HasMore = len(changes) > limit; out.HasMore {
+		changes = changes[:limit]
+	}
+	ids := make([]int64, 0, len(changes))
+	for _, change := range changes {
+		if change.Op == store.ChangeUpsert {
+			ids = append(ids, change.ID)
+		}
+	}
+	docs, err := ad.store.Get(ids)
+	if err != nil {
+		return
+	}
+	ads, err := model.NewAdsFromStoreDocs(docs)
+	if err != nil {
+		return
+	}
+	byID := make(map[int64]*model.Advertisement, len(ads))
+	for i := range ads {
+		byID[ads[i].ID] = &ads[i]
+	}
+
+	out.Changes = make([]model.AdChange, 0, len(changes))
+	out.LastSeq = since
+	for _, change := range changes {
+		adChange := model.AdChange{Seq: change.Seq, Op: change.Op, ID: change.ID, At: change.At}
+		if change.Op == store.ChangeUpsert {
+			adChange.Ad = byID[change.ID]
+		}
+		out.Changes = append(out.Changes, adChange)
+		out.LastSeq = change.Seq
+	}
+	return
+}
+
+// WatchChanges returns a channel which is closed on the next change, nil without the store
+func (ad *Advertisement) WatchChanges() <-chan struct{} {
+	if ad.store == nil {
+		return nil
+	}
+	return ad.store.Watch()
+}
+
+// PruneChanges deletes the changes committed before the time, the changes not applied to the index yet are kept
+func (ad *Advertisement) PruneChanges(before time.Time) (int, error) {
+	if ad.store == nil {
+		return 0, nil
+	}
+	checkpoint, err := ad.store.Checkpoint(ad.checkpointName())
+	if err != nil {
+		return 0, err
+	}
+	return ad.store.Prune(before, checkpoint)
+}
@@ -0,0 +1,135 @@
+package repository
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/isdzulqor/kraicklist/config"
+	"github.com/isdzulqor/kraicklist/domain/model"
+	"github.com/isdzulqor/kraicklist/external/engine"
+	"github.com/isdzulqor/kraicklist/external/store"
+	"github.com/isdzulqor/kraicklist/helper/errors"
+)
+
+// rejectingBackend rejects the ads of the IDs while they're listed
+type rejectingBackend struct {
+	engine.SearchBackend
+	rejected map[int64]bool
+}
+
+func (b *rejectingBackend) Index(ctx context.Context, ads model.Advertisements) error {
+	var accepted model.Advertisements
+	var docErrors errors.DocErrors
+	for _, ad := range ads {
+		if b.rejected[ad.ID] {
+			docErrors = append(docErrors, errors.NewDocError(strconv.FormatInt(ad.ID, 10), errors.ErrorParamInvalid))
+			continue
+		}
+		accepted = append(accepted, ad)
+	}
+	if err := b.SearchBackend.Index(ctx, accepted); err != nil {
+		return err
+	}
+	return docErrors.ErrorOrNil()
+}
+
+func TestIndexAdsRetriesRejectedAds(t *testing.T) {
+	ctx := context.Background()
+	memory, err := engine.Open(ctx, "memory", &config.Config{}, engine.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer memory.Close()
+	adStore, err := store.InitBoltStore(filepath.Join(t.TempDir(), "ads.db"), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adStore.Close()
+
+	backend := &rejectingBackend{SearchBackend: memory, rejected: map[int64]bool{2: true}}
+	repo := InitAdvertisement(backend).WithStore(adStore)
+	ads := model.Advertisements{{ID: 1, Title: "first"}, {ID: 2, Title: "second"}}
+
+	err = repo.IndexAds(ctx, ads)
+	if docErrors, ok := errors.AsDocErrors(err); !ok || len(docErrors) != 1 || docErrors[0].DocID != "2" {
+		t.Fatalf("expected ad 2 to be rejected, got %v", err)
+	}
+	if pending, _ := adStore.Pending(repo.checkpointName()); len(pending) != 1 || pending[0] != 2 {
+		t.Fatalf("expected ad 2 to be pending, got %v", pending)
+	}
+
+	// the unchanged ad is applied again once the index accepts it
+	delete(backend.rejected, 2)
+	if err = repo.IndexAds(ctx, ads[1:]); err != nil {
+		t.Fatal(err)
+	}
+	indexed, err := memory.Get(ctx, []int64{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(indexed) != 2 {
+		t.Fatalf("expected both ads to be indexed, got %d", len(indexed))
+	}
+	if pending, _ := adStore.Pending(repo.checkpointName()); len(pending) != 0 {
+		t.Fatalf("expected nothing pending, got %v", pending)
+	}
+}
+
+func TestIndexAdsReportsOnlyItsOwnAds(t *testing.T) {
+	ctx := context.Background()
+	memory, err := engine.Open(ctx, "memory", &config.Config{}, engine.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer memory.Close()
+	adStore, err := store.InitBoltStore(filepath.Join(t.TempDir(), "ads.db"), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adStore.Close()
+
+	backend := &rejectingBackend{SearchBackend: memory, rejected: map[int64]bool{1: true, 3: true}}
+	repo := InitAdvertisement(backend).WithStore(adStore)
+
+	// ad 1 is written by another writer and isn't synced yet
+	other, err := model.Advertisements{{ID: 1, Title: "other"}}.ToStoreDocs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = adStore.Put(other); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		ads      model.Advertisements
+		wantDocs []string
+	}{
+		{name: "accepted ads", ads: model.Advertisements{{ID: 2, Title: "second"}}},
+		{name: "rejected ad", ads: model.Advertisements{{ID: 3, Title: "third"}, {ID: 4, Title: "fourth"}}, wantDocs: []string{"3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := repo.IndexAds(ctx, tt.ads)
+			docErrors, _ := errors.AsDocErrors(err)
+			if len(tt.wantDocs) == 0 && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(docErrors) != len(tt.wantDocs) {
+				t.Fatalf("doc errors = %v, want %v", docErrors, tt.wantDocs)
+			}
+			for i, docErr := range docErrors {
+				if docErr.DocID != tt.wantDocs[i] {
+					t.Errorf("doc error %d is of %s, want %s", i, docErr.DocID, tt.wantDocs[i])
+				}
+			}
+		})
+	}
+	// the ad of the other writer is still pending for a retry
+	if pending, _ := adStore.Pending(repo.checkpointName()); len(pending) != 2 || pending[0] != 1 || pending[1] != 3 {
+		t.Errorf("pending = %v, want [1 3]", pending)
+	}
+}
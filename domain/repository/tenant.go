@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/isdzulqor/kraicklist/domain/model"
+	"github.com/isdzulqor/kraicklist/helper/errors"
+	"github.com/isdzulqor/kraicklist/helper/files"
+)
+
+// Tenant persists the tenants as a json file, it's read on every call so the tenants created by the API
+// are visible to the CLI commands
+type Tenant struct {
+	path string
+	mu   sync.Mutex
+}
+
+func InitTenant(path string) *Tenant {
+	return &Tenant{
+		path: path,
+	}
+}
+
+// GetTenants lists the tenants ordered by their names
+func (r *Tenant) GetTenants() (model.Tenants, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.read()
+}
+
+func (r *Tenant) GetTenant(name string) (out model.Tenant, err error) {
+	tenants, err := r.GetTenants()
+	if err != nil {
+		return
+	}
+	for _, tenant := range tenants {
+		if tenant.Name == name {
+			return tenant, nil
+		}
+	}
+	err = errors.ErrorNotFound.AppendMessage(fmt.Sprintf("tenant %s is not found", name))
+	return
+}
+
+func (r *Tenant) SaveTenant(tenant model.Tenant) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tenants, err := r.read()
+	if err != nil {
+		return err
+	}
+	for _, existing := range tenants {
+		if existing.Name == tenant.Name {
+			return errors.ErrorParamInvalid.AppendMessage(fmt.Sprintf("tenant %s already exists", tenant.Name))
+		}
+	}
+	tenants = append(tenants, tenant)
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].Name < tenants[j].Name })
+	return r.write(tenants)
+}
+
+func (r *Tenant) DeleteTenant(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tenants, err := r.read()
+	if err != nil {
+		return err
+	}
+	out := tenants[:0]
+	for _, tenant := range tenants {
+		if tenant.Name != name {
+			out = append(out, tenant)
+		}
+	}
+	if len(out) == len(tenants) {
+		return errors.ErrorNotFound.AppendMessage(fmt.Sprintf("tenant %s is not found", name))
+	}
+	return r.write(out)
+}
+
+func (r *Tenant) read() (out model.Tenants, err error) {
+	content, err := ioutil.ReadFile(r.path)
+	if os.IsNotExist(err) {
+		return model.Tenants{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tenants %s, err: %v", r.path, err)
+	}
+	if err = json.Unmarshal(content, &out); err != nil {
+		return nil, fmt.Errorf("failed to decode tenants %s, err: %v", r.path, err)
+	}
+	return
+}
+
+func (r *Tenant) write(tenants model.Tenants) error {
+	if err := writeJSONFile(r.path, tenants); err != nil {
+		return fmt.Errorf("failed to write tenants %s, err: %v", r.path, err)
+	}
+	return nil
+}
+
+// writeJSONFile writes the data as indented json, the directory is created when it's missing
+func writeJSONFile(path string, data interface{}) error {
+	content, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return files.WriteAtomic(path, content, 0600)
+}
@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/isdzulqor/kraicklist/domain/model"
+)
+
+// Usage persists the daily usage counters as a json file
+type Usage struct {
+	path string
+	mu   sync.Mutex
+}
+
+func InitUsage(path string) *Usage {
+	return &Usage{
+		path: path,
+	}
+}
+
+// GetUsages returns the counters of the days between from and to, both inclusive on the usage date layout
+func (r *Usage) GetUsages(from, to string) (out model.Usages, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	usages, err := r.read()
+	if err != nil {
+		return
+	}
+	out = model.Usages{}
+	for _, usage := range usages {
+		if (from == "" || usage.Date >= from) && (to == "" || usage.Date <= to) {
+			out = append(out, usage)
+		}
+	}
+	return
+}
+
+// AddUsages adds the counters to the stored ones, the days before the oldest date are deleted
+func (r *Usage) AddUsages(usages model.Usages, oldest string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, err := r.read()
+	if err != nil {
+		return err
+	}
+	type usageID struct{ date, client, budget string }
+	merged := map[usageID]model.Usage{}
+	for _, list := range []model.Usages{stored, usages} {
+		for _, usage := range list {
+			if usage.Date < oldest {
+				continue
+			}
+			id := usageID{usage.Date, usage.Client, usage.Budget}
+			existing := merged[id]
+			usage.Requests += existing.Requests
+			usage.Rejected += existing.Rejected
+			merged[id] = usage
+		}
+	}
+
+	out := make(model.Usages, 0, len(merged))
+	for _, usage := range merged {
+		out = append(out, usage)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Date != out[j].Date {
+			return out[i].Date < out[j].Date
+		}
+		if out[i].Client != out[j].Client {
+			return out[i].Client < out[j].Client
+		}
+		return out[i].Budget < out[j].Budget
+	})
+	if err = writeJSONFile(r.path, out); err != nil {
+		return fmt.Errorf("failed to write usage %s, err: %v", r.path, err)
+	}
+	return nil
+}
+
+func (r *Usage) read() (out model.Usages, err error) {
+	content, err := ioutil.ReadFile(r.path)
+	if os.IsNotExist(err) {
+		return model.Usages{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read usage %s, err: %v", r.path, err)
+	}
+	if err = json.Unmarshal(content, &out); err != nil {
+		return nil, fmt.Errorf("failed to decode usage %s, err: %v", r.path, err)
+	}
+	return
+}
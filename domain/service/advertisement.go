@@ -2,9 +2,13 @@ package service
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/isdzulqor/kraicklist/domain/model"
 	"github.com/isdzulqor/kraicklist/domain/repository"
+	"github.com/isdzulqor/kraicklist/helper/errors"
+	"github.com/isdzulqor/kraicklist/helper/logging"
 )
 
 type Advertisement struct {
@@ -17,10 +21,111 @@ func InitAdvertisement(adRepo *repository.Advertisement) *Advertisement {
 	}
 }
 
-func (s *Advertisement) SearchAds(ctx context.Context, keyword string) (out model.Advertisements, err error) {
-	return s.adRepo.SearchAds(ctx, keyword)
+// SearchAds searches ads by the keyword, the status reports whether the ads are served by the failover replica
+func (s *Advertisement) SearchAds(ctx context.Context, keyword string) (out model.Advertisements,
+	status model.SearchStatus, err error) {
+	hits, status, err := s.adRepo.SearchAds(ctx, model.AdSearchQuery{Keyword: keyword})
+	if err != nil {
+		return
+	}
+	out = hits.Ads()
+	return
+}
+
+// IndexAds indexes ads and reports indexing status of each ad.
+// failed docs won't be returned as error, those are marked as failed on the statuses instead
+func (s *Advertisement) IndexAds(ctx context.Context, in model.Advertisements) (out model.AdIndexStatuses, err error) {
+	err = s.adRepo.IndexAds(ctx, in)
+	if docErrors, ok := errors.ExtractDocErrors(err); ok {
+		return model.NewAdIndexStatuses(in, docErrors), nil
+	}
+	if err != nil {
+		return
+	}
+	out = model.NewAdIndexStatuses(in, nil)
+	return
+}
+
+// GetStats gathers the statistics of the ads index, topN limits the top terms and tags
+func (s *Advertisement) GetStats(ctx context.Context, topN int) (model.IndexStats, error) {
+	return s.adRepo.GetStats(ctx, topN)
+}
+
+// GetShadowReport reports the comparisons of the shadow mode, limit bounds the listed divergent queries
+func (s *Advertisement) GetShadowReport(limit int) (model.ShadowReport, error) {
+	return s.adRepo.GetShadowReport(limit)
+}
+
+// StartIndexSync rebuilds the empty index from the store then retries the sync of the store changes
+// on every interval in the background until the ctx is done
+func (s *Advertisement) StartIndexSync(ctx context.Context, interval time.Duration, pageSize int) error {
+	if err := s.adRepo.EnsureIndex(ctx, pageSize); err != nil {
+		return fmt.Errorf("failed to rebuild the index from the store, err: %v", err)
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.adRepo.SyncIndex(ctx); err != nil {
+					if _, partial := errors.AsDocErrors(err); !partial {
+						logging.WarnContext(ctx, "failed to sync the index from the store, err: %v", err)
+					}
+				}
+			}
+		}
+	}()
+	return nil
 }
 
-func (s *Advertisement) IndexAds(ctx context.Context, in model.Advertisements) (err error) {
-	return s.adRepo.IndexAds(ctx, in)
+// GetChanges lists the changes after the since, it waits up to the wait for new changes when there are none
+func (s *Advertisement) GetChanges(ctx context.Context, since uint64, limit int,
+	wait time.Duration) (out model.AdChangeFeed, err error) {
+	deadline := time.NewTimer(wait)
+	defer deadline.Stop()
+	for {
+		// watch before reading, so the changes committed in between aren't missed
+		changed := s.adRepo.WatchChanges()
+		if out, err = s.adRepo.GetChanges(since, limit); err != nil || len(out.Changes) > 0 || wait <= 0 {
+			return
+		}
+		select {
+		case <-changed:
+		case <-deadline.C:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// StartChangeRetention prunes the changes older than the retention on every interval in the background
+// until the ctx is done
+func (s *Advertisement) StartChangeRetention(ctx context.Context, retention, interval time.Duration) {
+	prune := func() {
+		pruned, err := s.adRepo.PruneChanges(time.Now().Add(-retention))
+		if err != nil {
+			logging.WarnContext(ctx, "failed to prune the changes, err: %v", err)
+			return
+		}
+		if pruned > 0 {
+			logging.InfoContext(ctx, "%d changes older than %s are pruned", pruned, retention)
+		}
+	}
+	go func() {
+		prune()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				prune()
+			}
+		}
+	}()
 }
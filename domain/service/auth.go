@@ -0,0 +1,237 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/isdzulqor/kraicklist/config"
+	"github.com/isdzulqor/kraicklist/domain/model"
+	"github.com/isdzulqor/kraicklist/domain/repository"
+	"github.com/isdzulqor/kraicklist/helper/errors"
+)
+
+// apiKeyPrefix marks the keys, i.e: kl_3f2a9c1d0b4e5f67_<secret>
+const apiKeyPrefix = "kl_"
+
+// IssueAPIKeyRequest is the key to issue, zero TTL is the configured AUTH_KEY_TTL
+type IssueAPIKeyRequest struct {
+	Name   string        `json:"name"`
+	Scopes []string      `json:"scopes"`
+	Tenant string        `json:"tenant"`
+	TTL    time.Duration `json:"-"`
+}
+
+type Auth struct {
+	conf       *config.Config
+	apiKeyRepo *repository.APIKey
+	tenantRepo *repository.Tenant
+}
+
+func InitAuth(conf *config.Config, apiKeyRepo *repository.APIKey, tenantRepo *repository.Tenant) *Auth {
+	return &Auth{
+		conf:       conf,
+		apiKeyRepo: apiKeyRepo,
+		tenantRepo: tenantRepo,
+	}
+}
+
+// Enabled reports whether the API keys are required
+func (s *Auth) Enabled() bool {
+	return s.conf.Auth.Enabled
+}
+
+func (s *Auth) GetAPIKeys(ctx context.Context) (model.APIKeys, error) {
+	return s.apiKeyRepo.GetAPIKeys()
+}
+
+// IssueAPIKey creates a key with the scopes, the returned secret isn't stored so it can't be shown again
+func (s *Auth) IssueAPIKey(ctx context.Context, req IssueAPIKeyRequest) (out model.IssuedAPIKey, err error) {
+	if strings.TrimSpace(req.Name) == "" {
+		err = errors.ErrorParamInvalid.AppendMessage("name is necessary")
+		return
+	}
+	if len(req.Scopes) == 0 {
+		err = errors.ErrorParamInvalid.AppendMessage(fmt.Sprintf("scopes are necessary, any of %s",
+			strings.Join(model.Scopes, ", ")))
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !model.IsValidScope(scope) {
+			err = errors.ErrorParamInvalid.AppendMessage(fmt.Sprintf("scope %s is invalid, use any of %s",
+				scope, strings.Join(model.Scopes, ", ")))
+			return
+		}
+	}
+	if req.Tenant != "" {
+		if _, err = s.tenantRepo.GetTenant(req.Tenant); err != nil {
+			return
+		}
+	}
+	if req.TTL < 0 {
+		err = errors.ErrorParamInvalid.AppendMessage("ttl can't be negative")
+		return
+	}
+	if req.TTL == 0 {
+		req.TTL = s.conf.Auth.KeyTTL
+	}
+
+	if out, err = newAPIKey(req.Name, req.Scopes, req.Tenant, req.TTL); err != nil {
+		return
+	}
+	err = s.apiKeyRepo.SaveAPIKeys(out.APIKey)
+	return
+}
+
+// RotateAPIKey issues a key replacing the active one with the same lifetime, the replaced key
+// still works for the grace period so the clients could switch. Negative grace is AUTH_ROTATION_GRACE
+func (s *Auth) RotateAPIKey(ctx context.Context, id string, grace time.Duration) (out model.IssuedAPIKey, err error) {
+	key, err := s.apiKeyRepo.GetAPIKey(id)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	if !key.IsActive(now) {
+		err = errors.ErrorParamInvalid.AppendMessage(fmt.Sprintf("api key %s is already revoked or expired", id))
+		return
+	}
+	if grace < 0 {
+		grace = s.conf.Auth.RotationGrace
+	}
+
+	ttl := s.conf.Auth.KeyTTL
+	if key.ExpiresAt != nil {
+		ttl = key.ExpiresAt.Sub(key.CreatedAt)
+	}
+	if out, err = newAPIKey(key.Name, key.Scopes, key.Tenant, ttl); err != nil {
+		return
+	}
+	graceEnd := now.Add(grace)
+	if key.ExpiresAt == nil || graceEnd.Before(*key.ExpiresAt) {
+		key.ExpiresAt = &graceEnd
+	}
+	key.RotatedTo = out.ID
+	err = s.apiKeyRepo.SaveAPIKeys(out.APIKey, key)
+	return
+}
+
+// RevokeAPIKey disables the key immediately
+func (s *Auth) RevokeAPIKey(ctx context.Context, id string) (out model.APIKey, err error) {
+	if out, err = s.apiKeyRepo.GetAPIKey(id); err != nil {
+		return
+	}
+	if out.RevokedAt == nil {
+		now := time.Now()
+		out.RevokedAt = &now
+		err = s.apiKeyRepo.SaveAPIKeys(out)
+	}
+	return
+}
+
+// Authenticate finds the active key of the raw key, it's failed with errors.ErrorUnauthorized
+func (s *Auth) Authenticate(ctx context.Context, rawKey string) (out model.APIKey, err error) {
+	if rawKey == "" {
+		err = errors.ErrorUnauthorized.AppendMessage("api key is necessary")
+		return
+	}
+	id, secret, ok := parseAPIKey(rawKey)
+	if !ok {
+		err = errors.ErrorUnauthorized.AppendMessage("api key is invalid")
+		return
+	}
+	if out, err = s.apiKeyRepo.GetAPIKey(id); err != nil {
+		if errors.IsEqual(err, errors.ErrorNotFound) {
+			err = errors.ErrorUnauthorized.AppendMessage("api key is invalid")
+		}
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(out.Hash)) != 1 {
+		err = errors.ErrorUnauthorized.AppendMessage("api key is invalid")
+		return
+	}
+	if !out.IsActive(time.Now()) {
+		err = errors.ErrorUnauthorized.AppendMessage("api key is revoked or expired")
+	}
+	return
+}
+
+// Authorize checks the key has the scope on the tenant, empty tenant is the default indices.
+// The keys without a tenant are for all tenants, it's failed with errors.ErrorForbidden
+func (s *Auth) Authorize(key model.APIKey, scope, tenant string) error {
+	if !key.HasScope(scope) {
+		return errors.ErrorForbidden.AppendMessage(fmt.Sprintf("api key %s doesn't have %s scope", key.ID, scope))
+	}
+	if key.Tenant != "" && key.Tenant != tenant {
+		return errors.ErrorForbidden.AppendMessage(fmt.Sprintf("api key %s is only for tenant %s", key.ID, key.Tenant))
+	}
+	return nil
+}
+
+type apiKeyContextKey struct{}
+
+// WithAPIKey keeps the authenticated key on the request context
+func WithAPIKey(ctx context.Context, key model.APIKey) context.Context {
+	return context.WithValue(ctx, apiKeyContextKey{}, key)
+}
+
+// APIKeyFromContext returns the key authenticated on the request, if any
+func APIKeyFromContext(ctx context.Context) (model.APIKey, bool) {
+	key, ok := ctx.Value(apiKeyContextKey{}).(model.APIKey)
+	return key, ok
+}
+
+func newAPIKey(name string, scopes []string, tenant string, ttl time.Duration) (out model.IssuedAPIKey, err error) {
+	id, err := randomHex(8)
+	if err != nil {
+		return
+	}
+	secret, err := randomHex(24)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+	out = model.IssuedAPIKey{
+		APIKey: model.APIKey{
+			ID:        id,
+			Name:      name,
+			Scopes:    scopes,
+			Tenant:    tenant,
+			Hash:      hashSecret(secret),
+			CreatedAt: now,
+			ExpiresAt: &expiresAt,
+		},
+		Key: apiKeyPrefix + id + "_" + secret,
+	}
+	return
+}
+
+func parseAPIKey(rawKey string) (id, secret string, ok bool) {
+	if !strings.HasPrefix(rawKey, apiKeyPrefix) {
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(rawKey, apiKeyPrefix), "_", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return
+	}
+	return parts[0], parts[1], true
+}
+
+// hashSecret hashes the random secret, a fast hash is enough as the secret isn't guessable like a password
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(size int) (string, error) {
+	out := make([]byte, size)
+	if _, err := rand.Read(out); err != nil {
+		return "", fmt.Errorf("failed to generate api key, err: %v", err)
+	}
+	return hex.EncodeToString(out), nil
+}
@@ -0,0 +1,209 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/isdzulqor/kraicklist/config"
+	"github.com/isdzulqor/kraicklist/domain/model"
+	"github.com/isdzulqor/kraicklist/domain/repository"
+	"github.com/isdzulqor/kraicklist/helper/errors"
+)
+
+func initTestAuth(t *testing.T) (*Auth, *repository.APIKey) {
+	conf := &config.Config{}
+	conf.Auth.Enabled = true
+	conf.Auth.KeyTTL = time.Hour
+	conf.Auth.RotationGrace = time.Minute
+
+	dir := t.TempDir()
+	apiKeyRepo := repository.InitAPIKey(filepath.Join(dir, "api_keys.json"))
+	tenantRepo := repository.InitTenant(filepath.Join(dir, "tenants.json"))
+	for _, name := range []string{"acme", "globex"} {
+		if err := tenantRepo.SaveTenant(model.Tenant{Name: name, Indexer: "memory"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return InitAuth(conf, apiKeyRepo, tenantRepo), apiKeyRepo
+}
+
+func issueTestKey(t *testing.T, authService *Auth, tenant string, scopes ...string) model.IssuedAPIKey {
+	issued, err := authService.IssueAPIKey(context.Background(), IssueAPIKeyRequest{
+		Name:   "test",
+		Scopes: scopes,
+		Tenant: tenant,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return issued
+}
+
+func TestAuthenticate(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		// rawKey prepares the key to authenticate
+		rawKey     func(t *testing.T, authService *Auth, apiKeyRepo *repository.APIKey) string
+		wantStatus int
+	}{
+		{
+			name: "valid key",
+			rawKey: func(t *testing.T, authService *Auth, _ *repository.APIKey) string {
+				return issueTestKey(t, authService, "", model.ScopeSearch).Key
+			},
+		},
+		{
+			name:       "missing key",
+			rawKey:     func(*testing.T, *Auth, *repository.APIKey) string { return "" },
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "malformed key without prefix",
+			rawKey:     func(*testing.T, *Auth, *repository.APIKey) string { return "3f2a9c1d0b4e5f67_secret" },
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "malformed key without secret",
+			rawKey:     func(*testing.T, *Auth, *repository.APIKey) string { return "kl_3f2a9c1d0b4e5f67_" },
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "unknown key",
+			rawKey:     func(*testing.T, *Auth, *repository.APIKey) string { return "kl_3f2a9c1d0b4e5f67_secret" },
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "wrong secret",
+			rawKey: func(t *testing.T, authService *Auth, _ *repository.APIKey) string {
+				issued := issueTestKey(t, authService, "", model.ScopeSearch)
+				return apiKeyPrefix + issued.ID + "_" + strings.Repeat("0", 48)
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "expired key",
+			rawKey: func(t *testing.T, authService *Auth, apiKeyRepo *repository.APIKey) string {
+				issued := issueTestKey(t, authService, "", model.ScopeSearch)
+				expiredAt := time.Now().Add(-time.Second)
+				issued.ExpiresAt = &expiredAt
+				if err := apiKeyRepo.SaveAPIKeys(issued.APIKey); err != nil {
+					t.Fatal(err)
+				}
+				return issued.Key
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "revoked key",
+			rawKey: func(t *testing.T, authService *Auth, _ *repository.APIKey) string {
+				issued := issueTestKey(t, authService, "", model.ScopeSearch)
+				if _, err := authService.RevokeAPIKey(context.Background(), issued.ID); err != nil {
+					t.Fatal(err)
+				}
+				return issued.Key
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "rotated key inside the grace period",
+			rawKey: func(t *testing.T, authService *Auth, _ *repository.APIKey) string {
+				issued := issueTestKey(t, authService, "", model.ScopeSearch)
+				if _, err := authService.RotateAPIKey(context.Background(), issued.ID, time.Minute); err != nil {
+					t.Fatal(err)
+				}
+				return issued.Key
+			},
+		},
+		{
+			name: "rotated key after the grace period",
+			rawKey: func(t *testing.T, authService *Auth, _ *repository.APIKey) string {
+				issued := issueTestKey(t, authService, "", model.ScopeSearch)
+				if _, err := authService.RotateAPIKey(context.Background(), issued.ID, 0); err != nil {
+					t.Fatal(err)
+				}
+				return issued.Key
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "replacement of the rotated key",
+			rawKey: func(t *testing.T, authService *Auth, _ *repository.APIKey) string {
+				issued := issueTestKey(t, authService, "", model.ScopeSearch)
+				rotated, err := authService.RotateAPIKey(context.Background(), issued.ID, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return rotated.Key
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authService, apiKeyRepo := initTestAuth(t)
+			_, err := authService.Authenticate(ctx, tt.rawKey(t, authService, apiKeyRepo))
+			if tt.wantStatus == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected status %d, got no error", tt.wantStatus)
+			}
+			if status := errors.GetStatusCode(err); status != tt.wantStatus {
+				t.Errorf("status = %d, want %d, err: %v", status, tt.wantStatus, err)
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	authService, _ := initTestAuth(t)
+	searchKey := issueTestKey(t, authService, "", model.ScopeSearch)
+	acmeKey := issueTestKey(t, authService, "acme", model.ScopeSearch, model.ScopeIndex)
+
+	tests := []struct {
+		name       string
+		key        model.APIKey
+		scope      string
+		tenant     string
+		wantStatus int
+	}{
+		{name: "scope on default indices", key: searchKey.APIKey, scope: model.ScopeSearch},
+		{name: "unbound key on a tenant", key: searchKey.APIKey, scope: model.ScopeSearch, tenant: "globex"},
+		{name: "missing scope", key: searchKey.APIKey, scope: model.ScopeIndex, wantStatus: http.StatusForbidden},
+		{name: "tenant-bound key on its tenant", key: acmeKey.APIKey, scope: model.ScopeIndex, tenant: "acme"},
+		{
+			name:       "tenant-bound key on another tenant",
+			key:        acmeKey.APIKey,
+			scope:      model.ScopeSearch,
+			tenant:     "globex",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "tenant-bound key on default indices",
+			key:        acmeKey.APIKey,
+			scope:      model.ScopeSearch,
+			wantStatus: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := authService.Authorize(tt.key, tt.scope, tt.tenant)
+			if tt.wantStatus == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if status := errors.GetStatusCode(err); err == nil || status != tt.wantStatus {
+				t.Errorf("err = %v, want status %d", err, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/isdzulqor/kraicklist/domain/model"
+)
+
+// pendingLine holds a parsed line which is waiting for its batch to be flushed,
+// ad is nil when the line is failed to be parsed
+type pendingLine struct {
+	line   int
+	ad     *model.Advertisement
+	reason string
+}
+
+// StreamIndexAds reads ndjson ads from reader line by line and indexes them in batches of batchSize.
+// A bad line doesn't abort the rest, it's reported as failed instead.
+// onResult is called for every non empty line with the same order as the input.
+// Lines longer than maxLineBytes are discarded, so memory usage is bounded by batchSize and maxLineBytes
+func (s *Advertisement) StreamIndexAds(ctx context.Context, reader io.Reader, batchSize, maxLineBytes int,
+	onResult func(model.AdLineStatus) error) (summary model.BulkIndexSummary, err error) {
+	if batchSize <= 0 {
+		err = fmt.Errorf("batch size must be greater than 0")
+		return
+	}
+
+	var (
+		lineReader = bufio.NewReaderSize(reader, maxLineBytes)
+		pending    = make([]pendingLine, 0, batchSize)
+		batch      = make(model.Advertisements, 0, batchSize)
+		lineNumber int
+	)
+
+	flush := func() error {
+		var statuses map[int64]model.AdIndexStatus
+		if len(batch) > 0 {
+			statuses = s.indexBatch(ctx, batch)
+		}
+		for _, p := range pending {
+			status := model.AdLineStatus{
+				Line:   p.line,
+				Status: model.IndexStatusFailed,
+				Reason: p.reason,
+			}
+			if p.ad != nil {
+				status.ID = p.ad.ID
+				status.Status = statuses[p.ad.ID].Status
+				status.Reason = statuses[p.ad.ID].Reason
+			}
+			summary.Add(status)
+			if err := onResult(status); err != nil {
+				return err
+			}
+		}
+		pending = pending[:0]
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+
+		var (
+			line     []byte
+			isPrefix bool
+			readErr  error
+		)
+		line, isPrefix, readErr = lineReader.ReadLine()
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			err = fmt.Errorf("failed to read line %d, err: %v", lineNumber+1, readErr)
+			return
+		}
+		lineNumber++
+
+		if isPrefix {
+			// discard the rest of too long line
+			for isPrefix && readErr == nil {
+				_, isPrefix, readErr = lineReader.ReadLine()
+			}
+			pending = append(pending, pendingLine{
+				line:   lineNumber,
+				reason: fmt.Sprintf("line exceeds %d bytes", maxLineBytes),
+			})
+		} else if len(line) == 0 {
+			continue
+		} else {
+			ad := model.Advertisement{}
+			if unmarshalErr := json.Unmarshal(line, &ad); unmarshalErr != nil {
+				pending = append(pending, pendingLine{
+					line:   lineNumber,
+					reason: fmt.Sprintf("invalid json: %v", unmarshalErr),
+				})
+			} else {
+				pending = append(pending, pendingLine{line: lineNumber, ad: &ad})
+				batch = append(batch, ad)
+			}
+		}
+
+		if len(pending) >= batchSize {
+			if err = flush(); err != nil {
+				return
+			}
+		}
+	}
+
+	err = flush()
+	return
+}
+
+// indexBatch indexes the batch and maps the status by ad ID,
+// the whole batch will be marked as failed when the indexer fails entirely
+func (s *Advertisement) indexBatch(ctx context.Context, batch model.Advertisements) map[int64]model.AdIndexStatus {
+	out := make(map[int64]model.AdIndexStatus, len(batch))
+
+	statuses, err := s.IndexAds(ctx, batch)
+	if err != nil {
+		for _, ad := range batch {
+			out[ad.ID] = model.AdIndexStatus{
+				ID:     ad.ID,
+				Status: model.IndexStatusFailed,
+				Reason: err.Error(),
+			}
+		}
+		return out
+	}
+	for _, status := range statuses {
+		out[status.ID] = status
+	}
+	return out
+}
@@ -0,0 +1,238 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/isdzulqor/kraicklist/domain/model"
+	"github.com/isdzulqor/kraicklist/domain/repository"
+	"github.com/isdzulqor/kraicklist/helper/errors"
+	"github.com/isdzulqor/kraicklist/helper/logging"
+	"github.com/isdzulqor/kraicklist/helper/uuid"
+)
+
+// errJobCancelled is the cause of the jobs cancelled by CancelJob, the jobs stopped by the shutdown
+// keep their state and payload to be resumed on the next Start
+var errJobCancelled = fmt.Errorf("job is cancelled")
+
+// Job runs asynchronous indexing jobs with a fixed number of workers.
+// Job state is persisted after every batch, so interrupted jobs are resumed on Start
+type Job struct {
+	jobRepo   *repository.Job
+	adService *Advertisement
+
+	workers   int
+	batchSize int
+	queue     chan string
+
+	mu      sync.Mutex
+	cancels map[string]context.CancelCauseFunc
+}
+
+func InitJob(jobRepo *repository.Job, adService *Advertisement, workers, queueSize, batchSize int) *Job {
+	return &Job{
+		jobRepo:   jobRepo,
+		adService: adService,
+		workers:   workers,
+		batchSize: batchSize,
+		queue:     make(chan string, queueSize),
+		cancels:   map[string]context.CancelCauseFunc{},
+	}
+}
+
+// Start spawns the workers and re-enqueues unfinished jobs from the previous run
+func (s *Job) Start(ctx context.Context) error {
+	for i := 0; i < s.workers; i++ {
+		go s.work(ctx)
+	}
+
+	jobs, err := s.jobRepo.GetUnfinishedJobs(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load unfinished jobs, err: %v", err)
+	}
+	for _, job := range jobs {
+		logging.InfoContext(ctx, "resuming job %s from %d of %d docs", job.ID, job.Processed, job.Total)
+		go func(id string) {
+			s.queue <- id
+		}(job.ID)
+	}
+	return nil
+}
+
+// SubmitIndexAds persists the ads and enqueues a job to index them
+func (s *Job) SubmitIndexAds(ctx context.Context, ads model.Advertisements) (out model.Job, err error) {
+	if len(ads) == 0 {
+		err = errors.ErrorParamInvalid.AppendMessage("no ads to be indexed")
+		return
+	}
+
+	out = model.NewJob(uuid.UUIDv4(), model.JobTypeIndexAds, len(ads))
+	if err = s.jobRepo.SavePayload(ctx, out.ID, ads); err != nil {
+		return
+	}
+	if err = s.jobRepo.SaveJob(ctx, out); err != nil {
+		return
+	}
+
+	select {
+	case s.queue <- out.ID:
+	default:
+		out.End(model.JobStatusFailed, fmt.Errorf("job queue is full"))
+		if saveErr := s.jobRepo.SaveJob(ctx, out); saveErr != nil {
+			logging.ErrContext(ctx, "%v", saveErr)
+		}
+		s.jobRepo.DeletePayload(ctx, out.ID)
+		err = errors.ErrorServiceUnavailable.AppendMessage("job queue is full, try again later")
+	}
+	return
+}
+
+func (s *Job) GetJob(ctx context.Context, id string) (model.Job, error) {
+	return s.jobRepo.GetJob(ctx, id)
+}
+
+// CancelJob cancels a queued job immediately, while a running job will be stopped after its current batch
+func (s *Job) CancelJob(ctx context.Context, id string) (out model.Job, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if out, err = s.jobRepo.GetJob(ctx, id); err != nil {
+		return
+	}
+	if out.IsFinished() {
+		err = errors.ErrorParamInvalid.AppendMessage("job is already " + out.Status)
+		return
+	}
+
+	if cancel, running := s.cancels[id]; running {
+		cancel(errJobCancelled)
+		return
+	}
+
+	out.End(model.JobStatusCancelled, nil)
+	if err = s.jobRepo.SaveJob(ctx, out); err != nil {
+		return
+	}
+	err = s.jobRepo.DeletePayload(ctx, id)
+	return
+}
+
+func (s *Job) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case id := <-s.queue:
+			jobCtx := logging.WithRequestIDContext(ctx, "job-"+id)
+			if err := s.run(jobCtx, id); err != nil {
+				logging.ErrContext(jobCtx, "job %s is stopped, err: %v", id, err)
+			}
+		}
+	}
+}
+
+// acquire marks the job as running and registers its cancel func,
+// returns false when the job has been finished or cancelled before it's picked up
+func (s *Job) acquire(ctx context.Context, id string) (job model.Job, jobCtx context.Context, ok bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if job, err = s.jobRepo.GetJob(ctx, id); err != nil || job.IsFinished() {
+		return
+	}
+	job.Start()
+	if err = s.jobRepo.SaveJob(ctx, job); err != nil {
+		return
+	}
+
+	var cancel context.CancelCauseFunc
+	jobCtx, cancel = context.WithCancelCause(ctx)
+	s.cancels[id] = cancel
+	ok = true
+	return
+}
+
+func (s *Job) release(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cancel, exist := s.cancels[id]; exist {
+		cancel(nil)
+		delete(s.cancels, id)
+	}
+}
+
+func (s *Job) run(ctx context.Context, id string) (err error) {
+	job, jobCtx, ok, err := s.acquire(ctx, id)
+	if err != nil || !ok {
+		return
+	}
+	defer s.release(id)
+
+	ads, err := s.jobRepo.GetPayload(ctx, id)
+	if err != nil {
+		job.End(model.JobStatusFailed, err)
+		return s.finish(ctx, job)
+	}
+
+	logging.InfoContext(ctx, "running job %s, %d of %d docs", id, job.Total-job.Processed, job.Total)
+	for job.Processed < len(ads) {
+		if jobCtx.Err() != nil {
+			return s.stop(ctx, jobCtx, job)
+		}
+
+		end := job.Processed + s.batchSize
+		if end > len(ads) {
+			end = len(ads)
+		}
+		batch := ads[job.Processed:end]
+
+		statuses, indexErr := s.adService.IndexAds(jobCtx, batch)
+		if jobCtx.Err() != nil {
+			// the interrupted batch isn't counted as processed
+			return s.stop(ctx, jobCtx, job)
+		}
+		if indexErr != nil {
+			statuses = make(model.AdIndexStatuses, 0, len(batch))
+			for _, ad := range batch {
+				statuses = append(statuses, model.AdIndexStatus{
+					ID:     ad.ID,
+					Status: model.IndexStatusFailed,
+					Reason: indexErr.Error(),
+				})
+			}
+		}
+		job.AddStatuses(statuses)
+
+		if err = s.jobRepo.SaveJob(ctx, job); err != nil {
+			return
+		}
+	}
+
+	status := model.JobStatusSucceeded
+	if job.Failed == job.Total {
+		status = model.JobStatusFailed
+	}
+	job.End(status, nil)
+	logging.InfoContext(ctx, "job %s is %s, %d indexed, %d failed", id, status, job.Indexed, job.Failed)
+	return s.finish(ctx, job)
+}
+
+// stop ends the job cancelled by CancelJob. The job stopped by the shutdown is left unfinished
+// along with its payload, so it's resumed from its processed docs on the next Start
+func (s *Job) stop(ctx context.Context, jobCtx context.Context, job model.Job) error {
+	if context.Cause(jobCtx) == errJobCancelled {
+		job.End(model.JobStatusCancelled, nil)
+		return s.finish(ctx, job)
+	}
+	logging.InfoContext(ctx, "job %s is interrupted at %d of %d docs, it's resumed on the next start",
+		job.ID, job.Processed, job.Total)
+	return nil
+}
+
+func (s *Job) finish(ctx context.Context, job model.Job) error {
+	if err := s.jobRepo.SaveJob(ctx, job); err != nil {
+		return err
+	}
+	return s.jobRepo.DeletePayload(ctx, job.ID)
+}
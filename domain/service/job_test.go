@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/isdzulqor/kraicklist/config"
+	"github.com/isdzulqor/kraicklist/domain/model"
+	"github.com/isdzulqor/kraicklist/domain/repository"
+	"github.com/isdzulqor/kraicklist/external/engine"
+)
+
+// blockingBackend holds the indexing until the ctx is done
+type blockingBackend struct {
+	engine.SearchBackend
+	started chan struct{}
+}
+
+func (b *blockingBackend) Index(ctx context.Context, ads model.Advertisements) error {
+	b.started <- struct{}{}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// downBackend fails every indexing
+type downBackend struct {
+	engine.SearchBackend
+}
+
+func (b downBackend) Index(ctx context.Context, ads model.Advertisements) error {
+	return fmt.Errorf("index is down")
+}
+
+// initTestJob runs the jobs on the memory backend wrapped by the wrap
+func initTestJob(t *testing.T, wrap func(memory engine.SearchBackend) engine.SearchBackend) (*Job, *repository.Job) {
+	memory, err := engine.Open(context.Background(), "memory", &config.Config{}, engine.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { memory.Close() })
+	jobRepo, err := repository.InitJob(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return InitJob(jobRepo, InitAdvertisement(repository.InitAdvertisement(wrap(memory))), 1, 10, 10), jobRepo
+}
+
+func TestJobRunCapsTheStoredErrors(t *testing.T) {
+	ctx := context.Background()
+	jobService, jobRepo := initTestJob(t, func(memory engine.SearchBackend) engine.SearchBackend {
+		return downBackend{memory}
+	})
+
+	const total = model.JobMaxErrors*2 + 5
+	ads := make(model.Advertisements, 0, total)
+	for i := 1; i <= total; i++ {
+		ads = append(ads, model.Advertisement{ID: int64(i), Title: fmt.Sprintf("ad %d", i)})
+	}
+	job, err := jobService.SubmitIndexAds(ctx, ads)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = jobService.run(ctx, job.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if job, err = jobRepo.GetJob(ctx, job.ID); err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != model.JobStatusFailed || job.Failed != total || len(job.Errors) != model.JobMaxErrors {
+		t.Fatalf("expected failed job with %d failed docs and %d errors, got %s with %d and %d",
+			total, model.JobMaxErrors, job.Status, job.Failed, len(job.Errors))
+	}
+}
+
+func TestJobRunStopsOnCancelAndShutdown(t *testing.T) {
+	tests := []struct {
+		name        string
+		shutdown    bool
+		wantStatus  string
+		wantPayload bool
+	}{
+		{name: "cancelled job is ended", wantStatus: model.JobStatusCancelled},
+		{name: "shutdown keeps the job to resume", shutdown: true, wantStatus: model.JobStatusRunning, wantPayload: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &blockingBackend{started: make(chan struct{}, 1)}
+			jobService, jobRepo := initTestJob(t, func(memory engine.SearchBackend) engine.SearchBackend {
+				backend.SearchBackend = memory
+				return backend
+			})
+
+			ctx, shutdown := context.WithCancel(context.Background())
+			defer shutdown()
+			job, err := jobService.SubmitIndexAds(ctx, model.Advertisements{{ID: 1, Title: "first"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			done := make(chan error)
+			go func() {
+				done <- jobService.run(ctx, job.ID)
+			}()
+			<-backend.started
+			if tt.shutdown {
+				shutdown()
+			} else if _, err = jobService.CancelJob(context.Background(), job.ID); err != nil {
+				t.Fatal(err)
+			}
+			if err = <-done; err != nil {
+				t.Fatal(err)
+			}
+
+			if job, err = jobRepo.GetJob(context.Background(), job.ID); err != nil {
+				t.Fatal(err)
+			}
+			if job.Status != tt.wantStatus || job.Processed != 0 {
+				t.Fatalf("expected %s job without processed docs, got %s with %d", tt.wantStatus, job.Status, job.Processed)
+			}
+			_, err = jobRepo.GetPayload(context.Background(), job.ID)
+			if hasPayload := err == nil; hasPayload != tt.wantPayload {
+				t.Fatalf("expected payload kept %v, got err %v", tt.wantPayload, err)
+			}
+		})
+	}
+}
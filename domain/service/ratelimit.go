@@ -0,0 +1,214 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/isdzulqor/kraicklist/config"
+	"github.com/isdzulqor/kraicklist/domain/model"
+	"github.com/isdzulqor/kraicklist/domain/repository"
+	"github.com/isdzulqor/kraicklist/helper/errors"
+	"github.com/isdzulqor/kraicklist/helper/logging"
+)
+
+// rateBudget refills the bucket by rate tokens per second up to the burst
+type rateBudget struct {
+	rate  float64
+	burst int
+}
+
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type usageID struct {
+	date, client, budget string
+}
+
+// RateLimit takes the requests of the clients from their token buckets per budget, and meters the daily usage.
+// The budgets are named by the scopes, i.e: search and index
+type RateLimit struct {
+	conf      *config.Config
+	usageRepo *repository.Usage
+	budgets   map[string]rateBudget
+	// now is the clock of the buckets and the usage days
+	now func() time.Time
+
+	mu       sync.Mutex
+	buckets  map[usageID]*rateBucket
+	pending  map[usageID]*model.Usage
+	rejected int64
+
+	// flushMu keeps the flushes in order, the pending counters of a failed flush are merged back
+	flushMu sync.Mutex
+}
+
+func InitRateLimit(conf *config.Config, usageRepo *repository.Usage) *RateLimit {
+	return &RateLimit{
+		conf:      conf,
+		usageRepo: usageRepo,
+		budgets: map[string]rateBudget{
+			model.ScopeSearch: {rate: conf.RateLimit.SearchRate, burst: conf.RateLimit.SearchBurst},
+			model.ScopeIndex:  {rate: conf.RateLimit.IndexRate, burst: conf.RateLimit.IndexBurst},
+		},
+		now:     time.Now,
+		buckets: map[usageID]*rateBucket{},
+		pending: map[usageID]*model.Usage{},
+	}
+}
+
+// Allow takes a request of the client from its bucket of the budget. The request is always allowed
+// when the rate limit is disabled or the budget isn't limited, the decision has zero limit then
+func (s *RateLimit) Allow(client, budget string) (out model.RateLimitDecision) {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out.Allowed = true
+	if limit, ok := s.budgets[budget]; ok && s.conf.RateLimit.Enabled {
+		out = s.take(usageID{client: client, budget: budget}, limit, now)
+	}
+
+	id := usageID{date: now.UTC().Format(model.UsageDateLayout), client: client, budget: budget}
+	usage, ok := s.pending[id]
+	if !ok {
+		usage = &model.Usage{Date: id.date, Client: client, Budget: budget}
+		s.pending[id] = usage
+	}
+	usage.Requests++
+	if !out.Allowed {
+		usage.Rejected++
+		s.rejected++
+	}
+	return
+}
+
+func (s *RateLimit) take(id usageID, limit rateBudget, now time.Time) (out model.RateLimitDecision) {
+	bucket, ok := s.buckets[id]
+	if !ok {
+		bucket = &rateBucket{tokens: float64(limit.burst), last: now}
+		s.buckets[id] = bucket
+	}
+	bucket.tokens = math.Min(float64(limit.burst), bucket.tokens+now.Sub(bucket.last).Seconds()*limit.rate)
+	bucket.last = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		out.Allowed = true
+	} else {
+		out.RetryAfter = seconds((1 - bucket.tokens) / limit.rate)
+	}
+	out.Limit = limit.burst
+	out.Remaining = int(bucket.tokens)
+	out.Reset = seconds((float64(limit.burst) - bucket.tokens) / limit.rate)
+	return
+}
+
+// TrustProxy reports whether the client ip is taken from X-Forwarded-For
+func (s *RateLimit) TrustProxy() bool {
+	return s.conf.RateLimit.TrustProxy
+}
+
+// Rejected is the number of the requests over the limit since the start
+func (s *RateLimit) Rejected() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rejected
+}
+
+// GetUsages returns the daily usage between from and to, both inclusive, of the client or of all clients
+// when it's empty. The days are on the usage date layout, i.e: 2006-01-02
+func (s *RateLimit) GetUsages(ctx context.Context, from, to, client string) (out model.Usages, err error) {
+	for _, date := range []string{from, to} {
+		if _, parseErr := time.Parse(model.UsageDateLayout, date); date != "" && parseErr != nil {
+			err = errors.ErrorParamInvalid.AppendMessage(fmt.Sprintf("%s must be a date, i.e: %s",
+				date, model.UsageDateLayout))
+			return
+		}
+	}
+	if err = s.Flush(ctx); err != nil {
+		return
+	}
+	usages, err := s.usageRepo.GetUsages(from, to)
+	if err != nil {
+		return
+	}
+	out = model.Usages{}
+	for _, usage := range usages {
+		if client == "" || usage.Client == client {
+			out = append(out, usage)
+		}
+	}
+	return
+}
+
+// Flush stores the pending usage counters, the days older than the retention are deleted
+func (s *RateLimit) Flush(ctx context.Context) error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = map[usageID]*model.Usage{}
+	s.mu.Unlock()
+
+	usages := make(model.Usages, 0, len(pending))
+	for _, usage := range pending {
+		usages = append(usages, *usage)
+	}
+	oldest := s.now().Add(-s.conf.Usage.Retention).UTC().Format(model.UsageDateLayout)
+	if err := s.usageRepo.AddUsages(usages, oldest); err != nil {
+		// keep the counters for the next flush
+		s.mu.Lock()
+		for id, usage := range pending {
+			if current, ok := s.pending[id]; ok {
+				current.Requests += usage.Requests
+				current.Rejected += usage.Rejected
+				continue
+			}
+			s.pending[id] = usage
+		}
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Start flushes the usage and forgets the idle buckets on every USAGE_FLUSH_INTERVAL in the background
+// until the ctx is done
+func (s *RateLimit) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.conf.Usage.FlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.Flush(ctx); err != nil {
+					logging.WarnContext(ctx, "failed to flush the usage, err: %v", err)
+				}
+				s.forgetIdle(s.now().Add(-s.conf.RateLimit.IdleTimeout))
+			}
+		}
+	}()
+}
+
+func (s *RateLimit) forgetIdle(before time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, bucket := range s.buckets {
+		if bucket.last.Before(before) {
+			delete(s.buckets, id)
+		}
+	}
+}
+
+// seconds rounds the seconds up to the duration
+func seconds(value float64) time.Duration {
+	return time.Duration(math.Ceil(value * float64(time.Second)))
+}
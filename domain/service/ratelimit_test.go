@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/isdzulqor/kraicklist/config"
+	"github.com/isdzulqor/kraicklist/domain/model"
+	"github.com/isdzulqor/kraicklist/domain/repository"
+)
+
+// testClock is the clock of the rate limit, it only moves on advance
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func initTestRateLimit(t *testing.T, usagePath string) (*RateLimit, *testClock) {
+	conf := &config.Config{}
+	conf.RateLimit.Enabled = true
+	conf.RateLimit.SearchRate, conf.RateLimit.SearchBurst = 2, 3
+	conf.RateLimit.IndexRate, conf.RateLimit.IndexBurst = 1, 1
+	conf.Usage.Retention = 24 * time.Hour
+
+	clock := &testClock{now: time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)}
+	rateLimit := InitRateLimit(conf, repository.InitUsage(usagePath))
+	rateLimit.now = clock.Now
+	return rateLimit, clock
+}
+
+func TestRateLimitAllow(t *testing.T) {
+	type step struct {
+		advance time.Duration
+		client  string
+		budget  string
+		want    model.RateLimitDecision
+	}
+	tests := []struct {
+		name     string
+		disabled bool
+		steps    []step
+	}{
+		{
+			name: "burst is exhausted",
+			steps: []step{
+				{budget: model.ScopeSearch, want: model.RateLimitDecision{Allowed: true, Limit: 3, Remaining: 2, Reset: 500 * time.Millisecond}},
+				{budget: model.ScopeSearch, want: model.RateLimitDecision{Allowed: true, Limit: 3, Remaining: 1, Reset: time.Second}},
+				{budget: model.ScopeSearch, want: model.RateLimitDecision{Allowed: true, Limit: 3, Remaining: 0, Reset: 1500 * time.Millisecond}},
+				{budget: model.ScopeSearch, want: model.RateLimitDecision{
+					Limit: 3, Remaining: 0, Reset: 1500 * time.Millisecond, RetryAfter: 500 * time.Millisecond}},
+			},
+		},
+		{
+			name: "bucket is refilled",
+			steps: []step{
+				{budget: model.ScopeIndex, want: model.RateLimitDecision{Allowed: true, Limit: 1, Remaining: 0, Reset: time.Second}},
+				{advance: 400 * time.Millisecond, budget: model.ScopeIndex, want: model.RateLimitDecision{
+					Limit: 1, Remaining: 0, Reset: 600 * time.Millisecond, RetryAfter: 600 * time.Millisecond}},
+				{advance: 600 * time.Millisecond, budget: model.ScopeIndex, want: model.RateLimitDecision{
+					Allowed: true, Limit: 1, Remaining: 0, Reset: time.Second}},
+				// the refill is capped at the burst
+				{advance: time.Hour, budget: model.ScopeIndex, want: model.RateLimitDecision{
+					Allowed: true, Limit: 1, Remaining: 0, Reset: time.Second}},
+			},
+		},
+		{
+			name: "budgets and clients are separate",
+			steps: []step{
+				{budget: model.ScopeIndex, want: model.RateLimitDecision{Allowed: true, Limit: 1, Remaining: 0, Reset: time.Second}},
+				{budget: model.ScopeIndex, want: model.RateLimitDecision{Limit: 1, Reset: time.Second, RetryAfter: time.Second}},
+				{budget: model.ScopeSearch, want: model.RateLimitDecision{Allowed: true, Limit: 3, Remaining: 2, Reset: 500 * time.Millisecond}},
+				{client: "ip:10.0.0.2", budget: model.ScopeIndex, want: model.RateLimitDecision{
+					Allowed: true, Limit: 1, Remaining: 0, Reset: time.Second}},
+			},
+		},
+		{
+			name: "unlimited budget",
+			steps: []step{
+				{budget: model.ScopeAdmin, want: model.RateLimitDecision{Allowed: true}},
+			},
+		},
+		{
+			name:     "rate limit is disabled",
+			disabled: true,
+			steps: []step{
+				{budget: model.ScopeIndex, want: model.RateLimitDecision{Allowed: true}},
+				{budget: model.ScopeIndex, want: model.RateLimitDecision{Allowed: true}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rateLimit, clock := initTestRateLimit(t, filepath.Join(t.TempDir(), "usage.json"))
+			rateLimit.conf.RateLimit.Enabled = !tt.disabled
+			for i, step := range tt.steps {
+				clock.advance(step.advance)
+				client := step.client
+				if client == "" {
+					client = "ip:10.0.0.1"
+				}
+				if got := rateLimit.Allow(client, step.budget); got != step.want {
+					t.Errorf("step %d: decision = %+v, want %+v", i, got, step.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimitFlushKeepsTheCountersOnFailure(t *testing.T) {
+	ctx := context.Background()
+	// the usage path is a directory, so the flush is failed
+	rateLimit, _ := initTestRateLimit(t, t.TempDir())
+
+	rateLimit.Allow("ip:10.0.0.1", model.ScopeIndex)
+	rateLimit.Allow("ip:10.0.0.1", model.ScopeIndex)
+	if err := rateLimit.Flush(ctx); err == nil {
+		t.Fatal("expected the flush to be failed")
+	}
+	rateLimit.Allow("ip:10.0.0.1", model.ScopeIndex)
+
+	rateLimit.usageRepo = repository.InitUsage(filepath.Join(t.TempDir(), "usage.json"))
+	usages, err := rateLimit.GetUsages(ctx, "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := model.Usages{{Date: "2026-10-19", Client: "ip:10.0.0.1", Budget: model.ScopeIndex, Requests: 3, Rejected: 2}}
+	if len(usages) != 1 || usages[0] != want[0] {
+		t.Errorf("usages = %+v, want %+v", usages, want)
+	}
+	if rejected := rateLimit.Rejected(); rejected != 2 {
+		t.Errorf("rejected = %d, want 2", rejected)
+	}
+}
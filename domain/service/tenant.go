@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/isdzulqor/kraicklist/config"
+	"github.com/isdzulqor/kraicklist/domain/model"
+	"github.com/isdzulqor/kraicklist/domain/repository"
+	"github.com/isdzulqor/kraicklist/helper/errors"
+)
+
+// TenantRuntime serves the tenants, it opens their indices, store and jobs and deletes them on drop
+type TenantRuntime interface {
+	// OpenTenant starts serving the tenant, its indices are created when they don't exist.
+	// The index name of the activated indexer is returned
+	OpenTenant(ctx context.Context, name string) (indexName string, err error)
+	// DropTenant stops serving the tenant and deletes its indices, store and jobs
+	DropTenant(ctx context.Context, name string) error
+}
+
+type Tenant struct {
+	conf       *config.Config
+	tenantRepo *repository.Tenant
+	runtime    TenantRuntime
+}
+
+func InitTenant(conf *config.Config, tenantRepo *repository.Tenant, runtime TenantRuntime) *Tenant {
+	return &Tenant{
+		conf:       conf,
+		tenantRepo: tenantRepo,
+		runtime:    runtime,
+	}
+}
+
+func (s *Tenant) GetTenants(ctx context.Context) (model.Tenants, error) {
+	return s.tenantRepo.GetTenants()
+}
+
+// CreateTenant creates the indices of the tenant then registers it
+func (s *Tenant) CreateTenant(ctx context.Context, name string) (out model.Tenant, err error) {
+	if err = config.ValidateTenantName(name); err != nil {
+		err = errors.ErrorParamInvalid.AppendMessage(err.Error())
+		return
+	}
+	if _, err = s.tenantRepo.GetTenant(name); err == nil {
+		err = errors.ErrorParamInvalid.AppendMessage("tenant " + name + " already exists")
+		return
+	} else if !errors.IsEqual(err, errors.ErrorNotFound) {
+		return
+	}
+
+	indexName, err := s.runtime.OpenTenant(ctx, name)
+	if err != nil {
+		return
+	}
+	out = model.Tenant{
+		Name:      name,
+		Indexer:   s.conf.IndexerActivated,
+		IndexName: indexName,
+		CreatedAt: time.Now(),
+	}
+	if err = s.tenantRepo.SaveTenant(out); err != nil {
+		s.runtime.DropTenant(ctx, name)
+	}
+	return
+}
+
+// DropTenant deletes the indices, the store and the jobs of the tenant then unregisters it
+func (s *Tenant) DropTenant(ctx context.Context, name string) (out model.Tenant, err error) {
+	if out, err = s.tenantRepo.GetTenant(name); err != nil {
+		return
+	}
+	if err = s.runtime.DropTenant(ctx, name); err != nil {
+		return
+	}
+	err = s.tenantRepo.DeleteTenant(name)
+	return
+}
@@ -0,0 +1,303 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/isdzulqor/kraicklist/config"
+	"github.com/isdzulqor/kraicklist/domain/model"
+	"github.com/isdzulqor/kraicklist/external/index"
+	"github.com/isdzulqor/kraicklist/helper/logging"
+)
+
+const (
+	bleveReadOnlyOpenTimeout = 3 * time.Second
+	// bleveSearchSize is the default size of bleve search
+	bleveSearchSize = 10
+
+	statsTermsField = "title"
+)
+
+func init() {
+	Register(index.IndexBleve, openBleve)
+}
+
+type bleveBackend struct {
+	conf  *config.Config
+	index *index.BleveIndex
+}
+
+func openBleve(ctx context.Context, conf *config.Config, opts Options) (SearchBackend, error) {
+	if opts.IndexName != "" {
+		override := *conf
+		override.Advertisement.Bleve.IndexName = opts.IndexName
+		conf = &override
+	}
+	logging.InfoContext(ctx, "using bleve index %s...", conf.Advertisement.Bleve.IndexName)
+
+	var (
+		bleveIndex *index.BleveIndex
+		err        error
+	)
+	if opts.ReadOnly {
+		bleveIndex, err = index.InitBleveIndexReadOnly(ctx, conf.Advertisement.Bleve.IndexName, bleveReadOnlyOpenTimeout)
+	} else {
+		bleveIndex, err = index.InitBleveIndex(ctx, conf.Advertisement.Bleve.IndexName,
+			index.BleveBulkConfig{
+				BatchSize:  conf.Advertisement.Bleve.BatchSize,
+				NumWorkers: conf.Advertisement.Bleve.NumWorkers,
+			})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &bleveBackend{conf: conf, index: bleveIndex}, nil
+}
+
+func (b *bleveBackend) Name() string {
+	return index.IndexBleve
+}
+
+func (b *bleveBackend) IndexName() string {
+	return b.conf.Advertisement.Bleve.IndexName
+}
+
+func (b *bleveBackend) Index(ctx context.Context, ads model.Advertisements) error {
+	docs, err := ads.ToBleveDocs()
+	if err != nil {
+		return fmt.Errorf("failed to convert to bleveDocs, err:%v", err)
+	}
+	if errorDocs := b.index.BulkIndex(ctx, docs); errorDocs != nil {
+		return errorDocs.ToError()
+	}
+	return nil
+}
+
+func (b *bleveBackend) Get(ctx context.Context, ids []int64, fields ...string) (model.Advertisements, error) {
+	hits, err := b.index.GetDocs(ctx, toDocIDs(ids), bleveFields(fields))
+	if err != nil {
+		return nil, err
+	}
+	return bleveHitsToAds(hits)
+}
+
+func (b *bleveBackend) Delete(ctx context.Context, ids []int64) error {
+	if errorDocs := b.index.BulkDelete(ctx, toDocIDs(ids)); errorDocs != nil {
+		return errorDocs.ToError()
+	}
+	return nil
+}
+
+func (b *bleveBackend) Search(ctx context.Context, query model.AdSearchQuery) (out model.AdSearchHits, err error) {
+	opts := index.BleveSearchOptions{
+		Filter: bleveFilterQuery(query.Filter),
+		Size:   query.Size,
+	}
+	switch query.Sort {
+	case model.SortNewest:
+		opts.SortBy = []string{"-updated_at", "-_score"}
+	case model.SortOldest:
+		opts.SortBy = []string{"updated_at", "-_score"}
+	}
+
+	if opts.Size <= 0 {
+		opts.Size = bleveSearchSize
+	}
+
+	// tags are matched by phrase on bleve like the scan, the hits failing the exact match are skipped
+	// and the next pages are searched until the size is filled
+	for {
+		var ads model.Advertisements
+		result, err := b.index.SearchQuery(ctx, query.Keyword, opts, &ads)
+		if err != nil {
+			return nil, err
+		}
+		for i, hit := range result.Hits {
+			ads[i].Normalize()
+			if !query.Filter.Match(ads[i]) {
+				continue
+			}
+			out = append(out, model.AdSearchHit{Advertisement: ads[i], Score: hit.Score})
+			if len(out) == opts.Size {
+				return out, nil
+			}
+		}
+		if len(result.Hits) < opts.Size {
+			return out, nil
+		}
+		opts.From += opts.Size
+	}
+}
+
+func (b *bleveBackend) Scan(ctx context.Context, filter model.AdFilter, pageSize int,
+	fn func(ads model.Advertisements) error, fields ...string) error {
+	loadFields := bleveFields(fields)
+	if filter.IsEmpty() {
+		return b.index.ScanDocs(ctx, nil, pageSize, loadFields, func(hits []index.BleveHit) error {
+			ads, err := bleveHitsToAds(hits)
+			if err != nil {
+				return err
+			}
+			return fn(ads)
+		})
+	}
+
+	// tags are matched by phrase on bleve, the exact match is checked here on the stored fields
+	if len(fields) > 0 {
+		loadFields = append(loadFields, "tags", "updated_at")
+	}
+	return b.index.ScanDocs(ctx, bleveFilterQuery(filter), pageSize, loadFields, func(hits []index.BleveHit) error {
+		ads, err := bleveHitsToAds(hits)
+		if err != nil {
+			return err
+		}
+		matched := ads[:0]
+		for _, doc := range ads {
+			if filter.Match(doc) {
+				matched = append(matched, doc)
+			}
+		}
+		if len(matched) == 0 {
+			return nil
+		}
+		return fn(matched)
+	})
+}
+
+func (b *bleveBackend) Count(ctx context.Context) (int64, error) {
+	count, err := b.index.DocCount()
+	return int64(count), err
+}
+
+func (b *bleveBackend) Stats(ctx context.Context, topN int) (out model.IndexStats, err error) {
+	out.Indexer = b.Name()
+	out.Index = b.IndexName()
+
+	bleveStats, err := b.index.Stats(ctx, statsTermsField, topN)
+	if err != nil {
+		return
+	}
+	out.DocCount = int64(bleveStats.DocCount)
+	out.DiskSizeBytes = bleveStats.DiskSizeBytes
+	out.Segments = bleveStats.Segments
+	for _, term := range bleveStats.TopTerms {
+		out.TopTerms = append(out.TopTerms, model.TermCount{Term: term.Term, Count: int64(term.Count)})
+	}
+
+	// bleve analyzes the tags into words, whole tags and updated_at range are gathered from the stored fields
+	tagCounts := map[string]int64{}
+	err = b.Scan(ctx, model.AdFilter{}, b.conf.Advertisement.Bulk.BatchSize, func(ads model.Advertisements) error {
+		for _, doc := range ads {
+			for _, tag := range doc.TagList() {
+				tagCounts[tag]++
+			}
+			if out.OldestUpdatedAt == 0 || doc.UpdatedAt < out.OldestUpdatedAt {
+				out.OldestUpdatedAt = doc.UpdatedAt
+			}
+			if doc.UpdatedAt > out.NewestUpdatedAt {
+				out.NewestUpdatedAt = doc.UpdatedAt
+			}
+		}
+		return nil
+	}, "tags", "updated_at")
+	if err != nil {
+		return
+	}
+
+	out.TagCardinality = int64(len(tagCounts))
+	out.TopTags = topTermCounts(tagCounts, topN)
+	return
+}
+
+func (b *bleveBackend) Ping(ctx context.Context) error {
+	_, err := b.index.DocCount()
+	return err
+}
+
+func (b *bleveBackend) Close() error {
+	return b.index.Close()
+}
+
+func (b *bleveBackend) Drop(ctx context.Context) error {
+	if err := b.Close(); err != nil {
+		return err
+	}
+	return os.RemoveAll(index.BleveDocPath(b.IndexName()))
+}
+
+// bleveFields maps the loaded fields, all stored fields are loaded when it's empty
+func bleveFields(fields []string) []string {
+	if len(fields) == 0 {
+		return []string{"*"}
+	}
+	return append([]string(nil), fields...)
+}
+
+func bleveFilterQuery(filter model.AdFilter) index.BleveQuery {
+	var queries []index.BleveQuery
+	if len(filter.Tags) > 0 {
+		queries = append(queries, index.BleveMatchAnyPhrase("tags", filter.Tags))
+	}
+	if filter.UpdatedSince > 0 || filter.UpdatedUntil > 0 {
+		var since, until *float64
+		if filter.UpdatedSince > 0 {
+			v := float64(filter.UpdatedSince)
+			since = &v
+		}
+		if filter.UpdatedUntil > 0 {
+			v := float64(filter.UpdatedUntil)
+			until = &v
+		}
+		queries = append(queries, index.BleveNumericRange("updated_at", since, until))
+	}
+	if len(queries) == 0 {
+		return nil
+	}
+	return index.BleveConjunction(queries...)
+}
+
+func bleveHitsToAds(hits []index.BleveHit) (out model.Advertisements, err error) {
+	out = make(model.Advertisements, 0, len(hits))
+	for _, hit := range hits {
+		var data []byte
+		if data, err = json.Marshal(hit.Fields); err != nil {
+			return
+		}
+		var doc model.Advertisement
+		if err = json.Unmarshal(data, &doc); err != nil {
+			err = fmt.Errorf("failed to decode doc %s, err: %v", hit.ID, err)
+			return
+		}
+		// the ID is always known even when the id field isn't loaded
+		if doc.ID, err = strconv.ParseInt(hit.ID, 10, 64); err != nil {
+			err = fmt.Errorf("invalid ad ID %s, err: %v", hit.ID, err)
+			return
+		}
+		doc.Normalize()
+		out = append(out, doc)
+	}
+	return
+}
+
+// topTermCounts sorts the counts descending, ties are ordered by the term
+func topTermCounts(counts map[string]int64, topN int) []model.TermCount {
+	var out []model.TermCount
+	for term, count := range counts {
+		out = append(out, model.TermCount{Term: term, Count: count})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count == out[j].Count {
+			return out[i].Term < out[j].Term
+		}
+		return out[i].Count > out[j].Count
+	})
+	if len(out) > topN {
+		out = out[:topN]
+	}
+	return out
+}
@@ -0,0 +1,73 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/isdzulqor/kraicklist/config"
+	"github.com/isdzulqor/kraicklist/domain/model"
+)
+
+func TestBleveBackendSearchMatchesTheExactTags(t *testing.T) {
+	// the bleve index is created under ./data of the working dir
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	ctx := context.Background()
+	conf := &config.Config{}
+	conf.Advertisement.Bleve.IndexName = "search-test.bleve"
+	conf.Advertisement.Bleve.BatchSize, conf.Advertisement.Bleve.NumWorkers = 10, 1
+	backend, err := openBleve(ctx, conf, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	// the older ads only have the tag as a phrase of their tags, they're ahead on the oldest sort
+	err = backend.Index(ctx, model.Advertisements{
+		{ID: 1, Title: "iphone bekas", Tags: []string{"kota bandung"}, UpdatedAt: 100},
+		{ID: 2, Title: "iphone murah", Tags: []string{"kota bandung"}, UpdatedAt: 200},
+		{ID: 3, Title: "iphone baru", Tags: []string{"kota"}, UpdatedAt: 300},
+		{ID: 4, Title: "iphone mulus", Tags: []string{"kota"}, UpdatedAt: 400},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		size    int
+		wantIDs []int64
+	}{
+		{name: "size is filled from the next pages", size: 1, wantIDs: []int64{3}},
+		{name: "all of the exact matches", size: 10, wantIDs: []int64{3, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hits, err := backend.Search(ctx, model.AdSearchQuery{
+				Keyword: "iphone",
+				Filter:  model.AdFilter{Tags: []string{"kota"}},
+				Sort:    model.SortOldest,
+				Size:    tt.size,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(hits) != len(tt.wantIDs) {
+				t.Fatalf("got %d hits, want %v", len(hits), tt.wantIDs)
+			}
+			for i, hit := range hits {
+				if hit.ID != tt.wantIDs[i] {
+					t.Errorf("hit %d is ad %d, want %d", i, hit.ID, tt.wantIDs[i])
+				}
+			}
+		})
+	}
+}
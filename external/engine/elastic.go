@@ -0,0 +1,286 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/isdzulqor/kraicklist/config"
+	"github.com/isdzulqor/kraicklist/domain/model"
+	"github.com/isdzulqor/kraicklist/external/index"
+	"github.com/isdzulqor/kraicklist/helper/logging"
+)
+
+func init() {
+	Register(index.IndexElastic, openElastic)
+}
+
+type elasticBackend struct {
+	conf  *config.Config
+	index *index.ElasticIndex
+}
+
+func openElastic(ctx context.Context, conf *config.Config, opts Options) (SearchBackend, error) {
+	if opts.IndexName != "" {
+		override := *conf
+		override.Advertisement.Elastic.IndexName = opts.IndexName
+		conf = &override
+	}
+	logging.InfoContext(ctx, "using elastic index %s...", conf.Advertisement.Elastic.IndexName)
+
+	esIndex, err := index.InitESIndex(ctx,
+		conf.Elastic.Host,
+		conf.Elastic.Username,
+		conf.Elastic.Password,
+		conf.Advertisement.Elastic.IndexName,
+		index.ElasticBulkConfig{
+			NumWorkers:       conf.Elastic.Bulk.NumWorkers,
+			FlushBytes:       conf.Elastic.Bulk.FlushBytes,
+			FlushInterval:    conf.Elastic.Bulk.FlushInterval,
+			MaxRetries:       conf.Elastic.Bulk.MaxRetries,
+			RetryBackoffMin:  conf.Elastic.Bulk.RetryBackoffMin,
+			RetryBackoffMax:  conf.Elastic.Bulk.RetryBackoffMax,
+			FailureThreshold: conf.Elastic.Bulk.FailureThreshold,
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	// check es7 cluster readiness with retry
+	if err = esIndex.PingWithRetry(conf.Elastic.PingRetry,
+		conf.Elastic.PingWaitTime); err != nil {
+		logging.WarnContext(ctx, "%v", err)
+	}
+
+	if opts.RecreateIndex {
+		if err = esIndex.DeleteIndex(ctx); err != nil {
+			logging.WarnContext(ctx, "%v", err)
+		}
+	}
+	return &elasticBackend{conf: conf, index: esIndex}, nil
+}
+
+func (e *elasticBackend) Name() string {
+	return index.IndexElastic
+}
+
+func (e *elasticBackend) IndexName() string {
+	return e.conf.Advertisement.Elastic.IndexName
+}
+
+func (e *elasticBackend) Index(ctx context.Context, ads model.Advertisements) error {
+	docs, err := ads.ToElasticDocs()
+	if err != nil {
+		return fmt.Errorf("failed to convert to elasticDocs, err:%v", err)
+	}
+	errorDocs, err := e.index.BulkIndexDocs(ctx, docs)
+	if err != nil {
+		return err
+	}
+	if errorDocs != nil {
+		return errorDocs.ToError()
+	}
+	return nil
+}
+
+func (e *elasticBackend) Get(ctx context.Context, ids []int64, fields ...string) (model.Advertisements, error) {
+	hits, err := e.index.GetDocs(ctx, toDocIDs(ids), fields...)
+	if err != nil {
+		return nil, err
+	}
+	return elasticHitsToAds(hits)
+}
+
+func (e *elasticBackend) Delete(ctx context.Context, ids []int64) error {
+	errorDocs, err := e.index.BulkDeleteDocs(ctx, toDocIDs(ids))
+	if err != nil {
+		return err
+	}
+	if errorDocs != nil {
+		return errorDocs.ToError()
+	}
+	return nil
+}
+
+func (e *elasticBackend) Search(ctx context.Context, query model.AdSearchQuery) (out model.AdSearchHits, err error) {
+	esQuery := index.ElasticRootQuery{Size: query.Size}
+	esQuery.ConstructElasticMultiMatchQuery(query.Keyword, "title", "content", "tags")
+	esQuery.ApplyFilter(elasticFilters(query.Filter)...)
+	switch query.Sort {
+	case model.SortNewest:
+		esQuery.Sort = []interface{}{map[string]string{"updated_at": "desc"}, "_score"}
+	case model.SortOldest:
+		esQuery.Sort = []interface{}{map[string]string{"updated_at": "asc"}, "_score"}
+	}
+
+	var ads model.Advertisements
+	result, err := e.index.SearchQuery(ctx, esQuery, &ads)
+	if err != nil {
+		return
+	}
+	for i, hit := range result.Hits.Hits {
+		out = append(out, model.AdSearchHit{Advertisement: ads[i], Score: hit.Score})
+	}
+	return
+}
+
+func (e *elasticBackend) Scan(ctx context.Context, filter model.AdFilter, pageSize int,
+	fn func(ads model.Advertisements) error, fields ...string) error {
+	return e.index.ScanDocs(ctx, pageSize, elasticFilterQuery(filter), func(hits []index.ElasticHit) error {
+		ads, err := elasticHitsToAds(hits)
+		if err != nil {
+			return err
+		}
+		return fn(ads)
+	}, fields...)
+}
+
+// Count refreshes the index first so the recent writes are counted
+func (e *elasticBackend) Count(ctx context.Context) (int64, error) {
+	if err := e.index.Refresh(ctx); err != nil {
+		return 0, err
+	}
+	return e.index.Count(ctx)
+}
+
+func (e *elasticBackend) Stats(ctx context.Context, topN int) (out model.IndexStats, err error) {
+	out.Indexer = e.Name()
+	out.Index = e.IndexName()
+
+	if out.DocCount, err = e.Count(ctx); err != nil {
+		return
+	}
+	storeStats, err := e.index.StoreStats(ctx)
+	if err != nil {
+		return
+	}
+	out.DiskSizeBytes = storeStats.SizeBytes
+	out.Segments = &storeStats.Segments
+
+	var aggs struct {
+		TopTags        elasticTermsAgg `json:"top_tags"`
+		TagCardinality struct {
+			Value int64 `json:"value"`
+		} `json:"tag_cardinality"`
+		OldestUpdatedAt struct {
+			Value *float64 `json:"value"`
+		} `json:"oldest_updated_at"`
+		NewestUpdatedAt struct {
+			Value *float64 `json:"value"`
+		} `json:"newest_updated_at"`
+	}
+	err = e.index.Aggregate(ctx, map[string]interface{}{
+		"top_tags":          map[string]interface{}{"terms": map[string]interface{}{"field": "tags.keyword", "size": topN}},
+		"tag_cardinality":   map[string]interface{}{"cardinality": map[string]interface{}{"field": "tags.keyword"}},
+		"oldest_updated_at": map[string]interface{}{"min": map[string]interface{}{"field": "updated_at"}},
+		"newest_updated_at": map[string]interface{}{"max": map[string]interface{}{"field": "updated_at"}},
+	}, &aggs)
+	if err != nil {
+		return
+	}
+	out.TopTags = aggs.TopTags.termCounts()
+	out.TagCardinality = aggs.TagCardinality.Value
+	if aggs.OldestUpdatedAt.Value != nil {
+		out.OldestUpdatedAt = int64(*aggs.OldestUpdatedAt.Value)
+	}
+	if aggs.NewestUpdatedAt.Value != nil {
+		out.NewestUpdatedAt = int64(*aggs.NewestUpdatedAt.Value)
+	}
+
+	// terms of a text field need fielddata which is disabled by default, it's aggregated separately
+	// so the other stats are still reported
+	var termsAggs struct {
+		TopTerms elasticTermsAgg `json:"top_terms"`
+	}
+	termsErr := e.index.Aggregate(ctx, map[string]interface{}{
+		"top_terms": map[string]interface{}{"terms": map[string]interface{}{"field": statsTermsField, "size": topN}},
+	}, &termsAggs)
+	if termsErr != nil {
+		out.Warnings = append(out.Warnings, fmt.Sprintf("top terms need fielddata enabled on %s field", statsTermsField))
+		return out, nil
+	}
+	out.TopTerms = termsAggs.TopTerms.termCounts()
+	return
+}
+
+func (e *elasticBackend) Ping(ctx context.Context) error {
+	return e.index.PingContext(ctx)
+}
+
+// Close is a no-op, the elastic client has nothing to release
+func (e *elasticBackend) Close() error {
+	return nil
+}
+
+func (e *elasticBackend) Drop(ctx context.Context) error {
+	return e.index.DeleteIndex(ctx)
+}
+
+type elasticTermsAgg struct {
+	Buckets []struct {
+		Key      string `json:"key"`
+		DocCount int64  `json:"doc_count"`
+	} `json:"buckets"`
+}
+
+func (agg elasticTermsAgg) termCounts() []model.TermCount {
+	out := make([]model.TermCount, 0, len(agg.Buckets))
+	for _, bucket := range agg.Buckets {
+		out = append(out, model.TermCount{Term: bucket.Key, Count: bucket.DocCount})
+	}
+	return out
+}
+
+func elasticFilterQuery(filter model.AdFilter) interface{} {
+	filters := elasticFilters(filter)
+	if len(filters) == 0 {
+		return nil
+	}
+	return map[string]interface{}{
+		"bool": map[string]interface{}{"filter": filters},
+	}
+}
+
+func elasticFilters(filter model.AdFilter) (filters []interface{}) {
+	if len(filter.Tags) > 0 {
+		filters = append(filters, map[string]interface{}{
+			"terms": map[string]interface{}{"tags.keyword": filter.Tags},
+		})
+	}
+	if updatedAt := updatedAtRange(filter); len(updatedAt) > 0 {
+		filters = append(filters, map[string]interface{}{
+			"range": map[string]interface{}{"updated_at": updatedAt},
+		})
+	}
+	return
+}
+
+func updatedAtRange(filter model.AdFilter) map[string]int64 {
+	out := map[string]int64{}
+	if filter.UpdatedSince > 0 {
+		out["gte"] = filter.UpdatedSince
+	}
+	if filter.UpdatedUntil > 0 {
+		out["lte"] = filter.UpdatedUntil
+	}
+	return out
+}
+
+func elasticHitsToAds(hits []index.ElasticHit) (out model.Advertisements, err error) {
+	out = make(model.Advertisements, 0, len(hits))
+	for _, hit := range hits {
+		var doc model.Advertisement
+		if err = json.Unmarshal(hit.Source, &doc); err != nil {
+			err = fmt.Errorf("failed to decode doc %s, err: %v", hit.ID, err)
+			return
+		}
+		// the ID is always known even when the id field isn't loaded
+		if doc.ID, err = strconv.ParseInt(hit.ID, 10, 64); err != nil {
+			err = fmt.Errorf("invalid ad ID %s, err: %v", hit.ID, err)
+			return
+		}
+		out = append(out, doc)
+	}
+	return
+}
@@ -0,0 +1,116 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/isdzulqor/kraicklist/config"
+	"github.com/isdzulqor/kraicklist/domain/model"
+)
+
+// SearchBackend stores and searches the ads, it's selected by INDEXER_ACTIVATED.
+// Fields limit the loaded ad fields by their json names, all fields are loaded when it's empty
+type SearchBackend interface {
+	// Name is the registered name of the backend, i.e: bleve
+	Name() string
+	// IndexName is the index of the backend the ads are stored on
+	IndexName() string
+
+	// Index creates or replaces the ads, errors.DocErrors is returned when some of them are failed
+	Index(ctx context.Context, ads model.Advertisements) error
+	// Get returns the ads by their IDs, ads which are not indexed are not listed
+	Get(ctx context.Context, ids []int64, fields ...string) (model.Advertisements, error)
+	// Delete deletes the ads by their IDs, errors.DocErrors is returned when some of them are failed
+	Delete(ctx context.Context, ids []int64) error
+	// Search searches the ads by the keyword, hits are ordered by the relevance score unless other sort is given
+	Search(ctx context.Context, query model.AdSearchQuery) (model.AdSearchHits, error)
+	// Scan pages through all ads passing the filter
+	Scan(ctx context.Context, filter model.AdFilter, pageSize int, fn func(ads model.Advertisements) error,
+		fields ...string) error
+	// Count returns the number of the ads, recent writes are visible
+	Count(ctx context.Context) (int64, error)
+	// Stats gathers the statistics of the index, topN limits the top terms and tags
+	Stats(ctx context.Context, topN int) (model.IndexStats, error)
+
+	Ping(ctx context.Context) error
+	Close() error
+	// Drop closes the backend and deletes its index, i.e: the tenant is dropped
+	Drop(ctx context.Context) error
+}
+
+// Options configures how the backend is opened
+type Options struct {
+	// RecreateIndex deletes the existing index first
+	RecreateIndex bool
+	// ReadOnly opens an existing index without write access
+	ReadOnly bool
+	// IndexName overrides the configured index of the backend, i.e: the bleve index name or the sqlite path
+	IndexName string
+}
+
+// Factory opens the backend with the config
+type Factory func(ctx context.Context, conf *config.Config, opts Options) (SearchBackend, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = map[string]Factory{}
+)
+
+// Register makes the backend available by the name, it panics when the name is registered twice
+func Register(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if factory == nil {
+		panic("engine: Register factory is nil for " + name)
+	}
+	if _, ok := factories[name]; ok {
+		panic("engine: Register called twice for " + name)
+	}
+	factories[name] = factory
+}
+
+// Names returns the sorted names of the registered backends
+func Names() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	out := make([]string, 0, len(factories))
+	for name := range factories {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// IsRegistered reports whether the backend is registered by the name
+func IsRegistered(name string) bool {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	_, ok := factories[name]
+	return ok
+}
+
+// Open opens the backend registered by the name
+func Open(ctx context.Context, name string, conf *config.Config, opts Options) (SearchBackend, error) {
+	factoriesMu.RLock()
+	factory, ok := factories[name]
+	factoriesMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("Indexer for %s is invalid, available: %s", name, strings.Join(Names(), ", "))
+	}
+	return factory(ctx, conf, opts)
+}
+
+func toDocIDs(ids []int64) []string {
+	out := make([]string, 0, len(ids))
+	for _, id := range ids {
+		out = append(out, fmt.Sprint(id))
+	}
+	return out
+}
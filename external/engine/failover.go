@@ -0,0 +1,274 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/isdzulqor/kraicklist/domain/model"
+	"github.com/isdzulqor/kraicklist/helper/errors"
+	"github.com/isdzulqor/kraicklist/helper/logging"
+)
+
+// failoverEventsRetention is the number of the latest failover events kept for the status
+const failoverEventsRetention = 20
+
+// FailoverConfig configures when the searches are routed to the replica and back to the primary
+type FailoverConfig struct {
+	// CheckInterval is the interval of the health checks on the primary
+	CheckInterval time.Duration
+	// LatencyThreshold marks the health checks and the searches slower than it as failed
+	LatencyThreshold time.Duration
+	// FailureThreshold is the number of the consecutive failures which routes the searches to the replica
+	FailureThreshold int
+	// RecoveryThreshold is the number of the consecutive healthy checks which routes the searches back to the primary
+	RecoveryThreshold int
+}
+
+// FailoverReporter reports the state of the failover mode
+type FailoverReporter interface {
+	FailoverStatus() model.FailoverStatus
+}
+
+// FailoverBackend serves from the primary while the writes go to both backends.
+// The searches are served by the replica while the primary is failing or slow, until it recovers
+type FailoverBackend struct {
+	SearchBackend
+
+	replica SearchBackend
+	config  FailoverConfig
+	stop    chan struct{}
+	done    chan struct{}
+
+	mu                  sync.Mutex
+	degraded            bool
+	reason              string
+	since               time.Time
+	failures, successes int
+	events              []model.FailoverEvent
+
+	failovers, recoveries, degradedSearches, replicaWriteErrors int64
+}
+
+// NewFailover wraps the primary and starts the health checks, the replica is closed along with it
+func NewFailover(primary, replica SearchBackend, config FailoverConfig) *FailoverBackend {
+	f := &FailoverBackend{
+		SearchBackend: primary,
+		replica:       replica,
+		config:        config,
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	go f.checkLoop()
+	return f
+}
+
+type searchStatusKey struct{}
+
+// WithSearchStatus returns the ctx the search status is reported on,
+// i.e: the search is served by the failover replica
+func WithSearchStatus(ctx context.Context) (context.Context, *model.SearchStatus) {
+	status := &model.SearchStatus{}
+	return context.WithValue(ctx, searchStatusKey{}, status), status
+}
+
+// Index indexes the ads on the primary then on the replica, only the primary result is returned
+func (f *FailoverBackend) Index(ctx context.Context, ads model.Advertisements) error {
+	err := f.SearchBackend.Index(ctx, ads)
+	f.writeReplica(ctx, "index", f.replica.Index(ctx, ads))
+	return err
+}
+
+// Delete deletes the ads on the primary then on the replica, only the primary result is returned
+func (f *FailoverBackend) Delete(ctx context.Context, ids []int64) error {
+	err := f.SearchBackend.Delete(ctx, ids)
+	f.writeReplica(ctx, "delete", f.replica.Delete(ctx, ids))
+	return err
+}
+
+func (f *FailoverBackend) writeReplica(ctx context.Context, action string, err error) {
+	if err == nil {
+		return
+	}
+	f.mu.Lock()
+	f.replicaWriteErrors++
+	f.mu.Unlock()
+	logging.WarnContext(ctx, "failover replica %s on %s is failed, err: %v", action, f.replica.Name(), err)
+}
+
+// Search searches on the primary, it's served by the replica while the primary is degraded
+// or when the search on the primary is failed
+func (f *FailoverBackend) Search(ctx context.Context, query model.AdSearchQuery) (model.AdSearchHits, error) {
+	f.mu.Lock()
+	degraded, reason := f.degraded, f.reason
+	f.mu.Unlock()
+	if degraded {
+		return f.searchReplica(ctx, query, reason)
+	}
+
+	start := time.Now()
+	hits, err := f.SearchBackend.Search(ctx, query)
+	latency := time.Since(start)
+	if err != nil {
+		// invalid queries and canceled requests would fail on the replica too
+		if ctx.Err() != nil || errors.GetStatusCode(err) < http.StatusInternalServerError {
+			return hits, err
+		}
+		reason = fmt.Sprintf("search on %s is failed, err: %v", f.SearchBackend.Name(), err)
+		f.observe(ctx, false, reason)
+		return f.searchReplica(ctx, query, reason)
+	}
+	f.observe(ctx, latency <= f.config.LatencyThreshold,
+		fmt.Sprintf("search on %s took %s, over the %s threshold", f.SearchBackend.Name(), latency,
+			f.config.LatencyThreshold))
+	return hits, nil
+}
+
+func (f *FailoverBackend) searchReplica(ctx context.Context, query model.AdSearchQuery,
+	reason string) (model.AdSearchHits, error) {
+	f.mu.Lock()
+	f.degradedSearches++
+	f.mu.Unlock()
+
+	if status, ok := ctx.Value(searchStatusKey{}).(*model.SearchStatus); ok {
+		status.Degraded = true
+		status.Reason = reason
+	}
+	return f.replica.Search(ctx, query)
+}
+
+// observe counts the consecutive healthy and failed checks, the reason is kept when it fails over
+func (f *FailoverBackend) observe(ctx context.Context, ok bool, reason string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if ok {
+		f.failures = 0
+		if !f.degraded {
+			return
+		}
+		if f.successes++; f.successes < f.config.RecoveryThreshold {
+			return
+		}
+		f.degraded = false
+		f.reason = ""
+		f.recoveries++
+		f.addEvent(model.FailoverEventRecovery, fmt.Sprintf("%s is healthy on %d consecutive checks",
+			f.SearchBackend.Name(), f.successes))
+		logging.InfoContext(ctx, "%s is recovered, searches are served by it again", f.SearchBackend.Name())
+		return
+	}
+
+	f.successes = 0
+	if f.failures++; f.degraded || f.failures < f.config.FailureThreshold {
+		return
+	}
+	f.degraded = true
+	f.reason = reason
+	f.since = time.Now()
+	f.failovers++
+	f.addEvent(model.FailoverEventFailover, reason)
+	logging.WarnContext(ctx, "searches are served by %s, reason: %s", f.replica.Name(), reason)
+}
+
+func (f *FailoverBackend) addEvent(eventType, reason string) {
+	f.events = append(f.events, model.FailoverEvent{Type: eventType, Reason: reason, At: time.Now()})
+	if len(f.events) > failoverEventsRetention {
+		f.events = f.events[len(f.events)-failoverEventsRetention:]
+	}
+}
+
+func (f *FailoverBackend) checkLoop() {
+	defer close(f.done)
+
+	ticker := time.NewTicker(f.config.CheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+			f.check()
+		}
+	}
+}
+
+// check pings the primary, a ping slower than the latency threshold is aborted and counted as failed
+func (f *FailoverBackend) check() {
+	ctx, cancel := context.WithTimeout(context.Background(), f.config.LatencyThreshold)
+	defer cancel()
+
+	start := time.Now()
+	err := f.SearchBackend.Ping(ctx)
+	latency := time.Since(start)
+	switch {
+	case err != nil:
+		f.observe(ctx, false, fmt.Sprintf("health check on %s is failed, err: %v", f.SearchBackend.Name(), err))
+	case latency > f.config.LatencyThreshold:
+		f.observe(ctx, false, fmt.Sprintf("health check on %s took %s, over the %s threshold",
+			f.SearchBackend.Name(), latency, f.config.LatencyThreshold))
+	default:
+		f.observe(ctx, true, "")
+	}
+}
+
+// Ping succeeds while either the primary or the replica is serving the searches
+func (f *FailoverBackend) Ping(ctx context.Context) error {
+	f.mu.Lock()
+	degraded := f.degraded
+	f.mu.Unlock()
+	if degraded {
+		return f.replica.Ping(ctx)
+	}
+	return f.SearchBackend.Ping(ctx)
+}
+
+// FailoverStatus reports the current state, the counters and the latest events
+func (f *FailoverBackend) FailoverStatus() (out model.FailoverStatus) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	out = model.FailoverStatus{
+		Primary:            f.SearchBackend.Name(),
+		Replica:            f.replica.Name(),
+		Degraded:           f.degraded,
+		Reason:             f.reason,
+		Failovers:          f.failovers,
+		Recoveries:         f.recoveries,
+		DegradedSearches:   f.degradedSearches,
+		ReplicaWriteErrors: f.replicaWriteErrors,
+	}
+	if f.degraded {
+		since := f.since
+		out.Since = &since
+	}
+	out.Events = make([]model.FailoverEvent, 0, len(f.events))
+	for i := len(f.events) - 1; i >= 0; i-- {
+		out.Events = append(out.Events, f.events[i])
+	}
+	return
+}
+
+// Close stops the health checks then closes both backends
+func (f *FailoverBackend) Close() error {
+	close(f.stop)
+	<-f.done
+	replicaErr := f.replica.Close()
+	if err := f.SearchBackend.Close(); err != nil {
+		return err
+	}
+	return replicaErr
+}
+
+// Drop drops both the primary and the replica
+func (f *FailoverBackend) Drop(ctx context.Context) error {
+	close(f.stop)
+	<-f.done
+	replicaErr := f.replica.Drop(ctx)
+	if err := f.SearchBackend.Drop(ctx); err != nil {
+		return err
+	}
+	return replicaErr
+}
@@ -0,0 +1,88 @@
+package engine
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/isdzulqor/kraicklist/config"
+	"github.com/isdzulqor/kraicklist/domain/model"
+	"github.com/isdzulqor/kraicklist/helper/errors"
+)
+
+// flakyBackend fails the searches and pings while it's down
+type flakyBackend struct {
+	SearchBackend
+	down int32
+}
+
+func (f *flakyBackend) Search(ctx context.Context, query model.AdSearchQuery) (model.AdSearchHits, error) {
+	if atomic.LoadInt32(&f.down) == 1 {
+		return nil, errors.ErrorThirdParty
+	}
+	return f.SearchBackend.Search(ctx, query)
+}
+
+func (f *flakyBackend) Ping(ctx context.Context) error {
+	if atomic.LoadInt32(&f.down) == 1 {
+		return errors.ErrorThirdParty
+	}
+	return nil
+}
+
+func TestFailoverBackend(t *testing.T) {
+	ctx := context.Background()
+	conf := &config.Config{}
+	memory, err := openMemory(ctx, conf, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	replica, err := openMemory(ctx, conf, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary := &flakyBackend{SearchBackend: memory}
+	failover := NewFailover(primary, replica, FailoverConfig{
+		CheckInterval:     time.Millisecond,
+		LatencyThreshold:  time.Second,
+		FailureThreshold:  2,
+		RecoveryThreshold: 2,
+	})
+	defer failover.Close()
+
+	if err = failover.Index(ctx, model.Advertisements{{ID: 1, Title: "iphone 11 for sale"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	atomic.StoreInt32(&primary.down, 1)
+	searchCtx, status := WithSearchStatus(ctx)
+	hits, err := failover.Search(searchCtx, model.AdSearchQuery{Keyword: "iphone"})
+	if err != nil || len(hits) != 1 || !status.Degraded {
+		t.Fatalf("expected the degraded hit from the replica, got %+v, %+v, err: %v", hits, status, err)
+	}
+
+	waitFor(t, func() bool { return failover.FailoverStatus().Degraded })
+	atomic.StoreInt32(&primary.down, 0)
+	waitFor(t, func() bool { return !failover.FailoverStatus().Degraded })
+
+	searchCtx, status = WithSearchStatus(ctx)
+	if _, err = failover.Search(searchCtx, model.AdSearchQuery{Keyword: "iphone"}); err != nil || status.Degraded {
+		t.Fatalf("expected the search on the recovered primary, got %+v, err: %v", status, err)
+	}
+	report := failover.FailoverStatus()
+	if report.Failovers != 1 || report.Recoveries != 1 || len(report.Events) != 2 ||
+		report.Events[0].Type != model.FailoverEventRecovery {
+		t.Fatalf("expected a failover and a recovery, got %+v", report)
+	}
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition is not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
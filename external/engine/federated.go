@@ -0,0 +1,215 @@
+package engine
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/isdzulqor/kraicklist/config"
+	"github.com/isdzulqor/kraicklist/domain/model"
+)
+
+// defaultFederatedSize is the number of the merged hits when the query has no size, the same as the backends
+const defaultFederatedSize = 10
+
+// FederatedSource is a backend the searches are fanned out to, each with its own timeout
+type FederatedSource struct {
+	Backend SearchBackend
+	Timeout time.Duration
+}
+
+// FederationConfig configures how the hits of the sources are merged
+type FederationConfig struct {
+	// Merge is rrf for the reciprocal rank fusion or score for the sum of the min-max normalised scores
+	Merge string
+	// RRFK dampens the weight of the top ranks on the reciprocal rank fusion
+	RRFK int
+}
+
+// FederatedBackend searches on all sources concurrently and merges their hits,
+// the writes and other reads only go to the first source
+type FederatedBackend struct {
+	SearchBackend
+
+	sources []FederatedSource
+	config  FederationConfig
+}
+
+// NewFederated wraps the sources, the first one is the primary. All of them are closed along with it
+func NewFederated(sources []FederatedSource, config FederationConfig) *FederatedBackend {
+	return &FederatedBackend{
+		SearchBackend: sources[0].Backend,
+		sources:       sources,
+		config:        config,
+	}
+}
+
+type federatedResult struct {
+	hits     model.AdSearchHits
+	err      error
+	timedOut bool
+	latency  time.Duration
+}
+
+// Search fans the query out to the sources, the hits of the failed sources are left out.
+// It's only failed when all sources are failed, with the error of the primary
+func (f *FederatedBackend) Search(ctx context.Context, query model.AdSearchQuery) (model.AdSearchHits, error) {
+	results := make([]federatedResult, len(f.sources))
+	var wg sync.WaitGroup
+	for i, source := range f.sources {
+		wg.Add(1)
+		go func(i int, source FederatedSource) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, source.Timeout)
+			defer cancel()
+
+			start := time.Now()
+			hits, err := source.Backend.Search(ctx, query)
+			// the backend may ignore the deadline or wrap its error
+			timedOut := ctx.Err() == context.DeadlineExceeded
+			if err == nil && timedOut {
+				err = ctx.Err()
+			}
+			results[i] = federatedResult{hits: hits, err: err, timedOut: timedOut, latency: time.Since(start)}
+		}(i, source)
+	}
+	wg.Wait()
+
+	statuses := make([]model.SearchSourceStatus, len(f.sources))
+	partial := false
+	for i, result := range results {
+		backend := f.sources[i].Backend
+		statuses[i] = model.SearchSourceStatus{
+			Source:    backend.Name() + ":" + backend.IndexName(),
+			Status:    model.SearchSourceOK,
+			Hits:      len(result.hits),
+			LatencyMs: durationMs(result.latency),
+		}
+		if result.err != nil {
+			partial = true
+			statuses[i].Status = model.SearchSourceFailed
+			if result.timedOut {
+				statuses[i].Status = model.SearchSourceTimeout
+			}
+			statuses[i].Hits = 0
+			statuses[i].Error = result.err.Error()
+		}
+	}
+	if status, ok := ctx.Value(searchStatusKey{}).(*model.SearchStatus); ok {
+		status.Sources = statuses
+		status.Partial = partial
+	}
+
+	var lists []model.AdSearchHits
+	for _, result := range results {
+		if result.err == nil {
+			lists = append(lists, result.hits)
+		}
+	}
+	if len(lists) == 0 {
+		return nil, results[0].err
+	}
+
+	size := query.Size
+	if size <= 0 {
+		size = defaultFederatedSize
+	}
+	return f.merge(lists, query.Sort, size), nil
+}
+
+// merge fuses the scores of the same ad across the lists, the most recently updated copy of the ad is kept
+func (f *FederatedBackend) merge(lists []model.AdSearchHits, sortBy string, size int) model.AdSearchHits {
+	var (
+		merged []model.AdSearchHit
+		scores []float64
+		byID   = map[int64]int{}
+	)
+	for _, hits := range lists {
+		listScores := f.fusedScores(hits)
+		for rank, hit := range hits {
+			score := listScores[rank]
+			i, ok := byID[hit.ID]
+			if !ok {
+				byID[hit.ID] = len(merged)
+				merged = append(merged, hit)
+				scores = append(scores, score)
+				continue
+			}
+			scores[i] += score
+			if hit.UpdatedAt > merged[i].UpdatedAt {
+				merged[i] = hit
+			}
+		}
+	}
+	for i := range merged {
+		merged[i].Score = scores[i]
+	}
+
+	// the stable sort keeps the order of the sources on ties, the primary first
+	sort.SliceStable(merged, func(i, j int) bool {
+		a, b := merged[i], merged[j]
+		switch {
+		case sortBy == model.SortNewest && a.UpdatedAt != b.UpdatedAt:
+			return a.UpdatedAt > b.UpdatedAt
+		case sortBy == model.SortOldest && a.UpdatedAt != b.UpdatedAt:
+			return a.UpdatedAt < b.UpdatedAt
+		}
+		return a.Score > b.Score
+	})
+	if len(merged) > size {
+		merged = merged[:size]
+	}
+	return merged
+}
+
+// fusedScores scores the hits of a list by their rank, or by their min-max normalised score
+func (f *FederatedBackend) fusedScores(hits model.AdSearchHits) []float64 {
+	out := make([]float64, len(hits))
+	if f.config.Merge != config.FederationMergeScore {
+		for rank := range hits {
+			out[rank] = 1 / float64(f.config.RRFK+rank+1)
+		}
+		return out
+	}
+
+	var min, max float64
+	for rank, hit := range hits {
+		if rank == 0 || hit.Score < min {
+			min = hit.Score
+		}
+		if rank == 0 || hit.Score > max {
+			max = hit.Score
+		}
+	}
+	for rank, hit := range hits {
+		out[rank] = 1
+		if max > min {
+			out[rank] = (hit.Score - min) / (max - min)
+		}
+	}
+	return out
+}
+
+// Close closes all sources, the first error is returned
+func (f *FederatedBackend) Close() (err error) {
+	for _, source := range f.sources {
+		if closeErr := source.Backend.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return
+}
+
+// Drop only drops the primary, the other sources are read-only so they're closed
+func (f *FederatedBackend) Drop(ctx context.Context) (err error) {
+	for _, source := range f.sources[1:] {
+		if closeErr := source.Backend.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	if dropErr := f.SearchBackend.Drop(ctx); dropErr != nil {
+		return dropErr
+	}
+	return
+}
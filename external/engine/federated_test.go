@@ -0,0 +1,63 @@
+package engine
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/isdzulqor/kraicklist/config"
+	"github.com/isdzulqor/kraicklist/domain/model"
+)
+
+func TestFederatedBackendSearch(t *testing.T) {
+	ctx := context.Background()
+	conf := &config.Config{}
+	current, err := openMemory(ctx, conf, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := openMemory(ctx, conf, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	current.Index(ctx, model.Advertisements{
+		{ID: 1, Title: "iphone 11 for sale", UpdatedAt: 20},
+		{ID: 2, Title: "iphone case", UpdatedAt: 20},
+	})
+	legacy.Index(ctx, model.Advertisements{
+		{ID: 1, Title: "iphone 11", UpdatedAt: 10},
+		{ID: 3, Title: "old iphone", UpdatedAt: 10},
+	})
+	down := &flakyBackend{SearchBackend: legacy, down: 1}
+
+	federated := NewFederated([]FederatedSource{
+		{Backend: current, Timeout: time.Second},
+		{Backend: legacy, Timeout: time.Second},
+		{Backend: down, Timeout: time.Second},
+	}, FederationConfig{Merge: config.FederationMergeRRF, RRFK: 60})
+
+	searchCtx, status := WithSearchStatus(ctx)
+	hits, err := federated.Search(searchCtx, model.AdSearchQuery{Keyword: "iphone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 3 || hits[0].ID != 1 || hits[0].UpdatedAt != 20 {
+		t.Fatalf("expected the deduplicated ad 1 on top with its newest copy, got %+v", hits)
+	}
+	if !status.Partial || len(status.Sources) != 3 || status.Sources[2].Status != model.SearchSourceFailed {
+		t.Fatalf("expected a partial response with the failed source, got %+v", status)
+	}
+
+	hits, err = federated.Search(ctx, model.AdSearchQuery{Keyword: "iphone", Sort: model.SortOldest, Size: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 2 || hits[0].ID != 3 {
+		t.Fatalf("expected the oldest ad first limited to the size, got %+v", hits)
+	}
+
+	allDown := NewFederated([]FederatedSource{{Backend: down, Timeout: time.Second}}, FederationConfig{})
+	if _, err = allDown.Search(ctx, model.AdSearchQuery{Keyword: "iphone"}); err == nil {
+		t.Fatal("expected an error when all sources are failed")
+	}
+}
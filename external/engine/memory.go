@@ -0,0 +1,208 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/isdzulqor/kraicklist/config"
+	"github.com/isdzulqor/kraicklist/domain/model"
+	"github.com/isdzulqor/kraicklist/external/index"
+)
+
+func init() {
+	Register(index.IndexMemory, openMemory)
+}
+
+type memoryBackend struct {
+	conf  *config.Config
+	index *index.MemoryIndex
+}
+
+func openMemory(ctx context.Context, conf *config.Config, opts Options) (SearchBackend, error) {
+	if opts.IndexName != "" {
+		override := *conf
+		override.Advertisement.Memory.SnapshotPath = opts.IndexName
+		conf = &override
+	}
+	memoryIndex, err := index.InitMemoryIndex(ctx, index.MemoryIndexConfig{
+		TextFields:   []string{"title", "content", "tags"},
+		SnapshotPath: conf.Advertisement.Memory.SnapshotPath,
+		ReadOnly:     opts.ReadOnly,
+	}, opts.RecreateIndex)
+	if err != nil {
+		return nil, err
+	}
+	return &memoryBackend{conf: conf, index: memoryIndex}, nil
+}
+
+func (m *memoryBackend) Name() string {
+	return index.IndexMemory
+}
+
+func (m *memoryBackend) IndexName() string {
+	if path := m.conf.Advertisement.Memory.SnapshotPath; path != "" {
+		return path
+	}
+	return index.IndexMemory
+}
+
+func (m *memoryBackend) Index(ctx context.Context, ads model.Advertisements) error {
+	docs, err := ads.ToMemoryDocs()
+	if err != nil {
+		return fmt.Errorf("failed to convert to memoryDocs, err:%v", err)
+	}
+	if errorDocs := m.index.BulkIndex(ctx, docs); errorDocs != nil {
+		return errorDocs.ToError()
+	}
+	return nil
+}
+
+// Get loads the whole ads, the fields are ignored as the docs are in memory already
+func (m *memoryBackend) Get(ctx context.Context, ids []int64, fields ...string) (model.Advertisements, error) {
+	hits, err := m.index.GetDocs(ctx, toDocIDs(ids))
+	if err != nil {
+		return nil, err
+	}
+	return memoryHitsToAds(hits)
+}
+
+func (m *memoryBackend) Delete(ctx context.Context, ids []int64) error {
+	if errorDocs := m.index.BulkDelete(ctx, toDocIDs(ids)); errorDocs != nil {
+		return errorDocs.ToError()
+	}
+	return nil
+}
+
+func (m *memoryBackend) Search(ctx context.Context, query model.AdSearchQuery) (out model.AdSearchHits, err error) {
+	opts := index.MemorySearchOptions{
+		Filter: memoryFilterQuery(query.Filter),
+		Size:   query.Size,
+	}
+	switch query.Sort {
+	case model.SortNewest:
+		opts.SortBy = []string{"-updated_at", "-_score"}
+	case model.SortOldest:
+		opts.SortBy = []string{"updated_at", "-_score"}
+	}
+
+	hits, err := m.index.SearchQuery(ctx, query.Keyword, opts)
+	if err != nil {
+		return
+	}
+	ads, err := memoryHitsToAds(hits)
+	if err != nil {
+		return
+	}
+	for i, hit := range hits {
+		out = append(out, model.AdSearchHit{Advertisement: ads[i], Score: hit.Score})
+	}
+	return
+}
+
+func (m *memoryBackend) Scan(ctx context.Context, filter model.AdFilter, pageSize int,
+	fn func(ads model.Advertisements) error, fields ...string) error {
+	return m.index.ScanDocs(ctx, memoryFilterQuery(filter), pageSize, func(hits []index.MemoryHit) error {
+		ads, err := memoryHitsToAds(hits)
+		if err != nil {
+			return err
+		}
+		return fn(ads)
+	})
+}
+
+func (m *memoryBackend) Count(ctx context.Context) (int64, error) {
+	return int64(m.index.DocCount()), nil
+}
+
+func (m *memoryBackend) Stats(ctx context.Context, topN int) (out model.IndexStats, err error) {
+	out.Indexer = m.Name()
+	out.Index = m.IndexName()
+	out.DocCount = int64(m.index.DocCount())
+	out.DiskSizeBytes = m.index.SnapshotSizeBytes()
+	for _, term := range m.index.TopTerms(statsTermsField, topN) {
+		out.TopTerms = append(out.TopTerms, model.TermCount{Term: term.Term, Count: int64(term.Count)})
+	}
+
+	tagCounts := map[string]int64{}
+	err = m.Scan(ctx, model.AdFilter{}, m.conf.Advertisement.Bulk.BatchSize, func(ads model.Advertisements) error {
+		for _, doc := range ads {
+			for _, tag := range doc.TagList() {
+				tagCounts[tag]++
+			}
+			if out.OldestUpdatedAt == 0 || doc.UpdatedAt < out.OldestUpdatedAt {
+				out.OldestUpdatedAt = doc.UpdatedAt
+			}
+			if doc.UpdatedAt > out.NewestUpdatedAt {
+				out.NewestUpdatedAt = doc.UpdatedAt
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	out.TagCardinality = int64(len(tagCounts))
+	out.TopTags = topTermCounts(tagCounts, topN)
+	return
+}
+
+func (m *memoryBackend) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Close writes the snapshot when it's configured
+func (m *memoryBackend) Close() error {
+	return m.index.Close()
+}
+
+// Drop deletes the snapshot without writing it on close
+func (m *memoryBackend) Drop(ctx context.Context) error {
+	path := m.conf.Advertisement.Memory.SnapshotPath
+	if path == "" {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func memoryFilterQuery(filter model.AdFilter) index.MemoryQuery {
+	var queries []index.MemoryQuery
+	if len(filter.Tags) > 0 {
+		queries = append(queries, index.MemoryMatchAny("tags", filter.Tags))
+	}
+	if filter.UpdatedSince > 0 || filter.UpdatedUntil > 0 {
+		var since, until *float64
+		if filter.UpdatedSince > 0 {
+			v := float64(filter.UpdatedSince)
+			since = &v
+		}
+		if filter.UpdatedUntil > 0 {
+			v := float64(filter.UpdatedUntil)
+			until = &v
+		}
+		queries = append(queries, index.MemoryNumericRange("updated_at", since, until))
+	}
+	if len(queries) == 0 {
+		return nil
+	}
+	return index.MemoryConjunction(queries...)
+}
+
+func memoryHitsToAds(hits []index.MemoryHit) (out model.Advertisements, err error) {
+	out = make(model.Advertisements, 0, len(hits))
+	for _, hit := range hits {
+		var doc model.Advertisement
+		if err = json.Unmarshal(hit.Source, &doc); err != nil {
+			err = fmt.Errorf("failed to decode doc %s, err: %v", hit.ID, err)
+			return
+		}
+		doc.Normalize()
+		out = append(out, doc)
+	}
+	return
+}
@@ -0,0 +1,306 @@
+package engine
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/isdzulqor/kraicklist/domain/model"
+	"github.com/isdzulqor/kraicklist/helper/logging"
+)
+
+// ShadowConfig configures the comparisons of the shadow mode
+type ShadowConfig struct {
+	// TopK is the number of top hits compared
+	TopK int
+	// Timeout limits the search on the secondary
+	Timeout time.Duration
+	// MaxInflight limits the concurrent searches on the secondary, the comparison is skipped when it's reached
+	MaxInflight int
+	// Retention is the number of the latest comparisons kept for the report
+	Retention int
+}
+
+// ShadowReporter reports the comparisons of the shadow mode
+type ShadowReporter interface {
+	ShadowReport(limit int) model.ShadowReport
+}
+
+// ShadowBackend serves from the primary while the writes go to both backends.
+// Each search is repeated on the secondary asynchronously and the top hits are compared
+type ShadowBackend struct {
+	SearchBackend
+
+	secondary SearchBackend
+	config    ShadowConfig
+	inflight  chan struct{}
+	wg        sync.WaitGroup
+
+	mu          sync.Mutex
+	comparisons []model.ShadowComparison
+	next        int
+
+	compared, skipped, failed    int64
+	secondaryWrites, writeErrors int64
+
+	sumJaccard, sumRankCorrelation, sumLatencyDiffMs float64
+	rankCorrelations                                 int64
+}
+
+// NewShadow wraps the primary, the secondary is closed along with it
+func NewShadow(primary, secondary SearchBackend, config ShadowConfig) *ShadowBackend {
+	return &ShadowBackend{
+		SearchBackend: primary,
+		secondary:     secondary,
+		config:        config,
+		inflight:      make(chan struct{}, config.MaxInflight),
+	}
+}
+
+// Index indexes the ads on the primary then on the secondary, only the primary result is returned
+func (s *ShadowBackend) Index(ctx context.Context, ads model.Advertisements) error {
+	err := s.SearchBackend.Index(ctx, ads)
+	s.writeSecondary(ctx, "index", s.secondary.Index(ctx, ads))
+	return err
+}
+
+// Delete deletes the ads on the primary then on the secondary, only the primary result is returned
+func (s *ShadowBackend) Delete(ctx context.Context, ids []int64) error {
+	err := s.SearchBackend.Delete(ctx, ids)
+	s.writeSecondary(ctx, "delete", s.secondary.Delete(ctx, ids))
+	return err
+}
+
+func (s *ShadowBackend) writeSecondary(ctx context.Context, action string, err error) {
+	s.mu.Lock()
+	s.secondaryWrites++
+	if err != nil {
+		s.writeErrors++
+	}
+	s.mu.Unlock()
+
+	if err != nil {
+		logging.WarnContext(ctx, "shadow %s on %s is failed, err: %v", action, s.secondary.Name(), err)
+	}
+}
+
+// Search searches on the primary, the same query is compared on the secondary in the background
+func (s *ShadowBackend) Search(ctx context.Context, query model.AdSearchQuery) (model.AdSearchHits, error) {
+	start := time.Now()
+	hits, err := s.SearchBackend.Search(ctx, query)
+	if err != nil {
+		return hits, err
+	}
+	primaryLatency := time.Since(start)
+
+	select {
+	case s.inflight <- struct{}{}:
+	default:
+		s.mu.Lock()
+		s.skipped++
+		s.mu.Unlock()
+		return hits, nil
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer func() {
+			<-s.inflight
+			s.wg.Done()
+		}()
+		s.compare(query, hits, primaryLatency)
+	}()
+	return hits, nil
+}
+
+func (s *ShadowBackend) compare(query model.AdSearchQuery, primaryHits model.AdSearchHits,
+	primaryLatency time.Duration) {
+	// the request may be finished already, the secondary has its own deadline
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	secondaryHits, err := s.secondary.Search(ctx, query)
+	secondaryLatency := time.Since(start)
+
+	out := model.ShadowComparison{
+		Keyword:            query.Keyword,
+		Filter:             query.Filter,
+		Sort:               query.Sort,
+		PrimaryIDs:         topIDs(primaryHits, s.config.TopK),
+		SecondaryIDs:       topIDs(secondaryHits, s.config.TopK),
+		PrimaryLatencyMs:   durationMs(primaryLatency),
+		SecondaryLatencyMs: durationMs(secondaryLatency),
+		ComparedAt:         time.Now(),
+	}
+	out.LatencyDiffMs = out.SecondaryLatencyMs - out.PrimaryLatencyMs
+	if err != nil {
+		out.Error = err.Error()
+		logging.WarnContext(ctx, "shadow search on %s is failed, err: %v", s.secondary.Name(), err)
+	} else {
+		out.Jaccard = jaccard(out.PrimaryIDs, out.SecondaryIDs)
+		out.RankCorrelation = rankCorrelation(out.PrimaryIDs, out.SecondaryIDs)
+	}
+	s.record(out)
+}
+
+func (s *ShadowBackend) record(comparison model.ShadowComparison) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if comparison.Error != "" {
+		s.failed++
+	} else {
+		s.compared++
+		s.sumJaccard += comparison.Jaccard
+		s.sumLatencyDiffMs += comparison.LatencyDiffMs
+		if comparison.RankCorrelation != nil {
+			s.sumRankCorrelation += *comparison.RankCorrelation
+			s.rankCorrelations++
+		}
+	}
+
+	if s.config.Retention <= 0 {
+		return
+	}
+	if len(s.comparisons) < s.config.Retention {
+		s.comparisons = append(s.comparisons, comparison)
+		return
+	}
+	s.comparisons[s.next] = comparison
+	s.next = (s.next + 1) % s.config.Retention
+}
+
+// ShadowReport summarizes all comparisons, the limit of the most divergent retained queries are listed
+func (s *ShadowBackend) ShadowReport(limit int) (out model.ShadowReport) {
+	s.mu.Lock()
+	out = model.ShadowReport{
+		Primary:         s.SearchBackend.Name(),
+		Secondary:       s.secondary.Name(),
+		TopK:            s.config.TopK,
+		Compared:        s.compared,
+		Skipped:         s.skipped,
+		Failed:          s.failed,
+		SecondaryWrites: s.secondaryWrites,
+		WriteErrors:     s.writeErrors,
+	}
+	if s.compared > 0 {
+		out.MeanJaccard = s.sumJaccard / float64(s.compared)
+		out.MeanLatencyDiffMs = s.sumLatencyDiffMs / float64(s.compared)
+	}
+	if s.rankCorrelations > 0 {
+		out.MeanRankCorrelation = s.sumRankCorrelation / float64(s.rankCorrelations)
+	}
+	comparisons := append([]model.ShadowComparison(nil), s.comparisons...)
+	s.mu.Unlock()
+
+	// failed comparisons are counted but have nothing to compare
+	divergent := comparisons[:0]
+	for _, comparison := range comparisons {
+		if comparison.Error == "" {
+			divergent = append(divergent, comparison)
+		}
+	}
+	sort.SliceStable(divergent, func(i, j int) bool {
+		if a, b := divergent[i].Divergence(), divergent[j].Divergence(); a != b {
+			return a > b
+		}
+		return divergent[i].LatencyDiffMs > divergent[j].LatencyDiffMs
+	})
+	if len(divergent) > limit {
+		divergent = divergent[:limit]
+	}
+	out.Divergent = divergent
+	return
+}
+
+// Close waits for the running comparisons then closes both backends
+func (s *ShadowBackend) Close() error {
+	s.wg.Wait()
+	secondaryErr := s.secondary.Close()
+	if err := s.SearchBackend.Close(); err != nil {
+		return err
+	}
+	return secondaryErr
+}
+
+// Drop drops both the primary and the secondary
+func (s *ShadowBackend) Drop(ctx context.Context) error {
+	s.wg.Wait()
+	secondaryErr := s.secondary.Drop(ctx)
+	if err := s.SearchBackend.Drop(ctx); err != nil {
+		return err
+	}
+	return secondaryErr
+}
+
+func topIDs(hits model.AdSearchHits, k int) []int64 {
+	out := make([]int64, 0, k)
+	for i, hit := range hits {
+		if i == k {
+			break
+		}
+		out = append(out, hit.ID)
+	}
+	return out
+}
+
+// jaccard is the size of the intersection over the size of the union, 1 when both are empty
+func jaccard(a, b []int64) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+	set := make(map[int64]bool, len(a))
+	for _, id := range a {
+		set[id] = true
+	}
+	intersection := 0
+	union := len(set)
+	for _, id := range b {
+		if set[id] {
+			intersection++
+		} else {
+			union++
+		}
+	}
+	return float64(intersection) / float64(union)
+}
+
+// rankCorrelation is the spearman correlation of the ranks of the common IDs on both lists,
+// nil when there are less than 2 common IDs
+func rankCorrelation(a, b []int64) *float64 {
+	positionsOnB := make(map[int64]int, len(b))
+	for i, id := range b {
+		positionsOnB[id] = i
+	}
+	// positions on b of the common IDs ordered as on a
+	var positions []int
+	for _, id := range a {
+		if position, ok := positionsOnB[id]; ok {
+			positions = append(positions, position)
+		}
+	}
+	n := len(positions)
+	if n < 2 {
+		return nil
+	}
+
+	sorted := append([]int(nil), positions...)
+	sort.Ints(sorted)
+	ranksOnB := make(map[int]int, n)
+	for rank, position := range sorted {
+		ranksOnB[position] = rank
+	}
+	var sumSquares float64
+	for rankOnA, position := range positions {
+		d := float64(rankOnA - ranksOnB[position])
+		sumSquares += d * d
+	}
+	out := 1 - 6*sumSquares/float64(n*(n*n-1))
+	return &out
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
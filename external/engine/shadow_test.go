@@ -0,0 +1,75 @@
+package engine
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/isdzulqor/kraicklist/config"
+	"github.com/isdzulqor/kraicklist/domain/model"
+)
+
+func TestShadowBackendReport(t *testing.T) {
+	ctx := context.Background()
+	conf := &config.Config{}
+	primary, err := openMemory(ctx, conf, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondary, err := openMemory(ctx, conf, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	shadow := NewShadow(primary, secondary, ShadowConfig{TopK: 10, Timeout: time.Second, MaxInflight: 1, Retention: 10})
+
+	ads := model.Advertisements{
+		{ID: 1, Title: "iphone 11 for sale"},
+		{ID: 2, Title: "iphone case"},
+		{ID: 3, Title: "android tablet"},
+	}
+	if err = shadow.Index(ctx, ads); err != nil {
+		t.Fatal(err)
+	}
+	// only the secondary misses the tablet
+	if err = secondary.Delete(ctx, []int64{3}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, keyword := range []string{"iphone", "tablet"} {
+		hits, err := shadow.Search(ctx, model.AdSearchQuery{Keyword: keyword})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(hits) == 0 {
+			t.Fatalf("expected hits from the primary for %s", keyword)
+		}
+		// waits for the comparison as the next search would be skipped otherwise
+		shadow.wg.Wait()
+	}
+
+	report := shadow.ShadowReport(1)
+	if report.Compared != 2 || report.SecondaryWrites != 1 || report.WriteErrors != 0 {
+		t.Fatalf("unexpected counters, got %+v", report)
+	}
+	if len(report.Divergent) != 1 || report.Divergent[0].Keyword != "tablet" || report.Divergent[0].Jaccard != 0 {
+		t.Fatalf("expected tablet as the most divergent query, got %+v", report.Divergent)
+	}
+	if err = shadow.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRankCorrelation(t *testing.T) {
+	if rho := rankCorrelation([]int64{1, 2, 3}, []int64{3, 2, 1}); rho == nil || *rho != -1 {
+		t.Fatalf("expected -1 for the reversed order, got %v", rho)
+	}
+	if rho := rankCorrelation([]int64{1, 2, 3}, []int64{1, 9, 2, 3}); rho == nil || *rho != 1 {
+		t.Fatalf("expected 1 for the same order of the common ids, got %v", rho)
+	}
+	if rho := rankCorrelation([]int64{1, 2}, []int64{2, 3}); rho != nil {
+		t.Fatalf("expected nil for a single common id, got %v", *rho)
+	}
+	if j := jaccard([]int64{1, 2}, []int64{2, 3}); j != float64(1)/3 {
+		t.Fatalf("expected 1/3, got %v", j)
+	}
+}
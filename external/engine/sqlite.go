@@ -0,0 +1,209 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/isdzulqor/kraicklist/config"
+	"github.com/isdzulqor/kraicklist/domain/model"
+	"github.com/isdzulqor/kraicklist/external/index"
+)
+
+func init() {
+	Register(index.IndexSQLite, openSQLite)
+}
+
+var sqliteIndexConfig = index.SQLiteIndexConfig{
+	TextFields:    []string{"title", "content", "tags"},
+	TermFields:    []string{"tags"},
+	NumericFields: []string{"updated_at"},
+}
+
+type sqliteBackend struct {
+	conf  *config.Config
+	index *index.SQLiteIndex
+}
+
+func openSQLite(ctx context.Context, conf *config.Config, opts Options) (SearchBackend, error) {
+	if opts.IndexName != "" {
+		override := *conf
+		override.Advertisement.SQLite.Path = opts.IndexName
+		conf = &override
+	}
+	var (
+		sqliteIndex *index.SQLiteIndex
+		err         error
+	)
+	if opts.ReadOnly {
+		sqliteIndex, err = index.InitSQLiteIndexReadOnly(ctx, conf.Advertisement.SQLite.Path, sqliteIndexConfig)
+	} else {
+		sqliteIndex, err = index.InitSQLiteIndex(ctx, conf.Advertisement.SQLite.Path, sqliteIndexConfig,
+			opts.RecreateIndex)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteBackend{conf: conf, index: sqliteIndex}, nil
+}
+
+func (s *sqliteBackend) Name() string {
+	return index.IndexSQLite
+}
+
+func (s *sqliteBackend) IndexName() string {
+	return s.conf.Advertisement.SQLite.Path
+}
+
+func (s *sqliteBackend) Index(ctx context.Context, ads model.Advertisements) error {
+	docs, err := ads.ToSQLiteDocs()
+	if err != nil {
+		return fmt.Errorf("failed to convert to sqliteDocs, err:%v", err)
+	}
+	if errorDocs := s.index.BulkIndex(ctx, docs); errorDocs != nil {
+		return errorDocs.ToError()
+	}
+	return nil
+}
+
+// Get loads the whole ads, the source is stored as a single json column
+func (s *sqliteBackend) Get(ctx context.Context, ids []int64, fields ...string) (model.Advertisements, error) {
+	hits, err := s.index.GetDocs(ctx, toDocIDs(ids))
+	if err != nil {
+		return nil, err
+	}
+	return sqliteHitsToAds(hits)
+}
+
+func (s *sqliteBackend) Delete(ctx context.Context, ids []int64) error {
+	if errorDocs := s.index.BulkDelete(ctx, toDocIDs(ids)); errorDocs != nil {
+		return errorDocs.ToError()
+	}
+	return nil
+}
+
+func (s *sqliteBackend) Search(ctx context.Context, query model.AdSearchQuery) (out model.AdSearchHits, err error) {
+	opts := index.SQLiteSearchOptions{
+		Filter: sqliteFilterQuery(query.Filter),
+		Size:   query.Size,
+	}
+	switch query.Sort {
+	case model.SortNewest:
+		opts.SortBy = []string{"-updated_at", "-_score"}
+	case model.SortOldest:
+		opts.SortBy = []string{"updated_at", "-_score"}
+	}
+
+	hits, err := s.index.SearchQuery(ctx, query.Keyword, opts)
+	if err != nil {
+		return
+	}
+	ads, err := sqliteHitsToAds(hits)
+	if err != nil {
+		return
+	}
+	for i, hit := range hits {
+		out = append(out, model.AdSearchHit{Advertisement: ads[i], Score: hit.Score})
+	}
+	return
+}
+
+func (s *sqliteBackend) Scan(ctx context.Context, filter model.AdFilter, pageSize int,
+	fn func(ads model.Advertisements) error, fields ...string) error {
+	return s.index.ScanDocs(ctx, sqliteFilterQuery(filter), pageSize, func(hits []index.SQLiteHit) error {
+		ads, err := sqliteHitsToAds(hits)
+		if err != nil {
+			return err
+		}
+		return fn(ads)
+	})
+}
+
+func (s *sqliteBackend) Count(ctx context.Context) (int64, error) {
+	return s.index.DocCount(ctx)
+}
+
+func (s *sqliteBackend) Stats(ctx context.Context, topN int) (out model.IndexStats, err error) {
+	out.Indexer = s.Name()
+	out.Index = s.IndexName()
+
+	sqliteStats, err := s.index.Stats(ctx, statsTermsField, "tags", "updated_at", topN)
+	if err != nil {
+		return
+	}
+	out.DocCount = sqliteStats.DocCount
+	out.DiskSizeBytes = sqliteStats.DiskSizeBytes
+	for _, term := range sqliteStats.TopTerms {
+		out.TopTerms = append(out.TopTerms, model.TermCount{Term: term.Term, Count: int64(term.Count)})
+	}
+	for _, tag := range sqliteStats.TopValues {
+		out.TopTags = append(out.TopTags, model.TermCount{Term: tag.Term, Count: int64(tag.Count)})
+	}
+	out.TagCardinality = sqliteStats.ValueCardinality
+	if sqliteStats.Min != nil {
+		out.OldestUpdatedAt = int64(*sqliteStats.Min)
+	}
+	if sqliteStats.Max != nil {
+		out.NewestUpdatedAt = int64(*sqliteStats.Max)
+	}
+	return
+}
+
+func (s *sqliteBackend) Ping(ctx context.Context) error {
+	return s.index.Ping(ctx)
+}
+
+func (s *sqliteBackend) Close() error {
+	return s.index.Close()
+}
+
+func (s *sqliteBackend) Drop(ctx context.Context) error {
+	if err := s.Close(); err != nil {
+		return err
+	}
+	// along with the write-ahead log files
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		if err := os.Remove(s.IndexName() + suffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func sqliteFilterQuery(filter model.AdFilter) index.SQLiteQuery {
+	var queries []index.SQLiteQuery
+	if len(filter.Tags) > 0 {
+		queries = append(queries, index.SQLiteMatchAny("tags", filter.Tags))
+	}
+	if filter.UpdatedSince > 0 || filter.UpdatedUntil > 0 {
+		var since, until *float64
+		if filter.UpdatedSince > 0 {
+			v := float64(filter.UpdatedSince)
+			since = &v
+		}
+		if filter.UpdatedUntil > 0 {
+			v := float64(filter.UpdatedUntil)
+			until = &v
+		}
+		queries = append(queries, index.SQLiteNumericRange("updated_at", since, until))
+	}
+	if len(queries) == 0 {
+		return nil
+	}
+	return index.SQLiteConjunction(queries...)
+}
+
+func sqliteHitsToAds(hits []index.SQLiteHit) (out model.Advertisements, err error) {
+	out = make(model.Advertisements, 0, len(hits))
+	for _, hit := range hits {
+		var doc model.Advertisement
+		if err = json.Unmarshal(hit.Source, &doc); err != nil {
+			err = fmt.Errorf("failed to decode doc %s, err: %v", hit.ID, err)
+			return
+		}
+		doc.Normalize()
+		out = append(out, doc)
+	}
+	return
+}
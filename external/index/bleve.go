@@ -4,16 +4,25 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
 	"sync"
+	"time"
 
+	"github.com/isdzulqor/kraicklist/helper/errors"
 	"github.com/isdzulqor/kraicklist/helper/logging"
 
 	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
 )
 
 const (
 	prefixBleve = "external-bleve:"
 	IndexBleve  = "bleve"
+
+	defaultBleveBatchSize  = 100
+	defaultBleveNumWorkers = 4
 )
 
 type SearchResultCustom bleve.SearchResult
@@ -39,19 +48,27 @@ type BleveDocError struct {
 
 type BleveDocErrors []BleveDocError
 
-// TODO: convert to error lib
 func (errorDocs BleveDocErrors) ToError() error {
-	return nil
+	return errors.ToDocErrors(errorDocs, func(docError BleveDocError) (string, error) {
+		return docError.DocID, docError.err
+	}).ErrorOrNil()
+}
+
+// BleveBulkConfig configures BulkIndex, docs are indexed with batches of BatchSize by NumWorkers goroutines
+type BleveBulkConfig struct {
+	BatchSize  int
+	NumWorkers int
 }
 
 type BleveIndex struct {
 	clientIndex bleve.Index
 	indexName   string
+	bulkConfig  BleveBulkConfig
 }
 
 // TODO: utilize context
-func InitBleveIndex(ctx context.Context, indexName string) (out *BleveIndex, err error) {
-	docPath := "./data/" + indexName
+func InitBleveIndex(ctx context.Context, indexName string, bulkConfig BleveBulkConfig) (out *BleveIndex, err error) {
+	docPath := BleveDocPath(indexName)
 	index, err := bleve.Open(docPath)
 	if err != nil {
 		logging.WarnContext(ctx, "%s failed to open index %s, will create new one", prefixBleve, docPath)
@@ -64,19 +81,76 @@ func InitBleveIndex(ctx context.Context, indexName string) (out *BleveIndex, err
 	out = &BleveIndex{
 		clientIndex: index,
 		indexName:   indexName,
+		bulkConfig:  bulkConfig,
+	}
+	return
+}
+
+// BleveSearchOptions narrows the search down by Filter, sorts by SortBy fields and limits it by Size,
+// zero values fall back to bleve defaults
+type BleveSearchOptions struct {
+	Filter BleveQuery
+	SortBy []string
+	Size   int
+	From   int
+}
+
+// InitBleveIndexReadOnly opens an existing index without write access.
+// The index is locked while it's opened for writing, i.e: by the API server, it gives up after timeout
+func InitBleveIndexReadOnly(ctx context.Context, indexName string, timeout time.Duration) (out *BleveIndex, err error) {
+	docPath := BleveDocPath(indexName)
+	if _, err = os.Stat(docPath); err != nil {
+		err = fmt.Errorf("%s index %s doesn't exist, seed it first", prefixBleve, docPath)
+		return
+	}
+
+	opened := make(chan error, 1)
+	var clientIndex bleve.Index
+	go func() {
+		var openErr error
+		clientIndex, openErr = bleve.OpenUsing(docPath, map[string]interface{}{"read_only": true})
+		opened <- openErr
+	}()
+
+	select {
+	case err = <-opened:
+		if err != nil {
+			err = fmt.Errorf("%s failed to open index %s, err: %v", prefixBleve, docPath, err)
+			return
+		}
+	case <-time.After(timeout):
+		err = fmt.Errorf("%s index %s is locked, stop the process using it i.e: the API server", prefixBleve, docPath)
+		return
+	}
+
+	out = &BleveIndex{
+		clientIndex: clientIndex,
+		indexName:   indexName,
 	}
 	return
 }
 
 // TODO: debug logging
-func (index *BleveIndex) SearchQuery(ctx context.Context, keyword string, dest interface{}) (result SearchResultCustom, err error) {
+func (index *BleveIndex) SearchQuery(ctx context.Context, keyword string, opts BleveSearchOptions,
+	dest interface{}) (result SearchResultCustom, err error) {
 	if keyword == "" {
 		err = fmt.Errorf("keyword can't be empty")
 		return
 	}
 
-	searchRequest := bleve.NewSearchRequest(bleve.NewQueryStringQuery(keyword))
+	var q query.Query = bleve.NewQueryStringQuery(keyword)
+	if opts.Filter != nil {
+		q = bleve.NewConjunctionQuery(q, opts.Filter)
+	}
+	searchRequest := bleve.NewSearchRequest(q)
 	searchRequest.Fields = []string{"*"}
+	if opts.Size > 0 {
+		searchRequest.Size = opts.Size
+	}
+	searchRequest.From = opts.From
+	if len(opts.SortBy) > 0 {
+		searchRequest.SortBy(opts.SortBy)
+	}
 
 	bleveResult, err := index.clientIndex.SearchInContext(ctx, searchRequest)
 	if err != nil {
@@ -97,24 +171,56 @@ func (index *BleveIndex) SearchQuery(ctx context.Context, keyword string, dest i
 	return
 }
 
-// TODO: utilize context
+// BulkIndex indexes docs with bleve batches of BatchSize which are executed by a fixed number of workers.
+// Docs those are not indexed yet when ctx is done are reported as failed with the ctx error
 func (index *BleveIndex) BulkIndex(ctx context.Context, docs BleveDocs) (docErrors *BleveDocErrors) {
-	errorChan := make(chan BleveDocError)
-	wg := sync.WaitGroup{}
-	for _, doc := range docs {
+	var (
+		start     = time.Now()
+		batchSize = index.bulkConfig.BatchSize
+		workers   = index.bulkConfig.NumWorkers
+
+		batchChan = make(chan BleveDocs)
+		errorChan = make(chan BleveDocError)
+		wg        = sync.WaitGroup{}
+	)
+	if batchSize <= 0 {
+		batchSize = defaultBleveBatchSize
+	}
+	if workers <= 0 {
+		workers = defaultBleveNumWorkers
+	}
+
+	for i := 0; i < workers; i++ {
 		wg.Add(1)
-		go func(d BleveDoc) {
-			logging.DebugContext(ctx, "indexing doc with ID %s", d.ID)
+		go func() {
+			defer wg.Done()
+			for batchDocs := range batchChan {
+				index.indexBatch(ctx, batchDocs, errorChan)
+			}
+		}()
+	}
 
-			if err := index.clientIndex.Index(d.ID, d.Data); err != nil {
-				errorChan <- BleveDocError{
-					DocID: d.ID,
-					err:   err,
+	go func() {
+		defer close(batchChan)
+		for i := 0; i < len(docs); i += batchSize {
+			end := i + batchSize
+			if end > len(docs) {
+				end = len(docs)
+			}
+			select {
+			case batchChan <- docs[i:end]:
+			case <-ctx.Done():
+				for _, d := range docs[i:] {
+					errorChan <- BleveDocError{
+						DocID: d.ID,
+						err:   ctx.Err(),
+					}
 				}
+				return
 			}
-			wg.Done()
-		}(doc)
-	}
+		}
+	}()
+
 	go func() {
 		wg.Wait()
 		close(errorChan)
@@ -127,12 +233,258 @@ func (index *BleveIndex) BulkIndex(ctx context.Context, docs BleveDocs) (docErro
 		}
 		*docErrors = append(*docErrors, docError)
 	}
+
+	elapsed := time.Since(start)
+	countFailed := 0
+	if docErrors != nil {
+		countFailed = len(*docErrors)
+	}
+	logging.InfoContext(ctx, "%s indexed %d of %d docs in %s, %.0f docs/s", prefixBleve,
+		len(docs)-countFailed, len(docs), elapsed, float64(len(docs)-countFailed)/elapsed.Seconds())
 	return
 }
 
+// indexBatch executes docs as a single bleve batch,
+// all docs on the batch are failed when the batch execution is failed
+func (index *BleveIndex) indexBatch(ctx context.Context, docs BleveDocs, errorChan chan<- BleveDocError) {
+	if ctx.Err() != nil {
+		for _, d := range docs {
+			errorChan <- BleveDocError{DocID: d.ID, err: ctx.Err()}
+		}
+		return
+	}
+
+	batch := index.clientIndex.NewBatch()
+	batchDocs := make(BleveDocs, 0, len(docs))
+	for _, d := range docs {
+		if err := batch.Index(d.ID, d.Data); err != nil {
+			errorChan <- BleveDocError{DocID: d.ID, err: err}
+			continue
+		}
+		batchDocs = append(batchDocs, d)
+	}
+
+	logging.DebugContext(ctx, "indexing batch of %d docs", batch.Size())
+	if err := index.clientIndex.Batch(batch); err != nil {
+		for _, d := range batchDocs {
+			errorChan <- BleveDocError{DocID: d.ID, err: err}
+		}
+	}
+}
+
 func (index *BleveIndex) Close() error {
 	if err := index.clientIndex.Close(); err != nil {
 		return fmt.Errorf("%s %v", prefixBleve, err)
 	}
 	return nil
 }
+
+// BleveHit is an indexed doc along with its stored fields
+type BleveHit struct {
+	ID     string
+	Fields map[string]interface{}
+}
+
+// GetDocs returns the docs those exist on the index by their IDs, only the given fields are loaded
+func (index *BleveIndex) GetDocs(ctx context.Context, ids []string, fields []string) (out []BleveHit, err error) {
+	if len(ids) == 0 {
+		return
+	}
+	searchRequest := bleve.NewSearchRequestOptions(bleve.NewDocIDQuery(ids), len(ids), 0, false)
+	searchRequest.Fields = fields
+
+	bleveResult, err := index.clientIndex.SearchInContext(ctx, searchRequest)
+	if err != nil {
+		err = fmt.Errorf("%s %v", prefixBleve, err)
+		return
+	}
+	for _, hit := range bleveResult.Hits {
+		out = append(out, BleveHit{ID: hit.ID, Fields: hit.Fields})
+	}
+	return
+}
+
+// BleveQuery is a bleve query to narrow docs down on ScanDocs
+type BleveQuery = query.Query
+
+// BleveMatchAnyPhrase matches docs having any of the phrases on the field
+func BleveMatchAnyPhrase(field string, phrases []string) BleveQuery {
+	queries := make([]query.Query, 0, len(phrases))
+	for _, phrase := range phrases {
+		q := bleve.NewMatchPhraseQuery(phrase)
+		q.SetField(field)
+		queries = append(queries, q)
+	}
+	return bleve.NewDisjunctionQuery(queries...)
+}
+
+// BleveNumericRange matches docs with the field in the inclusive range, nil min or max is unbounded
+func BleveNumericRange(field string, min, max *float64) BleveQuery {
+	inclusive := true
+	q := bleve.NewNumericRangeInclusiveQuery(min, max, &inclusive, &inclusive)
+	q.SetField(field)
+	return q
+}
+
+// BleveConjunction matches docs those match all the queries
+func BleveConjunction(queries ...BleveQuery) BleveQuery {
+	return bleve.NewConjunctionQuery(queries...)
+}
+
+// ScanDocs pages through docs matching q, or all docs when q is nil, ordered by ID.
+// fn is called for every page
+func (index *BleveIndex) ScanDocs(ctx context.Context, q BleveQuery, pageSize int, fields []string,
+	fn func([]BleveHit) error) error {
+	if q == nil {
+		q = bleve.NewMatchAllQuery()
+	}
+
+	var searchAfter []string
+	for {
+		searchRequest := bleve.NewSearchRequestOptions(q, pageSize, 0, false)
+		searchRequest.Fields = fields
+		searchRequest.SortBy([]string{"_id"})
+		searchRequest.SearchAfter = searchAfter
+
+		bleveResult, err := index.clientIndex.SearchInContext(ctx, searchRequest)
+		if err != nil {
+			return fmt.Errorf("%s %v", prefixBleve, err)
+		}
+		if len(bleveResult.Hits) == 0 {
+			return nil
+		}
+
+		hits := make([]BleveHit, 0, len(bleveResult.Hits))
+		for _, hit := range bleveResult.Hits {
+			hits = append(hits, BleveHit{ID: hit.ID, Fields: hit.Fields})
+		}
+		if err = fn(hits); err != nil {
+			return err
+		}
+		if len(bleveResult.Hits) < pageSize {
+			return nil
+		}
+		searchAfter = bleveResult.Hits[len(bleveResult.Hits)-1].Sort
+	}
+}
+
+// BulkDelete deletes docs by their IDs with bleve batches of BatchSize
+func (index *BleveIndex) BulkDelete(ctx context.Context, ids []string) (docErrors *BleveDocErrors) {
+	batchSize := index.bulkConfig.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBleveBatchSize
+	}
+
+	for i := 0; i < len(ids); i += batchSize {
+		end := i + batchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		err := ctx.Err()
+		if err == nil {
+			batch := index.clientIndex.NewBatch()
+			for _, id := range ids[i:end] {
+				batch.Delete(id)
+			}
+			err = index.clientIndex.Batch(batch)
+		}
+		if err != nil {
+			if docErrors == nil {
+				docErrors = &BleveDocErrors{}
+			}
+			for _, id := range ids[i:end] {
+				*docErrors = append(*docErrors, BleveDocError{DocID: id, err: err})
+			}
+		}
+	}
+	return
+}
+
+// DocCount returns the number of docs on the index
+func (index *BleveIndex) DocCount() (uint64, error) {
+	count, err := index.clientIndex.DocCount()
+	if err != nil {
+		return 0, fmt.Errorf("%s %v", prefixBleve, err)
+	}
+	return count, nil
+}
+
+// BleveDocPath is the directory of the index
+func BleveDocPath(indexName string) string {
+	return "./data/" + indexName
+}
+
+// TermCount is an indexed term along with the number of docs having it
+type TermCount struct {
+	Term  string
+	Count uint64
+}
+
+// BleveStats is the statistics of the index, Segments is nil when the index type doesn't have segments
+type BleveStats struct {
+	DocCount      uint64
+	DiskSizeBytes int64
+	Segments      *int64
+	TopTerms      []TermCount
+}
+
+// Stats gathers the statistics of the index, TopTerms are the topN terms of termsField by doc count
+func (index *BleveIndex) Stats(ctx context.Context, termsField string, topN int) (out BleveStats, err error) {
+	if out.DocCount, err = index.DocCount(); err != nil {
+		return
+	}
+
+	err = filepath.Walk(BleveDocPath(index.indexName), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			out.DiskSizeBytes += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		err = fmt.Errorf("%s %v", prefixBleve, err)
+		return
+	}
+
+	// only scorch index has segments
+	if indexStats, ok := index.clientIndex.StatsMap()["index"].(map[string]interface{}); ok {
+		if segments, ok := indexStats["num_root_filesegments"].(uint64); ok {
+			count := int64(segments)
+			out.Segments = &count
+		}
+	}
+
+	out.TopTerms, err = index.topTerms(termsField, topN)
+	return
+}
+
+func (index *BleveIndex) topTerms(field string, topN int) (out []TermCount, err error) {
+	dict, err := index.clientIndex.FieldDict(field)
+	if err != nil {
+		err = fmt.Errorf("%s %v", prefixBleve, err)
+		return
+	}
+	defer dict.Close()
+
+	for entry, dictErr := dict.Next(); entry != nil || dictErr != nil; entry, dictErr = dict.Next() {
+		if dictErr != nil {
+			err = fmt.Errorf("%s %v", prefixBleve, dictErr)
+			return
+		}
+		out = append(out, TermCount{Term: entry.Term, Count: entry.Count})
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count == out[j].Count {
+			return out[i].Term < out[j].Term
+		}
+		return out[i].Count > out[j].Count
+	})
+	if len(out) > topN {
+		out = out[:topN]
+	}
+	return
+}
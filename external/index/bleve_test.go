@@ -0,0 +1,116 @@
+package index
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/blevesearch/bleve"
+)
+
+const benchDataPath = "../../data/data.gz"
+
+// loadBenchDocs loads the bundled dataset as bleve docs
+func loadBenchDocs(b *testing.B) (out BleveDocs) {
+	file, err := os.Open(benchDataPath)
+	if err != nil {
+		b.Skipf("bundled dataset is not available, err: %v", err)
+	}
+	defer file.Close()
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		b.Fatal(err)
+	}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
+	for scanner.Scan() {
+		data := map[string]interface{}{}
+		if err := json.Unmarshal(scanner.Bytes(), &data); err != nil {
+			continue
+		}
+		out = append(out, BleveDoc{
+			ID:   fmt.Sprint(data["id"]),
+			Data: data,
+		})
+	}
+	return
+}
+
+func newBenchIndex(b *testing.B, bulkConfig BleveBulkConfig) *BleveIndex {
+	clientIndex, err := bleve.New(b.TempDir()+"/bench.bleve", bleve.NewIndexMapping())
+	if err != nil {
+		b.Fatal(err)
+	}
+	return &BleveIndex{
+		clientIndex: clientIndex,
+		indexName:   "bench.bleve",
+		bulkConfig:  bulkConfig,
+	}
+}
+
+// indexPerDocGoroutine is the previous BulkIndex implementation,
+// kept as the baseline of the benchmark
+func indexPerDocGoroutine(index *BleveIndex, docs BleveDocs) {
+	wg := sync.WaitGroup{}
+	for _, doc := range docs {
+		wg.Add(1)
+		go func(d BleveDoc) {
+			defer wg.Done()
+			index.clientIndex.Index(d.ID, d.Data)
+		}(doc)
+	}
+	wg.Wait()
+}
+
+// BenchmarkBleveBulkIndex compares indexing the bundled dataset
+// with a goroutine per doc against batches with a fixed worker pool, i.e:
+// go test -run=^$ -bench=BleveBulkIndex -benchtime=3x ./external/index
+func BenchmarkBleveBulkIndex(b *testing.B) {
+	docs := loadBenchDocs(b)
+	ctx := context.Background()
+
+	b.Run("per_doc_goroutine", func(b *testing.B) {
+		benchIndexing(b, BleveBulkConfig{}, func(index *BleveIndex) {
+			indexPerDocGoroutine(index, docs)
+		})
+		b.ReportMetric(float64(len(docs)), "docs/op")
+	})
+
+	for _, bulkConfig := range []BleveBulkConfig{
+		{BatchSize: 100, NumWorkers: 1},
+		{BatchSize: 100, NumWorkers: 4},
+		{BatchSize: 1000, NumWorkers: 4},
+	} {
+		name := fmt.Sprintf("batch_%d_workers_%d", bulkConfig.BatchSize, bulkConfig.NumWorkers)
+		b.Run(name, func(b *testing.B) {
+			benchIndexing(b, bulkConfig, func(index *BleveIndex) {
+				if docErrors := index.BulkIndex(ctx, docs); docErrors != nil {
+					b.Fatal(docErrors.ToError())
+				}
+			})
+			b.ReportMetric(float64(len(docs)), "docs/op")
+		})
+	}
+}
+
+// benchIndexing runs indexFn on a fresh index for each iteration,
+// only indexFn is measured
+func benchIndexing(b *testing.B, bulkConfig BleveBulkConfig, indexFn func(index *BleveIndex)) {
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		index := newBenchIndex(b, bulkConfig)
+		b.StartTimer()
+
+		indexFn(index)
+
+		b.StopTimer()
+		index.Close()
+		b.StartTimer()
+	}
+}
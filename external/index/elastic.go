@@ -5,21 +5,26 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/isdzulqor/kraicklist/helper/errors"
-	"github.com/isdzulqor/kraicklist/helper/jsons"
 	"github.com/isdzulqor/kraicklist/helper/logging"
 
 	es7 "github.com/elastic/go-elasticsearch/v7"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
 	"github.com/elastic/go-elasticsearch/v7/esutil"
 )
 
 const (
 	prefixElastic = "external-elastic:"
 	IndexElastic  = "elastic"
+
+	elasticScrollKeepAlive = time.Minute
 )
 
 type ElasticDoc struct {
@@ -35,7 +40,21 @@ type ElasticDocError struct {
 }
 
 type ElasticRootQuery struct {
-	Query interface{} `json:"query"`
+	Query interface{}   `json:"query"`
+	Sort  []interface{} `json:"sort,omitempty"`
+	Size  int           `json:"size,omitempty"`
+}
+
+// ApplyFilter narrows the constructed query down by the filter clauses which don't affect the score
+func (e *ElasticRootQuery) ApplyFilter(filters ...interface{}) {
+	if len(filters) == 0 {
+		return
+	}
+	e.Query = map[string]interface{}{
+		"bool": map[string]interface{}{
+			"must":   e.Query,
+			"filter": filters,
+		}}
 }
 
 func (e *ElasticRootQuery) ConstructElasticMultiMatchQuery(query string, fields ...string) {
@@ -90,21 +109,32 @@ func (q ElasticQueryResult) GetHitSources() (documentFields []interface{}) {
 
 type ElasticDocErrors []ElasticDocError
 
-// TODO: convert to error lib
 func (errorDocs ElasticDocErrors) ToError() error {
-	return nil
+	return errors.ToDocErrors(errorDocs, func(docError ElasticDocError) (string, error) {
+		return docError.DocID, docError.err
+	}).ErrorOrNil()
 }
 
 type ElasticIndex struct {
 	esClient  *es7.Client
 	indexName string
+
+	bulkConfig ElasticBulkConfig
+	throttle   *elasticThrottle
 }
 
-func InitESIndex(ctx context.Context, elasticHost []string, username, password, indexName string) (*ElasticIndex, error) {
+func InitESIndex(ctx context.Context, elasticHost []string, username, password, indexName string,
+	bulkConfig ElasticBulkConfig) (*ElasticIndex, error) {
 	es7, err := es7.NewClient(es7.Config{
 		Addresses: elasticHost,
 		Username:  username,
 		Password:  password,
+
+		// retry the whole request when the cluster is overloaded
+		RetryOnStatus: []int{http.StatusTooManyRequests, http.StatusBadGateway,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+		MaxRetries:   bulkConfig.MaxRetries,
+		RetryBackoff: bulkConfig.Backoff,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("%s failed to initiate ES7 client, err: %v", prefixElastic, err)
@@ -112,19 +142,126 @@ func InitESIndex(ctx context.Context, elasticHost []string, username, password,
 
 	logging.InfoContext(ctx, "%s ES7 client is initialized", prefixElastic)
 	return &ElasticIndex{
-		esClient:  es7,
-		indexName: indexName,
+		esClient:   es7,
+		indexName:  indexName,
+		bulkConfig: bulkConfig,
+		throttle: &elasticThrottle{
+			min: bulkConfig.RetryBackoffMin,
+			max: bulkConfig.RetryBackoffMax,
+		},
 	}, nil
 }
 
+// BulkIndexDocs indexes docs with ES bulk indexer.
+// Docs those are rejected with retryable status are retried with jittered backoff up to MaxRetries rounds.
+// err is returned when the ratio of failed docs reaches FailureThreshold, failed docs are listed on docErrors
+// and on the data of err as errors.DocErrors
 func (es *ElasticIndex) BulkIndexDocs(ctx context.Context, docs ElasticDocs) (docErrors *ElasticDocErrors, err error) {
-	var countSuccessful uint64
+	return es.bulk(ctx, bulkActionIndex, docs)
+}
+
+// BulkDeleteDocs deletes docs by their IDs with the same retry and failure threshold as BulkIndexDocs,
+// docs those don't exist on the index are considered deleted
+func (es *ElasticIndex) BulkDeleteDocs(ctx context.Context, ids []string) (docErrors *ElasticDocErrors, err error) {
+	docs := make(ElasticDocs, 0, len(ids))
+	for _, id := range ids {
+		docs = append(docs, ElasticDoc{ID: id})
+	}
+	return es.bulk(ctx, bulkActionDelete, docs)
+}
+
+func (es *ElasticIndex) bulk(ctx context.Context, action string, docs ElasticDocs) (docErrors *ElasticDocErrors, err error) {
+	var (
+		start    = time.Now()
+		pending  = docs
+		failures = ElasticDocErrors{}
+	)
+
+	for attempt := 0; len(pending) > 0; attempt++ {
+		var retryDocs ElasticDocs
+		var retryErrors, roundFailures ElasticDocErrors
+		if retryDocs, retryErrors, roundFailures, err = es.bulkRound(ctx, action, pending); err != nil {
+			return
+		}
+		failures = append(failures, roundFailures...)
+		if len(retryDocs) == 0 {
+			break
+		}
+
+		if attempt >= es.bulkConfig.MaxRetries || ctx.Err() != nil {
+			failures = append(failures, retryErrors...)
+			break
+		}
+		backoff := es.bulkConfig.Backoff(attempt)
+		logging.WarnContext(ctx, "%s %d docs are rejected, retrying in %s (%d/%d), last err: %v", prefixElastic,
+			len(retryDocs), backoff, attempt+1, es.bulkConfig.MaxRetries, retryErrors[0].err)
+		select {
+		case <-time.After(backoff):
+			pending = retryDocs
+		case <-ctx.Done():
+			failures = append(failures, retryErrors...)
+			pending = nil
+		}
+	}
+
+	if len(failures) > 0 {
+		docErrors = &failures
+	}
+
+	countFailed := len(failures)
+	logging.InfoContext(ctx, "%s %s %d of %d docs in %s, throttle delay %s", prefixElastic, bulkActionDone[action],
+		len(docs)-countFailed, len(docs), time.Since(start), es.throttle.current())
+
+	if countFailed > 0 && float64(countFailed)/float64(len(docs)) >= es.bulkConfig.FailureThreshold {
+		logging.WarnContext(ctx, "%s failed to %s %d of %d docs, reaching failure threshold %.2f", prefixElastic,
+			action, countFailed, len(docs), es.bulkConfig.FailureThreshold)
+		// the failed docs are kept on the error, so the callers can still report each of them
+		err = errors.ErrorThirdParty.AppendMessage(fmt.Sprintf("%d of %d docs failed to be %s", countFailed, len(docs),
+			bulkActionDone[action])).SetData(failures.ToError())
+		return
+	}
+	return
+}
+
+// bulkRound runs the bulk action for docs once, failed docs are split to retryable and permanent failures.
+// retryErrors[i] is the last error of retryDocs[i]
+func (es *ElasticIndex) bulkRound(ctx context.Context, action string, docs ElasticDocs) (retryDocs ElasticDocs,
+	retryErrors, failures ElasticDocErrors, err error) {
+	var (
+		outcomes   = make([]int32, len(docs))
+		itemErrors = make([]error, len(docs))
+
+		flushErrMu sync.Mutex
+		flushErr   error
+	)
+
 	bi, err := esutil.NewBulkIndexer(esutil.BulkIndexerConfig{
 		Index:         es.indexName,
 		Client:        es.esClient,
-		NumWorkers:    100,              // The number of worker goroutines
-		FlushBytes:    int(5e+6),        // The flush threshold in bytes
-		FlushInterval: 30 * time.Second, // The periodic flush interval
+		NumWorkers:    es.bulkConfig.NumWorkers,
+		FlushBytes:    es.bulkConfig.FlushBytes,
+		FlushInterval: es.bulkConfig.FlushInterval,
+
+		// slow down the workers when the previous flushes are pressured
+		OnFlushStart: func(ctx context.Context) context.Context {
+			es.throttle.wait(ctx)
+			return withFlushPressure(ctx)
+		},
+		OnFlushEnd: func(ctx context.Context) {
+			if isFlushPressured(ctx) {
+				es.throttle.pressure()
+				return
+			}
+			es.throttle.relax()
+		},
+		// the whole bulk request is failed, no item callback will be invoked for its docs
+		OnError: func(ctx context.Context, err error) {
+			logging.WarnContext(ctx, "%s bulk request is failed, err: %v", prefixElastic, err)
+			markFlushPressure(ctx)
+			flushErrMu.Lock()
+			flushErr = err
+			flushErrMu.Unlock()
+		},
 	})
 	if err != nil {
 		logging.ErrContext(ctx, "failed to init bulk indexer, err: %v", err)
@@ -132,36 +269,53 @@ func (es *ElasticIndex) BulkIndexDocs(ctx context.Context, docs ElasticDocs) (do
 		return
 	}
 
-	for _, doc := range docs {
-		data, _ := json.Marshal(doc.Data)
-		err = bi.Add(
+	for i, doc := range docs {
+		i := i
+		var body io.Reader
+		if action == bulkActionIndex {
+			data, marshalErr := json.Marshal(doc.Data)
+			if marshalErr != nil {
+				outcomes[i], itemErrors[i] = outcomeFailed, marshalErr
+				continue
+			}
+			body = bytes.NewReader(data)
+		}
+
+		// callbacks are invoked by the bulk indexer workers concurrently,
+		// each of them only writes the outcome of its own doc
+		addErr := bi.Add(
 			ctx,
 			esutil.BulkIndexerItem{
-				Action:     "index",
+				Action:     action,
 				DocumentID: doc.ID,
-				Body:       bytes.NewReader(data),
+				Body:       body,
 				OnSuccess: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem) {
-					atomic.AddUint64(&countSuccessful, 1)
+					atomic.StoreInt32(&outcomes[i], outcomeIndexed)
 				},
 				OnFailure: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
-					if docErrors == nil {
-						docErrors = &ElasticDocErrors{}
+					if action == bulkActionDelete && res.Status == http.StatusNotFound {
+						atomic.StoreInt32(&outcomes[i], outcomeIndexed)
+						return
 					}
-					if err != nil {
-						logging.WarnContext(ctx, "failed to index doc with ID %d, err: %v", doc.ID, err)
-					} else {
-						err = fmt.Errorf("ERROR: %s: %s", res.Error.Type, res.Error.Reason)
-						logging.WarnContext(ctx, "failed to index doc with ID %d, err: %v", doc.ID, err)
+					if err == nil {
+						err = fmt.Errorf("%d %s: %s", res.Status, res.Error.Type, res.Error.Reason)
 					}
-					*docErrors = append(*docErrors, ElasticDocError{
-						DocID: doc.ID,
-						err:   err,
-					})
+					itemErrors[i] = err
+
+					if isRetryableStatus(res.Status) || res.Error.Type == elasticRejectedExecution {
+						markFlushPressure(ctx)
+						atomic.StoreInt32(&outcomes[i], outcomeRetryable)
+						return
+					}
+					logging.WarnContext(ctx, "failed to %s doc with ID %s, err: %v", action, item.DocumentID, err)
+					atomic.StoreInt32(&outcomes[i], outcomeFailed)
 				},
 			},
 		)
-		if err != nil {
-			logging.ErrContext(ctx, "failed to add doc %s on bulk index, err: %v", doc.ID, err)
+		if addErr != nil {
+			logging.ErrContext(ctx, "failed to add doc %s on bulk %s, err: %v", doc.ID, action, addErr)
+			atomic.StoreInt32(&outcomes[i], outcomeFailed)
+			itemErrors[i] = addErr
 		}
 	}
 	if err = bi.Close(ctx); err != nil {
@@ -169,15 +323,24 @@ func (es *ElasticIndex) BulkIndexDocs(ctx context.Context, docs ElasticDocs) (do
 		return
 	}
 
-	biStats := bi.Stats()
-	if biStats.NumFailed == biStats.NumAdded {
-		logging.WarnContext(ctx, "%s failed to index docs, bulk index ES7 status: %v", prefixElastic,
-			jsons.ToStringJsonNoError(biStats))
-		err = errors.ErrorThirdParty
-		return
+	for i, doc := range docs {
+		switch outcomes[i] {
+		case outcomeIndexed:
+		case outcomeFailed:
+			failures = append(failures, ElasticDocError{DocID: doc.ID, err: itemErrors[i]})
+		default:
+			// retryable or its bulk request is failed entirely
+			itemErr := itemErrors[i]
+			if itemErr == nil {
+				itemErr = flushErr
+			}
+			if itemErr == nil {
+				itemErr = fmt.Errorf("bulk request is failed")
+			}
+			retryDocs = append(retryDocs, doc)
+			retryErrors = append(retryErrors, ElasticDocError{DocID: doc.ID, err: itemErr})
+		}
 	}
-
-	logging.DebugContext(ctx, "successfully indexing %d docs", biStats.NumIndexed)
 	return
 }
 
@@ -243,10 +406,23 @@ func (es *ElasticIndex) DeleteIndex(ctx context.Context) (err error) {
 }
 
 func (es *ElasticIndex) Ping() error {
-	_, err := es.esClient.Cluster.Health()
+	return es.PingContext(context.Background())
+}
+
+// PingContext checks the cluster health, it's aborted when the ctx is done
+func (es *ElasticIndex) PingContext(ctx context.Context) error {
+	res, err := es.esClient.Cluster.Health(es.esClient.Cluster.Health.WithContext(ctx))
 	if err != nil {
 		err = fmt.Errorf("%s %v", prefixElastic, err)
-		logging.ErrContext(context.Background(), "%v", err)
+		logging.ErrContext(ctx, "%v", err)
+		return err
+	}
+	defer res.Body.Close()
+
+	// i.e: 401 for wrong credentials
+	if res.IsError() {
+		err = fmt.Errorf("%s cluster health is failed, resp: %s", prefixElastic, res.String())
+		logging.ErrContext(ctx, "%v", err)
 		return err
 	}
 	return nil
@@ -266,3 +442,330 @@ func (es *ElasticIndex) PingWithRetry(retry int, waitTime time.Duration) (err er
 	}
 	return
 }
+
+// ElasticHit is an indexed doc with its raw source
+type ElasticHit struct {
+	ID     string          `json:"_id"`
+	Found  bool            `json:"found"`
+	Source json.RawMessage `json:"_source"`
+}
+
+type elasticScrollResult struct {
+	ScrollID string `json:"_scroll_id"`
+	Hits     struct {
+		Hits []ElasticHit `json:"hits"`
+	} `json:"hits"`
+}
+
+// GetDocs returns the docs those exist on the index by their IDs,
+// only sourceIncludes fields are loaded or the whole source when it's empty
+func (es *ElasticIndex) GetDocs(ctx context.Context, ids []string, sourceIncludes ...string) (out []ElasticHit, err error) {
+	if len(ids) == 0 {
+		return
+	}
+	data, err := json.Marshal(map[string][]string{"ids": ids})
+	if err != nil {
+		err = fmt.Errorf("%s %v", prefixElastic, err)
+		return
+	}
+
+	opts := []func(*esapi.MgetRequest){
+		es.esClient.Mget.WithContext(ctx),
+		es.esClient.Mget.WithIndex(es.indexName),
+	}
+	if len(sourceIncludes) > 0 {
+		opts = append(opts, es.esClient.Mget.WithSourceIncludes(sourceIncludes...))
+	}
+	res, err := es.esClient.Mget(bytes.NewReader(data), opts...)
+	if err != nil {
+		logging.ErrContext(ctx, "failed to get docs, err: %v", err)
+		err = errors.ErrorThirdParty
+		return
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		logging.WarnContext(ctx, "failed to get docs, resp: %s", res.String())
+		err = errors.ErrorThirdParty
+		return
+	}
+
+	var result struct {
+		Docs []ElasticHit `json:"docs"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&result); err != nil {
+		err = fmt.Errorf("%s %v", prefixElastic, err)
+		return
+	}
+	for _, doc := range result.Docs {
+		if doc.Found {
+			out = append(out, doc)
+		}
+	}
+	return
+}
+
+// ScanDocs scrolls through all docs matching the query, or all docs when query is nil,
+// fn is called for every page. Only sourceIncludes fields are loaded or the whole source when it's empty
+func (es *ElasticIndex) ScanDocs(ctx context.Context, pageSize int, query interface{}, fn func([]ElasticHit) error,
+	sourceIncludes ...string) (err error) {
+	body := map[string]interface{}{"sort": []string{"_doc"}}
+	if query != nil {
+		body["query"] = query
+	}
+	if len(sourceIncludes) > 0 {
+		body["_source"] = sourceIncludes
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("%s %v", prefixElastic, err)
+	}
+
+	res, err := es.esClient.Search(
+		es.esClient.Search.WithContext(ctx),
+		es.esClient.Search.WithIndex(es.indexName),
+		es.esClient.Search.WithBody(bytes.NewReader(data)),
+		es.esClient.Search.WithSize(pageSize),
+		es.esClient.Search.WithScroll(elasticScrollKeepAlive),
+	)
+	var scrollID string
+	defer func() {
+		if scrollID != "" {
+			es.clearScroll(scrollID)
+		}
+	}()
+
+	for {
+		var result elasticScrollResult
+		if result, err = es.decodeScroll(ctx, res, err); err != nil {
+			return
+		}
+		scrollID = result.ScrollID
+		if len(result.Hits.Hits) == 0 {
+			return
+		}
+		if err = fn(result.Hits.Hits); err != nil {
+			return
+		}
+
+		res, err = es.esClient.Scroll(
+			es.esClient.Scroll.WithContext(ctx),
+			es.esClient.Scroll.WithScrollID(scrollID),
+			es.esClient.Scroll.WithScroll(elasticScrollKeepAlive),
+		)
+	}
+}
+
+func (es *ElasticIndex) decodeScroll(ctx context.Context, res *esapi.Response, reqErr error) (result elasticScrollResult, err error) {
+	if reqErr != nil {
+		logging.ErrContext(ctx, "failed to scroll docs, err: %v", reqErr)
+		err = errors.ErrorThirdParty
+		return
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		logging.WarnContext(ctx, "failed to scroll docs, resp: %s", res.String())
+		err = errors.ErrorThirdParty
+		return
+	}
+	if err = json.NewDecoder(res.Body).Decode(&result); err != nil {
+		err = fmt.Errorf("%s %v", prefixElastic, err)
+	}
+	return
+}
+
+func (es *ElasticIndex) clearScroll(scrollID string) {
+	res, err := es.esClient.ClearScroll(es.esClient.ClearScroll.WithScrollID(scrollID))
+	if err != nil {
+		logging.WarnContext(context.Background(), "%s failed to clear scroll, err: %v", prefixElastic, err)
+		return
+	}
+	res.Body.Close()
+}
+
+// Count returns the number of docs on the index
+func (es *ElasticIndex) Count(ctx context.Context) (count int64, err error) {
+	res, err := es.esClient.Count(
+		es.esClient.Count.WithContext(ctx),
+		es.esClient.Count.WithIndex(es.indexName),
+	)
+	if err != nil {
+		logging.ErrContext(ctx, "failed to count docs, err: %v", err)
+		err = errors.ErrorThirdParty
+		return
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		logging.WarnContext(ctx, "failed to count docs, resp: %s", res.String())
+		err = errors.ErrorThirdParty
+		return
+	}
+
+	var result struct {
+		Count int64 `json:"count"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&result); err != nil {
+		err = fmt.Errorf("%s %v", prefixElastic, err)
+		return
+	}
+	count = result.Count
+	return
+}
+
+// Refresh makes the recent writes visible to search and count
+func (es *ElasticIndex) Refresh(ctx context.Context) error {
+	res, err := es.esClient.Indices.Refresh(
+		es.esClient.Indices.Refresh.WithContext(ctx),
+		es.esClient.Indices.Refresh.WithIndex(es.indexName),
+	)
+	if err != nil {
+		return fmt.Errorf("%s cannot refresh index, err: %v", prefixElastic, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("%s cannot refresh index, err resp: %v", prefixElastic, res.String())
+	}
+	return nil
+}
+
+// ElasticStoreStats is the on-disk statistics of the index on all primaries
+type ElasticStoreStats struct {
+	SizeBytes int64
+	Segments  int64
+}
+
+// StoreStats returns the store size and the segment count of the index
+func (es *ElasticIndex) StoreStats(ctx context.Context) (out ElasticStoreStats, err error) {
+	res, err := es.esClient.Indices.Stats(
+		es.esClient.Indices.Stats.WithContext(ctx),
+		es.esClient.Indices.Stats.WithIndex(es.indexName),
+		es.esClient.Indices.Stats.WithMetric("store", "segments"),
+	)
+	if err != nil {
+		logging.ErrContext(ctx, "failed to get index stats, err: %v", err)
+		err = errors.ErrorThirdParty
+		return
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		logging.WarnContext(ctx, "failed to get index stats, resp: %s", res.String())
+		err = errors.ErrorThirdParty
+		return
+	}
+
+	var result struct {
+		All struct {
+			Primaries struct {
+				Store struct {
+					SizeInBytes int64 `json:"size_in_bytes"`
+				} `json:"store"`
+				Segments struct {
+					Count int64 `json:"count"`
+				} `json:"segments"`
+			} `json:"primaries"`
+		} `json:"_all"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&result); err != nil {
+		err = fmt.Errorf("%s %v", prefixElastic, err)
+		return
+	}
+	out.SizeBytes = result.All.Primaries.Store.SizeInBytes
+	out.Segments = result.All.Primaries.Segments.Count
+	return
+}
+
+// Aggregate runs the aggregations on all docs, their results are decoded into dest
+func (es *ElasticIndex) Aggregate(ctx context.Context, aggs interface{}, dest interface{}) (err error) {
+	data, err := json.Marshal(map[string]interface{}{
+		"size": 0,
+		"aggs": aggs,
+	})
+	if err != nil {
+		return fmt.Errorf("%s %v", prefixElastic, err)
+	}
+
+	res, err := es.esClient.Search(
+		es.esClient.Search.WithContext(ctx),
+		es.esClient.Search.WithIndex(es.indexName),
+		es.esClient.Search.WithBody(bytes.NewReader(data)),
+	)
+	if err != nil {
+		logging.ErrContext(ctx, "failed to aggregate, err: %v", err)
+		return errors.ErrorThirdParty
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		logging.WarnContext(ctx, "failed to aggregate, resp: %s", res.String())
+		return errors.ErrorThirdParty.AppendMessage(res.String())
+	}
+
+	var result struct {
+		Aggregations json.RawMessage `json:"aggregations"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return fmt.Errorf("%s %v", prefixElastic, err)
+	}
+	if err = json.Unmarshal(result.Aggregations, dest); err != nil {
+		return fmt.Errorf("%s %v", prefixElastic, err)
+	}
+	return
+}
+
+// FieldTypes returns the live mapping of the index as field path to its type, multi-fields are
+// listed with their parent path, i.e: tags.keyword. found is false when the index doesn't exist
+func (es *ElasticIndex) FieldTypes(ctx context.Context) (out map[string]string, found bool, err error) {
+	res, err := es.esClient.Indices.GetMapping(
+		es.esClient.Indices.GetMapping.WithContext(ctx),
+		es.esClient.Indices.GetMapping.WithIndex(es.indexName),
+	)
+	if err != nil {
+		err = fmt.Errorf("%s cannot get mapping, err: %v", prefixElastic, err)
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return
+	}
+	if res.IsError() {
+		err = fmt.Errorf("%s cannot get mapping, err resp: %v", prefixElastic, res.String())
+		return
+	}
+
+	var result map[string]struct {
+		Mappings elasticMappingField `json:"mappings"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&result); err != nil {
+		err = fmt.Errorf("%s %v", prefixElastic, err)
+		return
+	}
+	out = map[string]string{}
+	for _, index := range result {
+		index.Mappings.flatten("", out)
+	}
+	found = true
+	return
+}
+
+type elasticMappingField struct {
+	Type       string                         `json:"type"`
+	Properties map[string]elasticMappingField `json:"properties"`
+	Fields     map[string]elasticMappingField `json:"fields"`
+}
+
+func (f elasticMappingField) flatten(path string, out map[string]string) {
+	if path != "" && f.Type != "" {
+		out[path] = f.Type
+	}
+	for name, child := range f.Properties {
+		child.flatten(joinFieldPath(path, name), out)
+	}
+	for name, child := range f.Fields {
+		child.flatten(joinFieldPath(path, name), out)
+	}
+}
+
+func joinFieldPath(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
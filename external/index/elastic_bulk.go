@@ -0,0 +1,136 @@
+package index
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	elasticRejectedExecution = "es_rejected_execution_exception"
+
+	// bulk doc outcomes of a bulk round
+	outcomeUnknown int32 = iota
+	outcomeIndexed
+	outcomeFailed
+	outcomeRetryable
+
+	bulkActionIndex  = "index"
+	bulkActionDelete = "delete"
+)
+
+// bulkActionDone is the past tense of bulk actions for logs and error messages
+var bulkActionDone = map[string]string{
+	bulkActionIndex:  "indexed",
+	bulkActionDelete: "deleted",
+}
+
+// ElasticBulkConfig configures BulkIndexDocs
+type ElasticBulkConfig struct {
+	NumWorkers    int
+	FlushBytes    int
+	FlushInterval time.Duration
+
+	// MaxRetries is the max rounds to retry docs those are rejected with retryable status, i.e: 429 es_rejected_execution
+	MaxRetries      int
+	RetryBackoffMin time.Duration
+	RetryBackoffMax time.Duration
+
+	// FailureThreshold is the ratio of failed docs, 0 to 1, which makes the whole bulk to be failed
+	FailureThreshold float64
+}
+
+// Backoff returns exponential backoff with full jitter for the attempt, starting from 0
+func (c ElasticBulkConfig) Backoff(attempt int) time.Duration {
+	backoff := c.RetryBackoffMin
+	for i := 0; i < attempt && backoff < c.RetryBackoffMax; i++ {
+		backoff *= 2
+	}
+	if backoff > c.RetryBackoffMax {
+		backoff = c.RetryBackoffMax
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+func isRetryableStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// elasticThrottle slows down bulk flushes when ES signals pressure.
+// The delay before each flush is doubled on a pressured flush and halved on a healthy one
+type elasticThrottle struct {
+	mu    sync.Mutex
+	delay time.Duration
+
+	min time.Duration
+	max time.Duration
+}
+
+func (t *elasticThrottle) wait(ctx context.Context) {
+	t.mu.Lock()
+	delay := t.delay
+	t.mu.Unlock()
+	if delay == 0 {
+		return
+	}
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+	}
+}
+
+func (t *elasticThrottle) pressure() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.delay < t.min {
+		t.delay = t.min
+	} else {
+		t.delay *= 2
+	}
+	if t.delay > t.max {
+		t.delay = t.max
+	}
+}
+
+func (t *elasticThrottle) relax() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.delay /= 2; t.delay < t.min {
+		t.delay = 0
+	}
+}
+
+func (t *elasticThrottle) current() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.delay
+}
+
+// flushPressureKey is the context key of a flag which is set when a flush is pressured
+type flushPressureKey struct{}
+
+func withFlushPressure(ctx context.Context) context.Context {
+	return context.WithValue(ctx, flushPressureKey{}, new(int32))
+}
+
+func markFlushPressure(ctx context.Context) {
+	if flag, ok := ctx.Value(flushPressureKey{}).(*int32); ok {
+		atomic.StoreInt32(flag, 1)
+	}
+}
+
+func isFlushPressured(ctx context.Context) bool {
+	flag, ok := ctx.Value(flushPressureKey{}).(*int32)
+	return ok && atomic.LoadInt32(flag) == 1
+}
@@ -0,0 +1,99 @@
+package index
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/isdzulqor/kraicklist/helper/errors"
+)
+
+// rejectingBulkServer answers the bulk requests rejecting the docs of the IDs with a mapping error
+func rejectingBulkServer(t *testing.T, rejected map[string]bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var items []map[string]interface{}
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var action map[string]struct {
+				ID string `json:"_id"`
+			}
+			if err := json.Unmarshal(scanner.Bytes(), &action); err != nil {
+				t.Errorf("invalid bulk line %s, err: %v", scanner.Text(), err)
+				return
+			}
+			meta, ok := action["index"]
+			if !ok {
+				continue
+			}
+			// the source line of the doc
+			scanner.Scan()
+			item := map[string]interface{}{"_id": meta.ID, "status": http.StatusCreated}
+			if rejected[meta.ID] {
+				item["status"] = http.StatusBadRequest
+				item["error"] = map[string]string{"type": "mapper_parsing_exception", "reason": "bad title"}
+			}
+			items = append(items, map[string]interface{}{"index": item})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"took": 1, "errors": len(rejected) > 0, "items": items})
+	}))
+}
+
+func TestBulkIndexDocsKeepsTheFailedDocsOnTheThresholdError(t *testing.T) {
+	tests := []struct {
+		name          string
+		threshold     float64
+		wantThreshold bool
+	}{
+		{name: "below the threshold", threshold: 1},
+		{name: "reaching the threshold", threshold: 0.5, wantThreshold: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := rejectingBulkServer(t, map[string]bool{"2": true, "3": true})
+			defer server.Close()
+			es, err := InitESIndex(context.Background(), []string{server.URL}, "", "", "ads", ElasticBulkConfig{
+				NumWorkers:       1,
+				FlushBytes:       1 << 20,
+				FlushInterval:    time.Second,
+				FailureThreshold: tt.threshold,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			docs := make(ElasticDocs, 0, 4)
+			for i := 1; i <= 4; i++ {
+				docs = append(docs, ElasticDoc{ID: fmt.Sprint(i), Data: map[string]string{"title": "ad"}})
+			}
+			docErrors, err := es.BulkIndexDocs(context.Background(), docs)
+			if docErrors == nil || len(*docErrors) != 2 {
+				t.Fatalf("expected 2 failed docs, got %v", docErrors)
+			}
+			if tt.wantThreshold != (err != nil) {
+				t.Fatalf("threshold err = %v, want it: %v", err, tt.wantThreshold)
+			}
+			if err == nil {
+				return
+			}
+			if status := errors.GetStatusCode(err); status != http.StatusBadGateway {
+				t.Errorf("status = %d, want %d", status, http.StatusBadGateway)
+			}
+			failed, ok := errors.ExtractDocErrors(err)
+			if !ok || len(failed) != 2 {
+				t.Fatalf("expected the 2 failed docs on the threshold error, got %v", failed)
+			}
+			for _, docErr := range failed {
+				if !strings.Contains(docErr.Reason(), "bad title") {
+					t.Errorf("doc %s reason = %q, want the mapping error", docErr.DocID, docErr.Reason())
+				}
+			}
+		})
+	}
+}
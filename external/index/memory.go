@@ -0,0 +1,636 @@
+package index
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/isdzulqor/kraicklist/helper/errors"
+	"github.com/isdzulqor/kraicklist/helper/logging"
+)
+
+const (
+	IndexMemory  = "memory"
+	prefixMemory = "external-memory:"
+
+	// BM25 parameters, the same defaults as lucene
+	memoryBM25K1 = 1.2
+	memoryBM25B  = 0.75
+
+	memoryDefaultSize = 10
+)
+
+type MemoryDoc struct {
+	ID   string
+	Data interface{}
+}
+
+type MemoryDocs []MemoryDoc
+
+type MemoryDocError struct {
+	DocID string
+	err   error
+}
+
+type MemoryDocErrors []MemoryDocError
+
+func (errorDocs MemoryDocErrors) ToError() error {
+	return errors.ToDocErrors(errorDocs, func(docError MemoryDocError) (string, error) {
+		return docError.DocID, docError.err
+	}).ErrorOrNil()
+}
+
+// MemoryIndexConfig configures the in-memory index, TextFields are analyzed and scored on search.
+// The docs are loaded from SnapshotPath on init and written back on Close when it's not empty
+type MemoryIndexConfig struct {
+	TextFields   []string
+	SnapshotPath string
+	ReadOnly     bool
+}
+
+// MemoryIndex is an inverted index kept in memory, the hits are scored with BM25 per text field
+type MemoryIndex struct {
+	config MemoryIndexConfig
+
+	mu   sync.RWMutex
+	docs map[string]*memoryDoc
+	// postings maps field to term to doc ID to the term frequency
+	postings map[string]map[string]map[string]int
+	// fieldLengths sums the number of terms of each field on all docs
+	fieldLengths map[string]int
+	dirty        bool
+}
+
+type memoryDoc struct {
+	source  json.RawMessage
+	fields  map[string]interface{}
+	lengths map[string]int
+	terms   map[string]map[string]int
+}
+
+// InitMemoryIndex creates the index, the docs of an existing snapshot are loaded unless skipSnapshot is set
+func InitMemoryIndex(ctx context.Context, config MemoryIndexConfig, skipSnapshot bool) (out *MemoryIndex, err error) {
+	out = &MemoryIndex{
+		config:       config,
+		docs:         map[string]*memoryDoc{},
+		postings:     map[string]map[string]map[string]int{},
+		fieldLengths: map[string]int{},
+	}
+	if config.SnapshotPath == "" || skipSnapshot {
+		logging.InfoContext(ctx, "%s memory index is initialized", prefixMemory)
+		return
+	}
+
+	if err = out.loadSnapshot(); err != nil {
+		if !os.IsNotExist(err) {
+			err = fmt.Errorf("%s failed to load snapshot %s, err: %v", prefixMemory, config.SnapshotPath, err)
+			return nil, err
+		}
+		if config.ReadOnly {
+			return nil, fmt.Errorf("%s snapshot %s doesn't exist, seed it first", prefixMemory, config.SnapshotPath)
+		}
+		logging.WarnContext(ctx, "%s snapshot %s doesn't exist, will create new one", prefixMemory, config.SnapshotPath)
+		err = nil
+	}
+	logging.InfoContext(ctx, "%s memory index is initialized with %d docs", prefixMemory, len(out.docs))
+	return
+}
+
+// BulkIndex creates or replaces the docs, docs those are not indexed yet when ctx is done are reported as failed
+func (index *MemoryIndex) BulkIndex(ctx context.Context, docs MemoryDocs) (docErrors *MemoryDocErrors) {
+	var errorDocs MemoryDocErrors
+	parsed := make(map[string]*memoryDoc, len(docs))
+	for _, doc := range docs {
+		if ctx.Err() != nil {
+			errorDocs = append(errorDocs, MemoryDocError{DocID: doc.ID, err: ctx.Err()})
+			continue
+		}
+		source, err := json.Marshal(doc.Data)
+		if err != nil {
+			errorDocs = append(errorDocs, MemoryDocError{DocID: doc.ID, err: err})
+			continue
+		}
+		parsedDoc, err := index.parseDoc(source)
+		if err != nil {
+			errorDocs = append(errorDocs, MemoryDocError{DocID: doc.ID, err: err})
+			continue
+		}
+		parsed[doc.ID] = parsedDoc
+	}
+
+	index.mu.Lock()
+	for id, doc := range parsed {
+		index.remove(id)
+		index.add(id, doc)
+	}
+	if len(parsed) > 0 {
+		index.dirty = true
+	}
+	index.mu.Unlock()
+
+	if len(errorDocs) > 0 {
+		docErrors = &errorDocs
+	}
+	return
+}
+
+// BulkDelete deletes the docs by their IDs, missing docs are not failures
+func (index *MemoryIndex) BulkDelete(ctx context.Context, ids []string) (docErrors *MemoryDocErrors) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	var errorDocs MemoryDocErrors
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			errorDocs = append(errorDocs, MemoryDocError{DocID: id, err: ctx.Err()})
+			continue
+		}
+		if index.remove(id) {
+			index.dirty = true
+		}
+	}
+	if len(errorDocs) > 0 {
+		docErrors = &errorDocs
+	}
+	return
+}
+
+func (index *MemoryIndex) parseDoc(source json.RawMessage) (*memoryDoc, error) {
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(source, &fields); err != nil {
+		return nil, err
+	}
+	doc := &memoryDoc{
+		source:  source,
+		fields:  fields,
+		lengths: map[string]int{},
+		terms:   map[string]map[string]int{},
+	}
+	for _, field := range index.config.TextFields {
+		terms := map[string]int{}
+		for _, token := range AnalyzeMemoryText(fieldText(fields[field])) {
+			terms[token]++
+			doc.lengths[field]++
+		}
+		doc.terms[field] = terms
+	}
+	return doc, nil
+}
+
+// add indexes the doc, the lock must be held
+func (index *MemoryIndex) add(id string, doc *memoryDoc) {
+	index.docs[id] = doc
+	for field, terms := range doc.terms {
+		fieldPostings := index.postings[field]
+		if fieldPostings == nil {
+			fieldPostings = map[string]map[string]int{}
+			index.postings[field] = fieldPostings
+		}
+		for term, freq := range terms {
+			if fieldPostings[term] == nil {
+				fieldPostings[term] = map[string]int{}
+			}
+			fieldPostings[term][id] = freq
+		}
+		index.fieldLengths[field] += doc.lengths[field]
+	}
+}
+
+// remove unindexes the doc, the lock must be held
+func (index *MemoryIndex) remove(id string) bool {
+	doc, ok := index.docs[id]
+	if !ok {
+		return false
+	}
+	for field, terms := range doc.terms {
+		for term := range terms {
+			delete(index.postings[field][term], id)
+			if len(index.postings[field][term]) == 0 {
+				delete(index.postings[field], term)
+			}
+		}
+		index.fieldLengths[field] -= doc.lengths[field]
+	}
+	delete(index.docs, id)
+	return true
+}
+
+// memoryStopWords is the english stop words of lucene, they are not indexed
+var memoryStopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "but": true, "by": true,
+	"for": true, "if": true, "in": true, "into": true, "is": true, "it": true, "no": true, "not": true, "of": true,
+	"on": true, "or": true, "such": true, "that": true, "the": true, "their": true, "then": true, "there": true,
+	"these": true, "they": true, "this": true, "to": true, "was": true, "will": true, "with": true,
+}
+
+// AnalyzeMemoryText splits the text into lowercase terms of letters and digits, stop words are dropped
+func AnalyzeMemoryText(text string) []string {
+	terms := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	out := terms[:0]
+	for _, term := range terms {
+		if !memoryStopWords[term] {
+			out = append(out, term)
+		}
+	}
+	return out
+}
+
+// fieldText joins a string or a list of strings, other values have no text
+func fieldText(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []interface{}:
+		texts := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				texts = append(texts, s)
+			}
+		}
+		return strings.Join(texts, " ")
+	}
+	return ""
+}
+
+// MemoryQuery filters the docs by their fields
+type MemoryQuery interface {
+	Match(fields map[string]interface{}) bool
+}
+
+type memoryMatchAny struct {
+	field  string
+	values map[string]bool
+}
+
+// MemoryMatchAny matches docs whose string or list of strings field equals any of the values
+func MemoryMatchAny(field string, values []string) MemoryQuery {
+	q := memoryMatchAny{field: field, values: map[string]bool{}}
+	for _, value := range values {
+		q.values[value] = true
+	}
+	return q
+}
+
+func (q memoryMatchAny) Match(fields map[string]interface{}) bool {
+	switch v := fields[q.field].(type) {
+	case string:
+		return q.values[v]
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok && q.values[s] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type memoryNumericRange struct {
+	field    string
+	min, max *float64
+}
+
+// MemoryNumericRange matches docs whose numeric field is within the inclusive range, nil is unbounded
+func MemoryNumericRange(field string, min, max *float64) MemoryQuery {
+	return memoryNumericRange{field: field, min: min, max: max}
+}
+
+func (q memoryNumericRange) Match(fields map[string]interface{}) bool {
+	value, ok := fields[q.field].(float64)
+	if !ok {
+		return false
+	}
+	return (q.min == nil || value >= *q.min) && (q.max == nil || value <= *q.max)
+}
+
+type memoryConjunction []MemoryQuery
+
+// MemoryConjunction matches docs passing all of the queries
+func MemoryConjunction(queries ...MemoryQuery) MemoryQuery {
+	return memoryConjunction(queries)
+}
+
+func (q memoryConjunction) Match(fields map[string]interface{}) bool {
+	for _, query := range q {
+		if !query.Match(fields) {
+			return false
+		}
+	}
+	return true
+}
+
+// MemorySearchOptions narrows the search down by Filter, sorts by SortBy fields and limits it by Size.
+// SortBy accepts numeric fields and _score, descending with - prefix, the default is -_score
+type MemorySearchOptions struct {
+	Filter MemoryQuery
+	SortBy []string
+	Size   int
+}
+
+// MemoryHit is an indexed doc with its raw source
+type MemoryHit struct {
+	ID     string
+	Score  float64
+	Source json.RawMessage
+}
+
+// SearchQuery finds docs having any term of the keyword on the text fields
+func (index *MemoryIndex) SearchQuery(ctx context.Context, keyword string, opts MemorySearchOptions) (out []MemoryHit,
+	err error) {
+	if keyword == "" {
+		err = fmt.Errorf("keyword can't be empty")
+		return
+	}
+	terms := uniqueTerms(AnalyzeMemoryText(keyword))
+
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+
+	scores := map[string]float64{}
+	total := float64(len(index.docs))
+	for _, field := range index.config.TextFields {
+		if index.fieldLengths[field] == 0 {
+			continue
+		}
+		avgLength := float64(index.fieldLengths[field]) / total
+		for _, term := range terms {
+			docFreqs := index.postings[field][term]
+			if len(docFreqs) == 0 {
+				continue
+			}
+			df := float64(len(docFreqs))
+			idf := math.Log(1 + (total-df+0.5)/(df+0.5))
+			for id, freq := range docFreqs {
+				tf := float64(freq)
+				norm := 1 - memoryBM25B + memoryBM25B*float64(index.docs[id].lengths[field])/avgLength
+				scores[id] += idf * tf * (memoryBM25K1 + 1) / (tf + memoryBM25K1*norm)
+			}
+		}
+	}
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	for id, score := range scores {
+		doc := index.docs[id]
+		if opts.Filter != nil && !opts.Filter.Match(doc.fields) {
+			continue
+		}
+		out = append(out, MemoryHit{ID: id, Score: score, Source: doc.source})
+	}
+	index.sortHits(out, opts.SortBy)
+
+	size := opts.Size
+	if size <= 0 {
+		size = memoryDefaultSize
+	}
+	if len(out) > size {
+		out = out[:size]
+	}
+	return
+}
+
+func uniqueTerms(terms []string) []string {
+	seen := map[string]bool{}
+	out := terms[:0]
+	for _, term := range terms {
+		if !seen[term] {
+			seen[term] = true
+			out = append(out, term)
+		}
+	}
+	return out
+}
+
+// sortHits sorts by the sort fields then by the ID, the read lock must be held
+func (index *MemoryIndex) sortHits(hits []MemoryHit, sortBy []string) {
+	if len(sortBy) == 0 {
+		sortBy = []string{"-_score"}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		for _, sortField := range sortBy {
+			field, desc := strings.TrimPrefix(sortField, "-"), strings.HasPrefix(sortField, "-")
+			var a, b float64
+			if field == "_score" {
+				a, b = hits[i].Score, hits[j].Score
+			} else {
+				a, _ = index.docs[hits[i].ID].fields[field].(float64)
+				b, _ = index.docs[hits[j].ID].fields[field].(float64)
+			}
+			if a == b {
+				continue
+			}
+			if desc {
+				return a > b
+			}
+			return a < b
+		}
+		return hits[i].ID < hits[j].ID
+	})
+}
+
+// GetDocs returns the docs those exist on the index by their IDs
+func (index *MemoryIndex) GetDocs(ctx context.Context, ids []string) (out []MemoryHit, err error) {
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+
+	for _, id := range ids {
+		if doc, ok := index.docs[id]; ok {
+			out = append(out, MemoryHit{ID: id, Source: doc.source})
+		}
+	}
+	return
+}
+
+// ScanDocs pages through the docs passing the query ordered by their IDs, q is optional.
+// The IDs are listed upfront, docs deleted while scanning are skipped
+func (index *MemoryIndex) ScanDocs(ctx context.Context, q MemoryQuery, pageSize int,
+	fn func(hits []MemoryHit) error) error {
+	if pageSize <= 0 {
+		return fmt.Errorf("%s page size must be greater than 0", prefixMemory)
+	}
+
+	index.mu.RLock()
+	ids := make([]string, 0, len(index.docs))
+	for id, doc := range index.docs {
+		if q == nil || q.Match(doc.fields) {
+			ids = append(ids, id)
+		}
+	}
+	index.mu.RUnlock()
+	sort.Strings(ids)
+
+	for start := 0; start < len(ids); start += pageSize {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		end := start + pageSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		hits, err := index.GetDocs(ctx, ids[start:end])
+		if err != nil {
+			return err
+		}
+		if len(hits) == 0 {
+			continue
+		}
+		if err = fn(hits); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DocCount returns the number of docs on the index
+func (index *MemoryIndex) DocCount() uint64 {
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+	return uint64(len(index.docs))
+}
+
+// TopTerms returns the terms of the field with the most docs
+func (index *MemoryIndex) TopTerms(field string, topN int) []TermCount {
+	index.mu.RLock()
+	out := make([]TermCount, 0, len(index.postings[field]))
+	for term, docFreqs := range index.postings[field] {
+		out = append(out, TermCount{Term: term, Count: uint64(len(docFreqs))})
+	}
+	index.mu.RUnlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count == out[j].Count {
+			return out[i].Term < out[j].Term
+		}
+		return out[i].Count > out[j].Count
+	})
+	if len(out) > topN {
+		out = out[:topN]
+	}
+	return out
+}
+
+// SnapshotSizeBytes returns the size of the snapshot file, 0 when there is none
+func (index *MemoryIndex) SnapshotSizeBytes() int64 {
+	if index.config.SnapshotPath == "" {
+		return 0
+	}
+	info, err := os.Stat(index.config.SnapshotPath)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// memorySnapshotLine is a doc on the snapshot, it's gzip jsonl
+type memorySnapshotLine struct {
+	ID     string          `json:"id"`
+	Source json.RawMessage `json:"source"`
+}
+
+func (index *MemoryIndex) loadSnapshot() error {
+	file, err := os.Open(index.config.SnapshotPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	decoder := json.NewDecoder(bufio.NewReader(reader))
+	for decoder.More() {
+		var line memorySnapshotLine
+		if err = decoder.Decode(&line); err != nil {
+			return err
+		}
+		doc, err := index.parseDoc(line.Source)
+		if err != nil {
+			return fmt.Errorf("doc %s, err: %v", line.ID, err)
+		}
+		index.add(line.ID, doc)
+	}
+	return nil
+}
+
+// Snapshot writes all docs to the snapshot file, it's replaced atomically
+func (index *MemoryIndex) Snapshot() (err error) {
+	path := index.config.SnapshotPath
+	if path == "" {
+		return fmt.Errorf("%s snapshot path is not set", prefixMemory)
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("%s %v", prefixMemory, err)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("%s %v", prefixMemory, err)
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	// writes wait for the snapshot so it's consistent
+	index.mu.Lock()
+	ids := make([]string, 0, len(index.docs))
+	for id := range index.docs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	writer := gzip.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	for _, id := range ids {
+		if err = encoder.Encode(memorySnapshotLine{ID: id, Source: index.docs[id].source}); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		index.dirty = false
+	}
+	index.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("%s %v", prefixMemory, err)
+	}
+
+	if err = writer.Close(); err != nil {
+		return fmt.Errorf("%s %v", prefixMemory, err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("%s %v", prefixMemory, err)
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("%s %v", prefixMemory, err)
+	}
+	return nil
+}
+
+// Close writes the snapshot when the docs are changed, it's skipped on read-only or without snapshot path
+func (index *MemoryIndex) Close() error {
+	index.mu.RLock()
+	dirty := index.dirty
+	index.mu.RUnlock()
+
+	if index.config.SnapshotPath == "" || index.config.ReadOnly || !dirty {
+		return nil
+	}
+	return index.Snapshot()
+}
@@ -0,0 +1,93 @@
+package index
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func newTestMemoryIndex(t *testing.T, snapshotPath string) *MemoryIndex {
+	index, err := InitMemoryIndex(context.Background(), MemoryIndexConfig{
+		TextFields:   []string{"title", "tags"},
+		SnapshotPath: snapshotPath,
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return index
+}
+
+func TestMemoryIndexSearchQuery(t *testing.T) {
+	ctx := context.Background()
+	index := newTestMemoryIndex(t, "")
+	docErrors := index.BulkIndex(ctx, MemoryDocs{
+		{ID: "1", Data: map[string]interface{}{"title": "iphone 11 for sale", "tags": []string{"phone"}, "updated_at": 10}},
+		{ID: "2", Data: map[string]interface{}{"title": "iphone iphone case", "tags": []string{"accessory"}, "updated_at": 20}},
+		{ID: "3", Data: map[string]interface{}{"title": "android tablet", "tags": []string{"phone"}, "updated_at": 30}},
+	})
+	if docErrors != nil {
+		t.Fatal(docErrors.ToError())
+	}
+
+	hits, err := index.SearchQuery(ctx, "IPhone", MemorySearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 2 || hits[0].ID != "2" || hits[1].ID != "1" {
+		t.Fatalf("expected hits 2 then 1 by the term frequency, got %+v", hits)
+	}
+
+	since := float64(15)
+	hits, err = index.SearchQuery(ctx, "iphone android", MemorySearchOptions{
+		Filter: MemoryConjunction(MemoryMatchAny("tags", []string{"phone"}), MemoryNumericRange("updated_at", nil, &since)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 1 || hits[0].ID != "1" {
+		t.Fatalf("expected only hit 1 passing the filter, got %+v", hits)
+	}
+
+	hits, err = index.SearchQuery(ctx, "iphone android", MemorySearchOptions{SortBy: []string{"-updated_at"}, Size: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 2 || hits[0].ID != "3" || hits[1].ID != "2" {
+		t.Fatalf("expected hits 3 then 2 by updated_at, got %+v", hits)
+	}
+
+	index.BulkDelete(ctx, []string{"2"})
+	hits, err = index.SearchQuery(ctx, "case", MemorySearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 0 {
+		t.Fatalf("expected no hits of the deleted doc, got %+v", hits)
+	}
+}
+
+func TestMemoryIndexSnapshot(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "ads.jsonl.gz")
+
+	index := newTestMemoryIndex(t, path)
+	index.BulkIndex(ctx, MemoryDocs{
+		{ID: "1", Data: map[string]interface{}{"title": "iphone 11"}},
+		{ID: "2", Data: map[string]interface{}{"title": "android tablet"}},
+	})
+	if err := index.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := newTestMemoryIndex(t, path)
+	if count := reloaded.DocCount(); count != 2 {
+		t.Fatalf("expected 2 docs on the snapshot, got %d", count)
+	}
+	hits, err := reloaded.SearchQuery(ctx, "tablet", MemorySearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 1 || hits[0].ID != "2" {
+		t.Fatalf("expected hit 2 after reload, got %+v", hits)
+	}
+}
@@ -0,0 +1,647 @@
+package index
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/isdzulqor/kraicklist/helper/errors"
+	"github.com/isdzulqor/kraicklist/helper/logging"
+
+	// cgo-free sqlite driver, it's compiled with FTS5
+	_ "modernc.org/sqlite"
+)
+
+const (
+	IndexSQLite  = "sqlite"
+	prefixSQLite = "external-sqlite:"
+
+	sqliteDefaultSize = 10
+	sqliteBusyTimeout = 5000 // milliseconds
+)
+
+type SQLiteDoc struct {
+	ID   string
+	Data interface{}
+}
+
+type SQLiteDocs []SQLiteDoc
+
+type SQLiteDocError struct {
+	DocID string
+	err   error
+}
+
+type SQLiteDocErrors []SQLiteDocError
+
+func (errorDocs SQLiteDocErrors) ToError() error {
+	return errors.ToDocErrors(errorDocs, func(docError SQLiteDocError) (string, error) {
+		return docError.DocID, docError.err
+	}).ErrorOrNil()
+}
+
+// SQLiteIndexConfig configures the fields of the docs, the field names are used as column names.
+// TextFields are searched with FTS5, TermFields are matched exactly and NumericFields are filtered by range and sortable
+type SQLiteIndexConfig struct {
+	TextFields    []string
+	TermFields    []string
+	NumericFields []string
+}
+
+// SQLiteIndex stores the docs on a SQLite database, the source is kept as json along with an FTS5 table of the text
+// fields, a table of the term fields and a column of each numeric field
+type SQLiteIndex struct {
+	db     *sql.DB
+	path   string
+	config SQLiteIndexConfig
+}
+
+// InitSQLiteIndex opens or creates the database on the path, the tables are dropped first when recreate is set
+func InitSQLiteIndex(ctx context.Context, path string, config SQLiteIndexConfig, recreate bool) (out *SQLiteIndex,
+	err error) {
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		err = fmt.Errorf("%s %v", prefixSQLite, err)
+		return
+	}
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(%d)&_pragma=journal_mode(WAL)", path, sqliteBusyTimeout)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		err = fmt.Errorf("%s failed to open %s, err: %v", prefixSQLite, path, err)
+		return
+	}
+
+	out = &SQLiteIndex{db: db, path: path, config: config}
+	if recreate {
+		if err = out.dropTables(ctx); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	if err = out.createTables(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	logging.InfoContext(ctx, "%s sqlite index %s is initialized", prefixSQLite, path)
+	return
+}
+
+// InitSQLiteIndexReadOnly opens an existing database without write access
+func InitSQLiteIndexReadOnly(ctx context.Context, path string, config SQLiteIndexConfig) (out *SQLiteIndex, err error) {
+	if _, err = os.Stat(path); err != nil {
+		err = fmt.Errorf("%s database %s doesn't exist, seed it first", prefixSQLite, path)
+		return
+	}
+	dsn := fmt.Sprintf("file:%s?mode=ro&_pragma=busy_timeout(%d)", path, sqliteBusyTimeout)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		err = fmt.Errorf("%s failed to open %s, err: %v", prefixSQLite, path, err)
+		return
+	}
+	if err = db.PingContext(ctx); err != nil {
+		db.Close()
+		err = fmt.Errorf("%s failed to open %s, err: %v", prefixSQLite, path, err)
+		return
+	}
+	out = &SQLiteIndex{db: db, path: path, config: config}
+	return
+}
+
+func (index *SQLiteIndex) createTables(ctx context.Context) error {
+	docColumns := []string{"rowid INTEGER PRIMARY KEY", "id TEXT NOT NULL UNIQUE", "source TEXT NOT NULL"}
+	for _, field := range index.config.NumericFields {
+		docColumns = append(docColumns, quoteIdent(field)+" REAL")
+	}
+	statements := []string{
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS docs (%s)", strings.Join(docColumns, ", ")),
+		fmt.Sprintf("CREATE VIRTUAL TABLE IF NOT EXISTS docs_fts USING fts5(%s, tokenize='unicode61')",
+			strings.Join(quoteIdents(index.config.TextFields), ", ")),
+		"CREATE VIRTUAL TABLE IF NOT EXISTS docs_fts_vocab USING fts5vocab(docs_fts, 'col')",
+		"CREATE TABLE IF NOT EXISTS doc_terms (doc_rowid INTEGER NOT NULL, field TEXT NOT NULL, term TEXT NOT NULL)",
+		"CREATE INDEX IF NOT EXISTS doc_terms_field_term ON doc_terms (field, term)",
+		"CREATE INDEX IF NOT EXISTS doc_terms_doc_rowid ON doc_terms (doc_rowid)",
+	}
+	for _, field := range index.config.NumericFields {
+		statements = append(statements, fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON docs (%s)",
+			quoteIdent("docs_"+field), quoteIdent(field)))
+	}
+	for _, statement := range statements {
+		if _, err := index.db.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("%s failed to create tables, err: %v", prefixSQLite, err)
+		}
+	}
+	return nil
+}
+
+func (index *SQLiteIndex) dropTables(ctx context.Context) error {
+	for _, table := range []string{"docs_fts_vocab", "docs_fts", "doc_terms", "docs"} {
+		if _, err := index.db.ExecContext(ctx, "DROP TABLE IF EXISTS "+table); err != nil {
+			return fmt.Errorf("%s failed to drop table %s, err: %v", prefixSQLite, table, err)
+		}
+	}
+	return nil
+}
+
+// BulkIndex creates or replaces the docs in a single transaction, all docs are failed when it can't be committed
+func (index *SQLiteIndex) BulkIndex(ctx context.Context, docs SQLiteDocs) (docErrors *SQLiteDocErrors) {
+	var errorDocs SQLiteDocErrors
+	failAll := func(err error) *SQLiteDocErrors {
+		errorDocs = errorDocs[:0]
+		for _, doc := range docs {
+			errorDocs = append(errorDocs, SQLiteDocError{DocID: doc.ID, err: err})
+		}
+		return &errorDocs
+	}
+
+	tx, err := index.db.BeginTx(ctx, nil)
+	if err != nil {
+		return failAll(fmt.Errorf("%s %v", prefixSQLite, err))
+	}
+	defer tx.Rollback()
+
+	for _, doc := range docs {
+		// a failed doc is rolled back to its savepoint so the others are still committed
+		if _, err = tx.ExecContext(ctx, "SAVEPOINT doc"); err != nil {
+			return failAll(fmt.Errorf("%s %v", prefixSQLite, err))
+		}
+		if err = index.indexDoc(ctx, tx, doc); err != nil {
+			if ctx.Err() != nil {
+				return failAll(ctx.Err())
+			}
+			errorDocs = append(errorDocs, SQLiteDocError{DocID: doc.ID, err: err})
+			if _, err = tx.ExecContext(ctx, "ROLLBACK TO doc"); err != nil {
+				return failAll(fmt.Errorf("%s %v", prefixSQLite, err))
+			}
+		}
+		if _, err = tx.ExecContext(ctx, "RELEASE doc"); err != nil {
+			return failAll(fmt.Errorf("%s %v", prefixSQLite, err))
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return failAll(fmt.Errorf("%s %v", prefixSQLite, err))
+	}
+	if len(errorDocs) > 0 {
+		docErrors = &errorDocs
+	}
+	return
+}
+
+func (index *SQLiteIndex) indexDoc(ctx context.Context, tx *sql.Tx, doc SQLiteDoc) error {
+	source, err := json.Marshal(doc.Data)
+	if err != nil {
+		return err
+	}
+	fields := map[string]interface{}{}
+	if err = json.Unmarshal(source, &fields); err != nil {
+		return err
+	}
+
+	columns, values := []string{"id", "source"}, []interface{}{doc.ID, string(source)}
+	updates := []string{"source = excluded.source"}
+	for _, field := range index.config.NumericFields {
+		var value interface{}
+		if number, ok := fields[field].(float64); ok {
+			value = number
+		}
+		columns, values = append(columns, quoteIdent(field)), append(values, value)
+		updates = append(updates, fmt.Sprintf("%s = excluded.%s", quoteIdent(field), quoteIdent(field)))
+	}
+	// the rowid is kept on update, the text and terms rows refer to it
+	_, err = tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO docs (%s) VALUES (%s) ON CONFLICT (id) DO UPDATE SET %s",
+		strings.Join(columns, ", "), placeholders(len(columns)), strings.Join(updates, ", ")), values...)
+	if err != nil {
+		return err
+	}
+	var rowID int64
+	if err = tx.QueryRowContext(ctx, "SELECT rowid FROM docs WHERE id = ?", doc.ID).Scan(&rowID); err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM docs_fts WHERE rowid = ?", rowID); err != nil {
+		return err
+	}
+	textValues := []interface{}{rowID}
+	for _, field := range index.config.TextFields {
+		textValues = append(textValues, fieldText(fields[field]))
+	}
+	_, err = tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO docs_fts (rowid, %s) VALUES (%s)",
+		strings.Join(quoteIdents(index.config.TextFields), ", "), placeholders(len(textValues))), textValues...)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM doc_terms WHERE doc_rowid = ?", rowID); err != nil {
+		return err
+	}
+	for _, field := range index.config.TermFields {
+		for _, term := range fieldTerms(fields[field]) {
+			_, err = tx.ExecContext(ctx, "INSERT INTO doc_terms (doc_rowid, field, term) VALUES (?, ?, ?)",
+				rowID, field, term)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fieldTerms lists a string or a list of strings
+func fieldTerms(value interface{}) (out []string) {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+	}
+	return
+}
+
+// BulkDelete deletes the docs by their IDs in a single transaction, missing docs are not failures
+func (index *SQLiteIndex) BulkDelete(ctx context.Context, ids []string) (docErrors *SQLiteDocErrors) {
+	failAll := func(err error) *SQLiteDocErrors {
+		errorDocs := make(SQLiteDocErrors, 0, len(ids))
+		for _, id := range ids {
+			errorDocs = append(errorDocs, SQLiteDocError{DocID: id, err: err})
+		}
+		return &errorDocs
+	}
+
+	tx, err := index.db.BeginTx(ctx, nil)
+	if err != nil {
+		return failAll(fmt.Errorf("%s %v", prefixSQLite, err))
+	}
+	defer tx.Rollback()
+
+	for _, id := range ids {
+		var rowID int64
+		err = tx.QueryRowContext(ctx, "SELECT rowid FROM docs WHERE id = ?", id).Scan(&rowID)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return failAll(fmt.Errorf("%s %v", prefixSQLite, err))
+		}
+		for _, statement := range []string{
+			"DELETE FROM docs_fts WHERE rowid = ?",
+			"DELETE FROM doc_terms WHERE doc_rowid = ?",
+			"DELETE FROM docs WHERE rowid = ?",
+		} {
+			if _, err = tx.ExecContext(ctx, statement, rowID); err != nil {
+				return failAll(fmt.Errorf("%s %v", prefixSQLite, err))
+			}
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return failAll(fmt.Errorf("%s %v", prefixSQLite, err))
+	}
+	return
+}
+
+// SQLiteQuery filters the docs by a SQL predicate on the docs table
+type SQLiteQuery interface {
+	Predicate() (string, []interface{})
+}
+
+type sqliteMatchAny struct {
+	field  string
+	values []string
+}
+
+// SQLiteMatchAny matches docs whose term field equals any of the values
+func SQLiteMatchAny(field string, values []string) SQLiteQuery {
+	return sqliteMatchAny{field: field, values: values}
+}
+
+func (q sqliteMatchAny) Predicate() (string, []interface{}) {
+	args := []interface{}{q.field}
+	for _, value := range q.values {
+		args = append(args, value)
+	}
+	return fmt.Sprintf("docs.rowid IN (SELECT doc_rowid FROM doc_terms WHERE field = ? AND term IN (%s))",
+		placeholders(len(q.values))), args
+}
+
+type sqliteNumericRange struct {
+	field    string
+	min, max *float64
+}
+
+// SQLiteNumericRange matches docs whose numeric field is within the inclusive range, nil is unbounded
+func SQLiteNumericRange(field string, min, max *float64) SQLiteQuery {
+	return sqliteNumericRange{field: field, min: min, max: max}
+}
+
+func (q sqliteNumericRange) Predicate() (string, []interface{}) {
+	var (
+		predicates []string
+		args       []interface{}
+	)
+	if q.min != nil {
+		predicates, args = append(predicates, fmt.Sprintf("docs.%s >= ?", quoteIdent(q.field))), append(args, *q.min)
+	}
+	if q.max != nil {
+		predicates, args = append(predicates, fmt.Sprintf("docs.%s <= ?", quoteIdent(q.field))), append(args, *q.max)
+	}
+	if len(predicates) == 0 {
+		return "1", nil
+	}
+	return strings.Join(predicates, " AND "), args
+}
+
+type sqliteConjunction []SQLiteQuery
+
+// SQLiteConjunction matches docs passing all of the queries
+func SQLiteConjunction(queries ...SQLiteQuery) SQLiteQuery {
+	return sqliteConjunction(queries)
+}
+
+func (q sqliteConjunction) Predicate() (string, []interface{}) {
+	var (
+		predicates []string
+		args       []interface{}
+	)
+	for _, query := range q {
+		predicate, queryArgs := query.Predicate()
+		predicates, args = append(predicates, "("+predicate+")"), append(args, queryArgs...)
+	}
+	if len(predicates) == 0 {
+		return "1", nil
+	}
+	return strings.Join(predicates, " AND "), args
+}
+
+// SQLiteSearchOptions narrows the search down by Filter, sorts by SortBy fields and limits it by Size.
+// SortBy accepts numeric fields and _score, descending with - prefix, the default is -_score
+type SQLiteSearchOptions struct {
+	Filter SQLiteQuery
+	SortBy []string
+	Size   int
+}
+
+// SQLiteHit is an indexed doc with its raw source
+type SQLiteHit struct {
+	ID     string
+	Score  float64
+	Source json.RawMessage
+}
+
+// SearchQuery finds docs having any term of the keyword on the text fields, they are scored by FTS5 BM25
+func (index *SQLiteIndex) SearchQuery(ctx context.Context, keyword string, opts SQLiteSearchOptions) (out []SQLiteHit,
+	err error) {
+	if keyword == "" {
+		err = fmt.Errorf("keyword can't be empty")
+		return
+	}
+	match := sqliteMatchExpression(keyword)
+	if match == "" {
+		return
+	}
+
+	// bm25 is lower for better matches, it's negated to be a score
+	statement := "SELECT docs.id, docs.source, -bm25(docs_fts) AS score FROM docs_fts " +
+		"JOIN docs ON docs.rowid = docs_fts.rowid WHERE docs_fts MATCH ?"
+	args := []interface{}{match}
+	if opts.Filter != nil {
+		predicate, filterArgs := opts.Filter.Predicate()
+		statement, args = statement+" AND "+predicate, append(args, filterArgs...)
+	}
+
+	sortBy := opts.SortBy
+	if len(sortBy) == 0 {
+		sortBy = []string{"-_score"}
+	}
+	var orders []string
+	for _, sortField := range sortBy {
+		field, direction := strings.TrimPrefix(sortField, "-"), "ASC"
+		if strings.HasPrefix(sortField, "-") {
+			direction = "DESC"
+		}
+		column := "score"
+		if field != "_score" {
+			column = "docs." + quoteIdent(field)
+		}
+		orders = append(orders, column+" "+direction)
+	}
+	size := opts.Size
+	if size <= 0 {
+		size = sqliteDefaultSize
+	}
+	statement += fmt.Sprintf(" ORDER BY %s, docs.id LIMIT ?", strings.Join(orders, ", "))
+	args = append(args, size)
+
+	rows, err := index.db.QueryContext(ctx, statement, args...)
+	if err != nil {
+		err = fmt.Errorf("%s %v", prefixSQLite, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			hit    SQLiteHit
+			source string
+		)
+		if err = rows.Scan(&hit.ID, &source, &hit.Score); err != nil {
+			err = fmt.Errorf("%s %v", prefixSQLite, err)
+			return
+		}
+		hit.Source = json.RawMessage(source)
+		out = append(out, hit)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("%s %v", prefixSQLite, err)
+	}
+	return
+}
+
+// sqliteMatchExpression ORs the quoted terms of the keyword, FTS5 operators on the keyword are not interpreted
+func sqliteMatchExpression(keyword string) string {
+	var terms []string
+	for _, term := range strings.FieldsFunc(keyword, func(r rune) bool {
+		return r == '"' || r == ' ' || r == '\t' || r == '\n'
+	}) {
+		terms = append(terms, `"`+term+`"`)
+	}
+	return strings.Join(terms, " OR ")
+}
+
+// GetDocs returns the docs those exist on the index by their IDs
+func (index *SQLiteIndex) GetDocs(ctx context.Context, ids []string) (out []SQLiteHit, err error) {
+	if len(ids) == 0 {
+		return
+	}
+	args := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	return index.queryHits(ctx, fmt.Sprintf("SELECT id, source FROM docs WHERE id IN (%s) ORDER BY id",
+		placeholders(len(ids))), args...)
+}
+
+// ScanDocs pages through the docs passing the query ordered by their IDs, q is optional
+func (index *SQLiteIndex) ScanDocs(ctx context.Context, q SQLiteQuery, pageSize int,
+	fn func(hits []SQLiteHit) error) error {
+	if pageSize <= 0 {
+		return fmt.Errorf("%s page size must be greater than 0", prefixSQLite)
+	}
+	predicate, filterArgs := "1", []interface{}(nil)
+	if q != nil {
+		predicate, filterArgs = q.Predicate()
+	}
+
+	lastID := ""
+	for {
+		args := append([]interface{}{lastID}, filterArgs...)
+		args = append(args, pageSize)
+		hits, err := index.queryHits(ctx, fmt.Sprintf(
+			"SELECT id, source FROM docs WHERE id > ? AND (%s) ORDER BY id LIMIT ?", predicate), args...)
+		if err != nil {
+			return err
+		}
+		if len(hits) == 0 {
+			return nil
+		}
+		if err = fn(hits); err != nil {
+			return err
+		}
+		if len(hits) < pageSize {
+			return nil
+		}
+		lastID = hits[len(hits)-1].ID
+	}
+}
+
+func (index *SQLiteIndex) queryHits(ctx context.Context, statement string, args ...interface{}) (out []SQLiteHit,
+	err error) {
+	rows, err := index.db.QueryContext(ctx, statement, args...)
+	if err != nil {
+		err = fmt.Errorf("%s %v", prefixSQLite, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, source string
+		if err = rows.Scan(&id, &source); err != nil {
+			err = fmt.Errorf("%s %v", prefixSQLite, err)
+			return
+		}
+		out = append(out, SQLiteHit{ID: id, Source: json.RawMessage(source)})
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("%s %v", prefixSQLite, err)
+	}
+	return
+}
+
+// DocCount returns the number of docs on the index
+func (index *SQLiteIndex) DocCount(ctx context.Context) (count int64, err error) {
+	if err = index.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM docs").Scan(&count); err != nil {
+		err = fmt.Errorf("%s %v", prefixSQLite, err)
+	}
+	return
+}
+
+// SQLiteStats is the statistics of the index, the top terms are of a text field
+// and the top values are of a term field
+type SQLiteStats struct {
+	DocCount         int64
+	DiskSizeBytes    int64
+	TopTerms         []TermCount
+	TopValues        []TermCount
+	ValueCardinality int64
+	Min, Max         *float64
+}
+
+// Stats gathers the statistics, min and max are of the numeric field
+func (index *SQLiteIndex) Stats(ctx context.Context, textField, termField, numericField string,
+	topN int) (out SQLiteStats, err error) {
+	if out.DocCount, err = index.DocCount(ctx); err != nil {
+		return
+	}
+	// the write-ahead log is a part of the database until it's checkpointed
+	for _, path := range []string{index.path, index.path + "-wal"} {
+		if info, statErr := os.Stat(path); statErr == nil {
+			out.DiskSizeBytes += info.Size()
+		}
+	}
+
+	if out.TopTerms, err = index.termCounts(ctx,
+		"SELECT term, doc FROM docs_fts_vocab WHERE col = ? ORDER BY doc DESC, term LIMIT ?", textField, topN); err != nil {
+		return
+	}
+	if out.TopValues, err = index.termCounts(ctx, "SELECT term, COUNT(*) AS docs FROM doc_terms WHERE field = ? "+
+		"GROUP BY term ORDER BY docs DESC, term LIMIT ?", termField, topN); err != nil {
+		return
+	}
+	err = index.db.QueryRowContext(ctx, "SELECT COUNT(DISTINCT term) FROM doc_terms WHERE field = ?", termField).
+		Scan(&out.ValueCardinality)
+	if err != nil {
+		err = fmt.Errorf("%s %v", prefixSQLite, err)
+		return
+	}
+	column := quoteIdent(numericField)
+	err = index.db.QueryRowContext(ctx, fmt.Sprintf("SELECT MIN(%s), MAX(%s) FROM docs", column, column)).
+		Scan(&out.Min, &out.Max)
+	if err != nil {
+		err = fmt.Errorf("%s %v", prefixSQLite, err)
+	}
+	return
+}
+
+func (index *SQLiteIndex) termCounts(ctx context.Context, statement string, args ...interface{}) (out []TermCount,
+	err error) {
+	rows, err := index.db.QueryContext(ctx, statement, args...)
+	if err != nil {
+		err = fmt.Errorf("%s %v", prefixSQLite, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var term TermCount
+		if err = rows.Scan(&term.Term, &term.Count); err != nil {
+			err = fmt.Errorf("%s %v", prefixSQLite, err)
+			return
+		}
+		out = append(out, term)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("%s %v", prefixSQLite, err)
+	}
+	return
+}
+
+func (index *SQLiteIndex) Ping(ctx context.Context) error {
+	if err := index.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("%s %v", prefixSQLite, err)
+	}
+	return nil
+}
+
+func (index *SQLiteIndex) Close() error {
+	if err := index.db.Close(); err != nil {
+		return fmt.Errorf("%s %v", prefixSQLite, err)
+	}
+	return nil
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func quoteIdents(names []string) []string {
+	out := make([]string, 0, len(names))
+	for _, name := range names {
+		out = append(out, quoteIdent(name))
+	}
+	return out
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
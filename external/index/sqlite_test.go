@@ -0,0 +1,64 @@
+package index
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLiteIndexSearchQuery(t *testing.T) {
+	ctx := context.Background()
+	index, err := InitSQLiteIndex(ctx, filepath.Join(t.TempDir(), "ads.sqlite"), SQLiteIndexConfig{
+		TextFields:    []string{"title", "tags"},
+		TermFields:    []string{"tags"},
+		NumericFields: []string{"updated_at"},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+
+	docErrors := index.BulkIndex(ctx, SQLiteDocs{
+		{ID: "1", Data: map[string]interface{}{"title": "iphone 11 for sale", "tags": []string{"phone"}, "updated_at": 10}},
+		{ID: "2", Data: map[string]interface{}{"title": "iphone iphone case", "tags": []string{"accessory"}, "updated_at": 20}},
+		{ID: "3", Data: map[string]interface{}{"title": "android tablet", "tags": []string{"phone"}, "updated_at": 30}},
+	})
+	if docErrors != nil {
+		t.Fatal(docErrors.ToError())
+	}
+
+	since := float64(15)
+	hits, err := index.SearchQuery(ctx, "iphone android", SQLiteSearchOptions{
+		Filter: SQLiteConjunction(SQLiteMatchAny("tags", []string{"phone"}), SQLiteNumericRange("updated_at", &since, nil)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 1 || hits[0].ID != "3" {
+		t.Fatalf("expected only hit 3 passing the filter, got %+v", hits)
+	}
+
+	hits, err = index.SearchQuery(ctx, `iphone OR "android`, SQLiteSearchOptions{SortBy: []string{"updated_at"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 3 || hits[0].ID != "1" || hits[2].ID != "3" {
+		t.Fatalf("expected hits by updated_at with the operators taken as terms, got %+v", hits)
+	}
+
+	// the update replaces the terms
+	index.BulkIndex(ctx, SQLiteDocs{{ID: "2", Data: map[string]interface{}{"title": "leather case", "updated_at": 40}}})
+	if docErrors = index.BulkDelete(ctx, []string{"3", "404"}); docErrors != nil {
+		t.Fatal(docErrors.ToError())
+	}
+	hits, err = index.SearchQuery(ctx, "iphone android", SQLiteSearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 1 || hits[0].ID != "1" {
+		t.Fatalf("expected only hit 1 after the update and delete, got %+v", hits)
+	}
+	if count, _ := index.DocCount(ctx); count != 2 {
+		t.Fatalf("expected 2 docs, got %d", count)
+	}
+}
@@ -0,0 +1,400 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	ChangeUpsert = "upsert"
+	ChangeDelete = "delete"
+
+	prefixBolt = "store-bolt:"
+)
+
+var (
+	bucketDocs        = []byte("docs")
+	bucketChanges     = []byte("changes")
+	bucketCheckpoints = []byte("checkpoints")
+	// the docs rejected by the indices, a bucket per checkpoint name
+	bucketPending = []byte("pending")
+)
+
+// pruneBatchSize is the number of the changes deleted per transaction
+const pruneBatchSize = 10000
+
+// Doc is a stored document, Source is its json
+type Doc struct {
+	ID     int64
+	Source json.RawMessage
+}
+
+// Change is an entry of the change sequence, the doc itself is read from the store when it's applied
+type Change struct {
+	Seq uint64    `json:"seq"`
+	Op  string    `json:"op"`
+	ID  int64     `json:"id"`
+	At  time.Time `json:"at"`
+}
+
+// BoltStore is the system of record of the docs on a single bbolt file.
+// Every write appends a change to the sequence the indices are updated from
+type BoltStore struct {
+	db   *bolt.DB
+	path string
+
+	// changed is closed and replaced whenever changes are committed
+	mu      sync.Mutex
+	changed chan struct{}
+}
+
+// InitBoltStore opens or creates the store, it waits up to the timeout for the file lock held by other process
+func InitBoltStore(path string, timeout time.Duration) (*BoltStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("%s failed to create the store directory, err: %v", prefixBolt, err)
+	}
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: timeout})
+	if err != nil {
+		return nil, fmt.Errorf("%s failed to open %s, it may be used by other process, err: %v", prefixBolt, path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketDocs, bucketChanges, bucketCheckpoints, bucketPending} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s failed to create the buckets, err: %v", prefixBolt, err)
+	}
+	return &BoltStore{db: db, path: path, changed: make(chan struct{})}, nil
+}
+
+// Path is the file of the store
+func (s *BoltStore) Path() string {
+	return s.path
+}
+
+// Put creates or replaces the docs along with their changes in a single transaction,
+// docs with the same source as the stored ones are left untouched unless they're pending on an index
+func (s *BoltStore) Put(docs []Doc) error {
+	return s.update(func(tx *bolt.Tx) error {
+		docsBucket, changes := tx.Bucket(bucketDocs), tx.Bucket(bucketChanges)
+		for _, doc := range docs {
+			key := encodeID(doc.ID)
+			if bytes.Equal(docsBucket.Get(key), doc.Source) && !isPending(tx, key) {
+				continue
+			}
+			if err := docsBucket.Put(key, doc.Source); err != nil {
+				return fmt.Errorf("%s failed to put doc %d, err: %v", prefixBolt, doc.ID, err)
+			}
+			if err := appendChange(changes, ChangeUpsert, doc.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Delete deletes the docs along with their changes in a single transaction, missing docs are skipped
+func (s *BoltStore) Delete(ids []int64) error {
+	return s.update(func(tx *bolt.Tx) error {
+		docsBucket, changes := tx.Bucket(bucketDocs), tx.Bucket(bucketChanges)
+		for _, id := range ids {
+			key := encodeID(id)
+			if docsBucket.Get(key) == nil {
+				continue
+			}
+			if err := docsBucket.Delete(key); err != nil {
+				return fmt.Errorf("%s failed to delete doc %d, err: %v", prefixBolt, id, err)
+			}
+			if err := appendChange(changes, ChangeDelete, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// update runs the write transaction and notifies the watchers when it appended changes
+func (s *BoltStore) update(fn func(tx *bolt.Tx) error) error {
+	var before, after uint64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		before = tx.Bucket(bucketChanges).Sequence()
+		if err := fn(tx); err != nil {
+			return err
+		}
+		after = tx.Bucket(bucketChanges).Sequence()
+		return nil
+	})
+	if err == nil && after > before {
+		s.mu.Lock()
+		close(s.changed)
+		s.changed = make(chan struct{})
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// Watch returns a channel which is closed on the next committed changes of this process
+func (s *BoltStore) Watch() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.changed
+}
+
+func appendChange(changes *bolt.Bucket, op string, id int64) error {
+	seq, err := changes.NextSequence()
+	if err != nil {
+		return fmt.Errorf("%s failed to get the next sequence, err: %v", prefixBolt, err)
+	}
+	value, err := json.Marshal(Change{Seq: seq, Op: op, ID: id, At: time.Now()})
+	if err != nil {
+		return err
+	}
+	return changes.Put(encodeSeq(seq), value)
+}
+
+// Get returns the docs by their IDs, missing docs are not listed
+func (s *BoltStore) Get(ids []int64) (out []Doc, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		docsBucket := tx.Bucket(bucketDocs)
+		for _, id := range ids {
+			if source := docsBucket.Get(encodeID(id)); source != nil {
+				out = append(out, Doc{ID: id, Source: copyBytes(source)})
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// Scan pages through all docs ordered by their IDs, each page is read on its own transaction
+func (s *BoltStore) Scan(pageSize int, fn func(docs []Doc) error) error {
+	var after []byte
+	for {
+		var page []Doc
+		err := s.db.View(func(tx *bolt.Tx) error {
+			cursor := tx.Bucket(bucketDocs).Cursor()
+			key, value := cursor.First()
+			if after != nil {
+				if key, value = cursor.Seek(after); key != nil && bytes.Equal(key, after) {
+					key, value = cursor.Next()
+				}
+			}
+			for ; key != nil && len(page) < pageSize; key, value = cursor.Next() {
+				page = append(page, Doc{ID: decodeID(key), Source: copyBytes(value)})
+			}
+			return nil
+		})
+		if err != nil || len(page) == 0 {
+			return err
+		}
+		if err = fn(page); err != nil {
+			return err
+		}
+		after = encodeID(page[len(page)-1].ID)
+	}
+}
+
+// Count returns the number of the docs
+func (s *BoltStore) Count() (count int64, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		count = int64(tx.Bucket(bucketDocs).Stats().KeyN)
+		return nil
+	})
+	return
+}
+
+// Changes returns up to the limit of the changes after the seq
+func (s *BoltStore) Changes(since uint64, limit int) (out []Change, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(bucketChanges).Cursor()
+		for key, value := cursor.Seek(encodeSeq(since + 1)); key != nil && len(out) < limit; key, value = cursor.Next() {
+			var change Change
+			if err := json.Unmarshal(value, &change); err != nil {
+				return fmt.Errorf("%s failed to decode change %d, err: %v", prefixBolt, binary.BigEndian.Uint64(key), err)
+			}
+			out = append(out, change)
+		}
+		return nil
+	})
+	return
+}
+
+// LastSeq is the seq of the latest change, 0 when nothing is written yet
+func (s *BoltStore) LastSeq() (seq uint64, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		seq = tx.Bucket(bucketChanges).Sequence()
+		return nil
+	})
+	return
+}
+
+// FirstSeq is the seq of the oldest retained change, the next seq when all changes are pruned
+func (s *BoltStore) FirstSeq() (seq uint64, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		changes := tx.Bucket(bucketChanges)
+		if key, _ := changes.Cursor().First(); key != nil {
+			seq = binary.BigEndian.Uint64(key)
+			return nil
+		}
+		seq = changes.Sequence() + 1
+		return nil
+	})
+	return
+}
+
+// Prune deletes the changes committed before the time, up to the seq
+func (s *BoltStore) Prune(before time.Time, maxSeq uint64) (pruned int, err error) {
+	for {
+		var keys [][]byte
+		err = s.db.Update(func(tx *bolt.Tx) error {
+			changes := tx.Bucket(bucketChanges)
+			cursor := changes.Cursor()
+			for key, value := cursor.First(); key != nil && len(keys) < pruneBatchSize; key, value = cursor.Next() {
+				var change Change
+				if err := json.Unmarshal(value, &change); err != nil {
+					return fmt.Errorf("%s failed to decode change %d, err: %v", prefixBolt, binary.BigEndian.Uint64(key), err)
+				}
+				if change.Seq > maxSeq || !change.At.Before(before) {
+					break
+				}
+				keys = append(keys, copyBytes(key))
+			}
+			// the cursor isn't reliable while deleting
+			for _, key := range keys {
+				if err := changes.Delete(key); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		pruned += len(keys)
+		if err != nil || len(keys) < pruneBatchSize {
+			return
+		}
+	}
+}
+
+// Checkpoint is the seq of the latest change applied to the named index
+func (s *BoltStore) Checkpoint(name string) (seq uint64, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		if value := tx.Bucket(bucketCheckpoints).Get([]byte(name)); value != nil {
+			seq = binary.BigEndian.Uint64(value)
+		}
+		return nil
+	})
+	return
+}
+
+// SetCheckpoint saves the seq of the latest change applied to the named index
+func (s *BoltStore) SetCheckpoint(name string, seq uint64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketCheckpoints).Put([]byte(name), encodeSeq(seq))
+	})
+}
+
+// CommitCheckpoint saves the seq of the latest change applied to the named index along with the docs of
+// the applied changes the index rejected. The rejected docs are pending until their changes are applied,
+// so putting them again appends a change even when they're unchanged
+func (s *BoltStore) CommitCheckpoint(name string, seq uint64, applied, rejected []int64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(bucketCheckpoints).Put([]byte(name), encodeSeq(seq)); err != nil {
+			return err
+		}
+		pending, err := tx.Bucket(bucketPending).CreateBucketIfNotExists([]byte(name))
+		if err != nil {
+			return err
+		}
+		for _, id := range applied {
+			if err = pending.Delete(encodeID(id)); err != nil {
+				return err
+			}
+		}
+		for _, id := range rejected {
+			if err = pending.Put(encodeID(id), []byte{1}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Pending returns the docs rejected by the named index, ordered by their IDs
+func (s *BoltStore) Pending(name string) (out []int64, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		pending := tx.Bucket(bucketPending).Bucket([]byte(name))
+		if pending == nil {
+			return nil
+		}
+		return pending.ForEach(func(key, _ []byte) error {
+			out = append(out, decodeID(key))
+			return nil
+		})
+	})
+	return
+}
+
+// ClearPending forgets the docs rejected by the named index, i.e: the index is rebuilt
+func (s *BoltStore) ClearPending(name string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket(bucketPending).DeleteBucket([]byte(name))
+		if err == bolt.ErrBucketNotFound {
+			return nil
+		}
+		return err
+	})
+}
+
+// isPending reports whether the doc is rejected by any index
+func isPending(tx *bolt.Tx, key []byte) (found bool) {
+	tx.Bucket(bucketPending).ForEach(func(name, _ []byte) error {
+		if pending := tx.Bucket(bucketPending).Bucket(name); pending != nil && pending.Get(key) != nil {
+			found = true
+		}
+		return nil
+	})
+	return
+}
+
+// Ping checks the store is still open
+func (s *BoltStore) Ping() error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return nil
+	})
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// the keys are big endian, so the cursor order is the numeric order of the non-negative IDs and seqs
+func encodeID(id int64) []byte {
+	return encodeSeq(uint64(id))
+}
+
+func decodeID(key []byte) int64 {
+	return int64(binary.BigEndian.Uint64(key))
+}
+
+func encodeSeq(seq uint64) []byte {
+	out := make([]byte, 8)
+	binary.BigEndian.PutUint64(out, seq)
+	return out
+}
+
+// copyBytes copies the value which is only valid during the transaction
+func copyBytes(value []byte) []byte {
+	return append([]byte(nil), value...)
+}
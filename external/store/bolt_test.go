@@ -0,0 +1,105 @@
+package store
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBoltStoreChanges(t *testing.T) {
+	s, err := InitBoltStore(filepath.Join(t.TempDir(), "ads.db"), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	docs := []Doc{
+		{ID: 1, Source: json.RawMessage(`{"id":1}`)},
+		{ID: 2, Source: json.RawMessage(`{"id":2}`)},
+		{ID: 300, Source: json.RawMessage(`{"id":300}`)},
+	}
+	if err = s.Put(docs); err != nil {
+		t.Fatal(err)
+	}
+	// the unchanged doc has no change, the missing doc is not deleted
+	if err = s.Put([]Doc{docs[0], {ID: 2, Source: json.RawMessage(`{"id":2,"title":"new"}`)}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Delete([]int64{1, 404}); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := s.Changes(2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 3 || changes[0].Seq != 3 || changes[1].ID != 2 || changes[2].Op != ChangeDelete || changes[2].ID != 1 {
+		t.Fatalf("expected the changes after seq 2, got %+v", changes)
+	}
+	if seq, _ := s.LastSeq(); seq != 5 {
+		t.Fatalf("expected last seq 5, got %d", seq)
+	}
+
+	if err = s.SetCheckpoint("bleve:ads", 4); err != nil {
+		t.Fatal(err)
+	}
+	if seq, _ := s.Checkpoint("bleve:ads"); seq != 4 {
+		t.Fatalf("expected checkpoint 4, got %d", seq)
+	}
+
+	var scanned []int64
+	err = s.Scan(1, func(docs []Doc) error {
+		for _, doc := range docs {
+			scanned = append(scanned, doc.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scanned) != 2 || scanned[0] != 2 || scanned[1] != 300 {
+		t.Fatalf("expected the docs ordered by ID, got %v", scanned)
+	}
+	if count, _ := s.Count(); count != 2 {
+		t.Fatalf("expected 2 docs, got %d", count)
+	}
+}
+
+func TestBoltStorePrune(t *testing.T) {
+	s, err := InitBoltStore(filepath.Join(t.TempDir(), "ads.db"), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	changed := s.Watch()
+	for id := int64(1); id <= 3; id++ {
+		if err = s.Put([]Doc{{ID: id, Source: json.RawMessage(`{}`)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case <-changed:
+	default:
+		t.Fatal("expected the watch to be notified")
+	}
+
+	// the changes after the seq are kept even if they're old enough
+	pruned, err := s.Prune(time.Now().Add(time.Minute), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first, _ := s.FirstSeq(); pruned != 2 || first != 3 {
+		t.Fatalf("expected 2 pruned changes and the first seq 3, got %d and %d", pruned, first)
+	}
+	if pruned, _ = s.Prune(time.Now().Add(-time.Minute), 3); pruned != 0 {
+		t.Fatalf("expected the recent change to be kept, got %d pruned", pruned)
+	}
+	if _, err = s.Prune(time.Now().Add(time.Minute), 3); err != nil {
+		t.Fatal(err)
+	}
+	if first, _ := s.FirstSeq(); first != 4 {
+		t.Fatalf("expected the next seq 4 when all changes are pruned, got %d", first)
+	}
+}
@@ -5,28 +5,43 @@ import (
 )
 
 const (
-	ParamInvalidError = "ParamInvalidError"
-	ThirdPartyError   = "ThirdPartyError"
+	ParamInvalidError    = "ParamInvalidError"
+	ThirdPartyError      = "ThirdPartyError"
+	NotFoundError        = "NotFoundError"
+	GoneError            = "GoneError"
+	PayloadTooLargeError = "PayloadTooLargeError"
 
 	ServiceUnavailableError = "ServiceUnavailableError"
 	InternalServerError     = "InternalServerError"
 	UnauthorizedError       = "UnauthorizedError"
+	ForbiddenError          = "ForbiddenError"
+	TooManyRequestsError    = "TooManyRequestsError"
 )
 
 var (
-	ErrorParamInvalid = WithMessage(ParamInvalidError, "param is invalid")
-	ErrorThirdParty   = WithMessage(ThirdPartyError, "something's wrong with third party service")
+	ErrorParamInvalid    = WithMessage(ParamInvalidError, "param is invalid")
+	ErrorThirdParty      = WithMessage(ThirdPartyError, "something's wrong with third party service")
+	ErrorNotFound        = WithMessage(NotFoundError, "resource is not found")
+	ErrorGone            = WithMessage(GoneError, "resource is no longer available")
+	ErrorPayloadTooLarge = WithMessage(PayloadTooLargeError, "request body is too large")
 
 	ErrorInternalServer     = WithMessage(InternalServerError, "internal server error")
 	ErrorUnauthorized       = WithMessage(UnauthorizedError, "unauthorized")
+	ErrorForbidden          = WithMessage(ForbiddenError, "forbidden")
+	ErrorTooManyRequests    = WithMessage(TooManyRequestsError, "too many requests")
 	ErrorServiceUnavailable = WithMessage(ServiceUnavailableError, "service is unavailable")
 )
 
 var ErrorMappings = map[string]int{
-	ParamInvalidError: http.StatusBadRequest,
-	ThirdPartyError:   http.StatusBadGateway,
+	ParamInvalidError:    http.StatusBadRequest,
+	ThirdPartyError:      http.StatusBadGateway,
+	NotFoundError:        http.StatusNotFound,
+	GoneError:            http.StatusGone,
+	PayloadTooLargeError: http.StatusRequestEntityTooLarge,
 
 	UnauthorizedError:       http.StatusUnauthorized,
+	ForbiddenError:          http.StatusForbidden,
+	TooManyRequestsError:    http.StatusTooManyRequests,
 	InternalServerError:     http.StatusInternalServerError,
 	ServiceUnavailableError: http.StatusServiceUnavailable,
 }
@@ -0,0 +1,139 @@
+package errors
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DocError represents a failure of a single document on a bulk operation
+type DocError struct {
+	DocID string `json:"doc_id"`
+	Err   error  `json:"-"`
+}
+
+func NewDocError(docID string, err error) DocError {
+	return DocError{
+		DocID: docID,
+		Err:   err,
+	}
+}
+
+func (e DocError) Error() string {
+	return fmt.Sprintf("doc %s: %s", e.DocID, e.Reason())
+}
+
+// Reason returns the cause message of the failure
+func (e DocError) Reason() string {
+	if e.Err == nil {
+		return "unknown error"
+	}
+	return e.Err.Error()
+}
+
+// DocErrors is a multi error which carries failed doc IDs along with their causes
+type DocErrors []DocError
+
+func (e DocErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, docErr := range e {
+		messages = append(messages, docErr.Error())
+	}
+	return fmt.Sprintf("%d docs failed: %s", len(e), strings.Join(messages, "; "))
+}
+
+// ToDocErrors converts the failed docs of a backend to DocErrors, docError returns the ID and the cause of each
+func ToDocErrors[T any](in []T, docError func(T) (docID string, err error)) DocErrors {
+	out := make(DocErrors, 0, len(in))
+	for _, doc := range in {
+		out = append(out, NewDocError(docError(doc)))
+	}
+	return out
+}
+
+// ErrorOrNil returns nil when there is no failed doc,
+// prevents returning a non-nil error interface holding an empty slice
+func (e DocErrors) ErrorOrNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Reasons maps each failed doc ID to its failure reason
+func (e DocErrors) Reasons() map[string]string {
+	out := make(map[string]string, len(e))
+	for _, docErr := range e {
+		out[docErr.DocID] = docErr.Reason()
+	}
+	return out
+}
+
+// GroupByReason groups failed doc IDs by their failure reason
+func (e DocErrors) GroupByReason() map[string][]string {
+	out := map[string][]string{}
+	for _, docErr := range e {
+		out[docErr.Reason()] = append(out[docErr.Reason()], docErr.DocID)
+	}
+	return out
+}
+
+// AsDocErrors extracts DocErrors from err if it is
+func AsDocErrors(err error) (DocErrors, bool) {
+	docErrs, ok := err.(DocErrors)
+	return docErrs, ok
+}
+
+// ExtractDocErrors extracts DocErrors from err or from the data of Error,
+// i.e: a bulk failed as a whole still carries the failures of its docs
+func ExtractDocErrors(err error) (DocErrors, bool) {
+	if docErrs, ok := AsDocErrors(err); ok {
+		return docErrs, true
+	}
+	if e, ok := err.(Error); ok {
+		docErrs, ok := e.Data.(DocErrors)
+		return docErrs, ok && len(docErrs) > 0
+	}
+	return nil, false
+}
+
+// MaxFailures caps the doc IDs kept on Failures, so the reports and checkpoints of large runs stay small
+const MaxFailures = 1000
+
+// Failures is failed doc IDs grouped by the failure reason, up to MaxFailures doc IDs
+type Failures map[string][]string
+
+// Add records the failed docs of a bulk operation on n docs under the reason with the prefix, it returns how
+// many of them failed including the ones over MaxFailures. When err isn't DocErrors every doc fails with it,
+// named by docID
+func (f *Failures) Add(prefix string, n int, docID func(i int) string, err error) (failed int) {
+	docErrors, ok := AsDocErrors(err)
+	if !ok && err != nil {
+		docErrors = make(DocErrors, 0, n)
+		for i := 0; i < n; i++ {
+			docErrors = append(docErrors, NewDocError(docID(i), err))
+		}
+	}
+	listed := f.Len()
+	if len(docErrors) > 0 && listed < MaxFailures && *f == nil {
+		*f = Failures{}
+	}
+	for reason, docIDs := range docErrors.GroupByReason() {
+		if listed >= MaxFailures {
+			break
+		}
+		if len(docIDs) > MaxFailures-listed {
+			docIDs = docIDs[:MaxFailures-listed]
+		}
+		listed += len(docIDs)
+		(*f)[prefix+reason] = append((*f)[prefix+reason], docIDs...)
+	}
+	return len(docErrors)
+}
+
+// Len is the number of the listed doc IDs
+func (f Failures) Len() (out int) {
+	for _, docIDs := range f {
+		out += len(docIDs)
+	}
+	return
+}
@@ -0,0 +1,122 @@
+package errors
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestFailuresAdd(t *testing.T) {
+	ids := []string{"1", "2", "3"}
+	docID := func(i int) string { return ids[i] }
+	down := fmt.Errorf("index is down")
+
+	tests := []struct {
+		name       string
+		prefix     string
+		err        error
+		wantFailed int
+		want       Failures
+	}{
+		{name: "no error", wantFailed: 0, want: nil},
+		{
+			name:       "doc errors",
+			err:        DocErrors{NewDocError("2", fmt.Errorf("bad title"))},
+			wantFailed: 1,
+			want:       Failures{"bad title": {"2"}},
+		},
+		{
+			name:       "whole operation fails",
+			prefix:     "delete: ",
+			err:        down,
+			wantFailed: 3,
+			want:       Failures{"delete: index is down": {"1", "2", "3"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var failures Failures
+			if failed := failures.Add(tt.prefix, len(ids), docID, tt.err); failed != tt.wantFailed {
+				t.Errorf("failed = %d, want %d", failed, tt.wantFailed)
+			}
+			if !reflect.DeepEqual(failures, tt.want) {
+				t.Errorf("failures = %v, want %v", failures, tt.want)
+			}
+		})
+	}
+
+	t.Run("appends to the same reason", func(t *testing.T) {
+		var failures Failures
+		failures.Add("", 1, docID, down)
+		failures.Add("", 1, func(int) string { return "4" }, down)
+		if want := (Failures{"index is down": {"1", "4"}}); !reflect.DeepEqual(failures, want) {
+			t.Errorf("failures = %v, want %v", failures, want)
+		}
+	})
+
+	t.Run("caps the listed doc IDs", func(t *testing.T) {
+		var failures Failures
+		docID := func(i int) string { return fmt.Sprint(i) }
+		if failed := failures.Add("", MaxFailures-1, docID, down); failed != MaxFailures-1 {
+			t.Errorf("failed = %d, want %d", failed, MaxFailures-1)
+		}
+		if failed := failures.Add("delete: ", 3, docID, down); failed != 3 {
+			t.Errorf("failed = %d, want 3", failed)
+		}
+		if failed := failures.Add("", 1, docID, down); failed != 1 {
+			t.Errorf("failed = %d, want 1", failed)
+		}
+		if failures.Len() != MaxFailures || len(failures["delete: index is down"]) != 1 {
+			t.Errorf("listed %d doc IDs and %d deletes, want %d and 1",
+				failures.Len(), len(failures["delete: index is down"]), MaxFailures)
+		}
+	})
+}
+
+func TestExtractDocErrors(t *testing.T) {
+	docErrors := DocErrors{NewDocError("1", fmt.Errorf("bad title"))}
+	tests := []struct {
+		name string
+		err  error
+		want DocErrors
+	}{
+		{name: "doc errors", err: docErrors, want: docErrors},
+		{name: "doc errors on the error data", err: ErrorThirdParty.SetData(docErrors), want: docErrors},
+		{name: "error without doc errors", err: ErrorThirdParty},
+		{name: "other error", err: fmt.Errorf("index is down")},
+		{name: "no error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ExtractDocErrors(tt.err)
+			if ok != (tt.want != nil) || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("doc errors = %v, %v, want %v", got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestToDocErrors(t *testing.T) {
+	type backendDocError struct {
+		id  string
+		err error
+	}
+	down := fmt.Errorf("index is down")
+	docError := func(e backendDocError) (string, error) { return e.id, e.err }
+
+	tests := []struct {
+		name string
+		in   []backendDocError
+		want DocErrors
+	}{
+		{name: "no failed doc", want: DocErrors{}},
+		{name: "failed docs", in: []backendDocError{{"1", down}, {"2", nil}}, want: DocErrors{{"1", down}, {"2", nil}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ToDocErrors(tt.in, docError); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("doc errors = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
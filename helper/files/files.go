@@ -0,0 +1,15 @@
+package files
+
+import (
+	"io/ioutil"
+	"os"
+)
+
+// WriteAtomic writes to a temporary file then renames it,
+// so the file won't be corrupted when the process is killed while writing
+func WriteAtomic(path string, content []byte, perm os.FileMode) error {
+	if err := ioutil.WriteFile(path+".tmp", content, perm); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
@@ -0,0 +1,38 @@
+package files
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteAtomic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	for _, content := range []string{`{"v":1}`, `{"v":2}`} {
+		if err := WriteAtomic(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != content {
+			t.Errorf("content = %s, want %s", got, content)
+		}
+		if _, err = os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+			t.Errorf("expected no temporary file, stat err: %v", err)
+		}
+	}
+
+	// the file is kept when the write is failed
+	if err := os.Mkdir(path+".tmp", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteAtomic(path, []byte(`{"v":3}`), 0644); err == nil {
+		t.Fatal("expected the write to be failed")
+	}
+	if got, _ := ioutil.ReadFile(path); string(got) != `{"v":2}` {
+		t.Errorf("content = %s, want the last written", got)
+	}
+}
@@ -41,6 +41,7 @@ type healthResponse struct {
 type HealthHandler struct {
 	persistences          *Persistences
 	shutdownDelayDuration time.Duration
+	shutdownHooks         []func()
 
 	token *string
 }
@@ -71,18 +72,27 @@ func (h HealthHandler) IsShuttingDown() (bool, *healthResponse) {
 	return false, nil
 }
 
-func (h HealthHandler) gracefulShutdown() {
-	stopChan := make(chan os.Signal)
+// OnShutdown registers fn to be called after the shutdown delay right before the service exits,
+// i.e: to close the persistences
+func (h *HealthHandler) OnShutdown(fn func()) {
+	h.shutdownHooks = append(h.shutdownHooks, fn)
+}
+
+func (h *HealthHandler) gracefulShutdown() {
+	stopChan := make(chan os.Signal, 1)
 	signal.Notify(stopChan, os.Interrupt, syscall.SIGTERM)
 	go h.listenToSigTerm(stopChan)
 }
 
-func (h HealthHandler) listenToSigTerm(stopChan chan os.Signal) {
+func (h *HealthHandler) listenToSigTerm(stopChan chan os.Signal) {
 	<-stopChan
 	ctx := context.Background()
 	logging.InfoContext(ctx, "Shutting down service... Will be killed on %s", h.shutdownDelayDuration)
 	isShuttingDown = true
 	time.Sleep(h.shutdownDelayDuration)
+	for _, fn := range h.shutdownHooks {
+		fn()
+	}
 	logging.InfoContext(ctx, "Bye...")
 	os.Exit(0)
 }
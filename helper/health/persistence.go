@@ -8,6 +8,8 @@ type Persistence struct {
 	Type      string  `json:"type"`
 	Status    string  `json:"status"`
 	PingError *string `json:"ping_error,omitempty"`
+	// Details is reported by the DetailedPersistence
+	Details interface{} `json:"details,omitempty"`
 
 	HealthPersistence HealthPersistence `json:"-"`
 }
@@ -40,6 +42,13 @@ func (p *Persistences) Ping() (ok bool) {
 			persistance.Status = "OK"
 			persistance.PingError = nil
 		}
+		if dp, isDetailed := persistance.HealthPersistence.(DetailedPersistence); isDetailed {
+			var degraded bool
+			degraded, persistance.Details = dp.Details()
+			if degraded && persistance.PingError == nil {
+				persistance.Status = "DEGRADED"
+			}
+		}
 		(*p)[i] = persistance
 	}
 	return
@@ -49,3 +58,9 @@ func (p *Persistences) Ping() (ok bool) {
 type HealthPersistence interface {
 	Ping() error
 }
+
+// DetailedPersistence is implemented by the persistence which reports more than the ping result,
+// a degraded persistence is still healthy but its status is DEGRADED
+type DetailedPersistence interface {
+	Details() (degraded bool, details interface{})
+}
@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const (
+	KindCounter = "counter"
+	KindGauge   = "gauge"
+)
+
+type metric struct {
+	kind  string
+	help  string
+	value func() float64
+}
+
+var (
+	mu      sync.RWMutex
+	metrics = map[string]metric{}
+)
+
+// Register exposes the metric, the value is read on each scrape.
+// It panics when the name is registered twice
+func Register(name, kind, help string, value func() float64) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if value == nil {
+		panic("metrics: Register value is nil for " + name)
+	}
+	if _, ok := metrics[name]; ok {
+		panic("metrics: Register called twice for " + name)
+	}
+	metrics[name] = metric{kind: kind, help: help, value: value}
+}
+
+// Bool turns a state into a gauge value
+func Bool(ok bool) float64 {
+	if ok {
+		return 1
+	}
+	return 0
+}
+
+// Handler writes the registered metrics on the prometheus text format
+func Handler(w http.ResponseWriter, r *http.Request) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, name := range names {
+		m := metrics[name]
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", name, m.help, name, m.kind, name,
+			strconv.FormatFloat(m.value(), 'g', -1, 64))
+	}
+}
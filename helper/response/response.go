@@ -11,10 +11,19 @@ import (
 
 // Success will write a default template response when returning a success response
 func Success(ctx context.Context, w http.ResponseWriter, status int, data interface{}) {
+	SuccessWithMeta(ctx, w, status, data, nil)
+}
+
+// SuccessWithMeta writes the success response with the meta keys next to data and error, i.e: degraded
+func SuccessWithMeta(ctx context.Context, w http.ResponseWriter, status int, data interface{},
+	meta map[string]interface{}) {
 	resp := map[string]interface{}{
 		"data":  data,
 		"error": nil,
 	}
+	for key, value := range meta {
+		resp[key] = value
+	}
 	js, err := json.Marshal(resp)
 	if err != nil {
 		resp := map[string]interface{}{
@@ -2,16 +2,20 @@ package api
 
 import (
 	"context"
-	"net/http"
+	"fmt"
+	"os"
 	"strings"
 
 	"github.com/isdzulqor/kraicklist/config"
 	"github.com/isdzulqor/kraicklist/domain/handler"
 	"github.com/isdzulqor/kraicklist/domain/repository"
 	"github.com/isdzulqor/kraicklist/domain/service"
-	"github.com/isdzulqor/kraicklist/external/index"
+	"github.com/isdzulqor/kraicklist/external/engine"
+	"github.com/isdzulqor/kraicklist/external/store"
 	"github.com/isdzulqor/kraicklist/helper/health"
 	"github.com/isdzulqor/kraicklist/helper/logging"
+	"github.com/isdzulqor/kraicklist/helper/metrics"
+	"github.com/isdzulqor/kraicklist/infra/backend"
 )
 
 func Exec() {
@@ -22,66 +26,284 @@ func Exec() {
 
 	logging.Init(strings.ToUpper(conf.LogLevel))
 
-	handlers := initDependencies(ctx, conf)
+	handlers, apiTenants, authService, rateLimit := initDependencies(ctx, conf)
 
 	// starting server
-	logging.InfoContext(ctx, "Starting HTTP on port %s", conf.Port)
-	router := createRouter(ctx, handlers)
-	if err := http.ListenAndServe(":"+conf.Port, router); err != nil {
-		logging.FatalContext(ctx, "Failed starting HTTP - %v", err)
+	router := createRouter(ctx, conf, handlers, apiTenants, authService, rateLimit)
+	server, err := newServer(ctx, conf, router)
+	if err != nil {
+		logging.FatalContext(ctx, "%v", err)
+	}
+	protocol := "HTTP"
+	if server.TLSConfig != nil {
+		protocol = "HTTPS"
+	}
+	logging.InfoContext(ctx, "Starting %s on port %s", protocol, conf.Port)
+	if err := serve(server); err != nil {
+		logging.FatalContext(ctx, "Failed starting %s - %v", protocol, err)
 	}
 }
 
-func initDependencies(ctx context.Context, conf *config.Config) handler.Root {
-	var (
-		err error
+func initDependencies(ctx context.Context, conf *config.Config) (handler.Root, *tenants, *service.Auth,
+	*service.RateLimit) {
+	deps, err := openDeps(ctx, conf)
+	if err != nil {
+		logging.FatalContext(ctx, "%v", err)
+	}
 
-		bleveIndex   *index.BleveIndex
-		elasticIndex *index.ElasticIndex
+	// every tenant is served by its own dependencies, the registered ones are opened on start
+	tenantRepo := repository.InitTenant(conf.Tenant.RegistryPath)
+	authService := service.InitAuth(conf, repository.InitAPIKey(conf.Auth.KeysPath), tenantRepo)
+	rateLimit := service.InitRateLimit(conf, repository.InitUsage(conf.Usage.Path))
+	rateLimit.Start(ctx)
+	registerRateLimitMetrics(rateLimit)
+	apiTenants := initTenants(ctx, conf, authService, rateLimit, deps)
+	registered, err := tenantRepo.GetTenants()
+	if err != nil {
+		logging.FatalContext(ctx, "%v", err)
+	}
+	for _, tenant := range registered {
+		if _, err = apiTenants.OpenTenant(ctx, tenant.Name); err != nil {
+			logging.FatalContext(ctx, "failed to open tenant %s, err: %v", tenant.Name, err)
+		}
+	}
+	tenantService := service.InitTenant(conf, tenantRepo, apiTenants)
+
+	healthHandler, err := health.NewHealthHandler(&deps.persistences, conf.GracefulShutdownTimeout)
+	if err != nil {
+		logging.FatalContext(ctx, "failed to init healthHandler")
+	}
+	healthHandler.WithToken(conf.HealthToken)
+	healthHandler.OnShutdown(func() {
+		if err := rateLimit.Flush(ctx); err != nil {
+			logging.ErrContext(ctx, "failed to flush the usage, err: %v", err)
+		}
+		apiTenants.close(ctx)
+	})
+
+	root := deps.root
+	root.Tenant = handler.InitTenant(tenantService)
+	root.APIKey = handler.InitAPIKey(authService)
+	root.Usage = handler.InitUsage(rateLimit)
+	root.Health = healthHandler
+	return root, apiTenants, authService, rateLimit
+}
 
-		healthPersistences health.Persistences
-	)
+// apiDeps is the dependencies serving the API on the indices of the default tenant or of a tenant
+type apiDeps struct {
+	conf         *config.Config
+	root         handler.Root
+	backend      engine.SearchBackend
+	store        *store.BoltStore
+	persistences health.Persistences
+
+	// stop stops the jobs and the background sync
+	stop context.CancelFunc
+}
+
+// openDeps opens the indices and the store of the config, then starts the jobs and the background sync
+func openDeps(ctx context.Context, conf *config.Config) (deps *apiDeps, err error) {
+	deps = &apiDeps{conf: conf}
 
 	// indexer check
-	switch conf.IndexerActivated {
-	case index.IndexBleve:
-		bleveIndex, err = index.InitBleveIndex(ctx, conf.Advertisement.Bleve.IndexName)
+	searchBackend, err := engine.Open(ctx, conf.IndexerActivated, conf, engine.Options{})
+	if err != nil {
+		return nil, err
+	}
+	pinger := backendPinger{backend: searchBackend}
+	deps.backend = searchBackend
+	defer func() {
+		if err != nil {
+			deps.close(ctx)
+			deps = nil
+		}
+	}()
+
+	// the searches are served by the replica while the primary is failing, the health check is still ok but degraded
+	if conf.Failover.Indexer != "" {
+		replica, err := engine.Open(ctx, conf.Failover.Indexer, conf, engine.Options{})
 		if err != nil {
-			logging.FatalContext(ctx, "%v", err)
+			return deps, fmt.Errorf("failed to open failover indexer, err: %v", err)
 		}
-	case index.IndexElastic:
-		elasticIndex, err = index.InitESIndex(ctx,
-			conf.Elastic.Host,
-			conf.Elastic.Username,
-			conf.Elastic.Password,
-			conf.Advertisement.Elastic.IndexName)
+		logging.InfoContext(ctx, "failover mode is active, %s is the replica of %s", replica.Name(), searchBackend.Name())
+		failover := engine.NewFailover(searchBackend, replica, engine.FailoverConfig{
+			CheckInterval:     conf.Failover.CheckInterval,
+			LatencyThreshold:  conf.Failover.LatencyThreshold,
+			FailureThreshold:  conf.Failover.FailureThreshold,
+			RecoveryThreshold: conf.Failover.RecoveryThreshold,
+		})
+		registerFailoverMetrics(failover)
+		searchBackend = failover
+		deps.backend = searchBackend
+		pinger = backendPinger{backend: failover, failover: failover}
+	}
+
+	// the other sources are searched along with the indexer, they're left out of the health check
+	// as the search is only failed when all of them are failed
+	federationSources, err := conf.FederationSources()
+	if err != nil {
+		return
+	}
+	if len(federationSources) > 0 {
+		sources := []engine.FederatedSource{{Backend: searchBackend, Timeout: conf.Federation.Timeout}}
+		for _, source := range federationSources {
+			backend, err := engine.Open(ctx, source.Indexer, conf, engine.Options{ReadOnly: true, IndexName: source.IndexName})
+			if err != nil {
+				for _, opened := range sources[1:] {
+					opened.Backend.Close()
+				}
+				return deps, fmt.Errorf("failed to open federation source %s, err: %v", source.Indexer, err)
+			}
+			sources = append(sources, engine.FederatedSource{Backend: backend, Timeout: source.Timeout})
+		}
+		logging.InfoContext(ctx, "federated search is active on %d sources, merged by %s", len(sources),
+			conf.Federation.Merge)
+		searchBackend = engine.NewFederated(sources, engine.FederationConfig{
+			Merge: conf.Federation.Merge,
+			RRFK:  conf.Federation.RRFK,
+		})
+		deps.backend = searchBackend
+	}
+
+	// append health persistence
+	deps.persistences = append(deps.persistences,
+		health.NewPersistence(searchBackend.IndexName(), searchBackend.Name(), pinger))
+
+	// the secondary isn't part of the health check, its failures only show up on the shadow report
+	if conf.Shadow.Indexer != "" {
+		secondary, err := engine.Open(ctx, conf.Shadow.Indexer, conf, engine.Options{})
 		if err != nil {
-			logging.FatalContext(ctx, "%v", err)
+			return deps, fmt.Errorf("failed to open shadow indexer, err: %v", err)
 		}
-		// append health persistence
-		healthPersistences = append(healthPersistences,
-			health.NewPersistence(conf.Advertisement.Elastic.IndexName,
-				conf.IndexerActivated, elasticIndex))
-	default:
-		logging.FatalContext(ctx, "Indexer for %s is invalid", conf.IndexerActivated)
+		logging.InfoContext(ctx, "shadow mode is active, %s is compared with %s", secondary.Name(), searchBackend.Name())
+		searchBackend = engine.NewShadow(searchBackend, secondary, engine.ShadowConfig{
+			TopK:        conf.Shadow.TopK,
+			Timeout:     conf.Shadow.Timeout,
+			MaxInflight: conf.Shadow.MaxInflight,
+			Retention:   conf.Shadow.Retention,
+		})
+		deps.backend = searchBackend
 	}
 
 	// initialize repo
-	adRepo := repository.InitAdvertisement(conf, bleveIndex, elasticIndex)
+	adRepo := repository.InitAdvertisement(searchBackend)
+	if conf.Store.Enabled {
+		if deps.store, err = backend.OpenStore(conf); err != nil {
+			return
+		}
+		adRepo.WithStore(deps.store)
+		deps.persistences = append(deps.persistences, health.NewPersistence(deps.store.Path(), "bbolt", deps.store))
+	}
+	jobRepo, err := repository.InitJob(conf.Job.Dir)
+	if err != nil {
+		return
+	}
 
 	// initialize service
+	runCtx, stop := context.WithCancel(ctx)
+	deps.stop = stop
 	adService := service.InitAdvertisement(adRepo)
+	jobService := service.InitJob(jobRepo, adService,
+		conf.Job.Workers,
+		conf.Job.QueueSize,
+		conf.Advertisement.Bulk.BatchSize)
+	if err = jobService.Start(runCtx); err != nil {
+		return
+	}
+	if deps.store != nil {
+		if err = adService.StartIndexSync(runCtx, conf.Store.SyncInterval, conf.Advertisement.Bulk.BatchSize); err != nil {
+			return
+		}
+		adService.StartChangeRetention(runCtx, conf.Changes.Retention, conf.Changes.PruneInterval)
+	}
 
 	// initialize handlers
-	adHandler := handler.InitAdvertisement(conf, adService)
-	healthHandler, err := health.NewHealthHandler(&healthPersistences, conf.GracefulShutdownTimeout)
-	if err != nil {
-		logging.FatalContext(ctx, "failed to init healthHandler")
+	deps.root = handler.Root{
+		Advertisement: handler.InitAdvertisement(conf, adService, jobService),
+		Job:           handler.InitJob(jobService),
+		Admin:         handler.InitAdmin(adService),
 	}
-	healthHandler.WithToken(conf.HealthToken)
+	return
+}
+
+// close stops the background work then closes the indices and the store
+func (d *apiDeps) close(ctx context.Context) {
+	if d.stop != nil {
+		d.stop()
+	}
+	if err := d.backend.Close(); err != nil {
+		logging.ErrContext(ctx, "%v", err)
+	}
+	if d.store != nil {
+		if err := d.store.Close(); err != nil {
+			logging.ErrContext(ctx, "%v", err)
+		}
+	}
+}
+
+// drop stops the background work then deletes the indices, the store and the jobs
+func (d *apiDeps) drop(ctx context.Context) error {
+	if d.stop != nil {
+		d.stop()
+	}
+	if err := d.backend.Drop(ctx); err != nil {
+		return err
+	}
+	if d.store != nil {
+		if err := d.store.Close(); err != nil {
+			return err
+		}
+		if err := os.Remove(d.store.Path()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.RemoveAll(d.conf.Job.Dir)
+}
 
-	return handler.Root{
-		Advertisement: adHandler,
-		Health:        healthHandler,
+// backendPinger checks the search backend on the health check, the failover status is reported when it's active
+type backendPinger struct {
+	backend  engine.SearchBackend
+	failover engine.FailoverReporter
+}
+
+func (p backendPinger) Ping() error {
+	return p.backend.Ping(context.Background())
+}
+
+func (p backendPinger) Details() (bool, interface{}) {
+	if p.failover == nil {
+		return false, nil
 	}
+	status := p.failover.FailoverStatus()
+	return status.Degraded, status
+}
+
+func registerFailoverMetrics(failover engine.FailoverReporter) {
+	metrics.Register("kraicklist_failover_degraded", metrics.KindGauge,
+		"Whether the searches are served by the failover replica.", func() float64 {
+			return metrics.Bool(failover.FailoverStatus().Degraded)
+		})
+	metrics.Register("kraicklist_failover_failovers_total", metrics.KindCounter,
+		"Number of the switches to the failover replica.", func() float64 {
+			return float64(failover.FailoverStatus().Failovers)
+		})
+	metrics.Register("kraicklist_failover_recoveries_total", metrics.KindCounter,
+		"Number of the switches back to the primary.", func() float64 {
+			return float64(failover.FailoverStatus().Recoveries)
+		})
+	metrics.Register("kraicklist_failover_degraded_searches_total", metrics.KindCounter,
+		"Number of the searches served by the failover replica.", func() float64 {
+			return float64(failover.FailoverStatus().DegradedSearches)
+		})
+	metrics.Register("kraicklist_failover_replica_write_errors_total", metrics.KindCounter,
+		"Number of the failed writes on the failover replica.", func() float64 {
+			return float64(failover.FailoverStatus().ReplicaWriteErrors)
+		})
+}
+
+func registerRateLimitMetrics(rateLimit *service.RateLimit) {
+	metrics.Register("kraicklist_rate_limited_requests_total", metrics.KindCounter,
+		"Number of the requests rejected over the rate limit.", func() float64 {
+			return float64(rateLimit.Rejected())
+		})
 }
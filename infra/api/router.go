@@ -4,13 +4,18 @@ import (
 	"context"
 	"net/http"
 
+	"github.com/isdzulqor/kraicklist/config"
 	"github.com/isdzulqor/kraicklist/domain/handler"
+	"github.com/isdzulqor/kraicklist/domain/model"
+	"github.com/isdzulqor/kraicklist/domain/service"
+	"github.com/isdzulqor/kraicklist/helper/metrics"
 	"github.com/isdzulqor/kraicklist/infra"
 
 	"github.com/gorilla/mux"
 )
 
-func createRouter(ctx context.Context, rootHandler handler.Root) http.Handler {
+func createRouter(ctx context.Context, conf *config.Config, rootHandler handler.Root, apiTenants *tenants,
+	authService *service.Auth, rateLimit *service.RateLimit) http.Handler {
 	router := mux.NewRouter()
 
 	// setup middlewares
@@ -24,10 +29,65 @@ func createRouter(ctx context.Context, rootHandler handler.Root) http.Handler {
 
 	// healthcheck endpoint
 	router.HandleFunc("/health", rootHandler.Health.GetHealth).Methods("GET")
+	router.HandleFunc("/metrics", metrics.Handler).Methods("GET")
 
-	// API serve
+	// tenants and api keys are managed regardless of the selected tenant, by the keys for all tenants
+	admin := scoped(authService, rateLimit, model.ScopeAdmin, "")
+	maxBody := conf.Server.MaxBodyBytes
+	router.Handle("/api/admin/tenants", admin(rootHandler.Tenant.GetTenants)).Methods("GET")
+	router.Handle("/api/admin/tenants", admin(limitBody(maxBody, rootHandler.Tenant.CreateTenant))).Methods("POST")
+	router.Handle("/api/admin/tenants/{name}", admin(rootHandler.Tenant.DropTenant)).Methods("DELETE")
+	router.Handle("/api/admin/keys", admin(rootHandler.APIKey.GetAPIKeys)).Methods("GET")
+	router.Handle("/api/admin/keys", admin(limitBody(maxBody, rootHandler.APIKey.IssueAPIKey))).Methods("POST")
+	router.Handle("/api/admin/keys/{id}/rotate", admin(limitBody(maxBody, rootHandler.APIKey.RotateAPIKey))).Methods("POST")
+	router.Handle("/api/admin/keys/{id}", admin(rootHandler.APIKey.RevokeAPIKey)).Methods("DELETE")
+	router.Handle("/api/admin/usage", admin(rootHandler.Usage.GetUsages)).Methods("GET")
+
+	// API serve, on the tenant selected by the path prefix, the header or the api key
+	router.PathPrefix(tenantPathPrefix + "{tenant}/api/").HandlerFunc(apiTenants.servePath)
+	router.PathPrefix("/api/").HandlerFunc(apiTenants.serveHeader)
+
+	// the deadlines of every request, the bulk upload and the change feed override them
+	return infra.Deadline(conf.Server.ReadTimeout, conf.Server.WriteTimeout)(router)
+}
+
+// createAPIRouter routes the API of a tenant, each route declares the scope the api key needs,
+// the budget of the rate limit it's taken from, the limit of its body and its own deadlines if any
+func createAPIRouter(conf *config.Config, rootHandler handler.Root, authService *service.Auth,
+	rateLimit *service.RateLimit, tenant string) http.Handler {
+	search := scoped(authService, rateLimit, model.ScopeSearch, tenant)
+	index := scoped(authService, rateLimit, model.ScopeIndex, tenant)
+	admin := scoped(authService, rateLimit, model.ScopeAdmin, tenant)
+
+	router := mux.NewRouter()
 	api := router.PathPrefix("/api").Subrouter()
-	api.HandleFunc("/advertisement/search", rootHandler.Advertisement.SearchAds).Methods("GET")
-	api.HandleFunc("/advertisement/index", rootHandler.Advertisement.IndexAds).Methods("POST")
+	api.Handle("/advertisement/search", search(rootHandler.Advertisement.SearchAds)).Methods("GET")
+	api.Handle("/advertisement/index",
+		index(limitBody(conf.Server.MaxBodyBytes, rootHandler.Advertisement.IndexAds))).Methods("POST")
+	api.Handle("/advertisement/bulk", infra.Deadline(conf.Server.BulkTimeout, conf.Server.BulkTimeout)(
+		index(limitBody(conf.Server.MaxBulkBodyBytes, rootHandler.Advertisement.BulkIndexAds)))).Methods("POST")
+	// the feed has no body to read, a read deadline would cancel the stream while the connection is idle
+	api.Handle("/advertisement/changes", infra.Deadline(0, conf.Server.StreamTimeout)(
+		search(rootHandler.Advertisement.GetChanges))).Methods("GET")
+	api.Handle("/jobs/{id}", index(rootHandler.Job.GetJob)).Methods("GET")
+	api.Handle("/jobs/{id}", index(rootHandler.Job.CancelJob)).Methods("DELETE")
+	api.Handle("/admin/stats", admin(rootHandler.Admin.GetStats)).Methods("GET")
+	api.Handle("/admin/shadow", admin(rootHandler.Admin.GetShadowReport)).Methods("GET")
 	return router
 }
+
+// scoped wraps the handlers requiring the scope on the tenant, the requests are taken from the budget
+// named by the scope. The admin scope isn't limited
+func scoped(authService *service.Auth, rateLimit *service.RateLimit,
+	scope, tenant string) func(http.HandlerFunc) http.Handler {
+	require := infra.RequireScope(authService, scope, tenant)
+	limit := infra.RateLimit(rateLimit, scope)
+	return func(h http.HandlerFunc) http.Handler {
+		return require(limit(h))
+	}
+}
+
+// limitBody fails the body of the handler over the limit, it's checked after the scope of the api key
+func limitBody(limit int64, h http.HandlerFunc) http.HandlerFunc {
+	return infra.LimitBody(limit)(h).ServeHTTP
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/isdzulqor/kraicklist/config"
+	"github.com/isdzulqor/kraicklist/helper/logging"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// newServer builds the HTTP server with the timeouts of the config, the read and write deadlines are set
+// per route by the router. TLS is served when the certificate is set, otherwise HTTP/2 cleartext
+// is optionally served along with HTTP/1
+func newServer(ctx context.Context, conf *config.Config, handler http.Handler) (*http.Server, error) {
+	server := &http.Server{
+		Addr:              ":" + conf.Port,
+		Handler:           handler,
+		ReadHeaderTimeout: conf.Server.ReadHeaderTimeout,
+		IdleTimeout:       conf.Server.IdleTimeout,
+		MaxHeaderBytes:    conf.Server.MaxHeaderBytes,
+	}
+
+	if conf.Server.TLSCertFile != "" {
+		certs := &certReloader{certFile: conf.Server.TLSCertFile, keyFile: conf.Server.TLSKeyFile}
+		if _, err := certs.reload(); err != nil {
+			return nil, err
+		}
+		certs.watch(ctx, conf.Server.TLSReloadInterval)
+		server.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certs.getCertificate,
+		}
+		return server, nil
+	}
+	if conf.Server.H2C {
+		server.Handler = h2c.NewHandler(handler, &http2.Server{IdleTimeout: conf.Server.IdleTimeout})
+	}
+	return server, nil
+}
+
+// serve listens on TLS when the server has the certificate
+func serve(server *http.Server) error {
+	if server.TLSConfig != nil {
+		return server.ListenAndServeTLS("", "")
+	}
+	return server.ListenAndServe()
+}
+
+// certReloader serves the certificate of the files, the files are reloaded once they're modified,
+// i.e: renewed by certbot. The loaded certificate is kept while the new files are invalid
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	modTimes [2]time.Time
+}
+
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// reload loads the files when they're modified since the last load
+func (c *certReloader) reload() (reloaded bool, err error) {
+	var modTimes [2]time.Time
+	for i, path := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return false, fmt.Errorf("failed to read TLS file %s, err: %v", path, err)
+		}
+		modTimes[i] = info.ModTime()
+	}
+
+	c.mu.RLock()
+	unchanged := c.cert != nil && modTimes == c.modTimes
+	c.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to load TLS certificate %s, err: %v", c.certFile, err)
+	}
+	c.mu.Lock()
+	c.cert, c.modTimes = &cert, modTimes
+	c.mu.Unlock()
+	return true, nil
+}
+
+// watch reloads the modified files on every interval in the background until the ctx is done
+func (c *certReloader) watch(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				reloaded, err := c.reload()
+				if err != nil {
+					logging.WarnContext(ctx, "keep serving the loaded certificate, %v", err)
+					continue
+				}
+				if reloaded {
+					logging.InfoContext(ctx, "TLS certificate %s is reloaded", c.certFile)
+				}
+			}
+		}
+	}()
+}
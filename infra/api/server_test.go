@@ -0,0 +1,108 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed certificate of the common name, modified at the time
+func writeTestCert(t *testing.T, certFile, keyFile, commonName string, modTime time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), modTime)
+	writeTestFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), modTime)
+}
+
+func writeTestFile(t *testing.T, path string, content []byte, modTime time.Time) {
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func servedCommonName(t *testing.T, certs *certReloader) string {
+	cert, err := certs.getCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certs := &certReloader{certFile: filepath.Join(dir, "cert.pem"), keyFile: filepath.Join(dir, "key.pem")}
+	modTime := time.Now().Add(-time.Hour)
+
+	if _, err := certs.reload(); err == nil {
+		t.Fatal("expected the missing files to be failed")
+	}
+
+	writeTestCert(t, certs.certFile, certs.keyFile, "first", modTime)
+	if reloaded, err := certs.reload(); err != nil || !reloaded {
+		t.Fatalf("first load: reloaded %v, err: %v", reloaded, err)
+	}
+	if name := servedCommonName(t, certs); name != "first" {
+		t.Fatalf("served %s, want first", name)
+	}
+
+	if reloaded, err := certs.reload(); err != nil || reloaded {
+		t.Fatalf("unmodified files: reloaded %v, err: %v", reloaded, err)
+	}
+
+	modTime = modTime.Add(time.Minute)
+	writeTestCert(t, certs.certFile, certs.keyFile, "second", modTime)
+	if reloaded, err := certs.reload(); err != nil || !reloaded {
+		t.Fatalf("renewed files: reloaded %v, err: %v", reloaded, err)
+	}
+	if name := servedCommonName(t, certs); name != "second" {
+		t.Fatalf("served %s, want second", name)
+	}
+
+	// the invalid files keep the loaded certificate
+	modTime = modTime.Add(time.Minute)
+	writeTestFile(t, certs.certFile, []byte("garbage"), modTime)
+	if reloaded, err := certs.reload(); err == nil || reloaded {
+		t.Fatalf("invalid files: reloaded %v, err: %v", reloaded, err)
+	}
+	if err := os.Remove(certs.keyFile); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded, err := certs.reload(); err == nil || reloaded {
+		t.Fatalf("missing key: reloaded %v, err: %v", reloaded, err)
+	}
+	if name := servedCommonName(t, certs); name != "second" {
+		t.Fatalf("served %s, want the loaded second", name)
+	}
+}
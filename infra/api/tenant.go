@@ -0,0 +1,149 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/isdzulqor/kraicklist/config"
+	"github.com/isdzulqor/kraicklist/domain/service"
+	"github.com/isdzulqor/kraicklist/helper/errors"
+	"github.com/isdzulqor/kraicklist/helper/logging"
+	"github.com/isdzulqor/kraicklist/helper/response"
+	"github.com/isdzulqor/kraicklist/infra"
+
+	"github.com/gorilla/mux"
+)
+
+// tenantPathPrefix selects the tenant by the path, i.e: /t/acme/api/advertisement/search
+const tenantPathPrefix = "/t/"
+
+// tenants serves the API requests with the dependencies of the resolved tenant,
+// the requests without a tenant are served by the default ones
+type tenants struct {
+	// ctx outlives the requests, the tenants created by a request keep running after it
+	ctx         context.Context
+	conf        *config.Config
+	authService *service.Auth
+	rateLimit   *service.RateLimit
+
+	defaultDeps    *apiDeps
+	defaultHandler http.Handler
+
+	mu       sync.RWMutex
+	deps     map[string]*apiDeps
+	handlers map[string]http.Handler
+}
+
+func initTenants(ctx context.Context, conf *config.Config, authService *service.Auth, rateLimit *service.RateLimit,
+	defaultDeps *apiDeps) *tenants {
+	return &tenants{
+		ctx:            ctx,
+		conf:           conf,
+		authService:    authService,
+		rateLimit:      rateLimit,
+		defaultDeps:    defaultDeps,
+		defaultHandler: createAPIRouter(conf, defaultDeps.root, authService, rateLimit, ""),
+		deps:           map[string]*apiDeps{},
+		handlers:       map[string]http.Handler{},
+	}
+}
+
+// OpenTenant opens the dependencies of the tenant on its own indices, store and jobs
+func (t *tenants) OpenTenant(ctx context.Context, name string) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if deps, ok := t.deps[name]; ok {
+		return deps.backend.IndexName(), nil
+	}
+	deps, err := openDeps(t.ctx, t.conf.ForTenant(name))
+	if err != nil {
+		return "", err
+	}
+	t.deps[name] = deps
+	t.handlers[name] = createAPIRouter(t.conf, deps.root, t.authService, t.rateLimit, name)
+	logging.InfoContext(ctx, "tenant %s is served on %s %s", name, deps.backend.Name(), deps.backend.IndexName())
+	return deps.backend.IndexName(), nil
+}
+
+// DropTenant stops serving the tenant then deletes its indices, store and jobs
+func (t *tenants) DropTenant(ctx context.Context, name string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	deps, ok := t.deps[name]
+	if !ok {
+		var err error
+		if deps, err = openDeps(t.ctx, t.conf.ForTenant(name)); err != nil {
+			return err
+		}
+	}
+	delete(t.deps, name)
+	delete(t.handlers, name)
+	if err := deps.drop(ctx); err != nil {
+		return fmt.Errorf("failed to drop tenant %s, err: %v", name, err)
+	}
+	logging.InfoContext(ctx, "tenant %s is dropped", name)
+	return nil
+}
+
+func (t *tenants) close(ctx context.Context) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for name, deps := range t.deps {
+		deps.close(ctx)
+		delete(t.deps, name)
+		delete(t.handlers, name)
+	}
+	t.defaultDeps.close(ctx)
+}
+
+// serveHeader serves the request of the tenant on the header, or the tenant of the api key without the header.
+// The default tenant serves the rest
+func (t *tenants) serveHeader(w http.ResponseWriter, r *http.Request) {
+	name := r.Header.Get(t.conf.Tenant.Header)
+	if name == "" && t.authService.Enabled() {
+		// the invalid keys are rejected by the routes
+		if key, err := t.authService.Authenticate(r.Context(), infra.APIKeyFromRequest(r)); err == nil {
+			name = key.Tenant
+		}
+	}
+	if name == "" {
+		t.defaultHandler.ServeHTTP(w, r)
+		return
+	}
+	t.serve(w, r, name)
+}
+
+// servePath serves the request of the tenant on the path prefix, the header must not select other tenant
+func (t *tenants) servePath(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["tenant"]
+	if header := r.Header.Get(t.conf.Tenant.Header); header != "" && header != name {
+		err := errors.ErrorParamInvalid.AppendMessage(
+			fmt.Sprintf("%s header %s doesn't match tenant %s on the path", t.conf.Tenant.Header, header, name))
+		response.Failed(r.Context(), w, errors.GetStatusCode(err), err)
+		return
+	}
+
+	scoped := r.Clone(r.Context())
+	scoped.URL.Path = strings.TrimPrefix(r.URL.Path, tenantPathPrefix+name)
+	scoped.URL.RawPath = ""
+	t.serve(w, scoped, name)
+}
+
+func (t *tenants) serve(w http.ResponseWriter, r *http.Request, name string) {
+	t.mu.RLock()
+	handler, ok := t.handlers[name]
+	t.mu.RUnlock()
+
+	if !ok {
+		err := errors.ErrorNotFound.AppendMessage(fmt.Sprintf("tenant %s is not found", name))
+		response.Failed(r.Context(), w, errors.GetStatusCode(err), err)
+		return
+	}
+	handler.ServeHTTP(w, r)
+}
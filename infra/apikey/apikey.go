@@ -0,0 +1,138 @@
+package apikey
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/isdzulqor/kraicklist/config"
+	"github.com/isdzulqor/kraicklist/domain/model"
+	"github.com/isdzulqor/kraicklist/domain/repository"
+	"github.com/isdzulqor/kraicklist/domain/service"
+	"github.com/isdzulqor/kraicklist/helper/logging"
+	"github.com/isdzulqor/kraicklist/infra/cli"
+)
+
+const (
+	actionIssue  = "issue"
+	actionList   = "list"
+	actionRotate = "rotate"
+	actionRevoke = "revoke"
+)
+
+type options struct {
+	name   string
+	scopes string
+	tenant string
+	ttl    time.Duration
+	grace  time.Duration
+}
+
+func parseOptions(conf *config.Config, args []string) (positional []string, opts options) {
+	flags := cli.NewFlagSet(cli.CmdAPIKey)
+	flags.StringVar(&opts.name, "name", "", "name of the issued key, i.e: the client using it")
+	flags.StringVar(&opts.scopes, "scopes", model.ScopeSearch,
+		"comma separated scopes of the issued key, any of "+strings.Join(model.Scopes, ", "))
+	flags.StringVar(&opts.tenant, "tenant", "", "tenant of the issued key, the key is for all tenants when it's empty")
+	flags.DurationVar(&opts.ttl, "ttl", conf.Auth.KeyTTL, "lifetime of the issued key")
+	flags.DurationVar(&opts.grace, "grace", conf.Auth.RotationGrace, "how long the rotated key still works")
+	positional = cli.Parse(flags, args)
+	if len(positional) == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	return
+}
+
+// Exec issues, lists, rotates or revokes the API keys on AUTH_KEYS_PATH, the running API picks up the changes
+func Exec(args []string) {
+	conf := config.Get()
+	ctx := context.Background()
+
+	// keep the output clean for piping, only warnings are logged
+	logging.Init(logging.Warn)
+
+	positional, opts := parseOptions(conf, args)
+	authService := service.InitAuth(conf, repository.InitAPIKey(conf.Auth.KeysPath),
+		repository.InitTenant(conf.Tenant.RegistryPath))
+
+	var err error
+	switch action := positional[0]; {
+	case action == actionIssue:
+		var key model.IssuedAPIKey
+		if key, err = authService.IssueAPIKey(ctx, service.IssueAPIKeyRequest{
+			Name:   opts.name,
+			Scopes: splitScopes(opts.scopes),
+			Tenant: opts.tenant,
+			TTL:    opts.ttl,
+		}); err == nil {
+			printIssued(key)
+		}
+	case action == actionList:
+		var keys model.APIKeys
+		if keys, err = authService.GetAPIKeys(ctx); err == nil {
+			printKeys(keys)
+		}
+	case action == actionRotate && len(positional) == 2:
+		var key model.IssuedAPIKey
+		if key, err = authService.RotateAPIKey(ctx, positional[1], opts.grace); err == nil {
+			printIssued(key)
+			fmt.Printf("Key %s still works until %s\n", positional[1], time.Now().Add(opts.grace).Format(time.RFC3339))
+		}
+	case action == actionRevoke && len(positional) == 2:
+		if _, err = authService.RevokeAPIKey(ctx, positional[1]); err == nil {
+			fmt.Printf("Key %s is revoked\n", positional[1])
+		}
+	default:
+		logging.FatalContext(ctx, "usage: apikey issue | list | rotate ID | revoke ID")
+	}
+	if err != nil {
+		logging.FatalContext(ctx, "%v", err)
+	}
+}
+
+func splitScopes(value string) (out []string) {
+	for _, scope := range strings.Split(value, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			out = append(out, scope)
+		}
+	}
+	return
+}
+
+func printIssued(key model.IssuedAPIKey) {
+	fmt.Printf("Key %s is issued with scopes %s, expires at %s\n", key.ID, strings.Join(key.Scopes, ","),
+		key.ExpiresAt.Format(time.RFC3339))
+	fmt.Printf("\n  %s\n\n", key.Key)
+	fmt.Println("\033[33mStore it now, it can't be shown again\033[0m")
+}
+
+func printKeys(keys model.APIKeys) {
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprint(w, "ID\tNAME\tSCOPES\tTENANT\tEXPIRES AT\tSTATUS\n")
+	for _, key := range keys {
+		status := "active"
+		switch {
+		case key.RevokedAt != nil:
+			status = "revoked"
+		case !key.IsActive(now):
+			status = "expired"
+		case key.RotatedTo != "":
+			status = "rotated to " + key.RotatedTo
+		}
+		tenant, expiresAt := key.Tenant, "-"
+		if tenant == "" {
+			tenant = "*"
+		}
+		if key.ExpiresAt != nil {
+			expiresAt = key.ExpiresAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", key.ID, key.Name, strings.Join(key.Scopes, ","), tenant,
+			expiresAt, status)
+	}
+	w.Flush()
+}
@@ -0,0 +1,50 @@
+package infra
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/isdzulqor/kraicklist/domain/service"
+	"github.com/isdzulqor/kraicklist/helper/errors"
+	"github.com/isdzulqor/kraicklist/helper/response"
+)
+
+const apiKeyHeader = "X-API-Key"
+
+// APIKeyFromRequest reads the key of the X-API-Key header or the bearer token
+func APIKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		return key
+	}
+	if auth := r.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// RequireScope works as middleware of a route, the API key of the request must have the scope on the tenant.
+// Empty tenant is the default indices, nothing is checked when the auth is disabled
+func RequireScope(authService *service.Auth, scope, tenant string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !authService.Enabled() {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx := r.Context()
+
+			key, err := authService.Authenticate(ctx, APIKeyFromRequest(r))
+			if err == nil {
+				err = authService.Authorize(key, scope, tenant)
+			}
+			if err != nil {
+				if errors.GetStatusCode(err) == http.StatusUnauthorized {
+					w.Header().Set("WWW-Authenticate", `Bearer realm="kraicklist"`)
+				}
+				response.Failed(ctx, w, errors.GetStatusCode(err), err)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(service.WithAPIKey(ctx, key)))
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package infra
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/isdzulqor/kraicklist/config"
+	"github.com/isdzulqor/kraicklist/domain/model"
+	"github.com/isdzulqor/kraicklist/domain/repository"
+	"github.com/isdzulqor/kraicklist/domain/service"
+)
+
+func TestRequireScope(t *testing.T) {
+	conf := &config.Config{}
+	conf.Auth.Enabled = true
+	conf.Auth.KeyTTL = time.Hour
+	dir := t.TempDir()
+	authService := service.InitAuth(conf,
+		repository.InitAPIKey(filepath.Join(dir, "api_keys.json")),
+		repository.InitTenant(filepath.Join(dir, "tenants.json")))
+	issued, err := authService.IssueAPIKey(context.Background(), service.IssueAPIKeyRequest{
+		Name:   "search",
+		Scopes: []string{model.ScopeSearch},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name                string
+		header              string
+		value               string
+		scope               string
+		disabled            bool
+		wantStatus          int
+		wantWWWAuthenticate bool
+	}{
+		{name: "api key header", header: apiKeyHeader, value: issued.Key, scope: model.ScopeSearch, wantStatus: http.StatusOK},
+		{
+			name:       "bearer token",
+			header:     "Authorization",
+			value:      "Bearer " + issued.Key,
+			scope:      model.ScopeSearch,
+			wantStatus: http.StatusOK,
+		},
+		{name: "missing key", scope: model.ScopeSearch, wantStatus: http.StatusUnauthorized, wantWWWAuthenticate: true},
+		{
+			name:                "wrong secret",
+			header:              apiKeyHeader,
+			value:               issued.Key + "0",
+			scope:               model.ScopeSearch,
+			wantStatus:          http.StatusUnauthorized,
+			wantWWWAuthenticate: true,
+		},
+		{name: "missing scope", header: apiKeyHeader, value: issued.Key, scope: model.ScopeAdmin, wantStatus: http.StatusForbidden},
+		{name: "auth disabled", scope: model.ScopeAdmin, disabled: true, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf.Auth.Enabled = !tt.disabled
+			handler := RequireScope(authService, tt.scope, "")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if key, ok := service.APIKeyFromContext(r.Context()); !tt.disabled && (!ok || key.ID != issued.ID) {
+					t.Errorf("api key %s isn't on the context, got %+v", issued.ID, key)
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/api/advertisement/search", nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d, body: %s", recorder.Code, tt.wantStatus, recorder.Body)
+			}
+			if got := recorder.Header().Get("WWW-Authenticate") != ""; got != tt.wantWWWAuthenticate {
+				t.Errorf("WWW-Authenticate is %q, want it set: %v", recorder.Header().Get("WWW-Authenticate"), tt.wantWWWAuthenticate)
+			}
+		})
+	}
+}
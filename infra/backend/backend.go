@@ -0,0 +1,77 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/isdzulqor/kraicklist/config"
+	"github.com/isdzulqor/kraicklist/domain/repository"
+	"github.com/isdzulqor/kraicklist/external/engine"
+	"github.com/isdzulqor/kraicklist/external/store"
+	"github.com/isdzulqor/kraicklist/helper/errors"
+	"github.com/isdzulqor/kraicklist/helper/logging"
+)
+
+// InitAdvertisement initializes the advertisement repository on the indexer for the CLI commands,
+// the returned func closes the index
+func InitAdvertisement(ctx context.Context, conf *config.Config, indexer string,
+	opts engine.Options) (*repository.Advertisement, func(), error) {
+	searchBackend, err := engine.Open(ctx, indexer, conf, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	closeIndex := func() {
+		if err := searchBackend.Close(); err != nil {
+			logging.ErrContext(ctx, "%v", err)
+		}
+	}
+	return repository.InitAdvertisement(searchBackend), closeIndex, nil
+}
+
+// InitAdvertisementWithStore initializes the advertisement repository for the CLI commands which write the ads,
+// the writes are committed on the store first when it's enabled. The returned func closes the index and the store
+func InitAdvertisementWithStore(ctx context.Context, conf *config.Config, indexer string,
+	opts engine.Options) (*repository.Advertisement, func(), error) {
+	if !conf.Store.Enabled {
+		return InitAdvertisement(ctx, conf, indexer, opts)
+	}
+
+	// the store is opened first, its lock times out while the bleve lock held by the API waits forever
+	adStore, err := OpenStore(conf)
+	if err != nil {
+		return nil, nil, err
+	}
+	closeStore := func() {
+		if err := adStore.Close(); err != nil {
+			logging.ErrContext(ctx, "%v", err)
+		}
+	}
+	adRepo, closeIndex, err := InitAdvertisement(ctx, conf, indexer, opts)
+	if err != nil {
+		closeStore()
+		return nil, nil, err
+	}
+	closeAll := func() {
+		closeIndex()
+		closeStore()
+	}
+	return adRepo.WithStore(adStore), closeAll, nil
+}
+
+// OpenStore opens the configured store
+func OpenStore(conf *config.Config) (*store.BoltStore, error) {
+	return store.InitBoltStore(conf.Store.Path, conf.Store.LockTimeout)
+}
+
+// TenantConfig scopes the config to the registered tenant for the CLI commands,
+// the config is returned as is without a tenant
+func TenantConfig(conf *config.Config, tenant string) (*config.Config, error) {
+	if tenant == "" {
+		return conf, nil
+	}
+	if _, err := repository.InitTenant(conf.Tenant.RegistryPath).GetTenant(tenant); err != nil {
+		return nil, fmt.Errorf("%s, create it first with POST /api/admin/tenants", errors.GetMessageOnly(err))
+	}
+	return conf.ForTenant(tenant), nil
+}
@@ -0,0 +1,61 @@
+package infra
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/isdzulqor/kraicklist/helper/errors"
+	"github.com/isdzulqor/kraicklist/helper/response"
+)
+
+// LimitBody works as middleware of a route, the body over the limit is failed with errors.ErrorPayloadTooLarge.
+// The declared length is checked upfront, the rest is failed on the read past the limit
+func LimitBody(limit int64) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > limit {
+				// the unread body isn't worth keeping the connection for
+				w.Header().Set("Connection", "close")
+				err := bodyTooLarge(limit)
+				response.Failed(r.Context(), w, errors.GetStatusCode(err), err)
+				return
+			}
+			r.Body = &limitedBody{ReadCloser: r.Body, limit: limit, remaining: limit}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type limitedBody struct {
+	io.ReadCloser
+	limit     int64
+	remaining int64
+	err       error
+}
+
+func (b *limitedBody) Read(p []byte) (n int, err error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	// read a byte past the limit to tell the body ending on the limit from the larger one
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err = b.ReadCloser.Read(p)
+	if int64(n) <= b.remaining {
+		b.remaining -= int64(n)
+		b.err = err
+		return n, err
+	}
+	n, b.remaining = int(b.remaining), 0
+	b.err = bodyTooLarge(b.limit)
+	return n, b.err
+}
+
+func bodyTooLarge(limit int64) error {
+	return errors.ErrorPayloadTooLarge.AppendMessage(fmt.Sprintf("request body must be up to %d bytes", limit))
+}
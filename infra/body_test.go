@@ -0,0 +1,72 @@
+package infra
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/isdzulqor/kraicklist/helper/errors"
+	"github.com/isdzulqor/kraicklist/helper/response"
+)
+
+func TestLimitBody(t *testing.T) {
+	const limit = 8
+	handler := LimitBody(limit)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			response.Failed(r.Context(), w, errors.GetStatusCode(err), err)
+			return
+		}
+		w.Write([]byte(strconv.Itoa(len(body))))
+	}))
+
+	tests := []struct {
+		name string
+		size int
+		// contentLength is the declared length, -1 is absent as on the chunked body
+		contentLength int64
+		wantStatus    int
+	}{
+		{name: "under the limit", size: limit - 1, contentLength: limit - 1, wantStatus: http.StatusOK},
+		{name: "exactly the limit", size: limit, contentLength: limit, wantStatus: http.StatusOK},
+		{name: "over the limit by one", size: limit + 1, contentLength: limit + 1, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "absent length on the limit", size: limit, contentLength: -1, wantStatus: http.StatusOK},
+		{name: "absent length over the limit", size: limit + 1, contentLength: -1, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "lying length over the limit", size: 4 * limit, contentLength: 1, wantStatus: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/advertisement/index", strings.NewReader(strings.Repeat("a", tt.size)))
+			req.ContentLength = tt.contentLength
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body: %s", recorder.Code, tt.wantStatus, recorder.Body)
+			}
+			if tt.wantStatus == http.StatusOK && recorder.Body.String() != strconv.Itoa(tt.size) {
+				t.Errorf("read %s bytes, want %d", recorder.Body, tt.size)
+			}
+		})
+	}
+}
+
+func TestLimitBodyRejectsTheDeclaredLengthUpfront(t *testing.T) {
+	called := false
+	handler := LimitBody(8)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/api/advertisement/index", strings.NewReader(strings.Repeat("a", 9)))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	if called {
+		t.Error("the handler is called on the declared oversized body")
+	}
+	if recorder.Header().Get("Connection") != "close" {
+		t.Errorf("Connection = %q, want close", recorder.Header().Get("Connection"))
+	}
+}
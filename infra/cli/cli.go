@@ -1,32 +1,130 @@
 package cli
 
 import (
+	"flag"
 	"fmt"
 	"os"
+	"strings"
 )
 
 const (
-	defaultCommands = `
-kraicklist, a Search Ads Application
-
-Commands:
- api  | run API server, i.e: go run main.go api
- seed | seed master data for first initiation, i.e: go run main.go seed
-`
-	CmdApi  = "api"
-	CmdSeed = "seed"
+	CmdApi     = "api"
+	CmdSeed    = "seed"
+	CmdExport  = "export"
+	CmdMigrate = "migrate"
+	CmdSearch  = "search"
+	CmdStats   = "stats"
+	CmdDoctor  = "doctor"
+	CmdReindex = "reindex"
+	CmdAPIKey  = "apikey"
+
+	cmdHelp = "help"
 )
 
-func ParseCommand() (cmd string) {
-	args := os.Args
-	if len(args) == 1 {
+// Command is a subcommand of the application
+type Command struct {
+	Name    string
+	Args    string
+	Summary string
+	Example string
+}
+
+// Commands is listed on the default usage
+var Commands = []Command{
+	{Name: CmdApi, Summary: "run API server", Example: "go run main.go api"},
+	{Name: CmdSeed, Summary: "seed master data for first initiation", Example: "go run main.go seed"},
+	{Name: CmdExport, Summary: "dump the indexed ads to gzip jsonl which could be seeded back",
+		Example: "go run main.go export --output=./dumps/ads.jsonl.gz"},
+	{Name: CmdMigrate, Summary: "copy ads between indexers then verify them",
+		Example: "go run main.go migrate --from=bleve --to=elastic"},
+	{Name: CmdSearch, Args: "KEYWORD", Summary: "search the configured index without the API server",
+		Example: `go run main.go search "iphone" --sort=newest --size=20`},
+	{Name: CmdStats, Summary: "print statistics of the configured index", Example: "go run main.go stats --format=json"},
+	{Name: CmdDoctor, Summary: "validate the config, the index and the master data", Example: "go run main.go doctor"},
+	{Name: CmdReindex, Summary: "rebuild the index entirely from the store", Example: "go run main.go reindex --to=elastic"},
+	{Name: CmdAPIKey, Args: "issue | list | rotate ID | revoke ID", Summary: "manage the API keys",
+		Example: "go run main.go apikey issue --name=ci --scopes=search,index"},
+}
+
+// ParseCommand splits os.Args to the subcommand and its arguments.
+// The usage is printed for help, an unknown command exits with status 2
+func ParseCommand() (cmd string, args []string) {
+	if len(os.Args) == 1 {
 		return
 	}
 
-	cmd = args[1]
+	cmd, args = os.Args[1], os.Args[2:]
+	switch cmd {
+	case cmdHelp, "-h", "-help", "--help":
+		PrintDefault()
+		os.Exit(0)
+	}
+	if _, ok := findCommand(cmd); !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", cmd)
+		PrintDefault()
+		os.Exit(2)
+	}
 	return
 }
 
+func findCommand(name string) (Command, bool) {
+	for _, command := range Commands {
+		if command.Name == name {
+			return command, true
+		}
+	}
+	return Command{}, false
+}
+
+// NewFlagSet creates flags of the command, the command usage is printed on --help or invalid flags
+func NewFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		command, _ := findCommand(name)
+		out := flags.Output()
+		fmt.Fprintf(out, "%s, i.e: %s\n\nUsage: %s [flags]", command.Summary, command.Example, name)
+		if command.Args != "" {
+			fmt.Fprintf(out, " %s", command.Args)
+		}
+		fmt.Fprint(out, "\n\nFlags:\n")
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// Parse parses flags those could be interspersed with the positional arguments,
+// i.e: search "iphone" --size=5. Arguments after -- are all positional
+func Parse(flags *flag.FlagSet, args []string) (positional []string) {
+	for {
+		flags.Parse(args)
+		rest := flags.Args()
+		if len(rest) == 0 {
+			return
+		}
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, rest...)
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
 func PrintDefault() {
-	fmt.Println(defaultCommands)
+	fmt.Print("\nkraicklist, a Search Ads Application\n\nCommands:\n")
+	for _, command := range Commands {
+		fmt.Printf(" %-8s| %s, i.e: %s\n", command.Name, command.Summary, command.Example)
+	}
+	fmt.Print("\nRun go run main.go COMMAND --help for the command flags\n")
+}
+
+// StringList is a flag which could be repeated, i.e: --tag=a --tag=b
+type StringList []string
+
+func (s *StringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *StringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
 }
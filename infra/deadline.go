@@ -0,0 +1,34 @@
+package infra
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/isdzulqor/kraicklist/helper/logging"
+)
+
+// Deadline works as middleware, it sets the read and write deadlines of the request, zero clears them.
+// The deadlines are set on every request as the connection keeps the ones of its previous request,
+// the deadline of a route overrides the one of its router
+func Deadline(read, write time.Duration) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			controller := http.NewResponseController(w)
+			now := time.Now()
+			if err := controller.SetReadDeadline(deadlineAt(now, read)); err != nil {
+				logging.DebugContext(r.Context(), "read deadline is not set, err: %v", err)
+			}
+			if err := controller.SetWriteDeadline(deadlineAt(now, write)); err != nil {
+				logging.DebugContext(r.Context(), "write deadline is not set, err: %v", err)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func deadlineAt(now time.Time, timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return now.Add(timeout)
+}
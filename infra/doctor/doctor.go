@@ -0,0 +1,338 @@
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/isdzulqor/kraicklist/config"
+	"github.com/isdzulqor/kraicklist/external/engine"
+	"github.com/isdzulqor/kraicklist/external/index"
+	"github.com/isdzulqor/kraicklist/helper/logging"
+	"github.com/isdzulqor/kraicklist/infra/cli"
+	"github.com/isdzulqor/kraicklist/infra/seed"
+)
+
+const (
+	statusOK   = "ok"
+	statusWarn = "warn"
+	statusFail = "fail"
+
+	checkTimeout = 10 * time.Second
+)
+
+// expectedElasticMapping is the field types the search, filters and stats rely on
+var expectedElasticMapping = map[string]string{
+	"id":           "long",
+	"title":        "text",
+	"content":      "text",
+	"thumb_url":    "text",
+	"tags":         "text",
+	"tags.keyword": "keyword",
+	"image_urls":   "text",
+	"updated_at":   "long",
+}
+
+// result is the outcome of a check, Hint tells how to fix it
+type result struct {
+	Name    string
+	Status  string
+	Message string
+	Hint    string
+}
+
+// Exec validates the config and the environment, it exits with status 1 when any check fails
+func Exec(args []string) {
+	flags := cli.NewFlagSet(cli.CmdDoctor)
+	cli.Parse(flags, args)
+
+	// only the doctor output is printed
+	logging.Init(logging.Fatal)
+
+	ctx := context.Background()
+	conf, results := checkConfig()
+	if conf != nil {
+		results = append(results, checkMasterData(ctx, conf))
+		results = append(results, checkJobDir(conf))
+		switch conf.IndexerActivated {
+		case index.IndexBleve:
+			results = append(results, checkBleve(ctx, conf)...)
+		case index.IndexElastic:
+			results = append(results, checkElastic(ctx, conf)...)
+		default:
+			if engine.IsRegistered(conf.IndexerActivated) {
+				results = append(results, checkBackend(ctx, conf)...)
+			}
+		}
+	}
+
+	failed := printResults(results)
+	if failed > 0 {
+		fmt.Printf("\n\033[31m%d of %d checks failed\033[0m\n", failed, len(results))
+		os.Exit(1)
+	}
+	fmt.Printf("\n\033[32mAll %d checks passed\033[0m\n", len(results))
+}
+
+func checkConfig() (*config.Config, []result) {
+	conf, err := config.Load()
+	if err != nil {
+		return nil, []result{{
+			Name:    "config",
+			Status:  statusFail,
+			Message: err.Error(),
+			Hint:    "fix the env var value on the environment or .env file",
+		}}
+	}
+
+	problems := conf.Validate()
+	if conf.IndexerActivated != "" && !engine.IsRegistered(conf.IndexerActivated) {
+		problems = append(problems, fmt.Sprintf("INDEXER_ACTIVATED %q must be one of %s",
+			conf.IndexerActivated, strings.Join(engine.Names(), ", ")))
+	}
+	if conf.Failover.Indexer != "" && !engine.IsRegistered(conf.Failover.Indexer) {
+		problems = append(problems, fmt.Sprintf("FAILOVER_INDEXER %q must be one of %s",
+			conf.Failover.Indexer, strings.Join(engine.Names(), ", ")))
+	}
+	sources, _ := conf.FederationSources()
+	for _, source := range sources {
+		if !engine.IsRegistered(source.Indexer) {
+			problems = append(problems, fmt.Sprintf("FEDERATION_SOURCES indexer %q must be one of %s",
+				source.Indexer, strings.Join(engine.Names(), ", ")))
+		}
+	}
+	if conf.Shadow.Indexer != "" && !engine.IsRegistered(conf.Shadow.Indexer) {
+		problems = append(problems, fmt.Sprintf("SHADOW_INDEXER %q must be one of %s",
+			conf.Shadow.Indexer, strings.Join(engine.Names(), ", ")))
+	}
+	if len(problems) == 0 {
+		return conf, []result{{Name: "config", Status: statusOK, Message: "all values are valid"}}
+	}
+	results := make([]result, 0, len(problems))
+	for _, problem := range problems {
+		results = append(results, result{
+			Name:    "config",
+			Status:  statusFail,
+			Message: problem,
+			Hint:    "fix the env var value on the environment or .env file",
+		})
+	}
+	return conf, results
+}
+
+var errFirstRecord = fmt.Errorf("first record is read")
+
+func checkMasterData(ctx context.Context, conf *config.Config) result {
+	out := result{Name: "master data"}
+	path := conf.Advertisement.MasterDataPath
+	hint := "set ADVERTISEMENT_MASTER_DATA_PATH to a readable jsonl, json or csv file, optionally gzip or zstd compressed"
+
+	loader, err := seed.NewLoader(seed.FormatAuto, "", "|")
+	if err != nil {
+		out.Status, out.Message = statusFail, err.Error()
+		return out
+	}
+
+	var first *seed.Record
+	err = loader.LoadFile(ctx, path, seed.Position{},
+		func(record seed.Record) error {
+			first = &record
+			return errFirstRecord
+		},
+		func(skipped seed.SkippedRecord) error {
+			return fmt.Errorf("bad record %v", skipped)
+		})
+	switch {
+	case err != nil && err != errFirstRecord:
+		out.Status, out.Message, out.Hint = statusFail, fmt.Sprintf("%s is not readable, %v", path, err), hint
+	case first == nil:
+		out.Status, out.Message, out.Hint = statusWarn, fmt.Sprintf("%s has no records", path), hint
+	default:
+		out.Status, out.Message = statusOK, fmt.Sprintf("%s is readable, the first ad ID is %d", path, first.Ad.ID)
+	}
+	return out
+}
+
+func checkJobDir(conf *config.Config) result {
+	out := result{Name: "job dir"}
+	dir := conf.Job.Dir
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		out.Status, out.Message = statusFail, fmt.Sprintf("%s can't be created, %v", dir, err)
+		out.Hint = "set JOB_DIR to a writable directory"
+		return out
+	}
+	tmp, err := ioutil.TempFile(dir, ".doctor-*")
+	if err != nil {
+		out.Status, out.Message = statusFail, fmt.Sprintf("%s is not writable, %v", dir, err)
+		out.Hint = "set JOB_DIR to a writable directory"
+		return out
+	}
+	tmp.Close()
+	os.Remove(tmp.Name())
+	out.Status, out.Message = statusOK, fmt.Sprintf("%s is writable", dir)
+	return out
+}
+
+func checkBleve(ctx context.Context, conf *config.Config) []result {
+	name := conf.Advertisement.Bleve.IndexName
+	path := index.BleveDocPath(name)
+	if _, err := os.Stat(filepath.Join(path, "index_meta.json")); err != nil {
+		return []result{{
+			Name:    "bleve index",
+			Status:  statusFail,
+			Message: fmt.Sprintf("%s doesn't exist, the API would create a new empty index", path),
+			Hint:    "check ADVERTISEMENT_BLEVE_INDEX_NAME for typos or run: go run main.go seed",
+		}}
+	}
+
+	bleveIndex, err := index.InitBleveIndexReadOnly(ctx, name, 3*time.Second)
+	if err != nil {
+		return []result{{
+			Name:    "bleve index",
+			Status:  statusFail,
+			Message: err.Error(),
+			Hint:    "stop the process holding the index or reseed it when it's corrupted",
+		}}
+	}
+	defer bleveIndex.Close()
+
+	results := []result{{Name: "bleve index", Status: statusOK, Message: fmt.Sprintf("%s is openable", path)}}
+	count, err := bleveIndex.DocCount()
+	switch {
+	case err != nil:
+		results = append(results, result{Name: "bleve docs", Status: statusFail, Message: err.Error(),
+			Hint: "reseed the index: go run main.go seed"})
+	case count == 0:
+		results = append(results, result{Name: "bleve docs", Status: statusFail, Message: "the index is empty",
+			Hint: "run: go run main.go seed"})
+	default:
+		results = append(results, result{Name: "bleve docs", Status: statusOK, Message: fmt.Sprintf("%d docs", count)})
+	}
+	return results
+}
+
+// checkBackend checks a backend which has no specific checks by opening, pinging and counting it
+func checkBackend(ctx context.Context, conf *config.Config) []result {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	name := conf.IndexerActivated
+	searchBackend, err := engine.Open(ctx, name, conf, engine.Options{ReadOnly: true})
+	if err != nil {
+		return []result{{Name: name, Status: statusFail, Message: err.Error(),
+			Hint: "check the config of the backend or run: go run main.go seed"}}
+	}
+	defer searchBackend.Close()
+
+	if err = searchBackend.Ping(ctx); err != nil {
+		return []result{{Name: name, Status: statusFail, Message: err.Error(),
+			Hint: "check the config of the backend"}}
+	}
+	results := []result{{Name: name, Status: statusOK,
+		Message: fmt.Sprintf("%s is reachable", searchBackend.IndexName())}}
+
+	count, err := searchBackend.Count(ctx)
+	switch {
+	case err != nil:
+		results = append(results, result{Name: name + " docs", Status: statusFail, Message: err.Error()})
+	case count == 0:
+		results = append(results, result{Name: name + " docs", Status: statusFail, Message: "the index is empty",
+			Hint: "run: go run main.go seed"})
+	default:
+		results = append(results, result{Name: name + " docs", Status: statusOK, Message: fmt.Sprintf("%d docs", count)})
+	}
+	return results
+}
+
+func checkElastic(ctx context.Context, conf *config.Config) []result {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	esIndex, err := index.InitESIndex(ctx, conf.Elastic.Host, conf.Elastic.Username, conf.Elastic.Password,
+		conf.Advertisement.Elastic.IndexName, index.ElasticBulkConfig{})
+	if err != nil {
+		return []result{{Name: "elastic", Status: statusFail, Message: err.Error(), Hint: "check ELASTIC_HOST"}}
+	}
+	if err = esIndex.Ping(); err != nil {
+		return []result{{
+			Name:    "elastic",
+			Status:  statusFail,
+			Message: err.Error(),
+			Hint:    "check ELASTIC_HOST is reachable and ELASTIC_USERNAME and ELASTIC_PASSWORD are correct",
+		}}
+	}
+	results := []result{{Name: "elastic", Status: statusOK,
+		Message: fmt.Sprintf("%s is reachable", strings.Join(conf.Elastic.Host, ", "))}}
+
+	indexName := conf.Advertisement.Elastic.IndexName
+	fieldTypes, found, err := esIndex.FieldTypes(ctx)
+	switch {
+	case err != nil:
+		return append(results, result{Name: "elastic mapping", Status: statusFail, Message: err.Error(),
+			Hint: "check the user has access to the index"})
+	case !found:
+		return append(results, result{Name: "elastic mapping", Status: statusFail,
+			Message: fmt.Sprintf("index %s doesn't exist", indexName),
+			Hint:    "check ADVERTISEMENT_ELASTIC_INDEX_NAME for typos or run: go run main.go seed"})
+	}
+	results = append(results, compareMapping(indexName, fieldTypes))
+
+	count, err := esIndex.Count(ctx)
+	switch {
+	case err != nil:
+		results = append(results, result{Name: "elastic docs", Status: statusFail, Message: err.Error()})
+	case count == 0:
+		results = append(results, result{Name: "elastic docs", Status: statusFail, Message: "the index is empty",
+			Hint: "run: go run main.go seed"})
+	default:
+		results = append(results, result{Name: "elastic docs", Status: statusOK, Message: fmt.Sprintf("%d docs", count)})
+	}
+	return results
+}
+
+func compareMapping(indexName string, fieldTypes map[string]string) result {
+	var mismatches []string
+	for field, expected := range expectedElasticMapping {
+		actual, ok := fieldTypes[field]
+		switch {
+		case !ok:
+			mismatches = append(mismatches, fmt.Sprintf("%s is missing", field))
+		case actual != expected:
+			mismatches = append(mismatches, fmt.Sprintf("%s is %s instead of %s", field, actual, expected))
+		}
+	}
+	if len(mismatches) == 0 {
+		return result{Name: "elastic mapping", Status: statusOK, Message: fmt.Sprintf("index %s matches", indexName)}
+	}
+	sort.Strings(mismatches)
+	return result{
+		Name:    "elastic mapping",
+		Status:  statusFail,
+		Message: fmt.Sprintf("index %s differs, %s", indexName, strings.Join(mismatches, "; ")),
+		Hint:    "recreate the index: go run main.go seed, or migrate it to a new index",
+	}
+}
+
+// printResults prints every check result and returns the number of failed ones
+func printResults(results []result) (failed int) {
+	for _, r := range results {
+		switch r.Status {
+		case statusOK:
+			fmt.Printf("\033[32m[ ok ]\033[0m %s: %s\n", r.Name, r.Message)
+		case statusWarn:
+			fmt.Printf("\033[33m[warn]\033[0m %s: %s\n", r.Name, r.Message)
+		default:
+			failed++
+			fmt.Printf("\033[31m[fail]\033[0m %s: %s\n", r.Name, r.Message)
+		}
+		if r.Hint != "" && r.Status != statusOK {
+			fmt.Printf("       \033[36mhint:\033[0m %s\n", r.Hint)
+		}
+	}
+	return
+}
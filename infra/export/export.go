@@ -0,0 +1,182 @@
+package export
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/isdzulqor/kraicklist/config"
+	"github.com/isdzulqor/kraicklist/domain/model"
+	"github.com/isdzulqor/kraicklist/domain/repository"
+	"github.com/isdzulqor/kraicklist/external/engine"
+	"github.com/isdzulqor/kraicklist/helper/logging"
+	"github.com/isdzulqor/kraicklist/infra/backend"
+	"github.com/isdzulqor/kraicklist/infra/cli"
+)
+
+const stdoutOutput = "-"
+
+type options struct {
+	output       string
+	tags         cli.StringList
+	updatedSince string
+	updatedUntil string
+	pageSize     int
+	tenant       string
+}
+
+func parseOptions(conf *config.Config, args []string) (opts options) {
+	flags := cli.NewFlagSet(cli.CmdExport)
+	flags.StringVar(&opts.output, "output", "./data/export.jsonl.gz", "gzip jsonl file path, or - to write to stdout")
+	flags.Var(&opts.tags, "tag", "only export ads having the tag, could be repeated to match any of them")
+	flags.StringVar(&opts.updatedSince, "updated-since", "",
+		"only export ads updated at or after, unix timestamp, RFC3339 or 2006-01-02")
+	flags.StringVar(&opts.updatedUntil, "updated-until", "",
+		"only export ads updated at or before, unix timestamp, RFC3339 or 2006-01-02")
+	flags.IntVar(&opts.pageSize, "page-size", conf.Advertisement.Bulk.BatchSize, "number of ads read per page")
+	flags.StringVar(&opts.tenant, "tenant", "", "tenant whose indices are used, the default indices when it's empty")
+	cli.Parse(flags, args)
+	return
+}
+
+// Exec dumps the ads of the activated indexer to gzip jsonl which could be seeded back
+func Exec(args []string) {
+	conf := config.Get()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopChan := make(chan os.Signal, 1)
+	signal.Notify(stopChan, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stopChan
+		cancel()
+	}()
+
+	logging.Init(strings.ToUpper(conf.LogLevel))
+
+	opts := parseOptions(conf, args)
+	if opts.pageSize <= 0 {
+		logging.FatalContext(ctx, "page size must be greater than 0")
+	}
+	filter, err := opts.filter()
+	if err != nil {
+		logging.FatalContext(ctx, "%v", err)
+	}
+
+	if conf, err = backend.TenantConfig(conf, opts.tenant); err != nil {
+		logging.FatalContext(ctx, "%v", err)
+	}
+
+	start := time.Now()
+	count, err := run(ctx, conf, filter, opts)
+	if err != nil {
+		logging.FatalContext(ctx, "export is failed, err: %v", err)
+	}
+	logging.InfoContext(ctx, "exported %d ads to %s in %s", count, opts.output, time.Since(start))
+}
+
+// run exports the ads of the activated indexer. The index is opened read only, so a missing index is failed
+// instead of created empty, and a locked one is given up instead of waited for
+func run(ctx context.Context, conf *config.Config, filter model.AdFilter, opts options) (count int, err error) {
+	adRepo, closeIndex, err := backend.InitAdvertisement(ctx, conf, conf.IndexerActivated, engine.Options{ReadOnly: true})
+	if err != nil {
+		return
+	}
+	defer closeIndex()
+	return exportTo(ctx, adRepo, filter, opts)
+}
+
+func (opts options) filter() (filter model.AdFilter, err error) {
+	filter.Tags = opts.tags
+	if filter.UpdatedSince, err = parseTimestamp(opts.updatedSince); err != nil {
+		err = fmt.Errorf("invalid --updated-since, %v", err)
+		return
+	}
+	if filter.UpdatedUntil, err = parseTimestamp(opts.updatedUntil); err != nil {
+		err = fmt.Errorf("invalid --updated-until, %v", err)
+	}
+	return
+}
+
+// parseTimestamp parses unix timestamp, RFC3339 or date to unix timestamp, empty value is 0
+func parseTimestamp(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if unix, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return unix, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.Unix(), nil
+		}
+	}
+	return 0, fmt.Errorf("%s is not a unix timestamp, RFC3339 or 2006-01-02 date", value)
+}
+
+// exportTo writes the ads to the output, a file is written to a temp file first
+// so an interrupted export doesn't leave a truncated file
+func exportTo(ctx context.Context, adRepo *repository.Advertisement, filter model.AdFilter,
+	opts options) (count int, err error) {
+	if opts.output == stdoutOutput {
+		return writeAds(ctx, adRepo, filter, opts.pageSize, os.Stdout)
+	}
+
+	if err = os.MkdirAll(filepath.Dir(opts.output), 0755); err != nil {
+		return
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(opts.output), filepath.Base(opts.output)+".*.tmp")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	if count, err = writeAds(ctx, adRepo, filter, opts.pageSize, tmp); err != nil {
+		tmp.Close()
+		return
+	}
+	if err = tmp.Close(); err != nil {
+		return
+	}
+	err = os.Rename(tmp.Name(), opts.output)
+	return
+}
+
+// writeAds writes the ads as gzip jsonl, the same format as the seed input
+func writeAds(ctx context.Context, adRepo *repository.Advertisement, filter model.AdFilter, pageSize int,
+	w io.Writer) (count int, err error) {
+	buffered := bufio.NewWriter(w)
+	gz := gzip.NewWriter(buffered)
+	encoder := json.NewEncoder(gz)
+	encoder.SetEscapeHTML(false)
+
+	err = adRepo.ScanAds(ctx, filter, pageSize, func(ads model.Advertisements) error {
+		for _, ad := range ads {
+			if err := encoder.Encode(ad); err != nil {
+				return err
+			}
+		}
+		count += len(ads)
+		logging.DebugContext(ctx, "exported %d ads", count)
+		return ctx.Err()
+	})
+	if err != nil {
+		return
+	}
+	if err = gz.Close(); err != nil {
+		return
+	}
+	err = buffered.Flush()
+	return
+}
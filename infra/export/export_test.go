@@ -0,0 +1,45 @@
+package export
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/isdzulqor/kraicklist/config"
+	"github.com/isdzulqor/kraicklist/domain/model"
+	"github.com/isdzulqor/kraicklist/external/index"
+)
+
+func TestRunFailsOnMissingIndex(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		indexer string
+		// missing is the index path which must not be created
+		missing string
+	}{
+		{name: "bleve", indexer: "bleve", missing: index.BleveDocPath("missing-export-test.bleve")},
+		{name: "memory", indexer: "memory", missing: filepath.Join(dir, "missing.memory.gz")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &config.Config{IndexerActivated: tt.indexer}
+			conf.Advertisement.Bleve.IndexName = "missing-export-test.bleve"
+			conf.Advertisement.Memory.SnapshotPath = filepath.Join(dir, "missing.memory.gz")
+			output := filepath.Join(dir, tt.name+".jsonl.gz")
+
+			count, err := run(context.Background(), conf, model.AdFilter{}, options{output: output, pageSize: 10})
+			if err == nil {
+				t.Fatalf("expected the export to be failed, exported %d ads", count)
+			}
+			if _, statErr := os.Stat(output); !os.IsNotExist(statErr) {
+				t.Errorf("expected no export file, stat err: %v", statErr)
+			}
+			if _, statErr := os.Stat(tt.missing); !os.IsNotExist(statErr) {
+				os.RemoveAll(tt.missing)
+				t.Errorf("expected the missing index %s not to be created, stat err: %v", tt.missing, statErr)
+			}
+		})
+	}
+}
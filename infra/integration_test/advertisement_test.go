@@ -70,9 +70,12 @@ func (suite *IntegrationTestSuite) TestSeedAndSearch() {
 			Content: randomizeString(100),
 		},
 	}
-	result, err := suite.hitIndexDocs(data)
+	statuses, err := suite.hitIndexDocs(data)
 	assert.NoError(suite.T(), err, "should not error out")
-	assert.Equal(suite.T(), result, "success")
+	assert.Len(suite.T(), statuses, len(data))
+	for _, status := range statuses {
+		assert.Equal(suite.T(), model.IndexStatusIndexed, status.Status)
+	}
 
 	time.Sleep(3 * time.Second)
 
@@ -120,7 +123,7 @@ func (suite *IntegrationTestSuite) hitSearch(q string) (adsResult model.Advertis
 	return
 }
 
-func (suite *IntegrationTestSuite) hitIndexDocs(adsData model.Advertisements) (data string, err error) {
+func (suite *IntegrationTestSuite) hitIndexDocs(adsData model.Advertisements) (data model.AdIndexStatuses, err error) {
 	url := suite.host + "/api/advertisement/index"
 
 	client := &http.Client{}
@@ -137,8 +140,12 @@ func (suite *IntegrationTestSuite) hitIndexDocs(adsData model.Advertisements) (d
 		return
 	}
 	defer res.Body.Close()
-	result := map[string]string{}
-	if err = json.NewDecoder(res.Body).Decode(&result); err != nil {
+	if res.StatusCode != http.StatusOK {
+		err = fmt.Errorf("unexpected status code %d", res.StatusCode)
+	}
+	result := map[string]model.AdIndexStatuses{}
+	if decodeErr := json.NewDecoder(res.Body).Decode(&result); decodeErr != nil {
+		err = decodeErr
 		return
 	}
 	data = result["data"]
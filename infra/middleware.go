@@ -1,7 +1,6 @@
 package infra
 
 import (
-	"context"
 	"net/http"
 	"time"
 
@@ -35,7 +34,7 @@ func LoggingHandler(next http.Handler) http.Handler {
 			reqID = uuid.UUIDv4()
 		}
 
-		ctx := logging.WithRequestIDContext(context.Background(), reqID)
+		ctx := logging.WithRequestIDContext(r.Context(), reqID)
 
 		start := time.Now()
 		logging.InfoContext(ctx, "Requesting "+r.Method+" "+r.URL.Path)
@@ -94,3 +93,15 @@ func (c *responseLog) Write(b []byte) (int, error) {
 	c.body = b
 	return c.ResponseWriter.Write(b)
 }
+
+// Unwrap lets http.ResponseController reach the wrapped ResponseWriter, i.e: to enable full duplex
+func (c *responseLog) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// Flush lets the wrapped ResponseWriter to stream the response
+func (c *responseLog) Flush() {
+	if flusher, ok := c.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
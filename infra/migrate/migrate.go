@@ -0,0 +1,304 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/isdzulqor/kraicklist/config"
+	"github.com/isdzulqor/kraicklist/domain/model"
+	"github.com/isdzulqor/kraicklist/domain/repository"
+	"github.com/isdzulqor/kraicklist/external/engine"
+	"github.com/isdzulqor/kraicklist/helper/errors"
+	"github.com/isdzulqor/kraicklist/helper/logging"
+	"github.com/isdzulqor/kraicklist/infra/backend"
+	"github.com/isdzulqor/kraicklist/infra/cli"
+	"github.com/isdzulqor/kraicklist/infra/seed"
+)
+
+const (
+	MismatchMissingOnTarget = "missing_on_target"
+	MismatchMissingOnSource = "missing_on_source"
+	MismatchChecksum        = "checksum_mismatch"
+)
+
+type options struct {
+	from       string
+	to         string
+	batchSize  int
+	sampleSize int
+	recreate   bool
+	allowEmpty bool
+	reportPath string
+	tenant     string
+}
+
+func parseOptions(conf *config.Config, args []string) (opts options) {
+	flags := cli.NewFlagSet(cli.CmdMigrate)
+	flags.StringVar(&opts.from, "from", "", "source indexer, i.e: bleve | elastic")
+	flags.StringVar(&opts.to, "to", "", "target indexer, i.e: bleve | elastic")
+	flags.IntVar(&opts.batchSize, "batch-size", conf.Advertisement.Bulk.BatchSize, "number of ads copied per batch")
+	flags.IntVar(&opts.sampleSize, "sample-size", 100, "number of random ads whose checksums are verified on both sides")
+	flags.BoolVar(&opts.recreate, "recreate", false, "delete the target index before copying")
+	flags.BoolVar(&opts.allowEmpty, "allow-empty", false, "verify the migration of an empty source")
+	flags.StringVar(&opts.reportPath, "report", "./data/migrate.report.json", "file to write the verification report as json")
+	flags.StringVar(&opts.tenant, "tenant", "", "tenant whose indices are copied, the default indices when it's empty")
+	cli.Parse(flags, args)
+	return
+}
+
+// Exec copies all ads from one indexer to the other then verifies both sides
+func Exec(args []string) {
+	conf := config.Get()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopChan := make(chan os.Signal, 1)
+	signal.Notify(stopChan, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stopChan
+		cancel()
+	}()
+
+	logging.Init(strings.ToUpper(conf.LogLevel))
+
+	opts := parseOptions(conf, args)
+	if err := opts.validate(); err != nil {
+		logging.FatalContext(ctx, "%v", err)
+	}
+
+	conf, err := backend.TenantConfig(conf, opts.tenant)
+	if err != nil {
+		logging.FatalContext(ctx, "%v", err)
+	}
+	source, target, closeAll, err := openIndices(ctx, conf, opts)
+	if err != nil {
+		logging.FatalContext(ctx, "%v", err)
+	}
+	defer closeAll()
+
+	report, err := migrate(ctx, source, target, opts)
+	report.FinishedAt = time.Now()
+	if err != nil {
+		report.Error = err.Error()
+	}
+	report.print()
+	if writeErr := seed.WriteJSONFile(opts.reportPath, report); writeErr != nil {
+		logging.ErrContext(ctx, "%v", writeErr)
+	}
+
+	if err != nil || !report.Verified {
+		closeAll()
+		logging.FatalContext(ctx, "migration from %s to %s is not verified, see %s", opts.from, opts.to, opts.reportPath)
+	}
+	logging.InfoContext(ctx, "migration from %s to %s is finished and verified", opts.from, opts.to)
+}
+
+// openIndices opens the source read only, so a missing source is failed instead of created empty.
+// The target is written through the store when it's enabled, so the store and its changes know the copied ads
+func openIndices(ctx context.Context, conf *config.Config,
+	opts options) (source, target *repository.Advertisement, closeAll func(), err error) {
+	source, closeSource, err := backend.InitAdvertisement(ctx, conf, opts.from, engine.Options{ReadOnly: true})
+	if err != nil {
+		return
+	}
+	target, closeTarget, err := backend.InitAdvertisementWithStore(ctx, conf, opts.to,
+		engine.Options{RecreateIndex: opts.recreate})
+	if err != nil {
+		closeSource()
+		return
+	}
+	closeAll = func() {
+		closeSource()
+		closeTarget()
+	}
+
+	// the recreated target gets the ads on the store back first, so it matches its checkpoint
+	if err = target.EnsureIndex(ctx, opts.batchSize); err != nil {
+		closeAll()
+	}
+	return
+}
+
+func (opts options) validate() error {
+	for _, indexer := range []string{opts.from, opts.to} {
+		if !engine.IsRegistered(indexer) {
+			return fmt.Errorf("--from and --to must be one of %s", strings.Join(engine.Names(), ", "))
+		}
+	}
+	if opts.from == opts.to {
+		return fmt.Errorf("--from and --to must be different indexers")
+	}
+	if opts.batchSize <= 0 {
+		return fmt.Errorf("batch size must be greater than 0")
+	}
+	if opts.sampleSize < 0 {
+		return fmt.Errorf("sample size can't be negative")
+	}
+	return nil
+}
+
+// Mismatch is a sampled ad which differs between the source and the target
+type Mismatch struct {
+	ID     int64  `json:"id"`
+	Reason string `json:"reason"`
+}
+
+// Report is the copying result and its verification
+type Report struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Read    int    `json:"read"`
+	Written int    `json:"written"`
+	Failed  int    `json:"failed"`
+
+	SourceCount int64      `json:"source_count"`
+	TargetCount int64      `json:"target_count"`
+	Sampled     int        `json:"sampled"`
+	Mismatches  []Mismatch `json:"mismatches,omitempty"`
+	Verified    bool       `json:"verified"`
+
+	// Failures is failed doc IDs grouped by the reason
+	Failures errors.Failures `json:"failures,omitempty"`
+	Error    string          `json:"error,omitempty"`
+
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+func (r Report) print() {
+	fmt.Printf("\n\033[36mMigration report: \033[0m%s to %s, read %d, written %d, failed %d, took %s\n",
+		r.From, r.To, r.Read, r.Written, r.Failed, r.FinishedAt.Sub(r.StartedAt).Round(time.Millisecond))
+	fmt.Printf("\033[36mDoc count: \033[0m%s %d, %s %d\n", r.From, r.SourceCount, r.To, r.TargetCount)
+	fmt.Printf("\033[36mSampled checksums: \033[0m%d, %d mismatches\n", r.Sampled, len(r.Mismatches))
+	for _, mismatch := range r.Mismatches {
+		fmt.Printf("  %d: %s\n", mismatch.ID, mismatch.Reason)
+	}
+	seed.PrintFailureSummary(r.Read, r.Failed, r.Failures)
+
+	if r.Verified {
+		fmt.Println("\033[32mVerified\033[0m")
+		return
+	}
+	fmt.Println("\033[31mNot verified\033[0m")
+}
+
+func migrate(ctx context.Context, source, target *repository.Advertisement, opts options) (report Report, err error) {
+	report = Report{From: opts.from, To: opts.to, StartedAt: time.Now()}
+	sampler := newSampler(opts.sampleSize)
+
+	logging.InfoContext(ctx, "copying ads from %s to %s...", opts.from, opts.to)
+	err = source.ScanAds(ctx, model.AdFilter{}, opts.batchSize, func(ads model.Advertisements) error {
+		report.Read += len(ads)
+		for _, ad := range ads {
+			sampler.add(ad.ID)
+		}
+
+		indexErr := target.IndexAds(ctx, ads)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		report.addBatch(ads, indexErr)
+		logging.DebugContext(ctx, "copied %d ads", report.Written)
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	logging.InfoContext(ctx, "verifying ads on %s against %s...", opts.to, opts.from)
+	if report.SourceCount, err = source.CountAds(ctx); err != nil {
+		return
+	}
+	if report.TargetCount, err = target.CountAds(ctx); err != nil {
+		return
+	}
+	if report.Mismatches, err = compareSamples(ctx, source, target, sampler.ids); err != nil {
+		return
+	}
+	report.Sampled = len(sampler.ids)
+	// nothing copied is rather a wrong source than a migration, unless it's allowed
+	report.Verified = report.Failed == 0 && report.SourceCount == report.TargetCount && len(report.Mismatches) == 0 &&
+		(report.Read > 0 || opts.allowEmpty)
+	return
+}
+
+// compareSamples compares checksums of the sampled ads on both sides
+func compareSamples(ctx context.Context, source, target *repository.Advertisement,
+	ids []int64) (mismatches []Mismatch, err error) {
+	if len(ids) == 0 {
+		return
+	}
+	sourceAds, err := source.GetAds(ctx, ids)
+	if err != nil {
+		return
+	}
+	targetAds, err := target.GetAds(ctx, ids)
+	if err != nil {
+		return
+	}
+
+	sourceSums := checksums(sourceAds)
+	targetSums := checksums(targetAds)
+	for _, id := range ids {
+		sourceSum, onSource := sourceSums[id]
+		targetSum, onTarget := targetSums[id]
+		switch {
+		case !onSource:
+			mismatches = append(mismatches, Mismatch{ID: id, Reason: MismatchMissingOnSource})
+		case !onTarget:
+			mismatches = append(mismatches, Mismatch{ID: id, Reason: MismatchMissingOnTarget})
+		case sourceSum != targetSum:
+			mismatches = append(mismatches, Mismatch{ID: id, Reason: MismatchChecksum})
+		}
+	}
+	return
+}
+
+func checksums(ads model.Advertisements) map[int64]string {
+	out := make(map[int64]string, len(ads))
+	for _, ad := range ads {
+		out[ad.ID] = ad.Checksum()
+	}
+	return out
+}
+
+// addBatch counts the writing result of the batch,
+// the whole batch is counted as failed when the target fails entirely
+func (r *Report) addBatch(batch model.Advertisements, err error) {
+	failed := r.Failures.Add("", len(batch), func(i int) string { return fmt.Sprint(batch[i].ID) }, err)
+	r.Failed += failed
+	r.Written += len(batch) - failed
+}
+
+// sampler keeps a uniform random sample of the IDs with reservoir sampling
+type sampler struct {
+	size int
+	seen int
+	ids  []int64
+	rand *rand.Rand
+}
+
+func newSampler(size int) *sampler {
+	return &sampler{
+		size: size,
+		ids:  make([]int64, 0, size),
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (s *sampler) add(id int64) {
+	s.seen++
+	if len(s.ids) < s.size {
+		s.ids = append(s.ids, id)
+		return
+	}
+	if i := s.rand.Intn(s.seen); i < s.size {
+		s.ids[i] = id
+	}
+}
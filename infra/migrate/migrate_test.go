@@ -0,0 +1,118 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/isdzulqor/kraicklist/config"
+	"github.com/isdzulqor/kraicklist/domain/model"
+	"github.com/isdzulqor/kraicklist/domain/repository"
+	"github.com/isdzulqor/kraicklist/external/engine"
+	"github.com/isdzulqor/kraicklist/infra/backend"
+)
+
+func testAds(n int) model.Advertisements {
+	ads := make(model.Advertisements, 0, n)
+	for i := 1; i <= n; i++ {
+		ads = append(ads, model.Advertisement{ID: int64(i), Title: fmt.Sprintf("ad %d", i), UpdatedAt: int64(i)})
+	}
+	return ads
+}
+
+func openMemory(t *testing.T, conf *config.Config) *repository.Advertisement {
+	memory, err := engine.Open(context.Background(), "memory", conf, engine.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { memory.Close() })
+	return repository.InitAdvertisement(memory)
+}
+
+func TestMigrateVerifiesAnEmptySourceOnlyWhenAllowed(t *testing.T) {
+	tests := []struct {
+		name         string
+		ads          int
+		allowEmpty   bool
+		wantVerified bool
+	}{
+		{name: "copied ads", ads: 3, wantVerified: true},
+		{name: "empty source", ads: 0, wantVerified: false},
+		{name: "allowed empty source", ads: 0, allowEmpty: true, wantVerified: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			source, target := openMemory(t, &config.Config{}), openMemory(t, &config.Config{})
+			if tt.ads > 0 {
+				if err := source.IndexAds(ctx, testAds(tt.ads)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			report, err := migrate(ctx, source, target, options{
+				from: "memory", to: "memory", batchSize: 2, sampleSize: 10, allowEmpty: tt.allowEmpty,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Read != tt.ads || report.Verified != tt.wantVerified {
+				t.Fatalf("expected %d read and verified %v, got %d and %v", tt.ads, tt.wantVerified, report.Read, report.Verified)
+			}
+		})
+	}
+}
+
+func TestOpenIndices(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	conf := &config.Config{}
+	conf.Advertisement.Memory.SnapshotPath = filepath.Join(dir, "source.memory.gz")
+	conf.Advertisement.SQLite.Path = filepath.Join(dir, "target.sqlite")
+	conf.Store.Enabled = true
+	conf.Store.Path = filepath.Join(dir, "ads.db")
+	conf.Store.LockTimeout = time.Second
+	opts := options{from: "memory", to: "sqlite", batchSize: 2, sampleSize: 10}
+
+	t.Run("missing source", func(t *testing.T) {
+		if _, _, closeAll, err := openIndices(ctx, conf, opts); err == nil {
+			closeAll()
+			t.Fatal("expected the missing source to be failed")
+		}
+	})
+
+	// the source snapshot is written on close
+	source, err := engine.Open(ctx, "memory", conf, engine.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = source.Index(ctx, testAds(3)); err != nil {
+		t.Fatal(err)
+	}
+	if err = source.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("target is written through the store", func(t *testing.T) {
+		sourceRepo, targetRepo, closeAll, err := openIndices(ctx, conf, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		report, err := migrate(ctx, sourceRepo, targetRepo, opts)
+		closeAll()
+		if err != nil || !report.Verified {
+			t.Fatalf("expected a verified migration, got %+v, err: %v", report, err)
+		}
+
+		adStore, err := backend.OpenStore(conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer adStore.Close()
+		if stored, err := adStore.Count(); err != nil || stored != 3 {
+			t.Fatalf("expected 3 ads on the store, got %d, err: %v", stored, err)
+		}
+	})
+}
@@ -0,0 +1,58 @@
+package infra
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/isdzulqor/kraicklist/domain/service"
+	"github.com/isdzulqor/kraicklist/helper/errors"
+	"github.com/isdzulqor/kraicklist/helper/response"
+)
+
+// RateLimit works as middleware of a route, the request is taken from the budget of its client.
+// It's placed after RequireScope so the client is the authenticated key, otherwise the client ip
+func RateLimit(rateLimit *service.RateLimit, budget string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			decision := rateLimit.Allow(RateLimitClient(r, rateLimit.TrustProxy()), budget)
+			if decision.Limit > 0 {
+				w.Header().Set("X-RateLimit-Limit", strconv.Itoa(decision.Limit))
+				w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+				w.Header().Set("X-RateLimit-Reset", formatSeconds(decision.Reset))
+			}
+			if !decision.Allowed {
+				w.Header().Set("Retry-After", formatSeconds(decision.RetryAfter))
+				err := errors.ErrorTooManyRequests.AppendMessage(fmt.Sprintf("%s budget is exceeded, retry after %ss",
+					budget, formatSeconds(decision.RetryAfter)))
+				response.Failed(r.Context(), w, errors.GetStatusCode(err), err)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RateLimitClient is the authenticated key of the request, i.e: key:3f2a9c1d0b4e5f67, otherwise its ip,
+// i.e: ip:10.0.0.1. The ip is the first of X-Forwarded-For when the proxy is trusted
+func RateLimitClient(r *http.Request, trustProxy bool) string {
+	if key, ok := service.APIKeyFromContext(r.Context()); ok {
+		return "key:" + key.ID
+	}
+	if forwarded := r.Header.Get("X-Forwarded-For"); trustProxy && forwarded != "" {
+		return "ip:" + strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// formatSeconds rounds the duration up to the whole seconds
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10)
+}
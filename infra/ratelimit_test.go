@@ -0,0 +1,85 @@
+package infra
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/isdzulqor/kraicklist/config"
+	"github.com/isdzulqor/kraicklist/domain/model"
+	"github.com/isdzulqor/kraicklist/domain/repository"
+	"github.com/isdzulqor/kraicklist/domain/service"
+)
+
+func TestRateLimitHeaders(t *testing.T) {
+	conf := &config.Config{}
+	conf.RateLimit.Enabled = true
+	// a token per 1000s, so the refill while testing doesn't move the rounded seconds
+	conf.RateLimit.IndexRate, conf.RateLimit.IndexBurst = 0.001, 2
+	rateLimit := service.InitRateLimit(conf, repository.InitUsage(filepath.Join(t.TempDir(), "usage.json")))
+	handler := RateLimit(rateLimit, model.ScopeIndex)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name       string
+		wantStatus int
+		wantHeader map[string]string
+	}{
+		{
+			name:       "first request",
+			wantStatus: http.StatusOK,
+			wantHeader: map[string]string{"X-RateLimit-Limit": "2", "X-RateLimit-Remaining": "1", "X-RateLimit-Reset": "1000", "Retry-After": ""},
+		},
+		{
+			name:       "last of the burst",
+			wantStatus: http.StatusOK,
+			wantHeader: map[string]string{"X-RateLimit-Limit": "2", "X-RateLimit-Remaining": "0", "X-RateLimit-Reset": "2000", "Retry-After": ""},
+		},
+		{
+			name:       "over the limit",
+			wantStatus: http.StatusTooManyRequests,
+			wantHeader: map[string]string{"X-RateLimit-Limit": "2", "X-RateLimit-Remaining": "0", "X-RateLimit-Reset": "2000", "Retry-After": "1000"},
+		},
+	}
+	// the cases run in order on the same bucket
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/api/advertisement/index", nil))
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+			for name, want := range tt.wantHeader {
+				if got := recorder.Header().Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimitClient(t *testing.T) {
+	tests := []struct {
+		name       string
+		forwarded  string
+		trustProxy bool
+		want       string
+	}{
+		{name: "remote address", want: "ip:192.0.2.1"},
+		{name: "untrusted proxy", forwarded: "10.0.0.1", want: "ip:192.0.2.1"},
+		{name: "trusted proxy", forwarded: "10.0.0.1, 10.0.0.2", trustProxy: true, want: "ip:10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/advertisement/search", nil)
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if got := RateLimitClient(req, tt.trustProxy); got != tt.want {
+				t.Errorf("client = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
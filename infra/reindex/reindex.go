@@ -0,0 +1,107 @@
+package reindex
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/isdzulqor/kraicklist/config"
+	"github.com/isdzulqor/kraicklist/external/engine"
+	"github.com/isdzulqor/kraicklist/helper/errors"
+	"github.com/isdzulqor/kraicklist/helper/logging"
+	"github.com/isdzulqor/kraicklist/infra/backend"
+	"github.com/isdzulqor/kraicklist/infra/cli"
+	"github.com/isdzulqor/kraicklist/infra/seed"
+)
+
+type options struct {
+	to        string
+	recreate  bool
+	batchSize int
+	tenant    string
+}
+
+func parseOptions(conf *config.Config, args []string) (opts options) {
+	flags := cli.NewFlagSet(cli.CmdReindex)
+	flags.StringVar(&opts.to, "to", conf.IndexerActivated, "indexer to rebuild, i.e: bleve | elastic")
+	flags.BoolVar(&opts.recreate, "recreate", true, "delete the index before rebuilding it")
+	flags.IntVar(&opts.batchSize, "batch-size", conf.Advertisement.Bulk.BatchSize, "number of ads indexed per batch")
+	flags.StringVar(&opts.tenant, "tenant", "", "tenant whose indices are used, the default indices when it's empty")
+	cli.Parse(flags, args)
+	return
+}
+
+// Exec rebuilds the index of the indexer entirely from the store
+func Exec(args []string) {
+	conf := config.Get()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopChan := make(chan os.Signal, 1)
+	signal.Notify(stopChan, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stopChan
+		cancel()
+	}()
+
+	logging.Init(strings.ToUpper(conf.LogLevel))
+
+	opts := parseOptions(conf, args)
+	if !conf.Store.Enabled {
+		logging.FatalContext(ctx, "store is disabled, set STORE_ENABLED=true")
+	}
+	if !engine.IsRegistered(opts.to) {
+		logging.FatalContext(ctx, "--to must be one of %s", strings.Join(engine.Names(), ", "))
+	}
+	if opts.batchSize <= 0 {
+		logging.FatalContext(ctx, "batch size must be greater than 0")
+	}
+
+	conf, err := backend.TenantConfig(conf, opts.tenant)
+	if err != nil {
+		logging.FatalContext(ctx, "%v", err)
+	}
+	adRepo, closeIndex, err := backend.InitAdvertisementWithStore(ctx, conf, opts.to,
+		engine.Options{RecreateIndex: opts.recreate})
+	if err != nil {
+		logging.FatalContext(ctx, "%v", err)
+	}
+	defer closeIndex()
+
+	logging.InfoContext(ctx, "rebuilding %s index from %s...", opts.to, conf.Store.Path)
+	start := time.Now()
+	var (
+		indexed  int
+		failures = map[string][]string{}
+		failed   int
+	)
+	err = adRepo.ReindexFromStore(ctx, opts.batchSize, func(total int, docErrors errors.DocErrors) {
+		indexed = total
+		failed += len(docErrors)
+		for reason, docIDs := range docErrors.GroupByReason() {
+			failures[reason] = append(failures[reason], docIDs...)
+		}
+		logging.DebugContext(ctx, "indexed %d ads", indexed)
+	})
+	if err == nil {
+		// the changes written by the API meanwhile
+		if syncErr := adRepo.SyncIndex(ctx); syncErr != nil {
+			if _, ok := errors.AsDocErrors(syncErr); !ok {
+				err = syncErr
+			}
+		}
+	}
+
+	fmt.Printf("\n\033[36mReindex report: \033[0m%s from %s, indexed %d, failed %d, took %s\n",
+		opts.to, conf.Store.Path, indexed, failed, time.Since(start).Round(time.Millisecond))
+	seed.PrintFailureSummary(indexed+failed, failed, failures)
+	if err != nil {
+		closeIndex()
+		logging.FatalContext(ctx, "%v", err)
+	}
+	logging.InfoContext(ctx, "reindex is finished")
+}
@@ -0,0 +1,124 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"github.com/isdzulqor/kraicklist/config"
+	"github.com/isdzulqor/kraicklist/domain/model"
+	"github.com/isdzulqor/kraicklist/external/engine"
+	"github.com/isdzulqor/kraicklist/helper/logging"
+	"github.com/isdzulqor/kraicklist/infra/backend"
+	"github.com/isdzulqor/kraicklist/infra/cli"
+)
+
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+
+	maxTitleLength = 60
+)
+
+type options struct {
+	tags   cli.StringList
+	sort   string
+	size   int
+	format string
+	tenant string
+}
+
+func parseOptions(args []string) (keyword string, opts options) {
+	flags := cli.NewFlagSet(cli.CmdSearch)
+	flags.Var(&opts.tags, "tag", "only search ads having the tag, could be repeated to match any of them")
+	flags.StringVar(&opts.sort, "sort", model.SortRelevance, "relevance | newest | oldest")
+	flags.IntVar(&opts.size, "size", 20, "number of results")
+	flags.StringVar(&opts.format, "format", FormatTable, "table | json")
+	flags.StringVar(&opts.tenant, "tenant", "", "tenant whose indices are used, the default indices when it's empty")
+	keyword = strings.Join(cli.Parse(flags, args), " ")
+	if keyword == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+	return
+}
+
+// Exec runs the search on the configured index with the same repository as the API and prints the hits
+func Exec(args []string) {
+	conf := config.Get()
+	ctx := context.Background()
+
+	// keep the output clean for piping, only warnings are logged
+	logging.Init(logging.Warn)
+
+	keyword, opts := parseOptions(args)
+	switch opts.sort {
+	case model.SortRelevance, model.SortNewest, model.SortOldest:
+	default:
+		logging.FatalContext(ctx, "sort %s is invalid, use %s, %s or %s", opts.sort,
+			model.SortRelevance, model.SortNewest, model.SortOldest)
+	}
+	if opts.format != FormatTable && opts.format != FormatJSON {
+		logging.FatalContext(ctx, "format %s is invalid, use %s or %s", opts.format, FormatTable, FormatJSON)
+	}
+	if opts.size <= 0 {
+		logging.FatalContext(ctx, "size must be greater than 0")
+	}
+
+	conf, err := backend.TenantConfig(conf, opts.tenant)
+	if err != nil {
+		logging.FatalContext(ctx, "%v", err)
+	}
+	adRepo, closeIndex, err := backend.InitAdvertisement(ctx, conf, conf.IndexerActivated, engine.Options{ReadOnly: true})
+	if err != nil {
+		logging.FatalContext(ctx, "%v", err)
+	}
+	defer closeIndex()
+
+	start := time.Now()
+	hits, _, err := adRepo.SearchAds(ctx, model.AdSearchQuery{
+		Keyword: keyword,
+		Filter:  model.AdFilter{Tags: opts.tags},
+		Sort:    opts.sort,
+		Size:    opts.size,
+	})
+	if err != nil {
+		closeIndex()
+		logging.FatalContext(ctx, "%v", err)
+	}
+
+	if opts.format == FormatJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		if err = encoder.Encode(hits); err != nil {
+			logging.ErrContext(ctx, "%v", err)
+		}
+		return
+	}
+	printTable(hits)
+	fmt.Printf("\n%d hits on %s in %s\n", len(hits), conf.IndexerActivated, time.Since(start).Round(time.Microsecond))
+}
+
+func printTable(hits model.AdSearchHits) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SCORE\tID\tUPDATED AT\tTITLE\tTAGS")
+	for _, hit := range hits {
+		fmt.Fprintf(w, "%.4f\t%d\t%s\t%s\t%s\n", hit.Score, hit.ID,
+			time.Unix(hit.UpdatedAt, 0).UTC().Format("2006-01-02 15:04"),
+			truncate(hit.Title, maxTitleLength), strings.Join(hit.TagList(), ", "))
+	}
+	w.Flush()
+}
+
+func truncate(s string, max int) string {
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	return string([]rune(s)[:max-3]) + "..."
+}
@@ -0,0 +1,60 @@
+package seed
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/isdzulqor/kraicklist/helper/files"
+)
+
+// Checkpoint is persisted after every confirmed batch, so the seed could be resumed after the position
+type Checkpoint struct {
+	Input     string    `json:"input"`
+	Position  Position  `json:"position"`
+	Batch     int       `json:"batch"`  // last confirmed batch number
+	Report    Report    `json:"report"` // its failures are capped at errors.MaxFailures doc IDs
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// loadCheckpoint returns nil when there is no checkpoint on the path
+func loadCheckpoint(path string) (*Checkpoint, error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint %s, err: %v", path, err)
+	}
+	checkpoint := &Checkpoint{}
+	if err = json.Unmarshal(content, checkpoint); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal checkpoint %s, err: %v", path, err)
+	}
+	return checkpoint, nil
+}
+
+func (c Checkpoint) save(path string) error {
+	c.UpdatedAt = time.Now()
+	return WriteJSONFile(path, c)
+}
+
+func removeCheckpoint(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove checkpoint %s, err: %v", path, err)
+	}
+	return nil
+}
+
+// WriteJSONFile writes the data as indented json, i.e: the checkpoint and the reports
+func WriteJSONFile(path string, data interface{}) error {
+	content, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s, err: %v", path, err)
+	}
+	if err = files.WriteAtomic(path, content, 0644); err != nil {
+		return fmt.Errorf("failed to write %s, err: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,409 @@
+package seed
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/isdzulqor/kraicklist/domain/model"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	FormatAuto  = "auto"
+	FormatJSONL = "jsonl"
+	FormatJSON  = "json"
+	FormatCSV   = "csv"
+
+	stdinInput = "-"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+	// csv columns are mapped to these ad fields, by default the column names are the same as the json fields
+	csvFields = []string{"id", "title", "content", "thumb_url", "tags", "updated_at", "image_urls"}
+)
+
+// Position is the position of a record on a file.
+// Offset is the byte offset after the record on the decompressed content, it's only tracked for jsonl,
+// Line is the line number for jsonl, element number for json array and row number for csv
+type Position struct {
+	File   string `json:"file"`
+	Offset int64  `json:"offset"`
+	Line   int    `json:"line"`
+}
+
+// Record is a parsed ad along with its position on the source
+type Record struct {
+	Ad model.Advertisement
+	Position
+}
+
+// SkippedRecord is a record which is failed to be parsed
+type SkippedRecord struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (s SkippedRecord) Error() string {
+	return fmt.Sprintf("%s:%d: %v", s.File, s.Line, s.Err)
+}
+
+// Loader streams ads from files of jsonl, json array or csv,
+// each of them could be plain, gzip or zstd compressed
+type Loader struct {
+	format     string
+	csvMapping map[string]string
+	csvListSep string
+
+	// bytesRead counts bytes read from the source files before decompression,
+	// bytesSkipped counts bytes those are seeked over when resuming
+	bytesRead    int64
+	bytesSkipped int64
+}
+
+// NewLoader creates Loader, csvMapping is formatted as field:column pairs separated by comma, i.e: id:ad_id,title:name
+func NewLoader(format, csvMapping, csvListSep string) (*Loader, error) {
+	switch format {
+	case FormatAuto, FormatJSONL, FormatJSON, FormatCSV:
+	default:
+		return nil, fmt.Errorf("format %s is invalid, use one of auto, jsonl, json, csv", format)
+	}
+
+	mapping := map[string]string{}
+	for _, field := range csvFields {
+		mapping[field] = field
+	}
+	if csvMapping != "" {
+		for _, pair := range strings.Split(csvMapping, ",") {
+			kv := strings.SplitN(pair, ":", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("csv mapping %s is invalid, use field:column", pair)
+			}
+			field, column := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+			if _, ok := mapping[field]; !ok {
+				return nil, fmt.Errorf("csv mapping field %s is invalid, use one of %s", field, strings.Join(csvFields, ", "))
+			}
+			mapping[field] = column
+		}
+	}
+
+	return &Loader{
+		format:     format,
+		csvMapping: mapping,
+		csvListSep: csvListSep,
+	}, nil
+}
+
+// ExpandInput resolves input to the list of files, input could be a file path, a glob pattern or - for stdin
+func ExpandInput(input string) ([]string, error) {
+	if input == stdinInput {
+		return []string{stdinInput}, nil
+	}
+	files, err := filepath.Glob(input)
+	if err != nil {
+		return nil, fmt.Errorf("input %s is invalid, err: %v", input, err)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no file matches input %s", input)
+	}
+	return files, nil
+}
+
+// BytesRead returns the number of bytes read from the source files so far
+func (l *Loader) BytesRead() int64 {
+	return atomic.LoadInt64(&l.bytesRead)
+}
+
+// BytesDone returns the number of bytes of the source files which are passed, either read or seeked over
+func (l *Loader) BytesDone() int64 {
+	return l.BytesRead() + atomic.LoadInt64(&l.bytesSkipped)
+}
+
+// LoadFile streams records of the file to onRecord and unparseable records to onSkip.
+// Records at or before from are not streamed, from is the position of the last record of the previous run.
+// Loading is stopped once any of the callbacks returns error
+func (l *Loader) LoadFile(ctx context.Context, file string, from Position,
+	onRecord func(Record) error, onSkip func(SkippedRecord) error) (err error) {
+	var (
+		source io.Reader = os.Stdin
+		f      *os.File
+	)
+	if file != stdinInput {
+		if f, err = os.Open(file); err != nil {
+			return fmt.Errorf("unable to open file due: %v", err)
+		}
+		defer f.Close()
+		source = f
+	}
+
+	reader, compressed, closeReader, err := decompress(&countingReader{reader: source, count: &l.bytesRead})
+	if err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+	defer closeReader()
+
+	format := l.format
+	if format == FormatAuto {
+		if format, err = detectFormat(file, reader); err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+	}
+
+	switch format {
+	case FormatJSON:
+		return l.loadJSON(ctx, file, from, reader, onRecord, onSkip)
+	case FormatCSV:
+		return l.loadCSV(ctx, file, from, reader, onRecord, onSkip)
+	}
+
+	// jsonl is resumed from the byte offset, plain file is seeked while compressed one is discarded
+	if from.Offset > 0 {
+		if !compressed && f != nil {
+			if _, err = f.Seek(from.Offset, io.SeekStart); err != nil {
+				return fmt.Errorf("%s: unable to seek to offset %d, err: %v", file, from.Offset, err)
+			}
+			atomic.AddInt64(&l.bytesSkipped, from.Offset-int64(reader.Buffered()))
+			reader.Reset(&countingReader{reader: f, count: &l.bytesRead})
+		} else if _, err = io.CopyN(ioutil.Discard, reader, from.Offset); err != nil {
+			return fmt.Errorf("%s: unable to skip to offset %d, err: %v", file, from.Offset, err)
+		}
+	}
+	return l.loadJSONL(ctx, file, from, reader, onRecord, onSkip)
+}
+
+type countingReader struct {
+	reader io.Reader
+	count  *int64
+}
+
+func (c *countingReader) Read(p []byte) (n int, err error) {
+	n, err = c.reader.Read(p)
+	atomic.AddInt64(c.count, int64(n))
+	return
+}
+
+// decompress detects the compression by the magic bytes
+func decompress(source io.Reader) (reader *bufio.Reader, compressed bool, closeReader func(), err error) {
+	closeReader = func() {}
+	reader = bufio.NewReader(source)
+	magic, _ := reader.Peek(len(zstdMagic))
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, true, nil, fmt.Errorf("unable to initialize gzip reader due: %v", err)
+		}
+		return bufio.NewReader(gzipReader), true, func() { gzipReader.Close() }, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		zstdReader, err := zstd.NewReader(reader)
+		if err != nil {
+			return nil, true, nil, fmt.Errorf("unable to initialize zstd reader due: %v", err)
+		}
+		return bufio.NewReader(zstdReader), true, zstdReader.Close, nil
+	}
+	return
+}
+
+// detectFormat detects the format by the file extension,
+// then by the first non space character of the content
+func detectFormat(file string, reader *bufio.Reader) (string, error) {
+	ext := strings.ToLower(filepath.Ext(file))
+	switch ext {
+	case ".gz", ".gzip", ".zst", ".zstd":
+		ext = strings.ToLower(filepath.Ext(strings.TrimSuffix(file, filepath.Ext(file))))
+	}
+	switch ext {
+	case ".jsonl", ".ndjson":
+		return FormatJSONL, nil
+	case ".csv":
+		return FormatCSV, nil
+	}
+
+	for i := 1; ; i++ {
+		peeked, err := reader.Peek(i)
+		if err != nil {
+			return "", fmt.Errorf("unable to detect format, use --format flag")
+		}
+		switch peeked[i-1] {
+		case ' ', '\t', '\r', '\n':
+			continue
+		case '[':
+			return FormatJSON, nil
+		case '{':
+			return FormatJSONL, nil
+		}
+		return "", fmt.Errorf("unable to detect format, use --format flag")
+	}
+}
+
+// loadJSONL streams lines of reader which is already positioned after from.Offset
+func (l *Loader) loadJSONL(ctx context.Context, file string, from Position, reader *bufio.Reader,
+	onRecord func(Record) error, onSkip func(SkippedRecord) error) error {
+	offset := from.Offset
+	for line := from.Line + 1; ; line++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		content, readErr := reader.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return fmt.Errorf("%s:%d: %v", file, line, readErr)
+		}
+		offset += int64(len(content))
+
+		if content = bytes.TrimSpace(content); len(content) > 0 {
+			ad := model.Advertisement{}
+			if err := json.Unmarshal(content, &ad); err != nil {
+				if err = onSkip(SkippedRecord{File: file, Line: line, Err: err}); err != nil {
+					return err
+				}
+			} else if err = onRecord(Record{Ad: ad, Position: Position{File: file, Offset: offset, Line: line}}); err != nil {
+				return err
+			}
+		}
+
+		if readErr == io.EOF {
+			return nil
+		}
+	}
+}
+
+// loadJSON streams elements of a json array, Line of the record is the element number
+func (l *Loader) loadJSON(ctx context.Context, file string, from Position, reader *bufio.Reader,
+	onRecord func(Record) error, onSkip func(SkippedRecord) error) error {
+	decoder := json.NewDecoder(reader)
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		return fmt.Errorf("%s: content is not a json array", file)
+	}
+
+	for element := 1; decoder.More(); element++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// decode as raw first, so an invalid element doesn't break the rest of the array
+		raw := json.RawMessage{}
+		if err := decoder.Decode(&raw); err != nil {
+			return fmt.Errorf("%s: element %d: %v", file, element, err)
+		}
+		if element <= from.Line {
+			continue
+		}
+		ad := model.Advertisement{}
+		if err := json.Unmarshal(raw, &ad); err != nil {
+			if err = onSkip(SkippedRecord{File: file, Line: element, Err: err}); err != nil {
+				return err
+			}
+			continue
+		}
+		position := Position{File: file, Offset: decoder.InputOffset(), Line: element}
+		if err := onRecord(Record{Ad: ad, Position: position}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadCSV streams csv rows, the first row must be the header
+func (l *Loader) loadCSV(ctx context.Context, file string, from Position, reader *bufio.Reader,
+	onRecord func(Record) error, onSkip func(SkippedRecord) error) error {
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+
+	header, err := csvReader.Read()
+	if err != nil {
+		return fmt.Errorf("%s: unable to read csv header, err: %v", file, err)
+	}
+	columns := map[string]int{}
+	for i, column := range header {
+		columns[strings.TrimSpace(column)] = i
+	}
+	if _, ok := columns[l.csvMapping["id"]]; !ok {
+		return fmt.Errorf("%s: csv column %s for id is not found", file, l.csvMapping["id"])
+	}
+
+	for row := 2; ; row++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		values, err := csvReader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if row <= from.Line {
+			continue
+		}
+		if err != nil {
+			line := row
+			if parseErr, ok := err.(*csv.ParseError); ok {
+				line = parseErr.Line
+			}
+			if err = onSkip(SkippedRecord{File: file, Line: line, Err: err}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		ad, err := l.csvToAd(columns, values)
+		if err != nil {
+			if err = onSkip(SkippedRecord{File: file, Line: row, Err: err}); err != nil {
+				return err
+			}
+			continue
+		}
+		if err = onRecord(Record{Ad: ad, Position: Position{File: file, Line: row}}); err != nil {
+			return err
+		}
+	}
+}
+
+func (l *Loader) csvToAd(columns map[string]int, values []string) (ad model.Advertisement, err error) {
+	get := func(field string) string {
+		if i, ok := columns[l.csvMapping[field]]; ok && i < len(values) {
+			return strings.TrimSpace(values[i])
+		}
+		return ""
+	}
+	getList := func(field string) interface{} {
+		value := get(field)
+		if value == "" {
+			return nil
+		}
+		return strings.Split(value, l.csvListSep)
+	}
+
+	if ad.ID, err = strconv.ParseInt(get("id"), 10, 64); err != nil {
+		err = fmt.Errorf("id is invalid, err: %v", err)
+		return
+	}
+	if updatedAt := get("updated_at"); updatedAt != "" {
+		if ad.UpdatedAt, err = strconv.ParseInt(updatedAt, 10, 64); err != nil {
+			err = fmt.Errorf("updated_at is invalid, err: %v", err)
+			return
+		}
+	}
+	ad.Title = get("title")
+	ad.Content = get("content")
+	ad.ThumbURL = get("thumb_url")
+	ad.Tags = getList("tags")
+	ad.ImageURLs = getList("image_urls")
+	return
+}
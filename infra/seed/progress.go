@@ -0,0 +1,79 @@
+package seed
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// progress periodically prints the seed progress with rate and ETA.
+// ETA is estimated from the bytes read of the source files, it's unknown when reading from stdin
+type progress struct {
+	loader     *Loader
+	totalBytes int64
+	start      time.Time
+
+	read    int64
+	indexed int64
+}
+
+func newProgress(loader *Loader, files []string) *progress {
+	p := &progress{
+		loader: loader,
+		start:  time.Now(),
+	}
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if file == stdinInput || err != nil {
+			p.totalBytes = 0
+			break
+		}
+		p.totalBytes += info.Size()
+	}
+	return p
+}
+
+func (p *progress) setCounts(read, indexed int) {
+	atomic.StoreInt64(&p.read, int64(read))
+	atomic.StoreInt64(&p.indexed, int64(indexed))
+}
+
+// run prints the progress every interval until ctx is done
+func (p *progress) run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	// docs indexed before the resumed position are not counted on the rate
+	startIndexed := atomic.LoadInt64(&p.indexed)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.print(startIndexed)
+		}
+	}
+}
+
+func (p *progress) print(startIndexed int64) {
+	elapsed := time.Since(p.start)
+	indexed := atomic.LoadInt64(&p.indexed)
+	rate := float64(indexed-startIndexed) / elapsed.Seconds()
+
+	message := fmt.Sprintf("read %d, indexed %d, %.0f docs/s, elapsed %s",
+		atomic.LoadInt64(&p.read), indexed, rate, elapsed.Round(time.Second))
+
+	bytesRead, bytesDone := p.loader.BytesRead(), p.loader.BytesDone()
+	if p.totalBytes > 0 && bytesRead > 0 {
+		percentage := float64(bytesDone) * 100 / float64(p.totalBytes)
+		bytesRate := float64(bytesRead) / elapsed.Seconds()
+		eta := time.Duration(float64(p.totalBytes-bytesDone) / bytesRate * float64(time.Second))
+		message = fmt.Sprintf("%.1f%% %s, ETA %s", percentage, message, eta.Round(time.Second))
+	}
+	fmt.Printf("\033[36mSeed progress: \033[0m%s\n", message)
+}
@@ -1,132 +1,354 @@
 package seed
 
 import (
-	"bufio"
-	"compress/gzip"
 	"context"
-	"encoding/json"
 	"fmt"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/isdzulqor/kraicklist/config"
 	"github.com/isdzulqor/kraicklist/domain/model"
-	"github.com/isdzulqor/kraicklist/external/index"
+	"github.com/isdzulqor/kraicklist/domain/repository"
+	"github.com/isdzulqor/kraicklist/external/engine"
+	"github.com/isdzulqor/kraicklist/helper/errors"
 	"github.com/isdzulqor/kraicklist/helper/logging"
+	"github.com/isdzulqor/kraicklist/infra/backend"
+	"github.com/isdzulqor/kraicklist/infra/cli"
 )
 
-func Exec() {
+const maxReportedSkips = 1000
+
+type options struct {
+	input      string
+	format     string
+	csvMapping string
+	csvListSep string
+	strict     bool
+	batchSize  int
+
+	mode   string
+	prune  bool
+	dryRun bool
+
+	resume           bool
+	checkpointPath   string
+	reportPath       string
+	progressInterval time.Duration
+
+	tenant string
+}
+
+func parseOptions(conf *config.Config, args []string) (opts options) {
+	flags := cli.NewFlagSet(cli.CmdSeed)
+	flags.StringVar(&opts.input, "input", conf.Advertisement.MasterDataPath,
+		"file path, glob pattern of files, or - to read from stdin")
+	flags.StringVar(&opts.format, "format", FormatAuto, "auto | jsonl | json | csv, compression is detected automatically")
+	flags.StringVar(&opts.csvMapping, "csv-mapping", "",
+		"csv column mapping as field:column pairs, i.e: id:ad_id,title:name")
+	flags.StringVar(&opts.csvListSep, "csv-list-separator", "|", "separator of tags and image_urls csv columns")
+	flags.BoolVar(&opts.strict, "strict", false, "abort on the first bad record")
+	flags.IntVar(&opts.batchSize, "batch-size", conf.Advertisement.Bulk.BatchSize, "number of ads indexed per batch")
+	flags.StringVar(&opts.mode, "mode", ModeFull,
+		"full | upsert, upsert only indexes new ads and ads with newer updated_at than the indexed ones")
+	flags.BoolVar(&opts.prune, "prune", false, "delete indexed ads those are missing from the source, upsert mode only")
+	flags.BoolVar(&opts.dryRun, "dry-run", false,
+		"print the adds, updates and deletes without touching the index, upsert mode only")
+	flags.BoolVar(&opts.resume, "resume", false, "resume from the checkpoint of the previous run")
+	flags.StringVar(&opts.checkpointPath, "checkpoint", "./data/seed.checkpoint.json",
+		"checkpoint file which is updated after every indexed batch")
+	flags.StringVar(&opts.reportPath, "report", "./data/seed.report.json", "file to write the final report as json")
+	flags.DurationVar(&opts.progressInterval, "progress-interval", 5*time.Second, "interval to print progress, 0 to disable")
+	flags.StringVar(&opts.tenant, "tenant", "", "tenant whose indices are used, the default indices when it's empty")
+	cli.Parse(flags, args)
+	return
+}
+
+func Exec(args []string) {
 	conf := config.Get()
+	opts := parseOptions(conf, args)
 	conf.PrintPretty()
 
-	ctx := context.Background()
+	// interrupting stops the seed gracefully, so the report is still written and it could be resumed
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopChan := make(chan os.Signal, 1)
+	signal.Notify(stopChan, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stopChan
+		cancel()
+	}()
 
 	logging.Init(strings.ToUpper(conf.LogLevel))
 
+	if opts.batchSize <= 0 {
+		logging.FatalContext(ctx, "batch size must be greater than 0")
+	}
+	if opts.mode != ModeFull && opts.mode != ModeUpsert {
+		logging.FatalContext(ctx, "mode %s is invalid, use %s or %s", opts.mode, ModeFull, ModeUpsert)
+	}
+	if (opts.prune || opts.dryRun) && opts.mode != ModeUpsert {
+		logging.FatalContext(ctx, "--prune and --dry-run are only supported with --mode=%s", ModeUpsert)
+	}
+	// pruning needs every ID of the source and a dry run has no checkpoint
+	if opts.resume && (opts.prune || opts.dryRun) {
+		logging.FatalContext(ctx, "--resume can't be combined with --prune or --dry-run")
+	}
+
 	logging.InfoContext(ctx, "preparing data seed...")
 
-	ads, err := loadAdsData(conf.Advertisement.MasterDataPath)
+	files, err := ExpandInput(opts.input)
 	if err != nil {
 		logging.FatalContext(ctx, "%v", err)
 	}
-
-	switch conf.IndexerActivated {
-	case index.IndexBleve:
-		seedDataWithBleve(ctx, ads, conf)
-	case index.IndexElastic:
-		seedDataWithElastic(ctx, ads, conf)
-	default:
-		logging.FatalContext(ctx, "Indexer for %s is invalid", conf.IndexerActivated)
+	loader, err := NewLoader(opts.format, opts.csvMapping, opts.csvListSep)
+	if err != nil {
+		logging.FatalContext(ctx, "%v", err)
 	}
 
-	logging.InfoContext(ctx, "data seed is finished")
-}
+	var checkpoint *Checkpoint
+	if opts.resume {
+		if checkpoint, err = loadCheckpoint(opts.checkpointPath); err != nil {
+			logging.FatalContext(ctx, "%v", err)
+		}
+		if checkpoint == nil {
+			logging.WarnContext(ctx, "no checkpoint found on %s, seeding from the beginning", opts.checkpointPath)
+		} else if checkpoint.Input != opts.input {
+			logging.FatalContext(ctx, "checkpoint %s is created for input %s, not %s",
+				opts.checkpointPath, checkpoint.Input, opts.input)
+		} else if opts.input == stdinInput {
+			logging.FatalContext(ctx, "seeding from stdin can't be resumed")
+		}
+	}
 
-func loadAdsData(filePath string) (out model.Advertisements, err error) {
-	// open file
-	file, err := os.Open(filePath)
-	if err != nil {
-		err = fmt.Errorf("unable to open file due: %v", err)
-		return
+	if conf, err = backend.TenantConfig(conf, opts.tenant); err != nil {
+		logging.FatalContext(ctx, "%v", err)
 	}
 
-	defer file.Close()
-	// read as gzip
-	reader, err := gzip.NewReader(file)
+	// the elastic index is only recreated on a fresh full seed
+	adRepo, closeIndex, err := backend.InitAdvertisementWithStore(ctx, conf, conf.IndexerActivated, engine.Options{
+		RecreateIndex: checkpoint == nil && opts.mode == ModeFull,
+	})
 	if err != nil {
-		err = fmt.Errorf("unable to initialize gzip reader due: %v", err)
-		return
+		logging.FatalContext(ctx, "%v", err)
 	}
+	defer closeIndex()
 
-	// read the reader using scanner to contstruct records
-	cs := bufio.NewScanner(reader)
-	for cs.Scan() {
-		ad := model.Advertisement{}
-		err = json.Unmarshal(cs.Bytes(), &ad)
-		if err != nil {
-			continue
+	// the recreated index gets the ads on the store back before the seed
+	if !opts.dryRun {
+		if err = adRepo.EnsureIndex(ctx, opts.batchSize); err != nil {
+			closeIndex()
+			logging.FatalContext(ctx, "%v", err)
 		}
-		out = append(out, ad)
 	}
-	return
-}
-
-func seedDataWithBleve(ctx context.Context, ads model.Advertisements, conf *config.Config) {
-	logging.InfoContext(ctx, "data seeding with bleve index...")
 
-	bleveIndex, err := index.InitBleveIndex(ctx, conf.Advertisement.Bleve.IndexName)
+	report, err := seedFiles(ctx, adRepo, loader, files, checkpoint, opts)
+	report.FinishedAt = time.Now()
 	if err != nil {
-		logging.FatalContext(ctx, "%v", err)
+		report.Error = err.Error()
+	}
+	report.print()
+	if writeErr := WriteJSONFile(opts.reportPath, report); writeErr != nil {
+		logging.ErrContext(ctx, "%v", writeErr)
 	}
-
-	docs, err := ads.ToBleveDocs()
 	if err != nil {
-		logging.FatalContext(ctx, "%v", err)
+		closeIndex()
+		if opts.dryRun {
+			logging.FatalContext(ctx, "%v", err)
+		}
+		logging.FatalContext(ctx, "%v, resume with --resume flag", err)
+	}
+	if opts.dryRun {
+		logging.InfoContext(ctx, "dry run is finished, the index is untouched")
 		return
 	}
 
-	if docErrors := bleveIndex.BulkIndex(ctx, docs); docErrors != nil {
-		logging.ErrContext(ctx, "%v", docErrors.ToError())
+	if err = removeCheckpoint(opts.checkpointPath); err != nil {
+		logging.WarnContext(ctx, "%v", err)
 	}
+	logging.InfoContext(ctx, "data seed is finished")
+}
+
+// Report is the final report of the seed, it's written as json for CI to inspect
+type Report struct {
+	Input   string `json:"input"`
+	Read    int    `json:"read"`
+	Indexed int    `json:"indexed"`
+	Skipped int    `json:"skipped"`
+	Failed  int    `json:"failed"`
+	Deleted int    `json:"deleted"`
+	Resumed bool   `json:"resumed"`
 
-	if err := bleveIndex.Close(); err != nil {
-		logging.ErrContext(ctx, "%v", err)
+	// Diff is only set on upsert mode, the index is untouched when DryRun
+	Mode   string `json:"mode"`
+	DryRun bool   `json:"dry_run"`
+	Diff   *Diff  `json:"diff,omitempty"`
+
+	// Failures is failed doc IDs grouped by the reason, Skips is the first skipped records
+	Failures errors.Failures `json:"failures,omitempty"`
+	Skips    []string        `json:"skips,omitempty"`
+	Error    string          `json:"error,omitempty"`
+
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+func (r Report) print() {
+	fmt.Printf("\n\033[36mSeed report: \033[0mread %d, indexed %d, deleted %d, skipped %d, failed %d, took %s\n",
+		r.Read, r.Indexed, r.Deleted, r.Skipped, r.Failed, r.FinishedAt.Sub(r.StartedAt).Round(time.Millisecond))
+	if r.DryRun {
+		fmt.Println("\033[33mDry run, nothing is written to the index\033[0m")
+	}
+	if r.Diff != nil {
+		r.Diff.print()
 	}
+	PrintFailureSummary(r.Read, r.Failed, r.Failures)
 }
 
-func seedDataWithElastic(ctx context.Context, ads model.Advertisements, conf *config.Config) {
-	logging.InfoContext(ctx, "data seeding with elastic index...")
+// seedFiles streams records of the files into the indexer in batches.
+// A checkpoint is saved after every indexed batch, records at or before the checkpoint position are skipped
+func seedFiles(ctx context.Context, adRepo *repository.Advertisement, loader *Loader,
+	files []string, checkpoint *Checkpoint, opts options) (report Report, err error) {
+	var (
+		batch       = make(model.Advertisements, 0, opts.batchSize)
+		batchNumber int
+		lastRecord  Position
+		from        Position
 
-	esIndex, err := index.InitESIndex(ctx,
-		conf.Elastic.Host,
-		conf.Elastic.Username,
-		conf.Elastic.Password,
-		conf.Advertisement.Elastic.IndexName)
-	if err != nil {
-		logging.FatalContext(ctx, "%v", err)
+		// seen is every ID of the source to be pruned against
+		seen map[int64]struct{}
+	)
+
+	report = Report{Input: opts.input, StartedAt: time.Now()}
+	if checkpoint != nil {
+		report = checkpoint.Report
+		report.Resumed = true
+		batchNumber = checkpoint.Batch
+		from = checkpoint.Position
+		logging.InfoContext(ctx, "resuming seed from %s line %d after batch %d", from.File, from.Line, batchNumber)
+	}
+	report.Mode, report.DryRun = opts.mode, opts.dryRun
+	if opts.mode == ModeUpsert && report.Diff == nil {
+		report.Diff = &Diff{}
+	}
+	if opts.prune {
+		seen = map[int64]struct{}{}
 	}
 
-	// check es7 cluster readiness with retry
-	if err = esIndex.PingWithRetry(conf.Elastic.PingRetry,
-		conf.Elastic.PingWaitTime); err != nil {
-		logging.WarnContext(ctx, "%v", err)
+	progress := newProgress(loader, files)
+	progress.setCounts(report.Read, report.Indexed)
+	progressCtx, stopProgress := context.WithCancel(ctx)
+	defer stopProgress()
+	go progress.run(progressCtx, opts.progressInterval)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		writes := batch
+		if opts.mode == ModeUpsert {
+			// diff is counted on a copy, so an interrupted batch doesn't change the report
+			diff := *report.Diff
+			var diffErr error
+			if writes, diffErr = diffBatch(ctx, adRepo, batch, &diff); diffErr != nil {
+				return diffErr
+			}
+			*report.Diff = diff
+		}
+
+		if len(writes) > 0 && !opts.dryRun {
+			indexErr := adRepo.IndexAds(ctx, writes)
+			if ctx.Err() != nil {
+				// the batch is interrupted, it's not confirmed on the checkpoint
+				return ctx.Err()
+			}
+			report.addBatch(writes, indexErr)
+		}
+		progress.setCounts(report.Read, report.Indexed)
+		batch = batch[:0]
+		batchNumber++
+
+		if opts.input == stdinInput || opts.dryRun {
+			return nil
+		}
+		return Checkpoint{
+			Input:    opts.input,
+			Position: lastRecord,
+			Batch:    batchNumber,
+			Report:   report,
+		}.save(opts.checkpointPath)
+	}
+	onRecord := func(record Record) error {
+		report.Read++
+		if seen != nil {
+			seen[record.Ad.ID] = struct{}{}
+		}
+		batch = append(batch, record.Ad)
+		lastRecord = record.Position
+		if len(batch) >= opts.batchSize {
+			return flush()
+		}
+		return nil
+	}
+	onSkip := func(skipped SkippedRecord) error {
+		report.Skipped++
+		if len(report.Skips) < maxReportedSkips {
+			report.Skips = append(report.Skips, skipped.Error())
+		}
+		if opts.strict {
+			return fmt.Errorf("strict mode is aborted on bad record %v", skipped)
+		}
+		logging.WarnContext(ctx, "skipped record %v", skipped)
+		return nil
 	}
 
-	if err = esIndex.DeleteIndex(ctx); err != nil {
-		logging.WarnContext(ctx, "%v", err)
+	for _, file := range files {
+		// files before the checkpoint position are already seeded
+		if from.File != "" && file < from.File {
+			continue
+		}
+		position := Position{}
+		if file == from.File {
+			position = from
+		}
+
+		logging.InfoContext(ctx, "seeding data from %s...", file)
+		if err = loader.LoadFile(ctx, file, position, onRecord, onSkip); err != nil {
+			return
+		}
 	}
+	if err = flush(); err != nil {
+		return
+	}
+	if opts.prune {
+		err = pruneAds(ctx, adRepo, seen, opts.batchSize, opts.dryRun, &report)
+	}
+	return
+}
 
-	docs, err := ads.ToElasticDocs()
-	if err != nil {
-		logging.FatalContext(ctx, "%v", err)
+// addBatch counts the indexing result of the batch,
+// the whole batch is counted as failed when the indexer fails entirely
+func (r *Report) addBatch(batch model.Advertisements, err error) {
+	failed := r.Failures.Add("", len(batch), func(i int) string { return fmt.Sprint(batch[i].ID) }, err)
+	r.Failed += failed
+	r.Indexed += len(batch) - failed
+}
+
+// PrintFailureSummary prints failed docs grouped by the failure reason
+func PrintFailureSummary(total, failed int, failures errors.Failures) {
+	if failed == 0 {
 		return
 	}
 
-	docErrors, err := esIndex.BulkIndexDocs(ctx, docs)
-	if err != nil {
-		logging.ErrContext(ctx, "%v", err)
+	fmt.Printf("\n\033[31mFailure summary: %d of %d docs failed to be indexed\033[0m\n", failed, total)
+	if listed := failures.Len(); listed < failed {
+		fmt.Printf("only the first %d failed docs are listed\n", listed)
 	}
-	if docErrors != nil {
-		logging.ErrContext(ctx, "%v", docErrors.ToError())
+	for reason, docIDs := range failures {
+		fmt.Printf("\033[36m%d docs: \033[0m%s\n", len(docIDs), reason)
+		fmt.Printf("  doc IDs: %s\n", strings.Join(docIDs, ", "))
 	}
+	fmt.Println()
 }
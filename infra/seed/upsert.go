@@ -0,0 +1,144 @@
+package seed
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/isdzulqor/kraicklist/domain/model"
+	"github.com/isdzulqor/kraicklist/domain/repository"
+	"github.com/isdzulqor/kraicklist/helper/logging"
+)
+
+const (
+	// ModeFull indexes every record, the elastic index is recreated on a fresh seed
+	ModeFull = "full"
+	// ModeUpsert only indexes new ads and ads those are updated after the indexed ones
+	ModeUpsert = "upsert"
+
+	maxDiffSamples = 20
+)
+
+// Diff is the changes of an upsert seed against the index
+type Diff struct {
+	Adds      int `json:"adds"`
+	Updates   int `json:"updates"`
+	Unchanged int `json:"unchanged"`
+	Deletes   int `json:"deletes"`
+
+	SampleAdds    []int64 `json:"sample_adds,omitempty"`
+	SampleUpdates []int64 `json:"sample_updates,omitempty"`
+	SampleDeletes []int64 `json:"sample_deletes,omitempty"`
+}
+
+func (d Diff) print() {
+	fmt.Printf("\033[36mDiff: \033[0m%d adds, %d updates, %d deletes, %d unchanged\n",
+		d.Adds, d.Updates, d.Deletes, d.Unchanged)
+	printSamples("adds", d.Adds, d.SampleAdds)
+	printSamples("updates", d.Updates, d.SampleUpdates)
+	printSamples("deletes", d.Deletes, d.SampleDeletes)
+}
+
+func printSamples(name string, total int, ids []int64) {
+	if len(ids) == 0 {
+		return
+	}
+	samples := make([]string, 0, len(ids))
+	for _, id := range ids {
+		samples = append(samples, fmt.Sprint(id))
+	}
+	more := ""
+	if total > len(ids) {
+		more = fmt.Sprintf(" and %d more", total-len(ids))
+	}
+	fmt.Printf("  %s: %s%s\n", name, strings.Join(samples, ", "), more)
+}
+
+func addSample(samples []int64, id int64) []int64 {
+	if len(samples) >= maxDiffSamples {
+		return samples
+	}
+	return append(samples, id)
+}
+
+// diffBatch compares the batch against the indexed ads by updated_at,
+// it returns the ads those need to be written which are the new and the updated ones
+func diffBatch(ctx context.Context, adRepo *repository.Advertisement, batch model.Advertisements,
+	diff *Diff) (writes model.Advertisements, err error) {
+	ids := make([]int64, 0, len(batch))
+	for _, ad := range batch {
+		ids = append(ids, ad.ID)
+	}
+	indexed, err := adRepo.GetUpdatedAts(ctx, ids)
+	if err != nil {
+		err = fmt.Errorf("failed to look up indexed ads, err: %v", err)
+		return
+	}
+
+	for _, ad := range batch {
+		updatedAt, found := indexed[ad.ID]
+		switch {
+		case !found:
+			diff.Adds++
+			diff.SampleAdds = addSample(diff.SampleAdds, ad.ID)
+		case ad.UpdatedAt > updatedAt:
+			diff.Updates++
+			diff.SampleUpdates = addSample(diff.SampleUpdates, ad.ID)
+		default:
+			diff.Unchanged++
+			continue
+		}
+		writes = append(writes, ad)
+	}
+	return
+}
+
+// pruneAds deletes the indexed ads those are not seen on the source,
+// they are only counted on the diff when it's a dry run
+func pruneAds(ctx context.Context, adRepo *repository.Advertisement, seen map[int64]struct{},
+	pageSize int, dryRun bool, report *Report) (err error) {
+	logging.InfoContext(ctx, "looking for indexed ads those are missing from the source...")
+
+	// deleting is deferred after the scan, so the paging is not shifted
+	var stale []int64
+	err = adRepo.ScanIDs(ctx, pageSize, func(ids []int64) error {
+		for _, id := range ids {
+			if _, ok := seen[id]; !ok {
+				stale = append(stale, id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to scan indexed ads, err: %v", err)
+	}
+
+	for _, id := range stale {
+		report.Diff.SampleDeletes = addSample(report.Diff.SampleDeletes, id)
+	}
+	report.Diff.Deletes = len(stale)
+	if dryRun || len(stale) == 0 {
+		return nil
+	}
+
+	for i := 0; i < len(stale); i += pageSize {
+		end := i + pageSize
+		if end > len(stale) {
+			end = len(stale)
+		}
+		deleteErr := adRepo.DeleteAds(ctx, stale[i:end])
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		report.addDeletes(stale[i:end], deleteErr)
+	}
+	logging.InfoContext(ctx, "pruned %d of %d ads those are missing from the source", report.Deleted, len(stale))
+	return nil
+}
+
+// addDeletes counts the deleting result of the ads, failed ones are listed on the failures
+func (r *Report) addDeletes(ids []int64, err error) {
+	failed := r.Failures.Add("delete: ", len(ids), func(i int) string { return fmt.Sprint(ids[i]) }, err)
+	r.Failed += failed
+	r.Deleted += len(ids) - failed
+}
@@ -0,0 +1,137 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/isdzulqor/kraicklist/config"
+	"github.com/isdzulqor/kraicklist/domain/model"
+	"github.com/isdzulqor/kraicklist/external/engine"
+	"github.com/isdzulqor/kraicklist/helper/logging"
+	"github.com/isdzulqor/kraicklist/infra/backend"
+	"github.com/isdzulqor/kraicklist/infra/cli"
+)
+
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+)
+
+type options struct {
+	top    int
+	format string
+	tenant string
+}
+
+func parseOptions(args []string) (opts options) {
+	flags := cli.NewFlagSet(cli.CmdStats)
+	flags.IntVar(&opts.top, "top", 10, "number of top terms and tags")
+	flags.StringVar(&opts.format, "format", FormatTable, "table | json")
+	flags.StringVar(&opts.tenant, "tenant", "", "tenant whose indices are used, the default indices when it's empty")
+	cli.Parse(flags, args)
+	return
+}
+
+// Exec prints the statistics of the configured index
+func Exec(args []string) {
+	conf := config.Get()
+	ctx := context.Background()
+
+	// keep the output clean for piping, only warnings are logged
+	logging.Init(logging.Warn)
+
+	opts := parseOptions(args)
+	if opts.format != FormatTable && opts.format != FormatJSON {
+		logging.FatalContext(ctx, "format %s is invalid, use %s or %s", opts.format, FormatTable, FormatJSON)
+	}
+	if opts.top <= 0 {
+		logging.FatalContext(ctx, "top must be greater than 0")
+	}
+
+	conf, err := backend.TenantConfig(conf, opts.tenant)
+	if err != nil {
+		logging.FatalContext(ctx, "%v", err)
+	}
+	adRepo, closeIndex, err := backend.InitAdvertisement(ctx, conf, conf.IndexerActivated, engine.Options{ReadOnly: true})
+	if err != nil {
+		logging.FatalContext(ctx, "%v", err)
+	}
+	defer closeIndex()
+
+	stats, err := adRepo.GetStats(ctx, opts.top)
+	if err != nil {
+		closeIndex()
+		logging.FatalContext(ctx, "%v", err)
+	}
+
+	if opts.format == FormatJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		if err = encoder.Encode(stats); err != nil {
+			logging.ErrContext(ctx, "%v", err)
+		}
+		return
+	}
+	printTable(stats)
+}
+
+func printTable(stats model.IndexStats) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Indexer\t%s\n", stats.Indexer)
+	fmt.Fprintf(w, "Index\t%s\n", stats.Index)
+	fmt.Fprintf(w, "Docs\t%d\n", stats.DocCount)
+	fmt.Fprintf(w, "Disk size\t%s\n", formatBytes(stats.DiskSizeBytes))
+	if stats.Segments != nil {
+		fmt.Fprintf(w, "Segments\t%d\n", *stats.Segments)
+	} else {
+		fmt.Fprint(w, "Segments\t-\n")
+	}
+	fmt.Fprintf(w, "Tag cardinality\t%d\n", stats.TagCardinality)
+	fmt.Fprintf(w, "Oldest updated at\t%s\n", formatUnix(stats.OldestUpdatedAt))
+	fmt.Fprintf(w, "Newest updated at\t%s\n", formatUnix(stats.NewestUpdatedAt))
+	w.Flush()
+
+	printTermCounts("TOP TERMS", stats.TopTerms)
+	printTermCounts("TOP TAGS", stats.TopTags)
+	for _, warning := range stats.Warnings {
+		fmt.Printf("\n\033[33mWarning: \033[0m%s\n", warning)
+	}
+}
+
+func printTermCounts(title string, terms []model.TermCount) {
+	if len(terms) == 0 {
+		return
+	}
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "%s\tDOCS\n", title)
+	for _, term := range terms {
+		fmt.Fprintf(w, "%s\t%d\n", term.Term, term.Count)
+	}
+	w.Flush()
+}
+
+func formatUnix(unix int64) string {
+	if unix == 0 {
+		return "-"
+	}
+	return time.Unix(unix, 0).UTC().Format(time.RFC3339)
+}
+
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -2,18 +2,39 @@ package main
 
 import (
 	"github.com/isdzulqor/kraicklist/infra/api"
+	"github.com/isdzulqor/kraicklist/infra/apikey"
 	"github.com/isdzulqor/kraicklist/infra/cli"
+	"github.com/isdzulqor/kraicklist/infra/doctor"
+	"github.com/isdzulqor/kraicklist/infra/export"
+	"github.com/isdzulqor/kraicklist/infra/migrate"
+	"github.com/isdzulqor/kraicklist/infra/reindex"
+	"github.com/isdzulqor/kraicklist/infra/search"
 	"github.com/isdzulqor/kraicklist/infra/seed"
+	"github.com/isdzulqor/kraicklist/infra/stats"
 )
 
 func main() {
-	cmd := cli.ParseCommand()
+	cmd, args := cli.ParseCommand()
 
 	switch cmd {
 	case cli.CmdApi:
 		api.Exec()
 	case cli.CmdSeed:
-		seed.Exec()
+		seed.Exec(args)
+	case cli.CmdExport:
+		export.Exec(args)
+	case cli.CmdMigrate:
+		migrate.Exec(args)
+	case cli.CmdSearch:
+		search.Exec(args)
+	case cli.CmdStats:
+		stats.Exec(args)
+	case cli.CmdDoctor:
+		doctor.Exec(args)
+	case cli.CmdReindex:
+		reindex.Exec(args)
+	case cli.CmdAPIKey:
+		apikey.Exec(args)
 	default:
 		cli.PrintDefault()
 	}